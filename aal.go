@@ -0,0 +1,106 @@
+package osecure
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// AAL is the authentication assurance level of the session (NIST SP 800-63B).
+type AAL int
+
+const (
+	AAL1 AAL = 1 // single factor
+	AAL2 AAL = 2 // multiple factors
+	AAL3 AAL = 3 // multiple factors with a hardware, phishing-resistant authenticator
+)
+
+// acrPrefixAAL is the prefix of acr values of NIST assurance levels, e.g. "http://idmanagement.gov/ns/assurance/aal/2".
+const acrPrefixAAL = "http://idmanagement.gov/ns/assurance/aal/"
+
+// ACR is the acr value requesting the level in StepUp.
+func (level AAL) ACR() string {
+	return fmt.Sprintf("%s%d", acrPrefixAAL, level)
+}
+
+// SetACRLevels sets levels of acr values of the identity provider, in addition to NIST acr values
+// and "phr" and "phrh" of OpenID EAP. It should be called before serving requests.
+func (s *OAuthSession) SetACRLevels(levels map[string]AAL) {
+	s.acrLevels = levels
+}
+
+// assuranceLevel is the highest level of the acr and amr claims, and the local second factor.
+func (s *OAuthSession) assuranceLevel(data *AuthSessionData) AAL {
+	level := AAL1
+	raise := func(l AAL) {
+		if l > level {
+			level = l
+		}
+	}
+
+	if acr, ok := data.Extra["acr"].(string); ok {
+		raise(s.acrLevel(acr))
+	}
+	if HasMFAClaim(data.Extra) {
+		raise(AAL2)
+		if hasAMR(data.Extra, "hwk") {
+			raise(AAL3)
+		}
+	}
+	if !data.SecondFactorAt.IsZero() {
+		raise(AAL2)
+	}
+	return level
+}
+
+func (s *OAuthSession) acrLevel(acr string) AAL {
+	if level, ok := s.acrLevels[acr]; ok {
+		return level
+	}
+	switch acr {
+	case AAL2.ACR(), "phr":
+		return AAL2
+	case AAL3.ACR(), "phrh":
+		return AAL3
+	}
+	return AAL1
+}
+
+// RequireAALF is a http middleware for http.HandlerFunc to check if the current user has authenticated
+// at the assurance level or higher. Otherwise, users are sent to the second factor page of SetSecondFactor
+// for AAL2 if it's set, or to StepUp requesting the acr value of the level, and API clients get 401
+// with error "insufficient_user_authentication" (RFC 9470).
+func (s *OAuthSession) RequireAALF(isAPI bool, level AAL) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return s.SecuredF(isAPI)(func(w http.ResponseWriter, r *http.Request) {
+			sessionData, _ := GetRequestSessionData(r)
+			if sessionData.AAL >= level {
+				h(w, r)
+				return
+			}
+
+			if isAPI {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_user_authentication", acr_values="%s"`, level.ACR()))
+				s.unauthorized(w, r, ErrorAssuranceLevelTooLow)
+				return
+			}
+
+			if level == AAL2 && s.secondFactorPath != "" && sessionData.SecondFactorAt.IsZero() {
+				s.redirect(w, r, s.SecondFactorURL(r.RequestURI))
+				return
+			}
+
+			err := s.StepUp(w, r, []string{level.ACR()})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		})
+	}
+}
+
+// RequireAALH is a http middleware for http.Handler to check if the current user has authenticated
+// at the assurance level or higher.
+func (s *OAuthSession) RequireAALH(isAPI bool, level AAL) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.Handler(s.RequireAALF(isAPI, level)(h.ServeHTTP))
+	}
+}
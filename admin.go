@@ -0,0 +1,119 @@
+package osecure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// DefaultAdminPermission is the permission required by AdminHandler if not specified.
+const DefaultAdminPermission = "osecure:admin"
+
+// CacheStats are sizes and states of in-memory caches of the session, see AdminHandler.
+type CacheStats struct {
+	NegativeCacheEntries int  `json:"negative_cache_entries"` // see OAuthConfig.NegativeCacheTTL
+	RefreshedPermissions int  `json:"refreshed_permissions"`  // see OAuthConfig.PermissionRefreshAhead
+	FailOpenEntries      int  `json:"fail_open_entries"`      // see DegradationFailOpen
+	CircuitBreakerOpen   bool `json:"circuit_breaker_open"`
+
+	IntrospectionCache *TieredCacheStats `json:"introspection_cache,omitempty"` // see SetIntrospectionCache
+}
+
+// CacheStats gets the current CacheStats.
+func (s *OAuthSession) CacheStats() CacheStats {
+	stats := CacheStats{
+		NegativeCacheEntries: s.negativeCache.len(),
+		RefreshedPermissions: s.refreshedPermissions.len(),
+	}
+	if s.circuitBreaker != nil {
+		stats.FailOpenEntries = s.circuitBreaker.lastKnownLen()
+		stats.CircuitBreakerOpen = s.circuitBreaker.isOpen()
+	}
+	if s.introspectionCache != nil {
+		introspectionStats := s.introspectionCache.Stats()
+		stats.IntrospectionCache = &introspectionStats
+	}
+	return stats
+}
+
+// AdminHandler serves the admin API for ops, mountable under the prefix (e.g. "/admin/auth"),
+// to users with the permission (DefaultAdminPermission if empty):
+//
+//	GET    {prefix}/sessions?subject={user ID}     lists sessions in the session store, of all users if subject is empty
+//	DELETE {prefix}/sessions/{session ID}          revokes the session
+//	POST   {prefix}/subjects/{user ID}/revoke      revokes all sessions of the user, see RevokeSessionsForSubject
+//	GET    {prefix}/stats                          responds CacheStats
+//
+// Revocations are written to the audit sink as AuditEventSessionRevoke.
+func (s *OAuthSession) AdminHandler(prefix string, permission string) http.Handler {
+	if permission == "" {
+		permission = DefaultAdminPermission
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	authorizer := AuthorizerFunc(func(ctx context.Context, sessionData *AuthSessionData, attributes *RequestAttributes) (bool, error) {
+		return sessionData.HasPermission(permission), nil
+	})
+
+	return s.AuthorizedH(true, authorizer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		path := strings.Split(strings.TrimPrefix(r.URL.Path, prefix+"/"), "/")
+
+		switch {
+		case len(path) == 1 && path[0] == "sessions" && r.Method == http.MethodGet:
+			s.adminListSessions(w, r)
+		case len(path) == 2 && path[0] == "sessions" && path[1] != "" && r.Method == http.MethodDelete:
+			s.adminRevokeSession(w, r, path[1])
+		case len(path) == 3 && path[0] == "subjects" && path[1] != "" && path[2] == "revoke" && r.Method == http.MethodPost:
+			s.adminRevokeSubject(w, r, path[1])
+		case len(path) == 1 && path[0] == "stats" && r.Method == http.MethodGet:
+			writeAdminJSON(w, s.CacheStats())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func (s *OAuthSession) adminListSessions(w http.ResponseWriter, r *http.Request) {
+	records, err := s.ListSessions(r.Context(), r.URL.Query().Get("subject"))
+	if err != nil {
+		http.Error(w, err.Error(), ErrorStatusCode(err))
+		return
+	}
+	if records == nil {
+		records = []*SessionRecord{}
+	}
+	writeAdminJSON(w, records)
+}
+
+func (s *OAuthSession) adminRevokeSession(w http.ResponseWriter, r *http.Request, id string) {
+	err := s.RevokeSession(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), ErrorStatusCode(err))
+		return
+	}
+	sessionData, _ := GetRequestSessionData(r)
+	s.auditTarget(r, AuditEventSessionRevoke, sessionData, nil, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *OAuthSession) adminRevokeSubject(w http.ResponseWriter, r *http.Request, userID string) {
+	err := s.RevokeSessionsForSubject(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), ErrorStatusCode(err))
+		return
+	}
+	sessionData, _ := GetRequestSessionData(r)
+	s.auditTarget(r, AuditEventSessionRevoke, sessionData, nil, userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
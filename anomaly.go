@@ -0,0 +1,191 @@
+package osecure
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultMaxTravelSpeed is the max plausible travel speed (km/h) of users, about the speed of airliners.
+const DefaultMaxTravelSpeed = 1000
+
+// minTravelDistance is the distance (km) below which travel is never impossible, tolerating GeoIP inaccuracy.
+const minTravelDistance = 500
+
+// Types of Anomaly.
+const (
+	AnomalyCountryChange    = "country_change"    // the session is used from another country
+	AnomalyImpossibleTravel = "impossible_travel" // the session moved faster than MaxTravelSpeed
+	AnomalyUserAgentSwap    = "user_agent_swap"   // the session is used by another User-Agent
+)
+
+// GeoLocation is the location of an IP. Latitude and Longitude are zero if unknown.
+type GeoLocation struct {
+	Country   string
+	Latitude  float64
+	Longitude float64
+}
+
+// GeoLocateFunc looks up the location of the IP, e.g. by a GeoIP database.
+type GeoLocateFunc func(ctx context.Context, ip net.IP) (*GeoLocation, error)
+
+// ClientObservation is the client last seen using the session, kept in the session data.
+type ClientObservation struct {
+	NetworkHash   string // hash of the IP
+	UserAgentHash string
+	GeoLocation
+	At time.Time
+}
+
+// Anomaly is a suspicious change of the client of a session.
+type Anomaly struct {
+	Type     string
+	Previous ClientObservation
+	Current  ClientObservation
+}
+
+func (anomaly Anomaly) String() string {
+	return anomaly.Type
+}
+
+// AnomalyAction is the action on anomalies decided by AnomalyHandler.
+type AnomalyAction int
+
+const (
+	AnomalyAllow          AnomalyAction = iota
+	AnomalyAlert                        // allowed, writing an audit event of AuditEventAnomaly
+	AnomalyReauthenticate               // rejected with ErrorReauthenticationRequired after the audit event, users log in again
+)
+
+// AnomalyHandler decides the action on anomalies of the session, e.g. alerting security staff, and forcing
+// reauthentication for AnomalyImpossibleTravel only.
+type AnomalyHandler func(ctx context.Context, data *AuthSessionData, anomalies []Anomaly) AnomalyAction
+
+// AnomalyDetector detects anomalies of cookie sessions by comparing the client with the one last seen.
+type AnomalyDetector struct {
+	// Locate enables AnomalyCountryChange and AnomalyImpossibleTravel, which are not detected if nil.
+	// It's called only when the IP of the session changes.
+	Locate GeoLocateFunc
+
+	MaxTravelSpeed float64 // km/h, DefaultMaxTravelSpeed if zero
+
+	// Handler decides the action on anomalies, AnomalyAlert for all anomalies if nil.
+	Handler AnomalyHandler
+}
+
+// SetAnomalyDetector enables anomaly detection of cookie sessions. It should be called before serving requests.
+func (s *OAuthSession) SetAnomalyDetector(detector *AnomalyDetector) {
+	s.anomalyDetector = detector
+}
+
+func (detector *AnomalyDetector) maxTravelSpeed() float64 {
+	if detector.MaxTravelSpeed > 0 {
+		return detector.MaxTravelSpeed
+	}
+	return DefaultMaxTravelSpeed
+}
+
+// observe observes the client of the request from the IP, reusing the location of the previous observation
+// if the IP is the same.
+func (detector *AnomalyDetector) observe(r *http.Request, ip net.IP, previous ClientObservation) ClientObservation {
+	current := ClientObservation{
+		UserAgentHash: hashClientAttribute(r.UserAgent()),
+		At:            time.Now(),
+	}
+	if ip == nil {
+		return current
+	}
+
+	current.NetworkHash = hashClientAttribute(ip.String())
+	if current.NetworkHash == previous.NetworkHash {
+		current.GeoLocation = previous.GeoLocation
+	} else if detector.Locate != nil {
+		location, err := detector.Locate(r.Context(), ip)
+		if err == nil && location != nil {
+			current.GeoLocation = *location
+		}
+	}
+	return current
+}
+
+// detect compares the current client with the previous one.
+func (detector *AnomalyDetector) detect(previous ClientObservation, current ClientObservation) []Anomaly {
+	var anomalies []Anomaly
+	add := func(anomalyType string) {
+		anomalies = append(anomalies, Anomaly{Type: anomalyType, Previous: previous, Current: current})
+	}
+
+	if previous.UserAgentHash != "" && previous.UserAgentHash != current.UserAgentHash {
+		add(AnomalyUserAgentSwap)
+	}
+	if previous.Country != "" && current.Country != "" && !strings.EqualFold(previous.Country, current.Country) {
+		add(AnomalyCountryChange)
+	}
+	if hasCoordinates(previous.GeoLocation) && hasCoordinates(current.GeoLocation) {
+		distance := greatCircleDistance(previous.GeoLocation, current.GeoLocation)
+		hours := current.At.Sub(previous.At).Hours()
+		if distance > minTravelDistance && distance > detector.maxTravelSpeed()*hours {
+			add(AnomalyImpossibleTravel)
+		}
+	}
+	return anomalies
+}
+
+func hasCoordinates(location GeoLocation) bool {
+	return location.Latitude != 0 || location.Longitude != 0
+}
+
+// greatCircleDistance is the distance (km) between the locations by the haversine formula.
+func greatCircleDistance(a GeoLocation, b GeoLocation) float64 {
+	const earthRadius = 6371
+	toRadians := func(degrees float64) float64 {
+		return degrees * math.Pi / 180
+	}
+
+	dLatitude := toRadians(b.Latitude - a.Latitude)
+	dLongitude := toRadians(b.Longitude - a.Longitude)
+	h := math.Sin(dLatitude/2)*math.Sin(dLatitude/2) +
+		math.Cos(toRadians(a.Latitude))*math.Cos(toRadians(b.Latitude))*math.Sin(dLongitude/2)*math.Sin(dLongitude/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// checkAnomalies detects anomalies of the cookie session and applies the action of them,
+// returning true if the client observation of the session data is updated.
+func (s *OAuthSession) checkAnomalies(r *http.Request, data *AuthSessionData) (bool, error) {
+	detector := s.anomalyDetector
+	if detector == nil {
+		return false, nil
+	}
+
+	previous := data.LastSeen
+	current := detector.observe(r, s.clientIP(r), previous)
+
+	if !previous.At.IsZero() {
+		anomalies := detector.detect(previous, current)
+		if len(anomalies) > 0 {
+			action := AnomalyAlert
+			if detector.Handler != nil {
+				action = detector.Handler(r.Context(), data, anomalies)
+			}
+			switch action {
+			case AnomalyAlert:
+				s.audit(r, AuditEventAnomaly, data, fmt.Errorf("anomalies: %v", anomalies))
+			case AnomalyReauthenticate:
+				s.audit(r, AuditEventAnomaly, data, fmt.Errorf("%w: anomalies: %v", ErrorReauthenticationRequired, anomalies))
+				return false, WrapError(ErrorStringUnauthorized, ErrorReauthenticationRequired)
+			}
+		}
+	}
+
+	// the observation is saved when the client changes, or refreshed periodically for travel speeds
+	if current.NetworkHash == previous.NetworkHash && current.UserAgentHash == previous.UserAgentHash &&
+		current.At.Sub(previous.At) < slidingSessionRefreshInterval {
+		return false, nil
+	}
+	data.LastSeen = current
+	return true, nil
+}
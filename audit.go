@@ -0,0 +1,198 @@
+package osecure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Types of AuditEvent.
+const (
+	AuditEventLogin              = "login"
+	AuditEventLoginFailure       = "login_failure"
+	AuditEventLogout             = "logout"
+	AuditEventAccessDenied       = "access_denied"
+	AuditEventImpersonationStart = "impersonation_start"
+	AuditEventImpersonationEnd   = "impersonation_end"
+	AuditEventPermissionsRefresh = "permissions_refresh" // permissions of the cookie session are fetched again
+	AuditEventSessionRevoke      = "session_revoke"      // sessions are revoked by an admin, see AdminHandler
+	AuditEventSecondFactor       = "second_factor"       // the second factor is passed, see CompleteSecondFactor
+	AuditEventRememberMe         = "remember_me"         // the session is restored by the remember-me token, see RememberMe
+	AuditEventAnomaly            = "anomaly"             // anomalies of the session are detected, see AnomalyDetector
+)
+
+// AuditEvent is an entry of the audit trail.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	UserID     string    `json:"user_id,omitempty"`
+	ActorID    string    `json:"actor_id,omitempty"` // the real user during impersonation
+	ClientID   string    `json:"client_id,omitempty"`
+	SessionID  string    `json:"session_id,omitempty"`
+	Tenant     string    `json:"tenant,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Reason     string    `json:"reason,omitempty"` // error of failures and denials
+	Target     string    `json:"target,omitempty"` // session ID or user ID affected by admin actions
+}
+
+// AuditSink stores audit events, which are append-only.
+type AuditSink interface {
+	WriteAuditEvent(ctx context.Context, event *AuditEvent) error
+}
+
+// AuditSinkFunc is an adapter to use a function as AuditSink.
+type AuditSinkFunc func(ctx context.Context, event *AuditEvent) error
+
+// WriteAuditEvent calls f(ctx, event).
+func (f AuditSinkFunc) WriteAuditEvent(ctx context.Context, event *AuditEvent) error {
+	return f(ctx, event)
+}
+
+// SetAuditSink enables audit logging. Audit events are written synchronously, and errors of the sink
+// are passed to onError, which can be nil. It should be called before serving requests.
+func (s *OAuthSession) SetAuditSink(sink AuditSink, onError func(error)) {
+	s.auditSink = sink
+	s.onAuditError = onError
+}
+
+// audit writes the audit event of the request, with the user of data which can be nil.
+func (s *OAuthSession) audit(r *http.Request, eventType string, data *AuthSessionData, reason error) {
+	s.auditTarget(r, eventType, data, reason, "")
+}
+
+// auditTarget writes the event of an admin action on the target.
+func (s *OAuthSession) auditTarget(r *http.Request, eventType string, data *AuthSessionData, reason error, target string) {
+	if s.auditSink == nil {
+		return
+	}
+
+	event := &AuditEvent{
+		Time:       time.Now(),
+		Type:       eventType,
+		Tenant:     s.tenant,
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Target:     target,
+	}
+	if data != nil {
+		event.UserID = data.UserID
+		event.ActorID = data.ActorID
+		event.ClientID = data.ClientID
+		if data.AuthSessionCookieData != nil {
+			event.SessionID = data.SessionID
+		}
+	}
+	if reason != nil {
+		event.Reason = reason.Error()
+	}
+
+	err := s.auditSink.WriteAuditEvent(r.Context(), event)
+	if err != nil && s.onAuditError != nil {
+		s.onAuditError(err)
+	}
+}
+
+// auditLogout writes the logout event with the user of the session cookie,
+// which is identified by session ID only if its token can't be introspected any more.
+func (s *OAuthSession) auditLogout(r *http.Request) {
+	if s.auditSink == nil {
+		return
+	}
+
+	data, _, err := s.getAuthSessionDataFromRequest(r)
+	if err != nil {
+		data = nil
+		if cookieData := s.retrieveAuthCookie(r); cookieData != nil {
+			data = &AuthSessionData{AuthSessionCookieData: cookieData}
+		}
+	}
+	s.audit(r, AuditEventLogout, data, nil)
+}
+
+// JSONFileAuditSink appends audit events to a file as JSON lines.
+type JSONFileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewJSONFileAuditSink opens the file to append audit events, creating it if it doesn't exist.
+func NewJSONFileAuditSink(path string) (*JSONFileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFileAuditSink{file: file}, nil
+}
+
+// WriteAuditEvent appends the event as a line, which is synced to disk before returning.
+func (sink *JSONFileAuditSink) WriteAuditEvent(ctx context.Context, event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	_, err = sink.file.Write(line)
+	if err != nil {
+		return err
+	}
+	return sink.file.Sync()
+}
+
+// Close closes the file.
+func (sink *JSONFileAuditSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.file.Close()
+}
+
+// HTTPAuditSink posts audit events as JSON to the URL, e.g. a log collector.
+type HTTPAuditSink struct {
+	URL    string
+	Header http.Header  // extra headers, e.g. Authorization
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// WriteAuditEvent posts the event, any status other than 2xx fails.
+func (sink *HTTPAuditSink) WriteAuditEvent(ctx context.Context, event *AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range sink.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := sink.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package osecure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"golang.org/x/oauth2"
+)
+
+// reservedAuthParams are parameters set by osecure or oauth2, which can't be configured by
+// OAuthConfig.AuthCodeParams or OAuthConfig.ExchangeParams.
+var reservedAuthParams = NewStringSet([]string{
+	"client_id", "client_secret", "redirect_uri", "response_type", "scope", "state", "code", "grant_type",
+})
+
+func validateAuthParams(errs *ConfigErrors, field string, params map[string]string) {
+	for name := range params {
+		if name == "" || reservedAuthParams.Contain(name) {
+			errs.add(field, fmt.Sprintf("parameter %q can't be configured", name), nil)
+		}
+	}
+}
+
+// authParamOptions converts parameters to options in order of names, so URLs are stable.
+func authParamOptions(params map[string]string) []oauth2.AuthCodeOption {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	opts := make([]oauth2.AuthCodeOption, len(names))
+	for i, name := range names {
+		opts[i] = oauth2.SetAuthURLParam(name, params[name])
+	}
+	return opts
+}
+
+// WithAuthCodeOptions attaches options of the authorization request to the request,
+// e.g. oauth2.SetAuthURLParam("login_hint", email), applied by StartOAuth after OAuthConfig.AuthCodeParams,
+// so they override parameters of the same names.
+func WithAuthCodeOptions(r *http.Request, opts ...oauth2.AuthCodeOption) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKeyAuthCodeOptions, opts))
+}
+
+// WithExchangeOptions attaches options of the token request to the callback request,
+// e.g. oauth2.SetAuthURLParam("resource", uri), applied by EndOAuth after OAuthConfig.ExchangeParams.
+func WithExchangeOptions(r *http.Request, opts ...oauth2.AuthCodeOption) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKeyExchangeOptions, opts))
+}
+
+// authCodeOptions are the options of the authorization request, followed by the options given by callers
+// like StepUp, which take precedence.
+func (s *OAuthSession) authCodeOptions(r *http.Request, opts ...oauth2.AuthCodeOption) []oauth2.AuthCodeOption {
+	requestOpts, _ := r.Context().Value(contextKeyAuthCodeOptions).([]oauth2.AuthCodeOption)
+	return concatAuthCodeOptions(s.authCodeParams, requestOpts, opts)
+}
+
+func (s *OAuthSession) exchangeOptions(r *http.Request) []oauth2.AuthCodeOption {
+	requestOpts, _ := r.Context().Value(contextKeyExchangeOptions).([]oauth2.AuthCodeOption)
+	return concatAuthCodeOptions(s.resourceExchangeOptions(), s.exchangeParams, requestOpts)
+}
+
+func concatAuthCodeOptions(lists ...[]oauth2.AuthCodeOption) []oauth2.AuthCodeOption {
+	var all []oauth2.AuthCodeOption
+	for _, opts := range lists {
+		all = append(all, opts...)
+	}
+	return all
+}
@@ -0,0 +1,156 @@
+package osecure
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Authenticator authenticates requests carrying credentials other than cookie and OAuth token,
+// letting one middleware stack protect both browser users and programmatic clients.
+// Authenticate returns nil session data and nil error if the request has no credential it handles.
+// The returned session data should have permissions filled, they are not fetched by GetPermissionsFunc.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*AuthSessionData, error)
+}
+
+// AuthenticatorFunc is an adapter to use ordinary function as Authenticator.
+type AuthenticatorFunc func(r *http.Request) (*AuthSessionData, error)
+
+// Authenticate calls f(r).
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*AuthSessionData, error) {
+	return f(r)
+}
+
+// AddAuthenticator adds an authenticator, which is tried before cookie and bearer token in the order added.
+// Requests authenticated by it are checked like bearer tokens, by the rate limiter, the brute-force detector,
+// the network policy and the second factor. It should be called before serving requests.
+func (s *OAuthSession) AddAuthenticator(authenticator Authenticator) {
+	s.authenticators = append(s.authenticators, authenticator)
+}
+
+// authenticate tries the authenticators in order. Session data returned by them is cloned,
+// since they may return the same data to concurrent requests.
+func (s *OAuthSession) authenticate(r *http.Request) (*AuthSessionData, error) {
+	for _, authenticator := range s.authenticators {
+		data, err := authenticator.Authenticate(r)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			data = data.Clone()
+			data.Tenant = s.tenant
+			return data, nil
+		}
+	}
+	return nil, nil
+}
+
+// verifyAuthenticators authenticates the request by the authenticators, with the checks of bearer tokens:
+// rate limiting and counting failed attempts, the network policy and the second factor.
+// It returns nil session data and nil error if no authenticator handles the request.
+func (s *OAuthSession) verifyAuthenticators(r *http.Request) (*AuthSessionData, error) {
+	data, err := s.authenticate(r)
+	if data == nil && err == nil {
+		return nil, nil
+	}
+
+	lockErr := s.checkIPRateLimit(r)
+	if lockErr != nil {
+		return nil, WrapError(ErrorStringUnauthorized, lockErr)
+	}
+	if err != nil {
+		if isCredentialFailure(err) {
+			s.recordFailure(r, "")
+		}
+		return nil, WrapError(ErrorStringUnauthorized, err)
+	}
+
+	err = s.checkSubjectRateLimit(r.Context(), data.UserID)
+	if err != nil {
+		return nil, WrapError(ErrorStringUnauthorized, err)
+	}
+	if data.isTokenExpired(s.clockSkew) || data.isSessionExpired(s.clockSkew) {
+		return nil, WrapError(ErrorStringUnauthorized, ErrorInvalidSession)
+	}
+	err = s.checkNetwork(r, data)
+	if err != nil {
+		return nil, err
+	}
+	s.resetBruteForce(r, data.UserID)
+
+	data.AAL = s.assuranceLevel(data)
+	if s.IsSecondFactorPending(data) {
+		return nil, WrapError(ErrorStringUnauthorized, ErrorSecondFactorRequired)
+	}
+	return data, nil
+}
+
+// isCredentialFailure checks if the error of authenticator is caused by the credential,
+// rather than failing to get permissions or unavailable verifiers.
+func isCredentialFailure(err error) bool {
+	return !CompareErrorMessage(err, ErrorStringCannotGetPermission) && !IsVerifierUnavailable(err)
+}
+
+// DefaultAPIKeyHeader is the header carrying API key.
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// APIKeyTokenType is the token type of session data authenticated by API key.
+const APIKeyTokenType = "APIKey"
+
+// APIKeyVerifier looks up the user and permissions of API key.
+// It returns ErrorInvalidAPIKey (or any other error) if the API key is unknown.
+type APIKeyVerifier func(ctx context.Context, apiKey string) (userID string, permissions []string, err error)
+
+// NewAPIKeyAuthenticator creates authenticator for API key in the header (DefaultAPIKeyHeader if empty).
+// The session data has the user ID as both user ID and client ID, like service accounts,
+// and the API key is not exposed in it.
+func NewAPIKeyAuthenticator(header string, verifier APIKeyVerifier) Authenticator {
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+
+	return AuthenticatorFunc(func(r *http.Request) (*AuthSessionData, error) {
+		apiKey := r.Header.Get(header)
+		if apiKey == "" {
+			return nil, nil
+		}
+
+		userID, permissions, err := verifier(r.Context(), apiKey)
+		if err != nil {
+			return nil, WrapError(ErrorStringInvalidAPIKey, err)
+		}
+
+		expiresAt := time.Now().Add(DefaultPermissionExpireTime)
+		token := &oauth2.Token{TokenType: APIKeyTokenType, Expiry: expiresAt}
+		return NewAuthSessionData(userID, userID, token, permissions, expiresAt), nil
+	})
+}
+
+// APIKeyIdentity is the user and permissions of API key.
+type APIKeyIdentity struct {
+	UserID      string
+	Permissions []string
+}
+
+// NewStaticAPIKeyVerifier creates APIKeyVerifier from a fixed table of API keys.
+func NewStaticAPIKeyVerifier(apiKeys map[string]APIKeyIdentity) APIKeyVerifier {
+	// index by digest to not keep the keys themselves
+	identities := make(map[[sha256.Size]byte]APIKeyIdentity)
+	for apiKey, identity := range apiKeys {
+		permissions := make([]string, len(identity.Permissions))
+		copy(permissions, identity.Permissions)
+		identities[sha256.Sum256([]byte(apiKey))] = APIKeyIdentity{UserID: identity.UserID, Permissions: permissions}
+	}
+
+	return func(ctx context.Context, apiKey string) (string, []string, error) {
+		identity, found := identities[sha256.Sum256([]byte(apiKey))]
+		if !found {
+			return "", nil, ErrorInvalidAPIKey
+		}
+		return identity.UserID, identity.Permissions, nil
+	}
+}
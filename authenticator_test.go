@@ -0,0 +1,99 @@
+package osecure
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newAPIKeyTestSession(t *testing.T) *OAuthSession {
+	t.Helper()
+	s := newTestSession(t, newTestVerifier(nil))
+	s.AddAuthenticator(NewAPIKeyAuthenticator("", NewStaticAPIKeyVerifier(map[string]APIKeyIdentity{
+		"good-key": {UserID: "robot", Permissions: []string{"admin"}},
+	})))
+	return s
+}
+
+func newAPIKeyRequest(apiKey string, remoteAddr string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	r.Header.Set(DefaultAPIKeyHeader, apiKey)
+	return r
+}
+
+func TestAuthenticatorRateLimit(t *testing.T) {
+	s := newAPIKeyTestSession(t)
+	s.SetRateLimiter(NewRateLimiter(3, 0, time.Minute))
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Verify(newAPIKeyRequest("guessed-key", "192.0.2.1:1234"))
+		if !CompareErrorMessage(err, ErrorStringUnauthorized) || errors.Is(err, ErrorTooManyFailures) {
+			t.Fatalf("attempt %d: got %v", i, err)
+		}
+	}
+
+	// the client is locked out, even with the right key
+	_, err := s.Verify(newAPIKeyRequest("good-key", "192.0.2.1:1234"))
+	if !errors.Is(err, ErrorTooManyFailures) {
+		t.Fatalf("locked out client: got %v, want %v", err, ErrorTooManyFailures)
+	}
+
+	data, err := s.Verify(newAPIKeyRequest("good-key", "192.0.2.2:1234"))
+	if err != nil || data.UserID != "robot" {
+		t.Fatalf("other client: got %v, %v", data, err)
+	}
+}
+
+func TestAuthenticatorBruteForce(t *testing.T) {
+	s := newAPIKeyTestSession(t)
+	var detected []*BruteForceEvent
+	s.SetBruteForceDetector(NewBruteForceDetector(2, func(r *http.Request, event *BruteForceEvent) {
+		detected = append(detected, event)
+	}))
+
+	for i := 0; i < 2; i++ {
+		s.Verify(newAPIKeyRequest("guessed-key", "192.0.2.1:1234"))
+	}
+	if len(detected) != 1 || detected[0].IP != "192.0.2.1" {
+		t.Fatalf("brute force of API keys: detected %v", detected)
+	}
+}
+
+func TestAuthenticatorNetworkPolicy(t *testing.T) {
+	s := newAPIKeyTestSession(t)
+	s.SetNetworkPolicy(NetworkPolicyFunc(func(ctx context.Context, data *AuthSessionData, ip net.IP) NetworkDecision {
+		if ip.Equal(net.ParseIP("192.0.2.1")) {
+			return NetworkAllow
+		}
+		return NetworkReject
+	}))
+
+	_, err := s.Verify(newAPIKeyRequest("good-key", "192.0.2.1:1234"))
+	if err != nil {
+		t.Fatalf("allowed network: %v", err)
+	}
+	_, err = s.Verify(newAPIKeyRequest("good-key", "198.51.100.1:1234"))
+	if !errors.Is(err, ErrorNetworkNotAllowed) {
+		t.Fatalf("denied network: got %v, want %v", err, ErrorNetworkNotAllowed)
+	}
+}
+
+func TestAuthenticatorSecondFactor(t *testing.T) {
+	s := newTestSession(t, newTestVerifier(nil))
+	s.SetSecondFactor("/2fa")
+	s.AddAuthenticator(AuthenticatorFunc(func(r *http.Request) (*AuthSessionData, error) {
+		data := NewAuthSessionData("alice", testClientID, makeBearerToken("token", time.Now().Add(time.Hour).Unix()), nil, time.Now().Add(time.Hour))
+		data.AuthTime = time.Now()
+		return data, nil
+	}))
+
+	_, err := s.Verify(httptest.NewRequest(http.MethodGet, "/", nil))
+	if !errors.Is(err, ErrorSecondFactorRequired) {
+		t.Fatalf("got %v, want %v", err, ErrorSecondFactorRequired)
+	}
+}
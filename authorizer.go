@@ -0,0 +1,74 @@
+package osecure
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// RequestAttributes are the attributes of a request an Authorizer makes decision on.
+type RequestAttributes struct {
+	Method     string
+	Host       string
+	Path       string
+	Query      url.Values
+	Header     http.Header
+	RemoteAddr string
+}
+
+// NewRequestAttributes collects the attributes of the request.
+func NewRequestAttributes(r *http.Request) *RequestAttributes {
+	return &RequestAttributes{
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Header:     r.Header,
+		RemoteAddr: r.RemoteAddr,
+	}
+}
+
+// Authorizer decides if the session is allowed to perform the request.
+// It's the extension point for policy engines like OPA or Casbin.
+type Authorizer interface {
+	IsAllowed(ctx context.Context, sessionData *AuthSessionData, attributes *RequestAttributes) (bool, error)
+}
+
+// AuthorizerFunc is an adapter to use ordinary function as Authorizer.
+type AuthorizerFunc func(ctx context.Context, sessionData *AuthSessionData, attributes *RequestAttributes) (bool, error)
+
+// IsAllowed calls f(ctx, sessionData, attributes).
+func (f AuthorizerFunc) IsAllowed(ctx context.Context, sessionData *AuthSessionData, attributes *RequestAttributes) (bool, error) {
+	return f(ctx, sessionData, attributes)
+}
+
+// AuthorizedF is a http middleware for http.HandlerFunc to check if the current user has logged in
+// and is allowed by the authorizer.
+func (s *OAuthSession) AuthorizedF(isAPI bool, authorizer Authorizer) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return s.SecuredF(isAPI)(func(w http.ResponseWriter, r *http.Request) {
+			sessionData, _ := GetRequestSessionData(r)
+
+			allowed, err := authorizer.IsAllowed(r.Context(), sessionData, NewRequestAttributes(r))
+			if err != nil {
+				http.Error(w, WrapError(ErrorStringCannotAuthorize, err).Error(), http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				s.audit(r, AuditEventAccessDenied, sessionData, ErrorAccessDenied)
+				s.forbidden(w, r, ErrorAccessDenied)
+				return
+			}
+
+			h(w, r)
+		})
+	}
+}
+
+// AuthorizedH is a http middleware for http.Handler to check if the current user has logged in
+// and is allowed by the authorizer.
+func (s *OAuthSession) AuthorizedH(isAPI bool, authorizer Authorizer) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.Handler(s.AuthorizedF(isAPI, authorizer)(h.ServeHTTP))
+	}
+}
@@ -0,0 +1,187 @@
+// Package osecure/aws_sigv4 provides AWS Signature Version 4 signing of http requests.
+package aws_sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Algorithm  = "AWS4-HMAC-SHA256"
+	TimeFormat = "20060102T150405Z"
+	DateFormat = "20060102"
+)
+
+var (
+	ErrorMissingCredentials = errors.New("missing AWS credentials")
+)
+
+// Credentials is AWS access key, with SessionToken for temporary credentials.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// CredentialsFromEnv reads credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+func CredentialsFromEnv() (Credentials, error) {
+	creds := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return Credentials{}, ErrorMissingCredentials
+	}
+	return creds, nil
+}
+
+// HashPayload is the hex encoded SHA-256 of the payload.
+func HashPayload(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Scope is the credential scope of the signature.
+func Scope(t time.Time, region string, service string) string {
+	return t.UTC().Format(DateFormat) + "/" + region + "/" + service + "/aws4_request"
+}
+
+// SignRequest signs the request with body as payload, setting X-Amz-Date, X-Amz-Security-Token and Authorization headers.
+func SignRequest(req *http.Request, body []byte, creds Credentials, region string, service string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(TimeFormat))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	signedHeaders := []string{"host", "x-amz-date"}
+	if creds.SessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+	if req.Header.Get("Content-Type") != "" {
+		signedHeaders = append(signedHeaders, "content-type")
+	}
+	if req.Header.Get("X-Amz-Target") != "" {
+		signedHeaders = append(signedHeaders, "x-amz-target")
+	}
+	sort.Strings(signedHeaders)
+
+	scope := Scope(now, region, service)
+	canonicalRequest := CanonicalRequest(req, signedHeaders, HashPayload(body))
+	signature := Signature(creds.SecretAccessKey, now, region, service, StringToSign(now, scope, canonicalRequest))
+
+	req.Header.Set("Authorization", Algorithm+" Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+", Signature="+signature)
+}
+
+// PresignRequest signs the request in the query of its URL, which is valid for expires (at most 7 days) with no payload.
+// The headers (lower case) are signed besides host, which must be sent along with the presigned URL.
+func PresignRequest(req *http.Request, creds Credentials, region string, service string, expires time.Duration, headers []string, now time.Time) {
+	now = now.UTC()
+	signedHeaders := append([]string{"host"}, headers...)
+	sort.Strings(signedHeaders)
+	scope := Scope(now, region, service)
+
+	query := req.URL.Query()
+	query.Set("X-Amz-Algorithm", Algorithm)
+	query.Set("X-Amz-Credential", creds.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", now.Format(TimeFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", strings.Join(signedHeaders, ";"))
+	if creds.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	query.Del("X-Amz-Signature")
+	req.URL.RawQuery = canonicalQuery(query)
+
+	canonicalRequest := CanonicalRequest(req, signedHeaders, HashPayload(nil))
+	signature := Signature(creds.SecretAccessKey, now, region, service, StringToSign(now, scope, canonicalRequest))
+	req.URL.RawQuery += "&X-Amz-Signature=" + signature
+}
+
+// CanonicalRequest builds the canonical request of the signed headers, which are lower case and sorted.
+func CanonicalRequest(req *http.Request, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		var value string
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		} else {
+			value = strings.Join(req.Header[http.CanonicalHeaderKey(name)], ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape is URI encoding of SigV4, which escapes everything but unreserved characters.
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// StringToSign builds the string to sign of the canonical request.
+func StringToSign(t time.Time, scope string, canonicalRequest string) string {
+	return Algorithm + "\n" + t.UTC().Format(TimeFormat) + "\n" + scope + "\n" + HashPayload([]byte(canonicalRequest))
+}
+
+// Signature signs the string to sign with the key derived from the secret access key.
+func Signature(secretAccessKey string, t time.Time, region string, service string, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), t.UTC().Format(DateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
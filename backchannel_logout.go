@@ -0,0 +1,134 @@
+package osecure
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rayark/osecure/v6/jwt"
+)
+
+// BackChannelLogoutEvent is the event type of logout tokens in OpenID Connect Back-Channel Logout.
+const BackChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+func (s *OAuthSession) validateLogoutToken(r *http.Request, keySet jwt.KeySet, rawToken string) (jwt.Claims, error) {
+	claims, err := s.validateEventToken(r, keySet, rawToken, BackChannelLogoutEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.String("sub") == "" && claims.String("sid") == "" {
+		return nil, ErrorInvalidLogoutToken
+	}
+	if claims.Has("nonce") {
+		return nil, ErrorInvalidLogoutToken
+	}
+
+	return claims, nil
+}
+
+// validateEventToken validates a security event token (RFC 8417) of the event, issued to this client.
+func (s *OAuthSession) validateEventToken(r *http.Request, keySet jwt.KeySet, rawToken string, event string) (jwt.Claims, error) {
+	token, err := jwt.Parse(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = token.VerifyWithKeySet(r.Context(), keySet)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims
+	err = claims.Validate(jwt.Expected{
+		Issuer:    s.issuer,
+		Audiences: []string{s.clientID},
+		Leeway:    s.clockSkew,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.Has("iat") {
+		return nil, ErrorInvalidEventToken
+	}
+	if _, ok := claims.Map("events")[event]; !ok {
+		return nil, ErrorInvalidEventToken
+	}
+
+	return claims, nil
+}
+
+func writeBackChannelLogoutError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             "invalid_request",
+		"error_description": err.Error(),
+	})
+}
+
+// BackChannelLogoutHandler is a http handler receiving logout tokens of OpenID Connect Back-Channel Logout.
+// Logout tokens are verified with keys of the OpenID provider, and the sessions of the token's
+// "sid" (or "sub" if "sid" is absent) are deleted from the session store.
+// Remember-me tokens of the logged out users are deleted too, since the provider session has ended.
+// It requires SetSessionStore, and OAuthConfig.Issuer is strongly recommended.
+func (s *OAuthSession) BackChannelLogoutHandler(keySet jwt.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.sessionStore == nil {
+			http.Error(w, ErrorSessionStoreRequired.Error(), http.StatusNotImplemented)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := s.validateLogoutToken(r, keySet, r.PostFormValue("logout_token"))
+		if err != nil {
+			writeBackChannelLogoutError(w, WrapError(ErrorStringInvalidLogoutToken, err))
+			return
+		}
+
+		query := SessionQuery{
+			UserID:            claims.String("sub"),
+			ProviderSessionID: claims.String("sid"),
+		}
+		if query.ProviderSessionID != "" {
+			// sub and sid together identify a single provider session
+			query.UserID = ""
+		}
+
+		records, err := s.sessionStore.Find(r.Context(), query)
+		if err == nil {
+			for _, record := range records {
+				err = s.sessionStore.Delete(r.Context(), record.ID)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err == nil && s.rememberMeStore != nil {
+			userIDs := map[string]bool{}
+			if sub := claims.String("sub"); sub != "" {
+				userIDs[sub] = true
+			}
+			for _, record := range records {
+				userIDs[record.UserID] = true
+			}
+			for userID := range userIDs {
+				err = s.rememberMeStore.DeleteByUser(r.Context(), userID)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	}
+}
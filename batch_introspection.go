@@ -0,0 +1,93 @@
+package osecure
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultBatchConcurrency is the default number of tokens verified concurrently by BatchIntrospect.
+const DefaultBatchConcurrency = 8
+
+// TokenVerification is the result of a token verified by BatchIntrospect.
+type TokenVerification struct {
+	AccessToken string
+	Data        *AuthSessionData // nil if failed
+	Err         error
+}
+
+// BatchIntrospect verifies many access tokens like VerifyAccessToken, e.g. to reprocess queued jobs carrying user tokens,
+// with at most concurrency (DefaultBatchConcurrency if not positive) introspections and permission fetches at a time.
+// Tokens are introspected at once if the TokenVerifier has BatchIntrospectTokenFunc.
+// Results are in the same order of the tokens, each with its own error, so some tokens can fail while others pass.
+func (s *OAuthSession) BatchIntrospect(ctx context.Context, accessTokens []string, concurrency int) []TokenVerification {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	results := make([]TokenVerification, len(accessTokens))
+	for i, accessToken := range accessTokens {
+		results[i].AccessToken = accessToken
+	}
+
+	var introspections []IntrospectionResult
+	if s.tokenVerifier.BatchIntrospectTokenFunc != nil {
+		var err error
+		introspections, err = s.tokenVerifier.BatchIntrospectTokenFunc(ctx, accessTokens)
+		if err == nil && len(introspections) != len(accessTokens) {
+			err = fmt.Errorf("got %d results of %d tokens", len(introspections), len(accessTokens))
+		}
+		if err != nil {
+			err = RedactError(err, accessTokens...)
+			for i := range results {
+				results[i].Err = WrapError(ErrorStringUnauthorized, WrapError(ErrorStringCannotIntrospectToken, err))
+			}
+			return results
+		}
+	}
+
+	var wg sync.WaitGroup
+	tokens := make(chan int)
+	for n := 0; n < concurrency && n < len(accessTokens); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tokens {
+				if introspections != nil {
+					results[i].Data, results[i].Err = s.verifyIntrospectedToken(ctx, accessTokens[i], &introspections[i])
+				} else {
+					results[i].Data, results[i].Err = s.VerifyAccessToken(ctx, accessTokens[i])
+				}
+			}
+		}()
+	}
+	for i := range accessTokens {
+		select {
+		case tokens <- i:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+		}
+	}
+	close(tokens)
+	wg.Wait()
+
+	return results
+}
+
+// verifyIntrospectedToken finishes VerifyAccessToken with the result of batch introspection.
+func (s *OAuthSession) verifyIntrospectedToken(ctx context.Context, accessToken string, introspection *IntrospectionResult) (*AuthSessionData, error) {
+	if introspection.Err != nil {
+		return nil, WrapError(ErrorStringUnauthorized, WrapError(ErrorStringCannotIntrospectToken, RedactError(introspection.Err, accessToken)))
+	}
+
+	data, err := s.checkIntrospection(accessToken, introspection)
+	if err != nil {
+		return nil, WrapError(ErrorStringUnauthorized, err)
+	}
+
+	_, err = s.ensurePermUpdated(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
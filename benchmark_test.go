@@ -0,0 +1,141 @@
+package osecure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func benchmarkPermissions(n int) []string {
+	permissions := make([]string, n)
+	for i := range permissions {
+		permissions[i] = fmt.Sprintf("repo:project-%d:read", i)
+	}
+	return permissions
+}
+
+func newBenchmarkCookieData(permissions []string) *AuthSessionCookieData {
+	now := time.Now()
+	return &AuthSessionCookieData{
+		Token:                &oauth2.Token{AccessToken: "access-token", TokenType: "Bearer", Expiry: now.Add(time.Hour)},
+		Permissions:          NewPermissionSet(permissions),
+		PermissionsExpiresAt: now.Add(10 * time.Minute),
+		SessionCreatedAt:     now,
+	}
+}
+
+func BenchmarkSerializeAuthCookieData(b *testing.B) {
+	for _, n := range []int{10, 1000} {
+		b.Run(fmt.Sprintf("permissions=%d", n), func(b *testing.B) {
+			cookieData := newBenchmarkCookieData(benchmarkPermissions(n))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := serializeAuthCookieData(cookieData)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDeserializeAuthCookieData(b *testing.B) {
+	for _, n := range []int{10, 1000} {
+		b.Run(fmt.Sprintf("permissions=%d", n), func(b *testing.B) {
+			data, err := serializeAuthCookieData(newBenchmarkCookieData(benchmarkPermissions(n)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := deserializeAuthCookieData(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func newCachedIntrospectionSession(b *testing.B) *OAuthSession {
+	s := newTestSession(b, newTestVerifier(benchmarkPermissions(10)))
+	cache, err := NewTieredCache(context.Background(), TieredCacheConfig{}, nil, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	s.SetIntrospectionCache(cache)
+	return s
+}
+
+func BenchmarkIntrospectTokenCached(b *testing.B) {
+	s := newCachedIntrospectionSession(b)
+	ctx := context.Background()
+	_, _, _, _, err := s.introspectToken(ctx, "user")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, _, _, err := s.introspectToken(ctx, "user")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifyBearerTokenCached(b *testing.B) {
+	s := newCachedIntrospectionSession(b)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer user")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.Verify(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHasPermission(b *testing.B) {
+	for _, n := range []int{10, 10000} {
+		permissions := benchmarkPermissions(n)
+		cookieData := newBenchmarkCookieData(permissions)
+		wildcardData := newBenchmarkCookieData(append(permissions[:n-1:n-1], "repo:*:write"))
+
+		b.Run(fmt.Sprintf("granted/permissions=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !cookieData.HasPermission(permissions[n/2]) {
+					b.Fatal("permission not granted")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("denied/permissions=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if cookieData.HasPermission("repo:other:write") {
+					b.Fatal("permission granted")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("wildcard/permissions=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !wildcardData.HasPermission("repo:other:write") {
+					b.Fatal("permission not granted")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package osecure
+
+import (
+	"net/http"
+	"time"
+)
+
+// BruteForceEvent describes consecutive verification failures found by BruteForceDetector.
+type BruteForceEvent struct {
+	IP       string // the client IP, empty for failures of the subject
+	UserID   string // the subject, empty for failures of the IP
+	Failures int
+}
+
+// BruteForceDetector calls OnDetected after every Threshold consecutive verification failures
+// (bearer token verification and CallbackView) from the same IP or for the same subject,
+// so applications can alert or block. Successful verification resets the counters,
+// which are kept in the store for Window since the first failure.
+type BruteForceDetector struct {
+	Store      RateLimitStore
+	Threshold  int
+	Window     time.Duration // DefaultRateLimitWindow if zero
+	OnDetected func(r *http.Request, event *BruteForceEvent)
+}
+
+// NewBruteForceDetector creates BruteForceDetector with MemoryRateLimitStore.
+func NewBruteForceDetector(threshold int, onDetected func(r *http.Request, event *BruteForceEvent)) *BruteForceDetector {
+	return &BruteForceDetector{
+		Store:      NewMemoryRateLimitStore(),
+		Threshold:  threshold,
+		OnDetected: onDetected,
+	}
+}
+
+// SetBruteForceDetector enables brute-force detection, the store can be shared with RateLimiter.
+// It should be called before serving requests.
+func (s *OAuthSession) SetBruteForceDetector(detector *BruteForceDetector) {
+	s.bruteForceDetector = detector
+}
+
+func (detector *BruteForceDetector) add(r *http.Request, key string, event *BruteForceEvent) {
+	window := detector.Window
+	if window == 0 {
+		window = DefaultRateLimitWindow
+	}
+
+	count, err := detector.Store.Add(r.Context(), key, window)
+	if err != nil || detector.Threshold <= 0 || count%detector.Threshold != 0 {
+		return
+	}
+	event.Failures = count
+	detector.OnDetected(r, event)
+}
+
+// detectBruteForce counts the consecutive failure of the client, and of the user if userID isn't empty.
+func (s *OAuthSession) detectBruteForce(r *http.Request, userID string) {
+	detector := s.bruteForceDetector
+	if detector == nil {
+		return
+	}
+
+	ip := s.rateLimitIPKey(r)
+	detector.add(r, "bf:"+ip, &BruteForceEvent{IP: ip[len("ip:"):]})
+	if userID != "" {
+		detector.add(r, "bf:"+rateLimitSubjectKey(userID), &BruteForceEvent{UserID: userID})
+	}
+}
+
+// resetBruteForce resets the consecutive failures of the client and the user after successful verification.
+func (s *OAuthSession) resetBruteForce(r *http.Request, userID string) {
+	detector := s.bruteForceDetector
+	if detector == nil {
+		return
+	}
+
+	detector.Store.Reset(r.Context(), "bf:"+s.rateLimitIPKey(r))
+	if userID != "" {
+		detector.Store.Reset(r.Context(), "bf:"+rateLimitSubjectKey(userID))
+	}
+}
@@ -0,0 +1,55 @@
+package osecure
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBruteForceDetector(t *testing.T) {
+	s := newTestSession(t, newFailingTestVerifier())
+	var events []BruteForceEvent
+	s.SetBruteForceDetector(NewBruteForceDetector(2, func(r *http.Request, event *BruteForceEvent) {
+		events = append(events, *event)
+	}))
+
+	verifyBearer(s, "invalid", "192.0.2.1:1234")
+	if len(events) != 0 {
+		t.Fatalf("detected after one failure: %+v", events)
+	}
+	verifyBearer(s, "other-client-alice", "192.0.2.1:1234")
+	if len(events) != 1 || events[0] != (BruteForceEvent{IP: "192.0.2.1", Failures: 2}) {
+		t.Fatalf("got %+v, want the client after two failures", events)
+	}
+
+	// failures of the user from other clients
+	verifyBearer(s, "other-client-alice", "192.0.2.2:1234")
+	if len(events) != 2 || events[1] != (BruteForceEvent{UserID: "alice", Failures: 2}) {
+		t.Fatalf("got %+v, want the user after two failures", events)
+	}
+
+	// detected again every threshold failures
+	verifyBearer(s, "invalid", "192.0.2.1:1234")
+	verifyBearer(s, "invalid", "192.0.2.1:1234")
+	if len(events) != 3 || events[2] != (BruteForceEvent{IP: "192.0.2.1", Failures: 4}) {
+		t.Fatalf("got %+v, want the client after four failures", events)
+	}
+}
+
+func TestBruteForceDetectorReset(t *testing.T) {
+	s := newTestSession(t, newFailingTestVerifier())
+	detected := 0
+	s.SetBruteForceDetector(NewBruteForceDetector(2, func(r *http.Request, event *BruteForceEvent) {
+		detected++
+	}))
+
+	// failures which aren't consecutive
+	for i := 0; i < 3; i++ {
+		verifyBearer(s, "other-client-alice", "192.0.2.1:1234")
+		if err := verifyBearer(s, "alice", "192.0.2.1:1234"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if detected != 0 {
+		t.Errorf("detected %d times, want none", detected)
+	}
+}
@@ -0,0 +1,104 @@
+// Package osecure/casbin_adapter provides authorizer which enforces Casbin policies.
+package casbin_adapter
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/rayark/osecure/v6"
+)
+
+// DefaultRolePrefix is the prefix of role subjects, so a permission can't be mistaken for the user of the same ID.
+const DefaultRolePrefix = "role:"
+
+// Enforcer is the part of Casbin enforcer used by the adapter.
+// It's satisfied by *casbin.Enforcer, *casbin.CachedEnforcer and *casbin.SyncedEnforcer.
+type Enforcer interface {
+	Enforce(rvals ...interface{}) (bool, error)
+}
+
+// RequestFunc builds the request values (r.sub, r.obj, r.act ...) of the Casbin model for a subject.
+type RequestFunc func(subject string, attributes *osecure.RequestAttributes) []interface{}
+
+// DefaultRequest builds (subject, path, method) for models like
+// `r = sub, obj, act` with `keyMatch(r.obj, p.obj)` matchers.
+func DefaultRequest(subject string, attributes *osecure.RequestAttributes) []interface{} {
+	return []interface{}{subject, attributes.Path, attributes.Method}
+}
+
+// ObjectRequest builds (subject, object, method) with a fixed object, for declaring policies per route.
+func ObjectRequest(object string) RequestFunc {
+	return func(subject string, attributes *osecure.RequestAttributes) []interface{} {
+		return []interface{}{subject, object, attributes.Method}
+	}
+}
+
+// Authorizer is an osecure.Authorizer which asks the Casbin enforcer for decisions.
+// The request is allowed if the user or any of its roles (osecure permissions) is allowed.
+type Authorizer struct {
+	Enforcer Enforcer
+	Request  RequestFunc
+	// RolePrefix is prepended to permissions to distinguish role subjects from user subjects,
+	// DefaultRolePrefix if empty.
+	RolePrefix string
+}
+
+// NewAuthorizer creates authorizer with the enforcer, DefaultRequest and DefaultRolePrefix.
+func NewAuthorizer(enforcer Enforcer) *Authorizer {
+	return &Authorizer{
+		Enforcer:   enforcer,
+		Request:    DefaultRequest,
+		RolePrefix: DefaultRolePrefix,
+	}
+}
+
+// Subjects lists the Casbin subjects of the session: user ID followed by roles in sorted order.
+func (a *Authorizer) Subjects(sessionData *osecure.AuthSessionData) []string {
+	permissions := sessionData.GetPermissions()
+	sort.Strings(permissions)
+
+	rolePrefix := a.RolePrefix
+	if rolePrefix == "" {
+		rolePrefix = DefaultRolePrefix
+	}
+
+	subjects := make([]string, 0, len(permissions)+1)
+	subjects = append(subjects, sessionData.GetUserID())
+	for _, permission := range permissions {
+		subjects = append(subjects, rolePrefix+permission)
+	}
+	return subjects
+}
+
+// IsAllowed implements osecure.Authorizer.
+func (a *Authorizer) IsAllowed(ctx context.Context, sessionData *osecure.AuthSessionData, attributes *osecure.RequestAttributes) (bool, error) {
+	request := a.Request
+	if request == nil {
+		request = DefaultRequest
+	}
+
+	for _, subject := range a.Subjects(sessionData) {
+		allowed, err := a.Enforcer.Enforce(request(subject, attributes)...)
+		if err != nil {
+			return false, err
+		}
+		if allowed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Enforced is a http middleware for http.Handler to check if the current user has logged in
+// and is allowed by the Casbin policies.
+func Enforced(s *osecure.OAuthSession, isAPI bool, enforcer Enforcer) func(http.Handler) http.Handler {
+	return s.AuthorizedH(isAPI, NewAuthorizer(enforcer))
+}
+
+// EnforcedObject is like Enforced, but enforces the policies of a fixed object for the route.
+func EnforcedObject(s *osecure.OAuthSession, isAPI bool, enforcer Enforcer, object string) func(http.Handler) http.Handler {
+	authorizer := NewAuthorizer(enforcer)
+	authorizer.Request = ObjectRequest(object)
+	return s.AuthorizedH(isAPI, authorizer)
+}
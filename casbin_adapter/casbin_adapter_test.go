@@ -0,0 +1,124 @@
+package casbin_adapter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+// testEnforcer allows (sub, obj, act) of the policies, where obj ending with "*" matches by prefix.
+type testEnforcer struct {
+	policies [][3]string
+	requests [][]interface{}
+	err      error
+}
+
+func (e *testEnforcer) Enforce(rvals ...interface{}) (bool, error) {
+	e.requests = append(e.requests, rvals)
+	if e.err != nil {
+		return false, e.err
+	}
+	for _, p := range e.policies {
+		obj := rvals[1].(string)
+		matched := obj == p[1] || (strings.HasSuffix(p[1], "*") && strings.HasPrefix(obj, strings.TrimSuffix(p[1], "*")))
+		if rvals[0] == p[0] && matched && rvals[2] == p[2] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newTestSessionData(userID string, permissions ...string) *osecure.AuthSessionData {
+	expiresAt := time.Now().Add(time.Hour)
+	return osecure.NewAuthSessionData(userID, "client", &oauth2.Token{AccessToken: "token", Expiry: expiresAt}, permissions, expiresAt)
+}
+
+func isAllowed(t *testing.T, authorizer *Authorizer, sessionData *osecure.AuthSessionData, method string, path string) bool {
+	t.Helper()
+	r := httptest.NewRequest(method, path, nil)
+	allowed, err := authorizer.IsAllowed(context.Background(), sessionData, osecure.NewRequestAttributes(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return allowed
+}
+
+func TestAuthorizer(t *testing.T) {
+	enforcer := &testEnforcer{policies: [][3]string{
+		{"alice", "/api/users/alice", "PUT"},
+		{"role:reader", "/api/*", "GET"},
+	}}
+	authorizer := NewAuthorizer(enforcer)
+
+	for name, test := range map[string]struct {
+		sessionData *osecure.AuthSessionData
+		method      string
+		path        string
+		want        bool
+	}{
+		"user policy":          {newTestSessionData("alice"), http.MethodPut, "/api/users/alice", true},
+		"user policy of other": {newTestSessionData("bob"), http.MethodPut, "/api/users/alice", false},
+		"role policy":          {newTestSessionData("bob", "reader"), http.MethodGet, "/api/items", true},
+		"role of other action": {newTestSessionData("bob", "reader"), http.MethodPost, "/api/items", false},
+		"without role":         {newTestSessionData("bob"), http.MethodGet, "/api/items", false},
+	} {
+		if got := isAllowed(t, authorizer, test.sessionData, test.method, test.path); got != test.want {
+			t.Errorf("%s: got %v, want %v", name, got, test.want)
+		}
+	}
+}
+
+func TestAuthorizerRolePrefix(t *testing.T) {
+	enforcer := &testEnforcer{policies: [][3]string{
+		{"alice", "/api/users/alice", "PUT"},
+	}}
+
+	// a permission named after a user must not gain the policies of the user
+	sessionData := newTestSessionData("mallory", "alice")
+	if isAllowed(t, NewAuthorizer(enforcer), sessionData, http.MethodPut, "/api/users/alice") {
+		t.Error("permission matched the user subject")
+	}
+	if isAllowed(t, &Authorizer{Enforcer: enforcer}, sessionData, http.MethodPut, "/api/users/alice") {
+		t.Error("permission matched the user subject with empty RolePrefix")
+	}
+
+	subjects := (&Authorizer{}).Subjects(newTestSessionData("alice", "writer", "reader"))
+	want := []string{"alice", "role:reader", "role:writer"}
+	if strings.Join(subjects, ",") != strings.Join(want, ",") {
+		t.Errorf("Subjects() = %v, want %v", subjects, want)
+	}
+}
+
+func TestAuthorizerRequest(t *testing.T) {
+	enforcer := &testEnforcer{policies: [][3]string{
+		{"role:reader", "items", "GET"},
+	}}
+	authorizer := NewAuthorizer(enforcer)
+	authorizer.Request = ObjectRequest("items")
+
+	if !isAllowed(t, authorizer, newTestSessionData("bob", "reader"), http.MethodGet, "/api/items/1") {
+		t.Error("denied by the policy of the object")
+	}
+	last := enforcer.requests[len(enforcer.requests)-1]
+	if last[0] != "role:reader" || last[1] != "items" || last[2] != http.MethodGet {
+		t.Errorf("request = %v", last)
+	}
+}
+
+func TestAuthorizerError(t *testing.T) {
+	errEnforce := errors.New("enforce error")
+	authorizer := NewAuthorizer(&testEnforcer{err: errEnforce})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	allowed, err := authorizer.IsAllowed(context.Background(), newTestSessionData("bob", "reader"), osecure.NewRequestAttributes(r))
+	if allowed || err != errEnforce {
+		t.Errorf("IsAllowed() = %v, %v", allowed, err)
+	}
+}
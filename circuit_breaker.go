@@ -0,0 +1,274 @@
+package osecure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Default settings of CircuitBreaker, used when the corresponding field is zero.
+const (
+	DefaultBreakerFailureThreshold = 5
+	DefaultBreakerOpenDuration     = 30 * time.Second
+	DefaultFailOpenDuration        = 5 * time.Minute
+)
+
+// Degradation policies of CircuitBreaker, when the verifier is unavailable.
+const (
+	DegradationFailClosed         = "fail_closed" // requests are unauthorized
+	DegradationFailOpen           = "fail_open"   // sessions verified before keep working with cached data for FailOpenDuration
+	DegradationServiceUnavailable = "unavailable" // Secured responds 503
+)
+
+var errCircuitOpen = fmt.Errorf("circuit breaker is open: %w", ErrorVerifierUnavailable)
+
+// StatusError is an error of a verifier endpoint responding the status code, see IsVerifierUnavailable.
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// IsVerifierUnavailable checks if the error of IntrospectTokenFunc, GetPermissionsFunc or the code exchange is caused by
+// unavailability of the verifier rather than the token: ErrorVerifierUnavailable, StatusError or oauth2.RetrieveError
+// of 5xx or 429, timeouts and transport failures of http requests.
+func IsVerifierUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrorVerifierUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return isUnavailableStatus(statusErr.StatusCode)
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return retrieveErr.Response != nil && isUnavailableStatus(retrieveErr.Response.StatusCode)
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return !errors.Is(urlErr.Err, context.Canceled)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func isUnavailableStatus(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError || statusCode == http.StatusTooManyRequests
+}
+
+// CircuitBreaker stops calling the verifier (IntrospectTokenFunc and GetPermissionsFunc) for OpenDuration
+// after FailureThreshold consecutive failures by its unavailability, then lets one call try it again.
+// Calls stopped by the breaker fail with ErrorVerifierUnavailable, and requests are handled by the Policy meanwhile.
+type CircuitBreaker struct {
+	FailureThreshold int           // DefaultBreakerFailureThreshold if zero
+	OpenDuration     time.Duration // DefaultBreakerOpenDuration if zero
+	Policy           string        // DegradationFailClosed if empty
+
+	// FailOpenDuration is how long sessions are accepted by cached data since the verifier became unavailable,
+	// DefaultFailOpenDuration if zero. Only for DegradationFailOpen.
+	FailOpenDuration time.Duration
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	openUntil           time.Time
+	isTrying            bool
+	unavailableSince    time.Time // zero if available
+
+	lastKnownMu        sync.Mutex
+	lastKnown          map[string]lastKnownResult // for DegradationFailOpen
+	lastKnownPruneSize int
+}
+
+type lastKnownResult struct {
+	value     interface{}
+	expiresAt int64 // unix time of the token expiry, zero if unknown
+}
+
+// NewCircuitBreaker creates CircuitBreaker with the policy and default settings.
+func NewCircuitBreaker(policy string) *CircuitBreaker {
+	return &CircuitBreaker{Policy: policy}
+}
+
+// SetCircuitBreaker protects the verifier by the circuit breaker. It should be called before serving requests.
+func (s *OAuthSession) SetCircuitBreaker(breaker *CircuitBreaker) {
+	s.circuitBreaker = breaker
+}
+
+// allow checks if the call can be made, only one call is made to try the verifier after the breaker opened.
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.isTrying {
+		return false
+	}
+	b.isTrying = true
+	return true
+}
+
+func (b *CircuitBreaker) record(isUnavailable bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.isTrying = false
+	if !isUnavailable {
+		b.consecutiveFailures = 0
+		b.openUntil = time.Time{}
+		b.unavailableSince = time.Time{}
+		return
+	}
+
+	now := time.Now()
+	if b.unavailableSince.IsZero() {
+		b.unavailableSince = now
+	}
+	b.consecutiveFailures++
+
+	threshold := b.FailureThreshold
+	if threshold == 0 {
+		threshold = DefaultBreakerFailureThreshold
+	}
+	if b.consecutiveFailures >= threshold {
+		openDuration := b.OpenDuration
+		if openDuration == 0 {
+			openDuration = DefaultBreakerOpenDuration
+		}
+		b.openUntil = now.Add(openDuration)
+	}
+}
+
+// call calls the verifier through the breaker.
+func (b *CircuitBreaker) call(fn func() error) error {
+	if !b.allow() {
+		return errCircuitOpen
+	}
+	err := fn()
+	b.record(IsVerifierUnavailable(err))
+	return err
+}
+
+// isOpen checks if calls are stopped now.
+func (b *CircuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.openUntil.IsZero() && time.Now().Before(b.openUntil)
+}
+
+// lastKnownLen is the number of last known results kept for DegradationFailOpen.
+func (b *CircuitBreaker) lastKnownLen() int {
+	b.lastKnownMu.Lock()
+	defer b.lastKnownMu.Unlock()
+
+	return len(b.lastKnown)
+}
+
+// isFailingOpen checks if cached data is accepted now.
+func (b *CircuitBreaker) isFailingOpen() bool {
+	if b.Policy != DegradationFailOpen {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.unavailableSince.IsZero() {
+		return false
+	}
+	failOpenDuration := b.FailOpenDuration
+	if failOpenDuration == 0 {
+		failOpenDuration = DefaultFailOpenDuration
+	}
+	return time.Since(b.unavailableSince) <= failOpenDuration
+}
+
+// remember keeps the result of the verifier for DegradationFailOpen, until the token expires.
+func (b *CircuitBreaker) remember(key string, value interface{}, expiresAt int64) {
+	if b.Policy != DegradationFailOpen {
+		return
+	}
+
+	b.lastKnownMu.Lock()
+	defer b.lastKnownMu.Unlock()
+
+	if b.lastKnown == nil {
+		b.lastKnown = make(map[string]lastKnownResult)
+	}
+	b.lastKnown[key] = lastKnownResult{value: value, expiresAt: expiresAt}
+
+	// prune expired results when the cache doubles since the last pruning
+	if len(b.lastKnown) > 2*b.lastKnownPruneSize+1024 {
+		now := time.Now().Unix()
+		for key, result := range b.lastKnown {
+			if result.expiresAt != 0 && result.expiresAt <= now {
+				delete(b.lastKnown, key)
+			}
+		}
+		b.lastKnownPruneSize = len(b.lastKnown)
+	}
+}
+
+// recall gets the last known result of the verifier while failing open.
+func (b *CircuitBreaker) recall(key string) (interface{}, bool) {
+	if !b.isFailingOpen() {
+		return nil, false
+	}
+
+	b.lastKnownMu.Lock()
+	defer b.lastKnownMu.Unlock()
+
+	result, found := b.lastKnown[key]
+	if !found || (result.expiresAt != 0 && result.expiresAt <= time.Now().Unix()) {
+		return nil, false
+	}
+	return result.value, true
+}
+
+// callVerifier calls the verifier through the circuit breaker if enabled, retrying transient failures.
+func (s *OAuthSession) callVerifier(ctx context.Context, fn func() error) error {
+	return s.retry(ctx, func() error {
+		if s.circuitBreaker == nil {
+			return fn()
+		}
+		return s.circuitBreaker.call(fn)
+	})
+}
+
+// recallVerifierResult gets the last known result by the circuit breaker if the verifier is unavailable,
+// otherwise returns the error.
+func (s *OAuthSession) recallVerifierResult(key string, err error) (interface{}, error) {
+	if s.circuitBreaker == nil || !IsVerifierUnavailable(err) {
+		return nil, err
+	}
+	v, found := s.circuitBreaker.recall(key)
+	if !found {
+		return nil, err
+	}
+	return v, nil
+}
+
+// isServiceUnavailable checks if the error of Authorize should be responded with 503.
+func (s *OAuthSession) isServiceUnavailable(err error) bool {
+	return s.circuitBreaker != nil && s.circuitBreaker.Policy == DegradationServiceUnavailable && IsVerifierUnavailable(err)
+}
@@ -0,0 +1,118 @@
+package osecure
+
+import (
+	"strings"
+)
+
+// Profile is the profile of the user in AuthSessionData.
+type Profile struct {
+	Roles       []string
+	Groups      []string
+	Email       string
+	DisplayName string
+}
+
+// ClaimsMapper maps claims of the token to the identity of the session.
+// The claims are extra data of token introspection, with "sub" and "aud" set to the userID and clientID
+// returned by IntrospectTokenFunc. If permissions is not nil, they're used instead of GetPermissionsFunc.
+type ClaimsMapper func(claims map[string]interface{}) (subject string, audience string, permissions []string, profile Profile)
+
+// SetClaimsMapper replaces the default mapping, which takes userID and clientID of token introspection,
+// permissions from GetPermissionsFunc, and the profile from ExtraKeyRoles etc.
+// It should be called before serving requests.
+func (s *OAuthSession) SetClaimsMapper(mapper ClaimsMapper) {
+	s.claimsMapper = mapper
+}
+
+// ClaimNames declares which claims hold the identity, as dot separated paths of nested claims,
+// e.g. "realm_access.roles". Empty names fall back to the default claims.
+type ClaimNames struct {
+	Subject     string `yaml:"subject"`      // "sub" by default
+	Audience    string `yaml:"audience"`     // "aud" by default
+	Permissions string `yaml:"permissions"`  // permissions from GetPermissionsFunc by default
+	Roles       string `yaml:"roles"`        // ExtraKeyRoles by default
+	Groups      string `yaml:"groups"`       // ExtraKeyGroups by default
+	Email       string `yaml:"email"`        // ExtraKeyEmail by default
+	DisplayName string `yaml:"display_name"` // ExtraKeyDisplayName by default
+}
+
+func nameOrDefault(name string, defaultName string) string {
+	if name != "" {
+		return name
+	}
+	return defaultName
+}
+
+// Mapper creates ClaimsMapper of the claim names.
+func (names ClaimNames) Mapper() ClaimsMapper {
+	return func(claims map[string]interface{}) (string, string, []string, Profile) {
+		var permissions []string
+		if names.Permissions != "" {
+			permissions = lookupClaimStrings(claims, names.Permissions)
+			if permissions == nil {
+				permissions = []string{}
+			}
+		}
+
+		subject, _ := lookupClaim(claims, nameOrDefault(names.Subject, "sub")).(string)
+		audience, _ := lookupClaim(claims, nameOrDefault(names.Audience, "aud")).(string)
+		email, _ := lookupClaim(claims, nameOrDefault(names.Email, ExtraKeyEmail)).(string)
+		displayName, _ := lookupClaim(claims, nameOrDefault(names.DisplayName, ExtraKeyDisplayName)).(string)
+
+		return subject, audience, permissions, Profile{
+			Roles:       lookupClaimStrings(claims, nameOrDefault(names.Roles, ExtraKeyRoles)),
+			Groups:      lookupClaimStrings(claims, nameOrDefault(names.Groups, ExtraKeyGroups)),
+			Email:       email,
+			DisplayName: displayName,
+		}
+	}
+}
+
+// lookupClaim gets the claim at the dot separated path, nil if absent.
+// Claims whose name contains dots (e.g. URL names) are matched as a whole first.
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	if v, found := claims[path]; found {
+		return v
+	}
+
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	nested, ok := claims[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return lookupClaim(nested, parts[1])
+}
+
+func lookupClaimStrings(claims map[string]interface{}, path string) []string {
+	return getExtraStrings(map[string]interface{}{"": lookupClaim(claims, path)}, "")
+}
+
+func profileFromExtra(extra map[string]interface{}) Profile {
+	email, _ := extra[ExtraKeyEmail].(string)
+	displayName, _ := extra[ExtraKeyDisplayName].(string)
+	return Profile{
+		Roles:       getExtraStrings(extra, ExtraKeyRoles),
+		Groups:      getExtraStrings(extra, ExtraKeyGroups),
+		Email:       email,
+		DisplayName: displayName,
+	}
+}
+
+// mapClaims maps the result of token introspection to the identity of the session by the claims mapper.
+// permissions is nil if they should be fetched by GetPermissionsFunc.
+func (s *OAuthSession) mapClaims(userID string, clientID string, extra map[string]interface{}) (string, string, []string, Profile) {
+	if s.claimsMapper == nil {
+		return userID, clientID, nil, profileFromExtra(extra)
+	}
+
+	claims := make(map[string]interface{}, len(extra)+2)
+	for key, value := range extra {
+		claims[key] = value
+	}
+	claims["sub"] = userID
+	claims["aud"] = clientID
+	return s.claimsMapper(claims)
+}
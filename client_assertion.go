@@ -0,0 +1,153 @@
+package osecure
+
+import (
+	"context"
+	"crypto"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rayark/osecure/v6/jwt"
+	"golang.org/x/oauth2"
+)
+
+// ClientAssertionType is the client_assertion_type of JWT client assertions (RFC 7523).
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// DefaultClientAssertionLifetime is the lifetime of client assertions if ClientAssertionSigner.Lifetime is zero.
+const DefaultClientAssertionLifetime = time.Minute
+
+// ClientAssertionSigner authenticates the client by signed JWT assertions (RFC 7523) instead of sending the
+// client secret, either private_key_jwt with an asymmetric key or client_secret_jwt with an HMAC algorithm.
+type ClientAssertionSigner struct {
+	ClientID  string
+	Algorithm string      // e.g. "PS256", "ES256" or "HS256" for client_secret_jwt
+	KeyID     string      // "kid" of the key registered at the auth server, optional
+	Key       interface{} // the private key, or []byte of the client secret for client_secret_jwt, see jwt.Sign
+
+	// Audience is the "aud" of assertions, e.g. the issuer. It's the URL of each endpoint if empty.
+	Audience string
+	// Lifetime is the time until assertions expire, DefaultClientAssertionLifetime if zero.
+	Lifetime time.Duration
+}
+
+// NewPrivateKeyJWTSigner creates ClientAssertionSigner of private_key_jwt, signing by the private key of
+// RSA, ECDSA or Ed25519 with the algorithm, e.g. "PS256".
+func NewPrivateKeyJWTSigner(clientID string, algorithm string, keyID string, key crypto.Signer) *ClientAssertionSigner {
+	return &ClientAssertionSigner{ClientID: clientID, Algorithm: algorithm, KeyID: keyID, Key: key}
+}
+
+// NewClientSecretJWTSigner creates ClientAssertionSigner of client_secret_jwt, signing by HS256 with the client secret.
+func NewClientSecretJWTSigner(clientID string, clientSecret string) *ClientAssertionSigner {
+	return &ClientAssertionSigner{ClientID: clientID, Algorithm: "HS256", Key: []byte(clientSecret)}
+}
+
+// Assertion signs an assertion for the endpoint, which is the audience unless Audience is set.
+func (signer *ClientAssertionSigner) Assertion(endpointURL string) (string, error) {
+	audience := signer.Audience
+	if audience == "" {
+		audience = endpointURL
+	}
+	jti, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		"iss": signer.ClientID,
+		"sub": signer.ClientID,
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": now.Add(durationOrDefault(signer.Lifetime, DefaultClientAssertionLifetime)).Unix(),
+		"jti": jti,
+	}
+	return jwt.Sign(jwt.Header{Algorithm: signer.Algorithm, Type: "JWT", KeyID: signer.KeyID}, claims, signer.Key)
+}
+
+// Authenticate sets client_id, client_assertion_type and client_assertion of the form posted to the endpoint,
+// removing client_secret if any.
+func (signer *ClientAssertionSigner) Authenticate(form url.Values, endpointURL string) error {
+	assertion, err := signer.Assertion(endpointURL)
+	if err != nil {
+		return err
+	}
+	form.Del("client_secret")
+	form.Set("client_id", signer.ClientID)
+	form.Set("client_assertion_type", ClientAssertionType)
+	form.Set("client_assertion", assertion)
+	return nil
+}
+
+// SetClientAssertionSigner authenticates the client by the signer at the token endpoint and the PAR endpoint,
+// instead of the client secret, which can be empty. It should be called before serving requests.
+func (s *OAuthSession) SetClientAssertionSigner(signer *ClientAssertionSigner) {
+	s.clientAssertionSigner = signer
+}
+
+// clientContext returns the context of requests to the token endpoint by oauth2,
+// whose http client authenticates by the client assertion if set.
+func (s *OAuthSession) clientContext(ctx context.Context) context.Context {
+	if s.clientAssertionSigner == nil {
+		return ctx
+	}
+
+	client := *http.DefaultClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = *c
+	}
+	client.Transport = &clientAssertionTransport{base: client.Transport, signer: s.clientAssertionSigner}
+	return context.WithValue(ctx, oauth2.HTTPClient, &client)
+}
+
+// clientAssertionTransport replaces the client secret of posted forms by the client assertion.
+type clientAssertionTransport struct {
+	base   http.RoundTripper
+	signer *ClientAssertionSigner
+}
+
+func (t *clientAssertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if req.Method != http.MethodPost || mediaType != "application/x-www-form-urlencoded" || req.Body == nil {
+		return base.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := *req.URL
+	endpoint.RawQuery = ""
+	endpoint.Fragment = ""
+	err = t.signer.Authenticate(form, endpoint.String())
+	if err != nil {
+		return nil, err
+	}
+	encoded := form.Encode()
+
+	// a RoundTripper must not modify the request
+	authenticated := req.Clone(req.Context())
+	authenticated.Body = ioutil.NopCloser(strings.NewReader(encoded))
+	authenticated.ContentLength = int64(len(encoded))
+	authenticated.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(encoded)), nil
+	}
+	if strings.HasPrefix(authenticated.Header.Get("Authorization"), "Basic ") {
+		authenticated.Header.Del("Authorization")
+	}
+	return base.RoundTrip(authenticated)
+}
@@ -0,0 +1,141 @@
+package osecure
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Modes of OAuthConfig.ClientBinding, binding the auth cookie to the client it's issued to.
+const (
+	ClientBindingNone      = ""           // not bound
+	ClientBindingUserAgent = "user_agent" // bound to User-Agent
+	ClientBindingNetwork   = "network"    // bound to IP prefix (/24 for IPv4, /48 for IPv6)
+	ClientBindingStrict    = "strict"     // bound to both User-Agent and IP prefix
+)
+
+func hashClientAttribute(attribute string) string {
+	sum := sha256.Sum256([]byte(attribute))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// ClientIPFunc gets the IP of the client of the request, nil if unknown.
+type ClientIPFunc func(r *http.Request) net.IP
+
+// RemoteAddrClientIP is ClientIPFunc getting the IP from the remote address, the default of OAuthSession.
+func RemoteAddrClientIP(r *http.Request) net.IP {
+	return parseHostIP(r.RemoteAddr)
+}
+
+// parseHostIP parses the IP of "host:port" or a bare host.
+func parseHostIP(hostport string) net.IP {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	return net.ParseIP(host)
+}
+
+// TrustedProxiesClientIP is ClientIPFunc for servers behind reverse proxies in the CIDR ranges.
+// Each proxy appends the address it receives the request from to X-Forwarded-For, and the leftmost addresses
+// can be set by clients, so the client IP is the rightmost address which isn't a trusted proxy.
+// Requests not from trusted proxies use the remote address.
+func TrustedProxiesClientIP(cidrs ...string) (ClientIPFunc, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	isTrusted := func(ip net.IP) bool {
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) net.IP {
+		ip := RemoteAddrClientIP(r)
+		if ip == nil || !isTrusted(ip) {
+			return ip
+		}
+
+		var hops []string
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip = parseHostIP(strings.TrimSpace(hops[i]))
+			if ip == nil || !isTrusted(ip) {
+				return ip
+			}
+		}
+		return ip
+	}, nil
+}
+
+// SetClientIP sets how the IP of clients is got for rate limiting, brute-force detection, network policies,
+// anomaly detection and client binding, RemoteAddrClientIP if nil. Servers behind reverse proxies should set it,
+// e.g. by TrustedProxiesClientIP, otherwise all clients share the IP of the proxy.
+// It should be called before serving requests.
+func (s *OAuthSession) SetClientIP(clientIP ClientIPFunc) {
+	s.clientIPFunc = clientIP
+}
+
+// clientIP gets IP of the client, see SetClientIP.
+func (s *OAuthSession) clientIP(r *http.Request) net.IP {
+	if s.clientIPFunc != nil {
+		return s.clientIPFunc(r)
+	}
+	return RemoteAddrClientIP(r)
+}
+
+// clientNetwork gets the IP prefix of the client.
+func (s *OAuthSession) clientNetwork(r *http.Request) string {
+	ip := s.clientIP(r)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func (s *OAuthSession) isBindingUserAgent() bool {
+	return s.clientBinding == ClientBindingUserAgent || s.clientBinding == ClientBindingStrict
+}
+
+func (s *OAuthSession) isBindingNetwork() bool {
+	return s.clientBinding == ClientBindingNetwork || s.clientBinding == ClientBindingStrict
+}
+
+// bindClient records the client attributes into the cookie data.
+func (s *OAuthSession) bindClient(r *http.Request, cookieData *AuthSessionCookieData) {
+	if s.isBindingUserAgent() {
+		cookieData.ClientUserAgentHash = hashClientAttribute(r.UserAgent())
+	}
+	if s.isBindingNetwork() {
+		cookieData.ClientNetworkHash = hashClientAttribute(s.clientNetwork(r))
+	}
+}
+
+// checkClientBinding checks if the cookie is presented by the client it's issued to.
+// Cookies issued before enabling client binding have no hashes and are accepted.
+func (s *OAuthSession) checkClientBinding(r *http.Request, cookieData *AuthSessionCookieData) error {
+	if s.isBindingUserAgent() && cookieData.ClientUserAgentHash != "" &&
+		cookieData.ClientUserAgentHash != hashClientAttribute(r.UserAgent()) {
+		return ErrorClientMismatch
+	}
+	if s.isBindingNetwork() && cookieData.ClientNetworkHash != "" &&
+		cookieData.ClientNetworkHash != hashClientAttribute(s.clientNetwork(r)) {
+		return ErrorClientMismatch
+	}
+	return nil
+}
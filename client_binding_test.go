@@ -0,0 +1,78 @@
+package osecure
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	clientIP, err := TrustedProxiesClientIP("10.0.0.0/8", "2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "direct client", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "direct client forging the header", remoteAddr: "192.0.2.1:1234", forwardedFor: []string{"198.51.100.1"}, want: "192.0.2.1"},
+		{name: "through proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"192.0.2.1"}, want: "192.0.2.1"},
+		{name: "through proxies", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"192.0.2.1, 10.0.0.2"}, want: "192.0.2.1"},
+		{name: "through proxies in headers", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"192.0.2.1", "10.0.0.2"}, want: "192.0.2.1"},
+		{name: "client forging the header through proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1, 192.0.2.1"}, want: "192.0.2.1"},
+		{name: "IPv6 proxy", remoteAddr: "[2001:db8::1]:1234", forwardedFor: []string{"2001:db8:1::1, 192.0.2.1"}, want: "192.0.2.1"},
+		{name: "proxy without header", remoteAddr: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "malformed hop", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"unknown"}, want: "<nil>"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		for _, value := range test.forwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := clientIP(r).String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	_, err = TrustedProxiesClientIP("10.0.0.0")
+	if err == nil {
+		t.Error("malformed CIDR accepted")
+	}
+}
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	s := newTestSession(t, newTestVerifier(nil))
+	s.AddAuthenticator(NewAPIKeyAuthenticator("", NewStaticAPIKeyVerifier(map[string]APIKeyIdentity{
+		"good-key": {UserID: "robot"},
+	})))
+	s.SetRateLimiter(NewRateLimiter(1, 0, time.Minute))
+	clientIP, err := TrustedProxiesClientIP("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetClientIP(clientIP)
+
+	request := func(apiKey string, forwardedFor string) error {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		r.Header.Set(DefaultAPIKeyHeader, apiKey)
+		_, err := s.Verify(r)
+		return err
+	}
+
+	request("guessed-key", "192.0.2.1")
+	if err := request("good-key", "192.0.2.1"); !errors.Is(err, ErrorTooManyFailures) {
+		t.Errorf("attacker: got %v, want %v", err, ErrorTooManyFailures)
+	}
+	// other users behind the same proxy aren't locked out
+	if err := request("good-key", "192.0.2.2"); err != nil {
+		t.Errorf("other user: %v", err)
+	}
+}
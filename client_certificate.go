@@ -0,0 +1,106 @@
+package osecure
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ClientCertificateTokenType is the token type of session data authenticated by client certificate.
+const ClientCertificateTokenType = "ClientCertificate"
+
+// ClientCertificateVerifier maps a verified client certificate to user and permissions.
+type ClientCertificateVerifier func(ctx context.Context, certificate *x509.Certificate) (userID string, permissions []string, err error)
+
+// verifiedClientCertificate gets the client certificate verified by the TLS server,
+// which requires tls.Config.ClientAuth to be VerifyClientCertIfGiven or RequireAndVerifyClientCert.
+func verifiedClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// NewClientCertificateAuthenticator creates authenticator for mTLS client certificates.
+// Requests carrying Authorization header are left to the token verification,
+// where certificate-bound tokens are checked against the certificate.
+// The session data has the user ID as both user ID and client ID, like service accounts.
+func NewClientCertificateAuthenticator(verifier ClientCertificateVerifier) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*AuthSessionData, error) {
+		if r.Header.Get("Authorization") != "" {
+			return nil, nil
+		}
+
+		certificate := verifiedClientCertificate(r)
+		if certificate == nil {
+			return nil, nil
+		}
+
+		userID, permissions, err := verifier(r.Context(), certificate)
+		if err != nil {
+			return nil, WrapError(ErrorStringInvalidClientCertificate, err)
+		}
+
+		expiresAt := certificate.NotAfter
+		if permissionsExpiresAt := time.Now().Add(DefaultPermissionExpireTime); permissionsExpiresAt.Before(expiresAt) {
+			expiresAt = permissionsExpiresAt
+		}
+		token := &oauth2.Token{TokenType: ClientCertificateTokenType, Expiry: expiresAt}
+		return NewAuthSessionData(userID, userID, token, permissions, expiresAt), nil
+	})
+}
+
+// CertificateThumbprint computes the base64url encoded SHA-256 thumbprint of certificate ("x5t#S256").
+func CertificateThumbprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// getConfirmation gets a member of the "cnf" extra data of token.
+func getConfirmation(extra map[string]interface{}, member string) string {
+	cnf, _ := extra[ExtraKeyConfirmation].(map[string]interface{})
+	value, _ := cnf[member].(string)
+	return value
+}
+
+// isSenderConstrained checks if the token has "cnf" confirmation, e.g. DPoP-bound or certificate-bound tokens.
+func isSenderConstrained(extra map[string]interface{}) bool {
+	cnf, _ := extra[ExtraKeyConfirmation].(map[string]interface{})
+	return len(cnf) > 0
+}
+
+// checkCertificateBinding checks certificate-bound access tokens (RFC 8705).
+// A token with "x5t#S256" confirmation must be presented over mTLS with the same certificate.
+func (s *OAuthSession) checkCertificateBinding(r *http.Request, extra map[string]interface{}) error {
+	thumbprint := getConfirmation(extra, "x5t#S256")
+	if thumbprint == "" {
+		if s.requireCertificateBoundTokens {
+			return ErrorCertificateMismatch
+		}
+		return nil
+	}
+
+	certificate := verifiedClientCertificate(r)
+	if certificate == nil {
+		return ErrorCertificateMismatch
+	}
+	if subtle.ConstantTimeCompare([]byte(CertificateThumbprint(certificate)), []byte(thumbprint)) != 1 {
+		return ErrorCertificateMismatch
+	}
+	return nil
+}
+
+// checkCookieCertificateBinding checks certificate-bound access tokens of the session cookie, which must still be
+// presented over mTLS with the same certificate, so the cookie can't be used without the client certificate.
+func (s *OAuthSession) checkCookieCertificateBinding(r *http.Request, extra map[string]interface{}) error {
+	if getConfirmation(extra, "x5t#S256") == "" {
+		return nil
+	}
+	return s.checkCertificateBinding(r, extra)
+}
@@ -0,0 +1,114 @@
+package osecure
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, commonName string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+// newMTLSRequest creates a request over mTLS with the client certificate, verified by the TLS server if verified.
+func newMTLSRequest(certificate *x509.Certificate, verified bool) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+	if certificate != nil {
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+		if verified {
+			r.TLS.VerifiedChains = [][]*x509.Certificate{{certificate}}
+		}
+	}
+	return r
+}
+
+func TestClientCertificateAuthenticator(t *testing.T) {
+	s := newTestSession(t, newTestVerifier(nil))
+	s.AddAuthenticator(NewClientCertificateAuthenticator(func(ctx context.Context, certificate *x509.Certificate) (string, []string, error) {
+		if certificate.Subject.CommonName != "robot" {
+			return "", nil, errors.New("unknown client")
+		}
+		return "robot", []string{"deploy"}, nil
+	}))
+
+	data, err := s.Verify(newMTLSRequest(newTestCertificate(t, "robot"), true))
+	if err != nil || data.UserID != "robot" || !data.HasPermission("deploy") {
+		t.Fatalf("verified certificate: got %v, %v", data, err)
+	}
+	if data.Token.TokenType != ClientCertificateTokenType {
+		t.Errorf("token type %q, want %q", data.Token.TokenType, ClientCertificateTokenType)
+	}
+
+	_, err = s.Verify(newMTLSRequest(newTestCertificate(t, "intruder"), true))
+	if !CompareErrorMessage(err, ErrorStringUnauthorized) {
+		t.Errorf("certificate of unknown client: got %v", err)
+	}
+
+	// certificates not verified by the TLS server are ignored
+	_, err = s.Verify(newMTLSRequest(newTestCertificate(t, "robot"), false))
+	if err == nil {
+		t.Error("unverified certificate authenticated")
+	}
+}
+
+func TestCertificateBoundToken(t *testing.T) {
+	certificate := newTestCertificate(t, "client")
+	verifier := newTestVerifier(nil)
+	verifier.IntrospectTokenFunc = func(ctx context.Context, accessToken string) (string, string, int64, map[string]interface{}, error) {
+		extra := map[string]interface{}{ExtraKeyConfirmation: map[string]interface{}{"x5t#S256": CertificateThumbprint(certificate)}}
+		return accessToken, testClientID, time.Now().Add(time.Hour).Unix(), extra, nil
+	}
+	s := newTestSession(t, verifier)
+
+	for name, test := range map[string]struct {
+		r    *http.Request
+		want error
+	}{
+		"same certificate":  {newMTLSRequest(certificate, true), nil},
+		"other certificate": {newMTLSRequest(newTestCertificate(t, "client"), true), ErrorCertificateMismatch},
+		"without mTLS":      {newMTLSRequest(nil, false), ErrorCertificateMismatch},
+	} {
+		test.r.Header.Set("Authorization", "Bearer alice")
+		w := httptest.NewRecorder()
+		_, err := s.Authorize(w, test.r)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got %v, want %v", name, err, test.want)
+		}
+		// the session isn't saved into the cookie, which would work without the certificate
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == s.name && cookie.MaxAge >= 0 {
+				t.Errorf("%s: certificate-bound session saved into the cookie", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package osecure
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ComplianceProfile is a security profile enforced by OAuthSession, see OAuthConfig.ComplianceProfile.
+type ComplianceProfile string
+
+const (
+	// ComplianceProfileNone enforces nothing beyond the config.
+	ComplianceProfileNone ComplianceProfile = ""
+	// ComplianceProfileFAPI2 enforces the FAPI 2.0 Security Profile for financial-grade deployments:
+	//  - authorization requests are pushed (OAuthConfig.PushedAuthorizationRequestEndpoint is required) with PKCE,
+	//  - request objects, if any, are signed by PS256, ES256 or EdDSA,
+	//  - authorization responses must have "iss" of OAuthConfig.Issuer (RFC 9207), which is required,
+	//  - authorization responses must not carry tokens, and token responses must be Bearer or DPoP tokens,
+	//  - access tokens must be sender-constrained by mTLS (RFC 8705) or DPoP (RFC 9449).
+	ComplianceProfileFAPI2 ComplianceProfile = "fapi2"
+)
+
+const pkceCookieSuffix = "_pkce"
+
+var errNoCodeVerifier = errors.New("no PKCE code verifier")
+
+func validateComplianceProfile(errs *ConfigErrors, conf *OAuthConfig) {
+	switch conf.ComplianceProfile {
+	case ComplianceProfileNone:
+	case ComplianceProfileFAPI2:
+		if conf.PushedAuthorizationRequestEndpoint == "" {
+			errs.add("pushed_authorization_request_endpoint", "required by compliance profile fapi2", nil)
+		}
+		if conf.Issuer == "" {
+			errs.add("issuer", "required by compliance profile fapi2", nil)
+		}
+	default:
+		errs.add("compliance_profile", fmt.Sprintf("unknown profile %q", conf.ComplianceProfile), nil)
+	}
+}
+
+func (s *OAuthSession) isFAPI2() bool {
+	return s.complianceProfile == ComplianceProfileFAPI2
+}
+
+func (s *OAuthSession) pkceCookieName() string {
+	return s.name + pkceCookieSuffix
+}
+
+// codeChallengeOptions generates the PKCE code verifier (RFC 7636) of the authorization request,
+// keeping it in a cookie until the callback, and returns the S256 code challenge parameters.
+func (s *OAuthSession) codeChallengeOptions(w http.ResponseWriter, r *http.Request) ([]oauth2.AuthCodeOption, error) {
+	if !s.pkce {
+		return nil, nil
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(b)
+
+	session, err := s.getCookieStore().New(r, s.pkceCookieName())
+	if err != nil {
+		return nil, err
+	}
+	session.Values["code_verifier"] = verifier
+	err = session.Save(r, w)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}, nil
+}
+
+// codeVerifierOptions takes the PKCE code verifier out of the cookie, returning the parameter of the token request.
+func (s *OAuthSession) codeVerifierOptions(w http.ResponseWriter, r *http.Request) ([]oauth2.AuthCodeOption, error) {
+	if !s.pkce {
+		return nil, nil
+	}
+
+	session, err := s.getCookieStore().Get(r, s.pkceCookieName())
+	if err != nil {
+		return nil, errNoCodeVerifier
+	}
+	verifier, _ := session.Values["code_verifier"].(string)
+	if verifier == "" {
+		return nil, errNoCodeVerifier
+	}
+
+	delete(session.Values, "code_verifier")
+	session.Options.MaxAge = -1
+	err = session.Save(r, w)
+	if err != nil {
+		return nil, err
+	}
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("code_verifier", verifier)}, nil
+}
+
+// checkAuthorizationResponse checks the "iss" parameter of the callback against OAuthConfig.Issuer (RFC 9207),
+// which is required by ComplianceProfileFAPI2 along with a pure code response.
+func (s *OAuthSession) checkAuthorizationResponse(r *http.Request) error {
+	issuer := r.FormValue("iss")
+	if s.isFAPI2() {
+		if issuer == "" {
+			return ErrorInvalidResponseIssuer
+		}
+		for _, name := range []string{"access_token", "id_token", "token_type"} {
+			if r.FormValue(name) != "" {
+				return fmt.Errorf("unexpected %s in authorization response", name)
+			}
+		}
+	}
+	if issuer != "" && s.issuer != "" && issuer != s.issuer {
+		return ErrorInvalidResponseIssuer
+	}
+	return nil
+}
+
+// checkTokenResponse checks the token type of the token response, only under ComplianceProfileFAPI2.
+func (s *OAuthSession) checkTokenResponse(token *oauth2.Token) error {
+	if !s.isFAPI2() {
+		return nil
+	}
+	if !strings.EqualFold(token.TokenType, "Bearer") && !strings.EqualFold(token.TokenType, DPoPScheme) {
+		return fmt.Errorf("unexpected token type %q", token.TokenType)
+	}
+	return nil
+}
+
+// checkSenderConstraint rejects access tokens without "x5t#S256" or "jkt" confirmation under ComplianceProfileFAPI2.
+// The confirmation itself is checked by checkCertificateBinding and checkDPoPBinding.
+func (s *OAuthSession) checkSenderConstraint(extra map[string]interface{}) error {
+	if !s.isFAPI2() {
+		return nil
+	}
+	if getConfirmation(extra, "x5t#S256") == "" && getConfirmation(extra, "jkt") == "" {
+		return ErrorTokenNotSenderConstrained
+	}
+	return nil
+}
+
+// isCompliantSigningAlgorithm checks the algorithm of request objects allowed by the compliance profile.
+func (s *OAuthSession) isCompliantSigningAlgorithm(alg string) bool {
+	if !s.isFAPI2() {
+		return true
+	}
+	switch alg {
+	case "PS256", "ES256", "EdDSA":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,363 @@
+package osecure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigError describes an invalid field of config.
+type ConfigError struct {
+	Field  string
+	Reason string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("invalid config %s: %s: %v", e.Field, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("invalid config %s: %s", e.Field, e.Reason)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors is the list of all invalid fields of config, returned by Validate.
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (errs *ConfigErrors) add(field string, reason string, err error) {
+	*errs = append(*errs, &ConfigError{Field: field, Reason: reason, Err: err})
+}
+
+func (errs ConfigErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Validate checks the keys are base64 encoded with valid lengths,
+// at least 32 bytes for AuthenticationKey and 16, 24 or 32 bytes (AES) for EncryptionKey.
+// EncryptionKey is required since the cookie carries the access token.
+func (conf *CookieConfig) Validate() error {
+	var errs ConfigErrors
+
+	validateCookieKeyPair(&errs, "", conf.AuthenticationKey, conf.EncryptionKey)
+	for i, pair := range conf.PreviousKeys {
+		validateCookieKeyPair(&errs, fmt.Sprintf("previous_keys[%d].", i), pair.AuthenticationKey, pair.EncryptionKey)
+	}
+	if conf.Format != "" && conf.Format != CookieFormatSecureCookie && conf.Format != CookieFormatJSONHMAC {
+		errs.add("format", "unknown format "+strconv.Quote(conf.Format), nil)
+	}
+
+	return errs.err()
+}
+
+func validateCookieKeyPair(errs *ConfigErrors, fieldPrefix string, authenticationKey string, encryptionKey string) {
+	key, ok := decodeBase64Key(errs, fieldPrefix+"authentication_key", authenticationKey)
+	if ok && len(key) < 32 {
+		errs.add(fieldPrefix+"authentication_key", fmt.Sprintf("key length is %d bytes, expected at least 32", len(key)), nil)
+	}
+
+	key, ok = decodeBase64Key(errs, fieldPrefix+"encryption_key", encryptionKey)
+	if ok && len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		errs.add(fieldPrefix+"encryption_key", fmt.Sprintf("key length is %d bytes, expected 16, 24 or 32", len(key)), nil)
+	}
+}
+
+func decodeBase64Key(errs *ConfigErrors, field string, encodedKey string) ([]byte, bool) {
+	if encodedKey == "" {
+		errs.add(field, "required", nil)
+		return nil, false
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		errs.add(field, "not standard base64", err)
+		return nil, false
+	}
+	return key, true
+}
+
+// Validate checks the config, returning ConfigErrors with all invalid fields.
+func (conf *OAuthConfig) Validate() error {
+	var errs ConfigErrors
+
+	if conf.ClientID == "" {
+		errs.add("client_id", "required", nil)
+	}
+	for _, scope := range conf.Scopes {
+		if !isValidScopeToken(scope) {
+			errs.add("scopes", fmt.Sprintf("invalid scope %q", scope), nil)
+		}
+	}
+	for _, audience := range conf.AcceptedAudiences {
+		if audience == "" {
+			errs.add("accepted_audiences", "empty audience", nil)
+		}
+	}
+	validateAuthParams(&errs, "auth_code_params", conf.AuthCodeParams)
+	validateAuthParams(&errs, "exchange_params", conf.ExchangeParams)
+	validateResources(&errs, "resources", conf.Resources)
+	validateComplianceProfile(&errs, conf)
+	if conf.Issuer != "" {
+		validateURL(&errs, "issuer", conf.Issuer)
+	}
+	if conf.EndSessionEndpoint != "" {
+		validateURL(&errs, "end_session_endpoint", conf.EndSessionEndpoint)
+	}
+	if conf.SSOCookieDomain != "" && (strings.ContainsAny(conf.SSOCookieDomain, ":/ ") || !strings.Contains(strings.Trim(conf.SSOCookieDomain, "."), ".")) {
+		errs.add("sso_cookie_domain", fmt.Sprintf("invalid domain %q", conf.SSOCookieDomain), nil)
+	}
+	if len(conf.SSOTrustedAudiences) > 0 && conf.SSOCookieDomain == "" {
+		errs.add("sso_trusted_audiences", "requires sso_cookie_domain", nil)
+	}
+	if conf.ExternalURL != "" {
+		validateURL(&errs, "external_url", conf.ExternalURL)
+	}
+	if conf.PushedAuthorizationRequestEndpoint != "" {
+		validateURL(&errs, "pushed_authorization_request_endpoint", conf.PushedAuthorizationRequestEndpoint)
+	}
+
+	switch conf.ClientBinding {
+	case ClientBindingNone, ClientBindingUserAgent, ClientBindingNetwork, ClientBindingStrict:
+	default:
+		errs.add("client_binding", fmt.Sprintf("unknown mode %q", conf.ClientBinding), nil)
+	}
+
+	validateNonNegative(&errs, "session_expire_time", conf.SessionExpireTime)
+	validateNonNegative(&errs, "permission_expire_time", conf.PermissionExpireTime)
+	validateNonNegative(&errs, "session_max_lifetime", conf.SessionMaxLifetime)
+	validateNonNegative(&errs, "clock_skew", conf.ClockSkew)
+	validateNonNegative(&errs, "negative_cache_ttl", conf.NegativeCacheTTL)
+	validateNonNegative(&errs, "permission_refresh_ahead", conf.PermissionRefreshAhead)
+	validateNonNegative(&errs, "retry_backoff", conf.RetryBackoff)
+	validateNonNegative(&errs, "retry_max_backoff", conf.RetryMaxBackoff)
+	validateNonNegative(&errs, "remember_me_lifetime", conf.RememberMeLifetime)
+	if conf.MaxRetries < 0 {
+		errs.add("max_retries", "negative number", nil)
+	}
+	if conf.MaxTokenLength < 0 {
+		errs.add("max_token_length", "negative number", nil)
+	}
+	if conf.MaxCookieSize < 0 {
+		errs.add("max_cookie_size", "negative number", nil)
+	}
+	switch conf.RedirectStatusCode {
+	case 0, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect:
+	default:
+		errs.add("redirect_status_code", fmt.Sprintf("unsupported status code %d, expected 302, 303 or 307", conf.RedirectStatusCode), nil)
+	}
+	if conf.MaxSessionValuesSize < 0 {
+		errs.add("max_session_values_size", "negative number", nil)
+	}
+	if conf.MaxCallbackQuerySize < 0 {
+		errs.add("max_callback_query_size", "negative number", nil)
+	}
+	if conf.MaxSessionsPerSubject < 0 {
+		errs.add("max_sessions_per_subject", "negative number", nil)
+	}
+	switch conf.SessionLimitPolicy {
+	case SessionLimitEvictOldest, SessionLimitReject:
+	default:
+		errs.add("session_limit_policy", fmt.Sprintf("unknown policy %q", conf.SessionLimitPolicy), nil)
+	}
+	if conf.SlidingSession && durationOrDefault(conf.SessionMaxLifetime, DefaultSessionMaxLifetime) < durationOrDefault(conf.SessionExpireTime, DefaultSessionExpireTime) {
+		errs.add("session_max_lifetime", "shorter than session_expire_time", nil)
+	}
+
+	return errs.err()
+}
+
+// Validate checks the endpoint URLs.
+func (endpoint OAuthEndpoint) Validate() error {
+	var errs ConfigErrors
+
+	validateURL(&errs, "auth_url", endpoint.AuthURL)
+	validateURL(&errs, "token_url", endpoint.TokenURL)
+
+	return errs.err()
+}
+
+// isValidScopeToken checks scope-token of RFC 6749 section 3.3.
+func isValidScopeToken(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for _, c := range scope {
+		if c < 0x21 || c == 0x22 || c == 0x5c || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// validateURL checks the URL is absolute, and uses https unless the host is loopback.
+func validateURL(errs *ConfigErrors, field string, rawURL string) {
+	if rawURL == "" {
+		errs.add(field, "required", nil)
+		return
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		errs.add(field, "malformed URL", err)
+		return
+	}
+	if !u.IsAbs() || u.Host == "" {
+		errs.add(field, "not an absolute URL", nil)
+		return
+	}
+
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !isLoopbackHost(u.Hostname()) {
+			errs.add(field, "https is required for non-loopback hosts", nil)
+		}
+	default:
+		errs.add(field, fmt.Sprintf("unsupported scheme %q", u.Scheme), nil)
+	}
+	if u.Fragment != "" {
+		errs.add(field, "fragment is not allowed", nil)
+	}
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func validateNonNegative(errs *ConfigErrors, field string, d time.Duration) {
+	if d < 0 {
+		errs.add(field, "negative duration", nil)
+	}
+}
+
+// Diagnosis is a result of Doctor.
+type Diagnosis struct {
+	Check string
+	Err   error // nil if passed
+}
+
+// Doctor validates the configs and probes the configured endpoints,
+// returning results of all checks, which are meant to be logged at startup or by an admin command.
+// Endpoints are reachable if they respond without 5xx status.
+func Doctor(ctx context.Context, cookieConf *CookieConfig, oauthConf *OAuthConfig, endpoint OAuthEndpoint, callbackURL string) []Diagnosis {
+	var diagnoses []Diagnosis
+	check := func(name string, err error) {
+		diagnoses = append(diagnoses, Diagnosis{Check: name, Err: err})
+	}
+
+	if cookieConf != nil {
+		check("cookie config", cookieConf.Validate())
+	}
+	check("oauth config", oauthConf.Validate())
+	check("oauth endpoint", endpoint.Validate())
+
+	var errs ConfigErrors
+	validateURL(&errs, "callback_url", callbackURL)
+	check("callback url", errs.err())
+
+	client := newProbeClient()
+
+	if endpoint.AuthURL != "" {
+		check("authorization endpoint", probeEndpoint(ctx, client, http.MethodGet, endpoint.AuthURL))
+	}
+	if endpoint.TokenURL != "" {
+		// a token request without grant responds 400 or 401 if the endpoint works
+		check("token endpoint", probeEndpoint(ctx, client, http.MethodPost, endpoint.TokenURL))
+	}
+	if oauthConf.EndSessionEndpoint != "" {
+		check("end session endpoint", probeEndpoint(ctx, client, http.MethodGet, oauthConf.EndSessionEndpoint))
+	}
+	if oauthConf.Issuer != "" {
+		check("issuer discovery", probeDiscovery(ctx, client, oauthConf.Issuer))
+	}
+
+	return diagnoses
+}
+
+// newProbeClient creates the http client of probes, which doesn't follow redirects.
+func newProbeClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func probeEndpoint(ctx context.Context, client *http.Client, method string, endpointURL string) error {
+	req, err := http.NewRequest(method, endpointURL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeDiscovery checks the OpenID provider metadata of the issuer, whose "issuer" must be identical.
+// Issuers which don't support OpenID Connect Discovery fail the check.
+func probeDiscovery(ctx context.Context, client *http.Client, issuer string) error {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var metadata struct {
+		Issuer string `json:"issuer"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&metadata)
+	if err != nil {
+		return err
+	}
+	if metadata.Issuer != issuer {
+		return fmt.Errorf("issuer of metadata is %q", metadata.Issuer)
+	}
+	return nil
+}
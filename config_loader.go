@@ -0,0 +1,200 @@
+package osecure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultEnvPrefix is the prefix of environment variables read by LoadFromEnv.
+const DefaultEnvPrefix = "OSECURE_"
+
+// Config is all configs to create an OAuthSession, which can be loaded from YAML and environment variables.
+type Config struct {
+	Cookie CookieConfig `yaml:"cookie"`
+	OAuth  OAuthConfig  `yaml:"oauth"`
+
+	AuthURL     string `yaml:"auth_url" env:"auth_url"`
+	TokenURL    string `yaml:"token_url" env:"token_url"`
+	CallbackURL string `yaml:"callback_url" env:"callback_url"`
+}
+
+// Endpoint is the OAuth endpoint of the config.
+func (conf *Config) Endpoint() OAuthEndpoint {
+	return OAuthEndpoint{
+		AuthURL:  conf.AuthURL,
+		TokenURL: conf.TokenURL,
+	}
+}
+
+// Validate validates all configs.
+func (conf *Config) Validate() error {
+	var errs ConfigErrors
+	for _, err := range []error{conf.Cookie.Validate(), conf.OAuth.Validate(), conf.Endpoint().Validate()} {
+		if err != nil {
+			errs = append(errs, err.(ConfigErrors)...)
+		}
+	}
+	validateURL(&errs, "callback_url", conf.CallbackURL)
+	return errs.err()
+}
+
+// NewOAuthSession validates the config and creates OAuthSession with it.
+func (conf *Config) NewOAuthSession(name string, tokenVerifier *TokenVerifier, stateHandler StateHandler) (*OAuthSession, error) {
+	err := conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return NewOAuthSession(name, &conf.Cookie, &conf.OAuth, conf.Endpoint(), tokenVerifier, conf.CallbackURL, stateHandler), nil
+}
+
+// LookupFunc looks up the value of a config key, e.g. os.LookupEnv.
+// Other config sources (e.g. viper) can be adapted to override configs by ApplyOverrides.
+type LookupFunc func(key string) (string, bool)
+
+// LoadFromYAML loads config from the YAML file, then overrides it by environment variables with DefaultEnvPrefix.
+func LoadFromYAML(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Config{}
+	err = yaml.UnmarshalStrict(b, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ApplyOverrides(conf, DefaultEnvPrefix, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// LoadFromEnv loads config from environment variables with DefaultEnvPrefix, e.g. OSECURE_CLIENT_ID.
+func LoadFromEnv() (*Config, error) {
+	conf := &Config{}
+	err := ApplyOverrides(conf, DefaultEnvPrefix, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// LoadOAuthConfigFromEnv loads OAuthConfig from environment variables with the prefix.
+func LoadOAuthConfigFromEnv(prefix string) (*OAuthConfig, error) {
+	conf := &OAuthConfig{}
+	err := ApplyOverrides(conf, prefix, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// LoadCookieConfigFromEnv loads CookieConfig from environment variables with the prefix.
+func LoadCookieConfigFromEnv(prefix string) (*CookieConfig, error) {
+	conf := &CookieConfig{}
+	err := ApplyOverrides(conf, prefix, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// ApplyOverrides overrides fields of the config struct pointer by values found by lookup.
+// The key of a field is the prefix and its "env" tag in upper case, fields of nested structs are keyed without nesting.
+// Lists are comma separated, durations are parsed by time.ParseDuration.
+func ApplyOverrides(conf interface{}, prefix string, lookup LookupFunc) error {
+	v := reflect.ValueOf(conf)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a struct pointer, got %T", conf)
+	}
+	return applyOverrides(v.Elem(), prefix, lookup)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func applyOverrides(v reflect.Value, prefix string, lookup LookupFunc) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldValue := v.Field(i)
+
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		if field.Type.Kind() == reflect.Struct {
+			err := applyOverrides(fieldValue, prefix, lookup)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := field.Tag.Get("env")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := strings.ToUpper(prefix + name)
+		value, found := lookup(key)
+		if !found {
+			continue
+		}
+
+		err := setConfigValue(fieldValue, value)
+		if err != nil {
+			return &ConfigError{Field: key, Reason: "cannot parse " + strconv.Quote(value), Err: err}
+		}
+	}
+	return nil
+}
+
+func setConfigValue(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
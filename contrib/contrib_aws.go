@@ -0,0 +1,223 @@
+package contrib
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"github.com/rayark/osecure/v6/aws_sigv4"
+)
+
+// AWSIAMTokenPrefix is the prefix of AWS IAM tokens, followed by the base64url encoded presigned URL
+// of sts:GetCallerIdentity.
+const AWSIAMTokenPrefix = "aws-iam-v1."
+
+// AWSIAMAudienceHeader is the header signed in presigned URLs, whose value is the audience of the token,
+// so tokens for one server can't be replayed to another.
+const AWSIAMAudienceHeader = "X-Osecure-Aws-Id"
+
+// AWSIAMTokenLifetime is the expiry of presigned URLs of AWSIAMToken, which STS accepts at most 15 minutes.
+const AWSIAMTokenLifetime = time.Minute
+
+// Keys of extra data of AWS IAM tokens, besides osecure.ExtraKeyIssuer.
+const (
+	ExtraKeyAWSAccount = "aws_account"
+	ExtraKeyAWSARN     = "aws_arn"
+	ExtraKeyAWSUserID  = "aws_user_id"
+)
+
+var (
+	ErrorInvalidAWSIAMToken = errors.New("invalid AWS IAM token")
+	ErrorAWSAccountDenied   = errors.New("AWS account is not allowed")
+)
+
+var stsHostPattern = regexp.MustCompile(`^sts(\.[a-z0-9-]+)?\.amazonaws\.com(\.cn)?$`)
+
+// AWSIAMToken mints the token of the IAM principal of the credentials for the audience, sent by callers as
+// bearer tokens to servers verifying them by AWSIAMIntrospection. It's the SigV4 presigned URL of
+// sts:GetCallerIdentity of the regional endpoint, or the global endpoint if region is empty.
+// Lambda functions get the credentials of their execution role by aws_sigv4.CredentialsFromEnv and the region
+// by AWS_REGION.
+func AWSIAMToken(creds aws_sigv4.Credentials, region string, audience string) (string, error) {
+	host := "sts.amazonaws.com"
+	signingRegion := "us-east-1"
+	if region != "" {
+		host = "sts." + region + ".amazonaws.com"
+		signingRegion = region
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://"+host+"/?Action=GetCallerIdentity&Version=2011-06-15", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(AWSIAMAudienceHeader, audience)
+	aws_sigv4.PresignRequest(req, creds, signingRegion, "sts", AWSIAMTokenLifetime, []string{strings.ToLower(AWSIAMAudienceHeader)}, time.Now())
+	return AWSIAMTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(req.URL.String())), nil
+}
+
+// AWSIAMConfig is the config of AWSIAMIntrospection.
+type AWSIAMConfig struct {
+	Audience   string   // audience of tokens, e.g. the client ID of the server
+	AccountIDs []string // AWS accounts of principals allowed, any if empty
+	HTTPClient *http.Client
+}
+
+// AWSIAMIntrospection define the introspection function of AWS IAM tokens of AWSIAMToken, so callers like
+// Lambda functions authenticate by their IAM roles instead of OAuth tokens. The presigned request is validated and
+// sent to STS, which verifies the SigV4 signature and answers the caller identity.
+// The user ID and client ID are the ARN of the principal, whose assumed role session is mapped to the role ARN
+// (e.g. "arn:aws:iam::123456789012:role/name"), so permissions can be granted by PredefinedPermissionRoles.
+// Sessions last until the presigned URL expires.
+func AWSIAMIntrospection(conf AWSIAMConfig) osecure.IntrospectTokenFunc {
+	return func(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+		presignedURL, expiresAt, err := parseAWSIAMToken(accessToken, time.Now())
+		if err != nil {
+			return
+		}
+
+		req, err := http.NewRequest(http.MethodGet, presignedURL, nil)
+		if err != nil {
+			return
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set(AWSIAMAudienceHeader, conf.Audience)
+		req.Header.Set("Accept", "application/json")
+
+		client := conf.HTTPClient
+		if client == nil {
+			client = &http.Client{Timeout: 30 * time.Second}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			err = ErrorInvalidAWSIAMToken
+			if resp.StatusCode >= http.StatusInternalServerError {
+				err = &osecure.StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("AWS STS error: status code: %d", resp.StatusCode)}
+			}
+			return
+		}
+
+		var result struct {
+			GetCallerIdentityResponse struct {
+				GetCallerIdentityResult struct {
+					Account string `json:"Account"`
+					Arn     string `json:"Arn"`
+					UserID  string `json:"UserId"`
+				} `json:"GetCallerIdentityResult"`
+			} `json:"GetCallerIdentityResponse"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			return
+		}
+		identity := result.GetCallerIdentityResponse.GetCallerIdentityResult
+		if identity.Arn == "" || identity.Account == "" {
+			err = ErrorInvalidAWSIAMToken
+			return
+		}
+		if len(conf.AccountIDs) > 0 && !containsString(conf.AccountIDs, identity.Account) {
+			err = ErrorAWSAccountDenied
+			return
+		}
+
+		userID = AWSPrincipalARN(identity.Arn)
+		clientID = userID
+		extra = map[string]interface{}{
+			osecure.ExtraKeyIssuer: "https://" + req.URL.Host,
+			ExtraKeyAWSAccount:     identity.Account,
+			ExtraKeyAWSARN:         identity.Arn,
+			ExtraKeyAWSUserID:      identity.UserID,
+		}
+		return
+	}
+}
+
+// parseAWSIAMToken validates the presigned URL of the token is a sts:GetCallerIdentity request to STS,
+// signed with the audience header, so the token can't make the server send other requests.
+func parseAWSIAMToken(token string, now time.Time) (presignedURL string, expiresAt int64, err error) {
+	if !strings.HasPrefix(token, AWSIAMTokenPrefix) {
+		return "", 0, ErrorInvalidAWSIAMToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, AWSIAMTokenPrefix))
+	if err != nil {
+		return "", 0, ErrorInvalidAWSIAMToken
+	}
+	u, err := url.Parse(string(b))
+	if err != nil || u.Scheme != "https" || u.User != nil || u.Port() != "" || !stsHostPattern.MatchString(u.Host) || (u.Path != "/" && u.Path != "") || u.Fragment != "" {
+		return "", 0, ErrorInvalidAWSIAMToken
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return "", 0, ErrorInvalidAWSIAMToken
+	}
+	for name, values := range query {
+		if len(values) != 1 {
+			return "", 0, ErrorInvalidAWSIAMToken
+		}
+		switch name {
+		case "Action", "Version", "X-Amz-Algorithm", "X-Amz-Credential", "X-Amz-Date", "X-Amz-Expires",
+			"X-Amz-SignedHeaders", "X-Amz-Signature", "X-Amz-Security-Token":
+		default:
+			return "", 0, ErrorInvalidAWSIAMToken
+		}
+	}
+	if query.Get("Action") != "GetCallerIdentity" || query.Get("Version") != "2011-06-15" || query.Get("X-Amz-Algorithm") != aws_sigv4.Algorithm {
+		return "", 0, ErrorInvalidAWSIAMToken
+	}
+	if !containsString(strings.Split(query.Get("X-Amz-SignedHeaders"), ";"), strings.ToLower(AWSIAMAudienceHeader)) {
+		return "", 0, ErrorInvalidAWSIAMToken
+	}
+
+	signedAt, err := time.Parse(aws_sigv4.TimeFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return "", 0, ErrorInvalidAWSIAMToken
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires <= 0 || expires > 15*60 {
+		return "", 0, ErrorInvalidAWSIAMToken
+	}
+	expiry := signedAt.Add(time.Duration(expires) * time.Second)
+	if now.Before(signedAt.Add(-time.Minute)) || !now.Before(expiry) {
+		return "", 0, ErrorInvalidAWSIAMToken
+	}
+	return u.String(), expiry.Unix(), nil
+}
+
+// AWSPrincipalARN maps the ARN of the caller identity to the ARN of the principal, which is the role ARN for
+// assumed role sessions, e.g. "arn:aws:sts::123456789012:assumed-role/name/session" to
+// "arn:aws:iam::123456789012:role/name". Other ARNs are returned as they are.
+func AWSPrincipalARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "sts" || !strings.HasPrefix(parts[5], "assumed-role/") {
+		return arn
+	}
+	resource := strings.Split(strings.TrimPrefix(parts[5], "assumed-role/"), "/")
+	if len(resource) != 2 {
+		return arn
+	}
+	// paths of roles are not in the ARN of sessions
+	return parts[0] + ":" + parts[1] + ":iam::" + parts[4] + ":role/" + resource[0]
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -4,8 +4,12 @@ package contrib
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/rayark/osecure/v6"
 	"golang.org/x/oauth2"
@@ -22,6 +26,9 @@ var GoogleOauth2Endpoint = osecure.OAuthEndpoint{
 // GoogleTokenInfoEndpointURL is Google's get token info endpoint url
 const GoogleTokenInfoEndpointURL = "https://www.googleapis.com/oauth2/v3/tokeninfo"
 
+// GoogleIssuer is the issuer of Google's tokens
+const GoogleIssuer = "https://accounts.google.com"
+
 // predefined token introspection func
 
 // GoogleIntrospection define the introspection function with google access token
@@ -54,11 +61,15 @@ func GoogleIntrospection() osecure.IntrospectTokenFunc {
 				return
 			}
 
-			err = fmt.Errorf("Google API error: status code: %d, description: %s", resp.StatusCode, errorResult.ErrorDescription)
+			err = &osecure.StatusError{
+				StatusCode: resp.StatusCode,
+				Err:        fmt.Errorf("Google API error: status code: %d, description: %s", resp.StatusCode, errorResult.ErrorDescription),
+			}
 			return
 		}
 
 		var result struct {
+			Issuer          string `json:"iss"`
 			Subject         string `json:"sub"`
 			Audience        string `json:"aud"`
 			AuthorizedParty string `json:"azp"`
@@ -80,10 +91,19 @@ func GoogleIntrospection() osecure.IntrospectTokenFunc {
 			aliases = []string{result.EMail}
 		}
 		extraData["aliases"] = aliases
+		if len(aliases) > 0 {
+			extraData[osecure.ExtraKeyEmail] = result.EMail
+		}
 		extraData["azp"] = result.AuthorizedParty
 		extraData["expires_in"] = result.ExpiresIn
 		extraData["access_type"] = result.AccessType
 
+		// tokeninfo only answers for tokens issued by Google, and omits iss for access tokens
+		if result.Issuer == "" {
+			result.Issuer = GoogleIssuer
+		}
+		extraData[osecure.ExtraKeyIssuer] = result.Issuer
+
 		userID = result.Subject
 		clientID = result.Audience
 		expiresAt = result.ExpiresAt
@@ -92,6 +112,84 @@ func GoogleIntrospection() osecure.IntrospectTokenFunc {
 	}
 }
 
+// ErrorInactiveToken is returned by Introspection if the token is not active.
+var ErrorInactiveToken = errors.New("token is not active")
+
+// Introspection define the introspection function with OAuth 2.0 token introspection endpoint (RFC 7662),
+// authenticating as the client. All members of the response are kept in extra data.
+func Introspection(endpointURL string, authClientID string, authClientSecret string) osecure.IntrospectTokenFunc {
+	return introspection(endpointURL, func(req *http.Request, form url.Values) error {
+		req.SetBasicAuth(url.QueryEscape(authClientID), url.QueryEscape(authClientSecret))
+		return nil
+	})
+}
+
+// IntrospectionWithAssertion is Introspection authenticating by JWT client assertions of the signer
+// (private_key_jwt or client_secret_jwt) instead of the client secret.
+func IntrospectionWithAssertion(endpointURL string, signer *osecure.ClientAssertionSigner) osecure.IntrospectTokenFunc {
+	return introspection(endpointURL, func(req *http.Request, form url.Values) error {
+		return signer.Authenticate(form, endpointURL)
+	})
+}
+
+// introspection is the introspection function whose requests are authenticated by the function,
+// which can set the header of the request or the form to post.
+func introspection(endpointURL string, authenticate func(req *http.Request, form url.Values) error) osecure.IntrospectTokenFunc {
+	return func(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+		form := url.Values{}
+		form.Set("token", accessToken)
+		form.Set("token_type_hint", "access_token")
+
+		req, err := http.NewRequest(http.MethodPost, endpointURL, nil)
+		if err != nil {
+			return
+		}
+		err = authenticate(req, form)
+		if err != nil {
+			return
+		}
+		body := form.Encode()
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			err = &osecure.StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("introspection error: status code: %d", resp.StatusCode)}
+			return
+		}
+
+		var result map[string]interface{}
+		decoder := json.NewDecoder(resp.Body)
+		decoder.UseNumber()
+		err = decoder.Decode(&result)
+		if err != nil {
+			return
+		}
+
+		if active, _ := result["active"].(bool); !active {
+			err = ErrorInactiveToken
+			return
+		}
+
+		userID, _ = result["sub"].(string)
+		clientID, _ = result["client_id"].(string)
+		if exp, ok := result["exp"].(json.Number); ok {
+			expiresAt, _ = exp.Int64()
+		}
+		extra = result
+		return
+	}
+}
+
 // predefined permission getter func
 
 // CommonPermissionRoles granted permission with everyone in the same way
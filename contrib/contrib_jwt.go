@@ -0,0 +1,63 @@
+package contrib
+
+import (
+	"context"
+
+	"github.com/rayark/osecure/v6"
+	"github.com/rayark/osecure/v6/jwt"
+)
+
+// JWTIntrospection define the introspection function validating JWT access tokens (RFC 9068) locally,
+// by the signature with the key set and the expected claims. "exp" and "sub" are required,
+// the client ID is "client_id", "azp" or the only "aud". All claims are kept in extra data.
+func JWTIntrospection(keySet jwt.KeySet, expected jwt.Expected) osecure.IntrospectTokenFunc {
+	return func(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+		token, err := jwt.Parse(accessToken)
+		if err != nil {
+			return
+		}
+		err = token.VerifyWithKeySet(ctx, keySet)
+		if err != nil {
+			return
+		}
+		err = token.Claims.Validate(expected)
+		if err != nil {
+			return
+		}
+
+		expiresAt, ok := token.Claims.Int64("exp")
+		userID = token.Claims.String("sub")
+		if !ok || userID == "" {
+			err = jwt.ErrorMissingClaim
+			return
+		}
+
+		clientID = token.Claims.String("client_id")
+		if clientID == "" {
+			clientID = token.Claims.String("azp")
+		}
+		if audiences := token.Claims.Strings("aud"); clientID == "" && len(audiences) == 1 {
+			clientID = audiences[0]
+		}
+		extra = token.Claims
+		return
+	}
+}
+
+// HybridIntrospection define the introspection function for mixed tokens, validating JWT access tokens by local
+// and introspecting opaque tokens by remote, e.g. JWTIntrospection and Introspection.
+// JWTs signed by keys not in the key set of local are introspected by remote as well,
+// e.g. legacy tokens which happen to be JWTs.
+func HybridIntrospection(local osecure.IntrospectTokenFunc, remote osecure.IntrospectTokenFunc) osecure.IntrospectTokenFunc {
+	return func(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+		if !jwt.IsJWT(accessToken) {
+			return remote(ctx, accessToken)
+		}
+
+		userID, clientID, expiresAt, extra, err = local(ctx, accessToken)
+		if err == jwt.ErrorKeyNotFound {
+			return remote(ctx, accessToken)
+		}
+		return
+	}
+}
@@ -0,0 +1,270 @@
+package contrib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"github.com/rayark/osecure/v6/jwt"
+)
+
+// KubernetesServiceAccountDir is the directory of the service account token and the cluster CA in pods.
+const KubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesReviewedTokenLifetime is the lifetime of sessions of reviewed tokens without expiry,
+// e.g. legacy service account tokens of secrets, after which they are reviewed again.
+const KubernetesReviewedTokenLifetime = 5 * time.Minute
+
+// Keys of extra data of Kubernetes service account tokens, besides osecure.ExtraKeyIssuer and osecure.ExtraKeyGroups.
+const (
+	ExtraKeyKubernetesNamespace      = "kubernetes_namespace"
+	ExtraKeyKubernetesServiceAccount = "kubernetes_service_account"
+	ExtraKeyKubernetesPod            = "kubernetes_pod"
+)
+
+var (
+	ErrorNotInCluster                = errors.New("not running in a Kubernetes cluster")
+	ErrorUnauthenticatedToken        = errors.New("token is not authenticated by Kubernetes")
+	ErrorNotKubernetesServiceAccount = errors.New("token is not of a Kubernetes service account")
+)
+
+// KubernetesCluster is the API server of a Kubernetes cluster, authenticated by the token of the service account
+// of the server, which must be allowed to create TokenReviews (e.g. bound to ClusterRole "system:auth-delegator").
+type KubernetesCluster struct {
+	APIServerURL string
+	// TokenFile is read for every request, since projected tokens are rotated by kubelet.
+	TokenFile  string
+	HTTPClient *http.Client // trusting the cluster CA
+}
+
+// InClusterKubernetes is the cluster running the pod, by the environment and the files of the service account.
+func InClusterKubernetes() (*KubernetesCluster, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrorNotInCluster
+	}
+
+	caCert, err := ioutil.ReadFile(KubernetesServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificate in %s/ca.crt", KubernetesServiceAccountDir)
+	}
+
+	return &KubernetesCluster{
+		APIServerURL: "https://" + net.JoinHostPort(host, port),
+		TokenFile:    KubernetesServiceAccountDir + "/token",
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12},
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			Timeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+// RoundTrip authenticates requests to the API server by the token of TokenFile.
+func (c *KubernetesCluster) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := ioutil.ReadFile(c.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	transport := http.DefaultTransport
+	if c.HTTPClient != nil && c.HTTPClient.Transport != nil {
+		transport = c.HTTPClient.Transport
+	}
+	return transport.RoundTrip(req)
+}
+
+func (c *KubernetesCluster) client() *http.Client {
+	client := &http.Client{Transport: c}
+	if c.HTTPClient != nil {
+		client.Timeout = c.HTTPClient.Timeout
+	}
+	return client
+}
+
+// KeySet is the key set of service account tokens of the cluster, fetched from the API server,
+// for KubernetesJWTIntrospection.
+func (c *KubernetesCluster) KeySet() *jwt.RemoteKeySet {
+	keySet := jwt.NewRemoteKeySet(strings.TrimSuffix(c.APIServerURL, "/") + "/openid/v1/jwks")
+	keySet.HTTPClient = c.client()
+	return keySet
+}
+
+// KubernetesTokenReview define the introspection function of Kubernetes service account tokens by the TokenReview API
+// of the cluster, which also rejects tokens of deleted pods and service accounts. The token must be issued for one of
+// the audiences if any, e.g. the client ID of the server, otherwise for the API server.
+// The user ID and client ID are "system:serviceaccount:<namespace>:<name>".
+func KubernetesTokenReview(cluster *KubernetesCluster, audiences ...string) osecure.IntrospectTokenFunc {
+	return func(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+		var review struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+			Spec       struct {
+				Token     string   `json:"token"`
+				Audiences []string `json:"audiences,omitempty"`
+			} `json:"spec"`
+			Status struct {
+				Authenticated bool `json:"authenticated"`
+				User          struct {
+					Username string              `json:"username"`
+					UID      string              `json:"uid"`
+					Groups   []string            `json:"groups"`
+					Extra    map[string][]string `json:"extra"`
+				} `json:"user"`
+				Error string `json:"error"`
+			} `json:"status"`
+		}
+		review.APIVersion = "authentication.k8s.io/v1"
+		review.Kind = "TokenReview"
+		review.Spec.Token = accessToken
+		review.Spec.Audiences = audiences
+
+		body, err := json.Marshal(&review)
+		if err != nil {
+			return
+		}
+		req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(cluster.APIServerURL, "/")+"/apis/authentication.k8s.io/v1/tokenreviews", bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := cluster.client().Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			err = &osecure.StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("Kubernetes TokenReview error: status code: %d", resp.StatusCode)}
+			return
+		}
+		err = json.NewDecoder(resp.Body).Decode(&review)
+		if err != nil {
+			return
+		}
+
+		if !review.Status.Authenticated {
+			err = ErrorUnauthenticatedToken
+			if review.Status.Error != "" {
+				err = fmt.Errorf("%w: %s", ErrorUnauthenticatedToken, review.Status.Error)
+			}
+			return
+		}
+		namespace, name, ok := parseServiceAccountUsername(review.Status.User.Username)
+		if !ok {
+			err = ErrorNotKubernetesServiceAccount
+			return
+		}
+
+		extra = map[string]interface{}{
+			ExtraKeyKubernetesNamespace:      namespace,
+			ExtraKeyKubernetesServiceAccount: name,
+			osecure.ExtraKeyGroups:           review.Status.User.Groups,
+			"uid":                            review.Status.User.UID,
+		}
+		if pods := review.Status.User.Extra["authentication.kubernetes.io/pod-name"]; len(pods) == 1 {
+			extra[ExtraKeyKubernetesPod] = pods[0]
+		}
+
+		// the API server has verified the token, whose claims are trusted
+		expiresAt = time.Now().Add(KubernetesReviewedTokenLifetime).Unix()
+		if token, err := jwt.Parse(accessToken); err == nil {
+			if exp, ok := token.Claims.Int64("exp"); ok {
+				expiresAt = exp
+			}
+			if issuer := token.Claims.String("iss"); issuer != "" {
+				extra[osecure.ExtraKeyIssuer] = issuer
+			}
+		}
+
+		userID = review.Status.User.Username
+		clientID = userID
+		return
+	}
+}
+
+// KubernetesJWTIntrospection define the introspection function of projected Kubernetes service account tokens
+// validated locally, by the issuer of the cluster (--service-account-issuer) and the key set, e.g. KeySet of the
+// cluster or jwt.NewRemoteKeySet of the JWKS URL of its OIDC discovery. The token must be issued for the audience,
+// e.g. the client ID of the server. Unlike KubernetesTokenReview, tokens of deleted pods are valid until expired.
+// The user ID and client ID are "system:serviceaccount:<namespace>:<name>".
+func KubernetesJWTIntrospection(issuer string, keySet jwt.KeySet, audience string) osecure.IntrospectTokenFunc {
+	expected := jwt.Expected{Issuer: issuer, Audiences: []string{audience}, Leeway: time.Minute}
+	return func(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+		token, err := jwt.Parse(accessToken)
+		if err != nil {
+			return
+		}
+		err = token.VerifyWithKeySet(ctx, keySet)
+		if err != nil {
+			return
+		}
+		err = token.Claims.Validate(expected)
+		if err != nil {
+			return
+		}
+
+		expiresAt, ok := token.Claims.Int64("exp")
+		if !ok {
+			// legacy tokens never expire
+			err = jwt.ErrorMissingClaim
+			return
+		}
+		namespace, name, ok := parseServiceAccountUsername(token.Claims.String("sub"))
+		if !ok {
+			err = ErrorNotKubernetesServiceAccount
+			return
+		}
+
+		extra = map[string]interface{}{
+			osecure.ExtraKeyIssuer:           issuer,
+			ExtraKeyKubernetesNamespace:      namespace,
+			ExtraKeyKubernetesServiceAccount: name,
+			osecure.ExtraKeyGroups:           []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace},
+		}
+		if kubernetes, ok := token.Claims["kubernetes.io"].(map[string]interface{}); ok {
+			if serviceAccount, ok := kubernetes["serviceaccount"].(map[string]interface{}); ok {
+				extra["uid"] = serviceAccount["uid"]
+			}
+			if pod, ok := kubernetes["pod"].(map[string]interface{}); ok {
+				extra[ExtraKeyKubernetesPod] = pod["name"]
+			}
+		}
+
+		userID = token.Claims.String("sub")
+		clientID = userID
+		return
+	}
+}
+
+// parseServiceAccountUsername parses "system:serviceaccount:<namespace>:<name>".
+func parseServiceAccountUsername(username string) (namespace string, name string, ok bool) {
+	parts := strings.Split(username, ":")
+	if len(parts) != 4 || parts[0] != "system" || parts[1] != "serviceaccount" || parts[2] == "" || parts[3] == "" {
+		return "", "", false
+	}
+	return parts[2], parts[3], true
+}
@@ -0,0 +1,154 @@
+package contrib
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/rayark/osecure/v6"
+	"github.com/rayark/osecure/v6/jwt"
+	"golang.org/x/oauth2"
+)
+
+// ExtraKeySPIFFETrustDomain is the key of extra data of the trust domain of JWT-SVIDs.
+const ExtraKeySPIFFETrustDomain = "spiffe_trust_domain"
+
+var (
+	ErrorInvalidSPIFFEID        = errors.New("invalid SPIFFE ID")
+	ErrorUntrustedTrustDomain   = errors.New("SPIFFE trust domain is not trusted")
+	ErrorInvalidX509SVIDSubject = errors.New("X.509-SVID must have exactly one SPIFFE ID")
+)
+
+// ParseSPIFFEID validates the SPIFFE ID (e.g. "spiffe://example.org/ns/prod/sa/api"), returning its trust domain
+// and path.
+func ParseSPIFFEID(id string) (trustDomain string, path string, err error) {
+	u, err := url.Parse(id)
+	if err != nil || u.Scheme != "spiffe" || u.Host == "" || u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" || u.Opaque != "" {
+		return "", "", ErrorInvalidSPIFFEID
+	}
+	for _, c := range u.Host {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return "", "", ErrorInvalidSPIFFEID
+		}
+	}
+	if u.Path != "" {
+		for _, segment := range strings.Split(u.Path[1:], "/") {
+			if segment == "" || segment == "." || segment == ".." {
+				return "", "", ErrorInvalidSPIFFEID
+			}
+			for _, c := range segment {
+				if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+					return "", "", ErrorInvalidSPIFFEID
+				}
+			}
+		}
+	}
+	return u.Host, u.Path, nil
+}
+
+// SPIFFEJWTIntrospection define the introspection function of JWT-SVIDs, whose signature is verified by the key set
+// of the trust domain of its SPIFFE ID, e.g. jwt.NewRemoteKeySet of the bundle endpoint of SPIRE server.
+// The JWT-SVID must be issued for the audience, e.g. the SPIFFE ID of the server.
+// The user ID and client ID are the SPIFFE ID, like service accounts.
+func SPIFFEJWTIntrospection(bundles map[string]jwt.KeySet, audience string) osecure.IntrospectTokenFunc {
+	return func(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+		token, err := jwt.Parse(accessToken)
+		if err != nil {
+			return
+		}
+		trustDomain, _, err := ParseSPIFFEID(token.Claims.String("sub"))
+		if err != nil {
+			return
+		}
+		keySet, ok := bundles[trustDomain]
+		if !ok {
+			err = ErrorUntrustedTrustDomain
+			return
+		}
+		err = token.VerifyWithKeySet(ctx, keySet)
+		if err != nil {
+			return
+		}
+		err = token.Claims.Validate(jwt.Expected{Audiences: []string{audience}})
+		if err != nil {
+			return
+		}
+		expiresAt, ok = token.Claims.Int64("exp")
+		if !ok {
+			err = jwt.ErrorMissingClaim
+			return
+		}
+
+		userID = token.Claims.String("sub")
+		clientID = userID
+		extra = map[string]interface{}{
+			osecure.ExtraKeyIssuer:    "spiffe://" + trustDomain,
+			ExtraKeySPIFFETrustDomain: trustDomain,
+		}
+		return
+	}
+}
+
+// SPIFFEPermissionRules maps SPIFFE IDs to permissions. A rule matches an ID exactly, the IDs under a path ending
+// with "/*" (e.g. "spiffe://example.org/ns/prod/*"), or all IDs of a trust domain (e.g. "spiffe://example.org").
+// The permissions of an ID are the union of the matching rules.
+type SPIFFEPermissionRules map[string][]string
+
+// Permissions gets the permissions of the SPIFFE ID.
+func (rules SPIFFEPermissionRules) Permissions(id string) []string {
+	trustDomain, path, err := ParseSPIFFEID(id)
+	if err != nil {
+		return nil
+	}
+
+	permissionSet := make(map[string]struct{})
+	for pattern, rulePermissions := range rules {
+		matched := pattern == id || pattern == "spiffe://"+trustDomain
+		if prefix := strings.TrimSuffix(pattern, "*"); !matched && prefix != pattern && strings.HasSuffix(prefix, "/") {
+			matched = strings.HasPrefix("spiffe://"+trustDomain+path, prefix)
+		}
+		if matched {
+			for _, permission := range rulePermissions {
+				permissionSet[permission] = struct{}{}
+			}
+		}
+	}
+
+	permissions := make([]string, 0, len(permissionSet))
+	for permission := range permissionSet {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// GetPermissionsFunc is the permission getter of the rules, for the sessions of SPIFFEJWTIntrospection.
+func (rules SPIFFEPermissionRules) GetPermissionsFunc() osecure.GetPermissionsFunc {
+	return func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+		return rules.Permissions(userID), nil
+	}
+}
+
+// SPIFFEClientCertificateVerifier is the verifier of X.509-SVIDs for osecure.NewClientCertificateAuthenticator,
+// mapping the SPIFFE ID in URI SAN to the user ID and the permissions by the rules. The certificate chain must be
+// verified by the TLS server with the X.509 bundles of trustDomains as tls.Config.ClientCAs. The verified chain isn't
+// known here, so a CA of any bundle in ClientCAs can sign SVIDs of all of trustDomains.
+func SPIFFEClientCertificateVerifier(rules SPIFFEPermissionRules, trustDomains ...string) osecure.ClientCertificateVerifier {
+	return func(ctx context.Context, certificate *x509.Certificate) (userID string, permissions []string, err error) {
+		if len(certificate.URIs) != 1 || certificate.IsCA {
+			return "", nil, ErrorInvalidX509SVIDSubject
+		}
+		userID = certificate.URIs[0].String()
+		trustDomain, _, err := ParseSPIFFEID(userID)
+		if err != nil {
+			return "", nil, err
+		}
+		if !containsString(trustDomains, trustDomain) {
+			return "", nil, ErrorUntrustedTrustDomain
+		}
+		return userID, rules.Permissions(userID), nil
+	}
+}
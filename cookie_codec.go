@@ -0,0 +1,507 @@
+package osecure
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// maxEncodedCookieLength is the length limit of encoded cookie values, the same as securecookie,
+// since browsers drop cookies larger than 4KB.
+const maxEncodedCookieLength = 4096
+
+var (
+	ErrorInvalidCookieCodecKey = errors.New("invalid key of cookie codec")
+	errCookieCodecDecode       = errors.New("cannot decode cookie by codec")
+)
+
+// CookieCodec encodes the payload of the session cookie into the cookie value and decodes it back, which must
+// authenticate the payload and should encrypt it, since it has the access token and the refresh token.
+type CookieCodec interface {
+	Encode(name string, payload []byte) (string, error)
+	Decode(name string, value string) ([]byte, error)
+}
+
+// SetCookieCodec encodes the session cookie by the codec instead of the cookie keys, e.g. FernetCookieCodec or
+// JWECookieCodec, so services in other languages can read and write the same session cookie.
+// The payload is the JSON object {"auth": {...}} of the session data in the schema of the serialized cookie data,
+// e.g. "at" for the access token, "exp" for its expiry and "perm" for permissions, or {"sid": "..."} if
+// MinimalCookie is enabled. Cookies of the cookie keys are still decoded, so sessions are kept when the codec is
+// enabled. Other cookies, e.g. the state cookie, are still encoded by the cookie keys.
+// It should be called before serving requests.
+func (s *OAuthSession) SetCookieCodec(codec CookieCodec) {
+	s.cookieCodec = codec
+}
+
+// getAuthCookieStore gets the cookie store of the session cookie, with the codec of SetCookieCodec encoding
+// cookies before the codecs of the cookie keys, and the domain of SSOCookieDomain.
+func (s *OAuthSession) getAuthCookieStore() *sessions.CookieStore {
+	cookieStore := s.getCookieStore()
+	if s.cookieCodec == nil && s.ssoCookieDomain == "" {
+		return cookieStore
+	}
+
+	authCookieStore := &sessions.CookieStore{
+		Codecs:  cookieStore.Codecs,
+		Options: cookieStore.Options,
+	}
+	_, isHMAC := s.cookieCodec.(*HMACCookieCodec)
+	if s.cookieCodec != nil {
+		adapter := cookieCodecAdapter{codec: s.cookieCodec, omitRefreshToken: isHMAC}
+		authCookieStore.Codecs = append([]securecookie.Codec{adapter}, cookieStore.Codecs...)
+	}
+	if s.ssoCookieDomain != "" || isHMAC {
+		options := *cookieStore.Options
+		if s.ssoCookieDomain != "" {
+			options.Domain = s.ssoCookieDomain
+		}
+		if isHMAC {
+			// the payload isn't encrypted, which is kept from scripts of pages
+			options.HttpOnly = true
+		}
+		authCookieStore.Options = &options
+	}
+	return authCookieStore
+}
+
+// sessionCookiePayload is the JSON payload of session cookies encoded by CookieCodec.
+type sessionCookiePayload struct {
+	Auth      json.RawMessage `json:"auth,omitempty"`
+	SessionID string          `json:"sid,omitempty"`
+}
+
+// cookieCodecAdapter adapts CookieCodec to securecookie.Codec of the session values of the session cookie.
+type cookieCodecAdapter struct {
+	codec CookieCodec
+	// omitRefreshToken leaves the refresh token out of the payload, for codecs which don't encrypt it.
+	omitRefreshToken bool
+}
+
+func (a cookieCodecAdapter) Encode(name string, value interface{}) (string, error) {
+	values, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return "", fmt.Errorf("cookie codec cannot encode %T", value)
+	}
+
+	for key := range values {
+		if key != "auth" && key != "sid" {
+			// other cookies, e.g. the state cookie, are left to the codecs of the cookie keys
+			return "", fmt.Errorf("cookie codec cannot encode value of %v", key)
+		}
+	}
+
+	payload := &sessionCookiePayload{}
+	if v, found := values["auth"]; found {
+		data, ok := v.([]byte)
+		if !ok || len(data) == 0 {
+			return "", fmt.Errorf("cookie codec cannot encode %T", v)
+		}
+		switch data[0] {
+		case cookieFormatJSON:
+			payload.Auth = data[1:]
+		case cookieFormatJSONGzip:
+			decompressed, err := gunzip(data[1:])
+			if err != nil {
+				return "", err
+			}
+			payload.Auth = decompressed
+		default:
+			return "", ErrorInvalidSession
+		}
+		if a.omitRefreshToken {
+			auth, err := omitJSONMember(payload.Auth, "rt")
+			if err != nil {
+				return "", err
+			}
+			payload.Auth = auth
+		}
+	}
+	if v, found := values["sid"]; found {
+		payload.SessionID, _ = v.(string)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := a.codec.Encode(name, data)
+	if err != nil {
+		return "", err
+	}
+	if len(encoded) > maxEncodedCookieLength {
+		return "", ErrorCookieTooLarge
+	}
+	return encoded, nil
+}
+
+// omitJSONMember removes the member of the JSON object.
+func omitJSONMember(data []byte, member string) ([]byte, error) {
+	object := make(map[string]json.RawMessage)
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := object[member]; !found {
+		return data, nil
+	}
+	delete(object, member)
+	return json.Marshal(object)
+}
+
+func (a cookieCodecAdapter) Decode(name string, value string, dst interface{}) error {
+	values, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("cookie codec cannot decode into %T", dst)
+	}
+
+	data, err := a.codec.Decode(name, value)
+	if err != nil {
+		return err
+	}
+	if !utf8.Valid(data) {
+		return errCookieCodecDecode
+	}
+	payload := &sessionCookiePayload{}
+	err = json.Unmarshal(data, payload)
+	if err != nil {
+		return err
+	}
+
+	if *values == nil {
+		*values = make(map[interface{}]interface{})
+	}
+	if len(payload.Auth) > 0 {
+		(*values)["auth"] = append([]byte{cookieFormatJSON}, payload.Auth...)
+	}
+	if payload.SessionID != "" {
+		(*values)["sid"] = payload.SessionID
+	}
+	return nil
+}
+
+// FernetCookieCodec encodes cookies as Fernet tokens (https://github.com/fernet/spec), e.g. of the Python
+// cryptography package. Tokens are encoded by the first key and decoded by any of the keys.
+// Fernet doesn't authenticate the cookie name, so the keys should only be used for the session cookie.
+type FernetCookieCodec struct {
+	// MaxAge rejects tokens older than it if not zero, while the expiry of sessions is in the payload anyway.
+	MaxAge time.Duration
+
+	keys []fernetKey
+}
+
+type fernetKey struct {
+	signingKey    []byte
+	encryptionKey cipher.Block
+}
+
+const (
+	fernetVersion   = byte(0x80)
+	fernetClockSkew = time.Minute
+)
+
+// NewFernetCookieCodec creates FernetCookieCodec of the keys, which are URL-safe base64 encoded 32 bytes,
+// e.g. generated by Fernet.generate_key() of Python.
+func NewFernetCookieCodec(keys ...string) (*FernetCookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, ErrorInvalidCookieCodecKey
+	}
+
+	codec := &FernetCookieCodec{keys: make([]fernetKey, 0, len(keys))}
+	for _, encodedKey := range keys {
+		key, err := base64.URLEncoding.DecodeString(encodedKey)
+		if err != nil || len(key) != 32 {
+			return nil, ErrorInvalidCookieCodecKey
+		}
+		block, err := aes.NewCipher(key[16:])
+		if err != nil {
+			return nil, err
+		}
+		codec.keys = append(codec.keys, fernetKey{signingKey: key[:16], encryptionKey: block})
+	}
+	return codec, nil
+}
+
+// Encode encrypts the payload as a Fernet token by AES-128-CBC and HMAC-SHA256.
+func (c *FernetCookieCodec) Encode(name string, payload []byte) (string, error) {
+	key := c.keys[0]
+
+	padding := aes.BlockSize - len(payload)%aes.BlockSize
+	token := make([]byte, 1+8+aes.BlockSize, 1+8+aes.BlockSize+len(payload)+padding+sha256.Size)
+	token[0] = fernetVersion
+	binary.BigEndian.PutUint64(token[1:9], uint64(time.Now().Unix()))
+	iv := token[9:]
+	_, err := rand.Read(iv)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := append(append([]byte(nil), payload...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(key.encryptionKey, iv).CryptBlocks(ciphertext, plaintext)
+	token = append(token, ciphertext...)
+
+	mac := hmac.New(sha256.New, key.signingKey)
+	mac.Write(token)
+	token = mac.Sum(token)
+	return base64.URLEncoding.EncodeToString(token), nil
+}
+
+// Decode verifies and decrypts the Fernet token.
+func (c *FernetCookieCodec) Decode(name string, value string) ([]byte, error) {
+	token, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errCookieCodecDecode
+	}
+	if len(token) < 1+8+aes.BlockSize+aes.BlockSize+sha256.Size || token[0] != fernetVersion ||
+		(len(token)-1-8-sha256.Size)%aes.BlockSize != 0 {
+		return nil, errCookieCodecDecode
+	}
+
+	signed, signature := token[:len(token)-sha256.Size], token[len(token)-sha256.Size:]
+	for _, key := range c.keys {
+		mac := hmac.New(sha256.New, key.signingKey)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			continue
+		}
+
+		now := time.Now()
+		issuedAt := time.Unix(int64(binary.BigEndian.Uint64(signed[1:9])), 0)
+		if issuedAt.After(now.Add(fernetClockSkew)) || (c.MaxAge > 0 && now.Sub(issuedAt) > c.MaxAge) {
+			return nil, errCookieCodecDecode
+		}
+
+		iv, ciphertext := signed[9:9+aes.BlockSize], signed[9+aes.BlockSize:]
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(key.encryptionKey, iv).CryptBlocks(plaintext, ciphertext)
+		padding := int(plaintext[len(plaintext)-1])
+		if padding == 0 || padding > aes.BlockSize ||
+			subtle.ConstantTimeCompare(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) != 1 {
+			return nil, errCookieCodecDecode
+		}
+		return plaintext[:len(plaintext)-padding], nil
+	}
+	return nil, errCookieCodecDecode
+}
+
+// JWECookieKey is a key of JWECookieCodec, whose length of 16, 24 or 32 bytes is of A128GCM, A192GCM or A256GCM.
+type JWECookieKey struct {
+	KeyID string
+	Key   []byte
+}
+
+// JWECookieCodec encodes cookies as JWE compact serialization of direct encryption ("alg": "dir") by AES-GCM,
+// which libraries of JOSE, e.g. jose of Node.js, decrypt with the key of "kid". The payload isn't compressed.
+// JWE doesn't authenticate the cookie name, so the keys should only be used for the session cookie.
+type JWECookieCodec struct {
+	keys []jweCookieKey // current key first
+}
+
+type jweCookieKey struct {
+	id         string
+	encryption string
+	aead       cipher.AEAD
+}
+
+// NewJWECookieCodec creates JWECookieCodec encrypting by the current key and decrypting by it and the previous keys.
+func NewJWECookieCodec(current JWECookieKey, previous ...JWECookieKey) (*JWECookieCodec, error) {
+	codec := &JWECookieCodec{keys: make([]jweCookieKey, 0, 1+len(previous))}
+	for _, key := range append([]JWECookieKey{current}, previous...) {
+		var encryption string
+		switch len(key.Key) {
+		case 16:
+			encryption = "A128GCM"
+		case 24:
+			encryption = "A192GCM"
+		case 32:
+			encryption = "A256GCM"
+		default:
+			return nil, ErrorInvalidCookieCodecKey
+		}
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		codec.keys = append(codec.keys, jweCookieKey{id: key.KeyID, encryption: encryption, aead: aead})
+	}
+	return codec, nil
+}
+
+type jweHeader struct {
+	Algorithm   string `json:"alg"`
+	Encryption  string `json:"enc"`
+	KeyID       string `json:"kid,omitempty"`
+	Compression string `json:"zip,omitempty"`
+}
+
+// Encode encrypts the payload as a compact JWE by the current key.
+func (c *JWECookieCodec) Encode(name string, payload []byte) (string, error) {
+	key := c.keys[0]
+
+	header, err := json.Marshal(&jweHeader{Algorithm: "dir", Encryption: key.encryption, KeyID: key.id})
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+
+	iv := make([]byte, key.aead.NonceSize())
+	_, err = rand.Read(iv)
+	if err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nil, iv, payload, []byte(encodedHeader))
+	ciphertext, tag := sealed[:len(payload)], sealed[len(payload):]
+
+	return encodedHeader + ".." + base64.RawURLEncoding.EncodeToString(iv) + "." +
+		base64.RawURLEncoding.EncodeToString(ciphertext) + "." + base64.RawURLEncoding.EncodeToString(tag), nil
+}
+
+// Decode decrypts the compact JWE by the key of "kid", or any key of the encryption if "kid" is absent.
+func (c *JWECookieCodec) Decode(name string, value string) ([]byte, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return nil, errCookieCodecDecode
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errCookieCodecDecode
+	}
+	header := &jweHeader{}
+	err = json.Unmarshal(headerJSON, header)
+	if err != nil || header.Algorithm != "dir" || header.Compression != "" {
+		return nil, errCookieCodecDecode
+	}
+
+	iv, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errCookieCodecDecode
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, errCookieCodecDecode
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, errCookieCodecDecode
+	}
+
+	for _, key := range c.keys {
+		if key.encryption != header.Encryption || (header.KeyID != "" && key.id != header.KeyID) ||
+			len(iv) != key.aead.NonceSize() || len(tag) != key.aead.Overhead() {
+			continue
+		}
+		payload, err := key.aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+		if err == nil {
+			return payload, nil
+		}
+	}
+	return nil, errCookieCodecDecode
+}
+
+// Formats of cookies of CookieConfig.Format.
+const (
+	CookieFormatSecureCookie = "securecookie" // gorilla/securecookie, the default
+	CookieFormatJSONHMAC     = "json_hmac"    // HMACCookieCodec
+)
+
+// hmacCookieVersion is the version prefix of cookies of HMACCookieCodec.
+const hmacCookieVersion = "1"
+
+// hmacCookieKeyLabel is the HKDF info of HMACCookieKey.
+const hmacCookieKeyLabel = "osecure json_hmac cookie"
+
+// HMACCookieKey derives the key of HMACCookieCodec from the authentication key of CookieConfig by HKDF-SHA256
+// (RFC 5869) without salt and with the info "osecure json_hmac cookie", so the derived key is handed out to
+// services validating the session cookie instead of the authentication key, which also authenticates other cookies.
+func HMACCookieKey(authenticationKey []byte) []byte {
+	// HKDF-Extract with the zero salt, then HKDF-Expand of a single block
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(authenticationKey)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(hmacCookieKeyLabel))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// HMACCookieCodec encodes cookies as JSON signed by HMAC-SHA256, which services in other languages validate with
+// the standard library only. The cookie value is
+//
+//	1.<base64url(payload)>.<base64url(HMAC-SHA256(key, name + "|1." + base64url(payload)))>
+//
+// where base64url is without padding and name is the cookie name. The payload isn't encrypted, so the refresh token
+// is left out of the session cookie, which is HttpOnly. Cookies are signed by the first key and verified by any of
+// them. With CookieConfig.Format, the keys are derived by HMACCookieKey.
+//
+// The reference vector: with the key of 32 bytes of "0123456789abcdef0123456789abcdef", the cookie "osecure" of
+// the payload {"sid":"abc"} is "1.eyJzaWQiOiJhYmMifQ.MPDby3jcmGf2bEMvsmcKfotPckgCacrXWLrWoFAYzes".
+type HMACCookieCodec struct {
+	keys [][]byte
+}
+
+// NewHMACCookieCodec creates HMACCookieCodec of the keys, which are at least 32 bytes.
+func NewHMACCookieCodec(keys ...[]byte) (*HMACCookieCodec, error) {
+	if len(keys) == 0 {
+		return nil, ErrorInvalidCookieCodecKey
+	}
+	for _, key := range keys {
+		if len(key) < 32 {
+			return nil, ErrorInvalidCookieCodecKey
+		}
+	}
+	return &HMACCookieCodec{keys: keys}, nil
+}
+
+func (c *HMACCookieCodec) sign(key []byte, name string, signed string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "|" + signed))
+	return mac.Sum(nil)
+}
+
+// Encode signs the payload by the first key.
+func (c *HMACCookieCodec) Encode(name string, payload []byte) (string, error) {
+	signed := hmacCookieVersion + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(c.sign(c.keys[0], name, signed)), nil
+}
+
+// Decode verifies the signature by any of the keys.
+func (c *HMACCookieCodec) Decode(name string, value string) ([]byte, error) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 || !strings.HasPrefix(value, hmacCookieVersion+".") {
+		return nil, errCookieCodecDecode
+	}
+	signed := value[:i]
+	signature, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil {
+		return nil, errCookieCodecDecode
+	}
+
+	for _, key := range c.keys {
+		if hmac.Equal(c.sign(key, name, signed), signature) {
+			payload, err := base64.RawURLEncoding.DecodeString(signed[len(hmacCookieVersion)+1:])
+			if err != nil {
+				return nil, errCookieCodecDecode
+			}
+			return payload, nil
+		}
+	}
+	return nil, errCookieCodecDecode
+}
@@ -0,0 +1,262 @@
+package osecure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// hmacCookieTestKey is the key of the reference vectors of HMACCookieCodec.
+var hmacCookieTestKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestHMACCookieCodecVectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     []byte
+		cookie  string
+		payload string
+		value   string
+	}{
+		{
+			name:    "raw key",
+			key:     hmacCookieTestKey,
+			cookie:  "osecure",
+			payload: `{"sid":"abc"}`,
+			value:   "1.eyJzaWQiOiJhYmMifQ.MPDby3jcmGf2bEMvsmcKfotPckgCacrXWLrWoFAYzes",
+		},
+		{
+			name:    "derived key",
+			key:     HMACCookieKey(hmacCookieTestKey),
+			cookie:  "osecure",
+			payload: `{"sid":"abc"}`,
+			value:   "1.eyJzaWQiOiJhYmMifQ.YkUIRKDImxJv8jjiwv9fhLkgec9bDYVTCUfzUhj0MEk",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codec, err := NewHMACCookieCodec(test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			value, err := codec.Encode(test.cookie, []byte(test.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != test.value {
+				t.Errorf("Encode() = %q, want %q", value, test.value)
+			}
+
+			payload, err := codec.Decode(test.cookie, test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != test.payload {
+				t.Errorf("Decode() = %q, want %q", payload, test.payload)
+			}
+		})
+	}
+}
+
+func TestHMACCookieCodecRejectsTampering(t *testing.T) {
+	const value = "1.eyJzaWQiOiJhYmMifQ.MPDby3jcmGf2bEMvsmcKfotPckgCacrXWLrWoFAYzes"
+	codec, err := NewHMACCookieCodec(hmacCookieTestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cookie string
+		value  string
+	}{
+		// {"sid":"abd"}
+		{name: "payload", cookie: "osecure", value: "1.eyJzaWQiOiJhYmQifQ.MPDby3jcmGf2bEMvsmcKfotPckgCacrXWLrWoFAYzes"},
+		{name: "signature", cookie: "osecure", value: value[:len(value)-1] + "a"},
+		{name: "version", cookie: "osecure", value: "2" + value[1:]},
+		{name: "cookie name", cookie: "other", value: value},
+		{name: "no signature", cookie: "osecure", value: "1.eyJzaWQiOiJhYmMifQ"},
+		{name: "empty", cookie: "osecure", value: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := codec.Decode(test.cookie, test.value)
+			if err == nil {
+				t.Errorf("Decode(%q, %q) succeeded", test.cookie, test.value)
+			}
+		})
+	}
+
+	other, err := NewHMACCookieCodec([]byte(strings.Repeat("x", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.Decode("osecure", value)
+	if err == nil {
+		t.Error("Decode() by another key succeeded")
+	}
+}
+
+func TestHMACCookieKey(t *testing.T) {
+	// HKDF-SHA256 of RFC 5869 without salt, computed independently
+	const want = "9225f9d16adc74baee387cd16b6dc08ec7359b5841b978130d19ba9cc8fcffaa"
+	if got := hex.EncodeToString(HMACCookieKey(hmacCookieTestKey)); got != want {
+		t.Errorf("HMACCookieKey() = %s, want %s", got, want)
+	}
+}
+
+func TestFernetCookieCodecSpecVector(t *testing.T) {
+	// https://github.com/fernet/spec/blob/master/generate.json
+	codec, err := NewFernetCookieCodec("cw_0x689RpI-jtRR7oE8h_eQsKImvJapLeSbXpwF4e4=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := codec.Decode("osecure", "gAAAAAAdwJ6wAAECAwQFBgcICQoLDA0ODy021cpGVWKZ_eEwCGM4BLLF_5CV9dOPmrhuVUPgJobwOz7JcbmrR64jVmpU4IwqDA==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "hello" {
+		t.Errorf("Decode() = %q, want %q", payload, "hello")
+	}
+
+	// the ciphertext "Dy021" of the token altered to "Dy031"
+	_, err = codec.Decode("osecure", "gAAAAAAdwJ6wAAECAwQFBgcICQoLDA0ODy031cpGVWKZ_eEwCGM4BLLF_5CV9dOPmrhuVUPgJobwOz7JcbmrR64jVmpU4IwqDA==")
+	if err == nil {
+		t.Error("Decode() of tampered token succeeded")
+	}
+}
+
+func TestJWECookieCodecRoundTrip(t *testing.T) {
+	codec, err := NewJWECookieCodec(JWECookieKey{KeyID: "k1", Key: []byte(strings.Repeat("k", 32))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := codec.Encode("osecure", []byte(`{"sid":"abc"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := codec.Decode("osecure", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != `{"sid":"abc"}` {
+		t.Errorf("Decode() = %q", payload)
+	}
+
+	parts := strings.Split(value, ".")
+	ciphertext, _ := base64.RawURLEncoding.DecodeString(parts[3])
+	ciphertext[0] ^= 1
+	parts[3] = base64.RawURLEncoding.EncodeToString(ciphertext)
+	_, err = codec.Decode("osecure", strings.Join(parts, "."))
+	if err == nil {
+		t.Error("Decode() of tampered token succeeded")
+	}
+}
+
+func newJSONHMACTestSession(t *testing.T) *OAuthSession {
+	t.Helper()
+	cookieConf := &CookieConfig{
+		AuthenticationKey: base64.StdEncoding.EncodeToString(hmacCookieTestKey),
+		EncryptionKey:     base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32))),
+		Format:            CookieFormatJSONHMAC,
+	}
+	return NewOAuthSession("osecure", cookieConf, &OAuthConfig{ClientID: "client"}, OAuthEndpoint{}, &TokenVerifier{}, "https://example.com/callback", nil)
+}
+
+func TestJSONHMACSessionCookie(t *testing.T) {
+	s := newJSONHMACTestSession(t)
+
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	w := httptest.NewRecorder()
+	err := s.setAuthCookie(w, httptest.NewRequest(http.MethodGet, "/", nil), s.newAuthSessionCookieData(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies", len(cookies))
+	}
+	cookie := cookies[0]
+	if !cookie.HttpOnly {
+		t.Error("session cookie is not HttpOnly")
+	}
+
+	// consumers validate the cookie by the derived key only
+	codec, err := NewHMACCookieCodec(HMACCookieKey(hmacCookieTestKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := codec.Decode(cookie.Name, cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(payload), `"at":"access"`) {
+		t.Errorf("payload %s has no access token", payload)
+	}
+	if strings.Contains(string(payload), "refresh") {
+		t.Errorf("payload %s has the refresh token", payload)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	cookieData, err := s.loadAuthCookie(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookieData.Token.AccessToken != "access" || cookieData.Token.RefreshToken != "" {
+		t.Errorf("loaded token %+v", cookieData.Token)
+	}
+}
+
+func TestReloadKeysKeepsCookieFormat(t *testing.T) {
+	s := newJSONHMACTestSession(t)
+
+	newKey := []byte(strings.Repeat("n", 32))
+	err := s.ReloadKeys(context.Background(), KeyProviderFunc(func(ctx context.Context) (*SecretKeys, error) {
+		return &SecretKeys{Cookie: &CookieConfig{
+			AuthenticationKey: base64.StdEncoding.EncodeToString(newKey),
+			EncryptionKey:     base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32))),
+		}}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	token := &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)}
+	err = s.setAuthCookie(w, httptest.NewRequest(http.MethodGet, "/", nil), s.newAuthSessionCookieData(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, err := NewHMACCookieCodec(HMACCookieKey(newKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+	_, err = codec.Decode(cookie.Name, cookie.Value)
+	if err != nil {
+		t.Errorf("cookie after reloading isn't json_hmac: %v", err)
+	}
+
+	err = s.ReloadKeys(context.Background(), KeyProviderFunc(func(ctx context.Context) (*SecretKeys, error) {
+		return &SecretKeys{Cookie: &CookieConfig{
+			AuthenticationKey: base64.StdEncoding.EncodeToString(newKey),
+			EncryptionKey:     base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32))),
+			Format:            CookieFormatSecureCookie,
+		}}, nil
+	}))
+	if err == nil {
+		t.Error("ReloadKeys() changing the format succeeded")
+	}
+}
@@ -0,0 +1,327 @@
+package osecure
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/securecookie"
+	"golang.org/x/oauth2"
+)
+
+// Formats of the serialized auth cookie data, the first byte of the serialized data.
+// Gob formats are written by previous versions and only decoded,
+// since gob breaks whenever AuthSessionCookieData changes.
+const (
+	cookieFormatGob      = byte(0)
+	cookieFormatGobGzip  = byte(1)
+	cookieFormatJSON     = byte(2)
+	cookieFormatJSONGzip = byte(3)
+)
+
+// authCookieVersion is the schema version of the JSON auth cookie payload.
+// Adding optional fields doesn't need a new version, while renaming or changing meaning of fields does,
+// along with a migration in authCookiePayload.toCookieData.
+const authCookieVersion = 1
+
+// cookieCompressionThreshold is the minimum size of serialized data to be compressed,
+// smaller data barely shrinks by gzip.
+const cookieCompressionThreshold = 256
+
+// authCookiePayload is the JSON schema of AuthSessionCookieData. Times are unix seconds, zero if absent.
+type authCookiePayload struct {
+	Version int `json:"v"`
+
+	AccessToken  string `json:"at"`
+	TokenType    string `json:"tt,omitempty"`
+	RefreshToken string `json:"rt,omitempty"`
+	TokenExpiry  int64  `json:"exp,omitempty"`
+
+	Permissions          []string `json:"perm,omitempty"`
+	PermissionsExpiresAt int64    `json:"perm_exp,omitempty"`
+	SessionCreatedAt     int64    `json:"iat,omitempty"`
+	SessionExpiresAt     int64    `json:"sess_exp,omitempty"`
+	AuthTime             int64    `json:"auth_time,omitempty"`
+	IDToken              string   `json:"id_token,omitempty"`
+	SessionID            string   `json:"sid,omitempty"`
+	ClientUserAgentHash  string   `json:"ua,omitempty"`
+	ClientNetworkHash    string   `json:"net,omitempty"`
+	Tenant               string   `json:"tnt,omitempty"`
+	ImpersonatedUserID   string   `json:"imp,omitempty"`
+	SecondFactorAt       int64    `json:"2fa,omitempty"`
+
+	LastSeen *clientObservationPayload `json:"seen,omitempty"`
+
+	Values map[string]json.RawMessage `json:"app,omitempty"`
+}
+
+// clientObservationPayload is the JSON schema of ClientObservation.
+type clientObservationPayload struct {
+	NetworkHash   string  `json:"ip,omitempty"`
+	UserAgentHash string  `json:"ua,omitempty"`
+	Country       string  `json:"c,omitempty"`
+	Latitude      float64 `json:"lat,omitempty"`
+	Longitude     float64 `json:"lon,omitempty"`
+	At            int64   `json:"at"`
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// setPermissions replaces the permissions, keeping the list in order for serialization,
+// so permissions aren't sorted again whenever the cookie is saved, e.g. by sliding session.
+// The list isn't changed, it's copied if not sorted.
+func (cookieData *AuthSessionCookieData) setPermissions(permissions []string) {
+	if !sort.StringsAreSorted(permissions) {
+		permissions = append([]string(nil), permissions...)
+		sort.Strings(permissions)
+	}
+	cookieData.Permissions = NewPermissionSet(permissions)
+	cookieData.sortedPermissions = permissions
+}
+
+// sortedPermissionList lists the permissions in order, reusing the list kept by setPermissions if permissions
+// aren't changed since then. It doesn't change the cookie data, which may be read concurrently.
+func (cookieData *AuthSessionCookieData) sortedPermissionList() []string {
+	if cookieData.Permissions.isListed(cookieData.sortedPermissions) {
+		return cookieData.sortedPermissions
+	}
+	permissions := cookieData.Permissions.List()
+	sort.Strings(permissions)
+	return permissions
+}
+
+func newAuthCookiePayload(cookieData *AuthSessionCookieData) *authCookiePayload {
+	payload := &authCookiePayload{
+		Version:              authCookieVersion,
+		Permissions:          cookieData.sortedPermissionList(),
+		PermissionsExpiresAt: unixOrZero(cookieData.PermissionsExpiresAt),
+		SessionCreatedAt:     unixOrZero(cookieData.SessionCreatedAt),
+		SessionExpiresAt:     unixOrZero(cookieData.SessionExpiresAt),
+		AuthTime:             unixOrZero(cookieData.AuthTime),
+		IDToken:              cookieData.IDToken,
+		SessionID:            cookieData.SessionID,
+		ClientUserAgentHash:  cookieData.ClientUserAgentHash,
+		ClientNetworkHash:    cookieData.ClientNetworkHash,
+		Tenant:               cookieData.Tenant,
+		ImpersonatedUserID:   cookieData.ImpersonatedUserID,
+		SecondFactorAt:       unixOrZero(cookieData.SecondFactorAt),
+		Values:               cookieData.Values,
+	}
+	if lastSeen := cookieData.LastSeen; !lastSeen.At.IsZero() {
+		payload.LastSeen = &clientObservationPayload{
+			NetworkHash:   lastSeen.NetworkHash,
+			UserAgentHash: lastSeen.UserAgentHash,
+			Country:       lastSeen.Country,
+			Latitude:      lastSeen.Latitude,
+			Longitude:     lastSeen.Longitude,
+			At:            lastSeen.At.Unix(),
+		}
+	}
+	if cookieData.Token != nil {
+		payload.AccessToken = cookieData.Token.AccessToken
+		payload.TokenType = cookieData.Token.TokenType
+		payload.RefreshToken = cookieData.Token.RefreshToken
+		payload.TokenExpiry = unixOrZero(cookieData.Token.Expiry)
+	}
+	return payload
+}
+
+func (payload *authCookiePayload) toCookieData() (*AuthSessionCookieData, error) {
+	if payload.Version != authCookieVersion {
+		// cookies of newer versions after a rollback
+		return nil, ErrorInvalidSession
+	}
+	if payload.AccessToken == "" {
+		return nil, ErrorInvalidSession
+	}
+
+	cookieData := &AuthSessionCookieData{
+		Token: &oauth2.Token{
+			AccessToken:  payload.AccessToken,
+			TokenType:    payload.TokenType,
+			RefreshToken: payload.RefreshToken,
+			Expiry:       timeOrZero(payload.TokenExpiry),
+		},
+		PermissionsExpiresAt: timeOrZero(payload.PermissionsExpiresAt),
+		SessionCreatedAt:     timeOrZero(payload.SessionCreatedAt),
+		SessionExpiresAt:     timeOrZero(payload.SessionExpiresAt),
+		AuthTime:             timeOrZero(payload.AuthTime),
+		IDToken:              payload.IDToken,
+		SessionID:            payload.SessionID,
+		ClientUserAgentHash:  payload.ClientUserAgentHash,
+		ClientNetworkHash:    payload.ClientNetworkHash,
+		Tenant:               payload.Tenant,
+		ImpersonatedUserID:   payload.ImpersonatedUserID,
+		SecondFactorAt:       timeOrZero(payload.SecondFactorAt),
+		Values:               payload.Values,
+	}
+	cookieData.setPermissions(payload.Permissions)
+	if lastSeen := payload.LastSeen; lastSeen != nil {
+		cookieData.LastSeen = ClientObservation{
+			NetworkHash:   lastSeen.NetworkHash,
+			UserAgentHash: lastSeen.UserAgentHash,
+			GeoLocation: GeoLocation{
+				Country:   lastSeen.Country,
+				Latitude:  lastSeen.Latitude,
+				Longitude: lastSeen.Longitude,
+			},
+			At: time.Unix(lastSeen.At, 0),
+		}
+	}
+	return cookieData, nil
+}
+
+// serializeAuthCookieData serializes the cookie data as versioned JSON, compressing it by gzip if large,
+// e.g. with a long permission list, so it fits in the cookie size limit.
+func serializeAuthCookieData(cookieData *AuthSessionCookieData) ([]byte, error) {
+	data, err := json.Marshal(newAuthCookiePayload(cookieData))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < cookieCompressionThreshold {
+		// prepend the format in place, mostly without growing
+		data = append(data, 0)
+		copy(data[1:], data)
+		data[0] = cookieFormatJSON
+		return data, nil
+	}
+
+	var compressed bytes.Buffer
+	compressed.Grow(len(data) / 2)
+	compressed.WriteByte(cookieFormatJSONGzip)
+	writer := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(writer)
+	writer.Reset(&compressed)
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// Gzip writers and readers are reused, since each allocates large buffers.
+var (
+	gzipWriterPool = sync.Pool{
+		New: func() interface{} {
+			writer, _ := gzip.NewWriterLevel(nil, gzip.BestCompression)
+			return writer
+		},
+	}
+	gzipReaderPool sync.Pool
+)
+
+// maxDecompressedSize limits decompressed cookie data, rejecting gzip bombs in forged or corrupted data.
+const maxDecompressedSize = 1 << 20
+
+func gunzip(data []byte) ([]byte, error) {
+	var err error
+	reader, ok := gzipReaderPool.Get().(*gzip.Reader)
+	if ok {
+		err = reader.Reset(bytes.NewReader(data))
+	} else {
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReaderPool.Put(reader)
+
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > maxDecompressedSize {
+		return nil, ErrorInvalidSession
+	}
+	return decompressed, nil
+}
+
+// deserializeAuthCookieData deserializes the cookie value, which is serialized data,
+// or *AuthSessionCookieData stored directly by previous versions.
+func deserializeAuthCookieData(v interface{}) (*AuthSessionCookieData, error) {
+	switch value := v.(type) {
+	case *AuthSessionCookieData:
+		return value, nil
+	case []byte:
+		if len(value) == 0 {
+			return nil, ErrorInvalidSession
+		}
+
+		data := value[1:]
+		var err error
+		if value[0] == cookieFormatGobGzip || value[0] == cookieFormatJSONGzip {
+			data, err = gunzip(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		switch value[0] {
+		case cookieFormatGob, cookieFormatGobGzip:
+			cookieData := &AuthSessionCookieData{}
+			err = gob.NewDecoder(bytes.NewReader(data)).Decode(cookieData)
+			if err != nil {
+				return nil, err
+			}
+			if cookieData.Token == nil || cookieData.Token.AccessToken == "" {
+				return nil, ErrorInvalidSession
+			}
+			return cookieData, nil
+		case cookieFormatJSON, cookieFormatJSONGzip:
+			if !utf8.Valid(data) {
+				// json replaces invalid bytes silently
+				return nil, ErrorInvalidSession
+			}
+			payload := &authCookiePayload{}
+			err = json.Unmarshal(data, payload)
+			if err != nil {
+				return nil, err
+			}
+			return payload.toCookieData()
+		default:
+			return nil, ErrorInvalidSession
+		}
+	default:
+		return nil, ErrorInvalidSession
+	}
+}
+
+// isCookieTooLarge checks if the error is securecookie or CookieCodec rejecting the encoded value longer than its max length.
+func isCookieTooLarge(err error) bool {
+	var multiErr securecookie.MultiError
+	if errors.As(err, &multiErr) && len(multiErr) > 0 {
+		err = multiErr[0]
+	}
+	if errors.Is(err, ErrorCookieTooLarge) {
+		// of CookieCodec
+		return true
+	}
+	var cookieErr securecookie.Error
+	return errors.As(err, &cookieErr) && cookieErr.IsUsage() && strings.HasSuffix(err.Error(), "the value is too long")
+}
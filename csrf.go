@@ -0,0 +1,72 @@
+package osecure
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+)
+
+// CSRF tokens are sent by the header or the form field, checked by VerifyCSRFF.
+const (
+	CSRFHeaderName = "X-CSRF-Token"
+	CSRFFormField  = "csrf_token"
+)
+
+// csrfToken derives the CSRF token of the session from its access token, which is known only to the server
+// since the cookie is encrypted, so the token needn't be stored (synchronizer token without state).
+func csrfToken(cookieData *AuthSessionCookieData) string {
+	mac := hmac.New(sha256.New, []byte(cookieData.Token.AccessToken))
+	mac.Write([]byte("osecure-csrf:" + cookieData.SessionID + ":" + strconv.FormatInt(cookieData.SessionCreatedAt.Unix(), 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CSRFToken gets the CSRF token of the session cookie, to be embedded into forms or sent by scripts
+// in the X-CSRF-Token header. It stays the same during the session.
+func (s *OAuthSession) CSRFToken(r *http.Request) (string, error) {
+	cookieData := s.retrieveAuthCookie(r)
+	if cookieData == nil || cookieData.Token == nil {
+		return "", ErrorInvalidSession
+	}
+	return csrfToken(cookieData), nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// VerifyCSRFF is a http middleware for http.HandlerFunc to check the CSRF token of unsafe requests
+// (i.e. other than GET, HEAD, OPTIONS and TRACE) with the session cookie.
+// Requests without the session cookie, e.g. with bearer tokens, aren't checked.
+func (s *OAuthSession) VerifyCSRFF() func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !isSafeMethod(r.Method) {
+				cookieData := s.retrieveAuthCookie(r)
+				if cookieData != nil && cookieData.Token != nil {
+					token := r.Header.Get(CSRFHeaderName)
+					if token == "" {
+						token = r.PostFormValue(CSRFFormField)
+					}
+					if !hmac.Equal([]byte(token), []byte(csrfToken(cookieData))) {
+						s.forbidden(w, r, ErrorInvalidCSRFToken)
+						return
+					}
+				}
+			}
+			h(w, r)
+		}
+	}
+}
+
+// VerifyCSRFH is a http middleware for http.Handler to check the CSRF token of unsafe requests.
+func (s *OAuthSession) VerifyCSRFH() func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.Handler(s.VerifyCSRFF()(h.ServeHTTP))
+	}
+}
@@ -0,0 +1,70 @@
+package osecure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCSRF(t *testing.T) {
+	s := newTestSession(t, newTestVerifier(nil))
+	aliceCookie := newTestSessionCookie(t, s, "alice")
+	bobCookie := newTestSessionCookie(t, s, "bob")
+
+	tokenOf := func(cookie *http.Cookie) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookie)
+		token, err := s.CSRFToken(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	aliceToken := tokenOf(aliceCookie)
+	if aliceToken != tokenOf(aliceCookie) {
+		t.Error("CSRF token changed during the session")
+	}
+	if aliceToken == tokenOf(bobCookie) {
+		t.Error("CSRF token shared by sessions")
+	}
+	if _, err := s.CSRFToken(httptest.NewRequest(http.MethodGet, "/", nil)); err != ErrorInvalidSession {
+		t.Errorf("CSRF token without session: got %v, want %v", err, ErrorInvalidSession)
+	}
+
+	handler := s.VerifyCSRFF()(func(w http.ResponseWriter, r *http.Request) {})
+	for name, test := range map[string]struct {
+		method string
+		cookie *http.Cookie
+		header string
+		form   string
+		want   int
+	}{
+		"safe method":            {http.MethodGet, aliceCookie, "", "", http.StatusOK},
+		"token in header":        {http.MethodPost, aliceCookie, aliceToken, "", http.StatusOK},
+		"token in form":          {http.MethodPost, aliceCookie, "", aliceToken, http.StatusOK},
+		"without token":          {http.MethodPost, aliceCookie, "", "", http.StatusForbidden},
+		"token of other session": {http.MethodDelete, bobCookie, aliceToken, "", http.StatusForbidden},
+		"wrong token":            {http.MethodPut, aliceCookie, "forged", "", http.StatusForbidden},
+		"without session cookie": {http.MethodPost, nil, "", "", http.StatusOK},
+	} {
+		body := url.Values{}
+		if test.form != "" {
+			body.Set(CSRFFormField, test.form)
+		}
+		r := httptest.NewRequest(test.method, "/", strings.NewReader(body.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.cookie != nil {
+			r.AddCookie(test.cookie)
+		}
+		if test.header != "" {
+			r.Header.Set(CSRFHeaderName, test.header)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: got %d, want %d", name, w.Code, test.want)
+		}
+	}
+}
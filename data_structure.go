@@ -3,7 +3,7 @@ package osecure
 type StringSet map[string]struct{}
 
 func NewStringSet(a []string) StringSet {
-	s := make(StringSet)
+	s := make(StringSet, len(a))
 
 	for _, x := range a {
 		s.Add(x)
@@ -25,6 +25,19 @@ func (s StringSet) Contain(x string) bool {
 	return ok
 }
 
+// isListed checks if the list has exactly the items of the set, assuming the list has no duplicates.
+func (s StringSet) isListed(a []string) bool {
+	if len(a) != len(s) {
+		return false
+	}
+	for _, x := range a {
+		if !s.Contain(x) {
+			return false
+		}
+	}
+	return true
+}
+
 func (s StringSet) List() []string {
 	a := make([]string, len(s))
 
@@ -36,3 +49,150 @@ func (s StringSet) List() []string {
 
 	return a
 }
+
+// HasAll checks if the set contains all the items.
+func (s StringSet) HasAll(a ...string) bool {
+	for _, x := range a {
+		if !s.Contain(x) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAny checks if the set contains any of the items.
+func (s StringSet) HasAny(a ...string) bool {
+	for _, x := range a {
+		if s.Contain(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone copies the set, so the copy can be changed without affecting the set.
+func (s StringSet) Clone() StringSet {
+	c := make(StringSet, len(s))
+	for x := range s {
+		c.Add(x)
+	}
+	return c
+}
+
+// Union creates a set of items in either set.
+func (s StringSet) Union(other StringSet) StringSet {
+	u := make(StringSet, len(s)+len(other))
+	for x := range s {
+		u.Add(x)
+	}
+	for x := range other {
+		u.Add(x)
+	}
+	return u
+}
+
+// Intersect creates a set of items in both sets.
+func (s StringSet) Intersect(other StringSet) StringSet {
+	if len(other) < len(s) {
+		s, other = other, s
+	}
+	i := make(StringSet)
+	for x := range s {
+		if other.Contain(x) {
+			i.Add(x)
+		}
+	}
+	return i
+}
+
+// Difference creates a set of items in the set but not in the other set.
+func (s StringSet) Difference(other StringSet) StringSet {
+	d := make(StringSet)
+	for x := range s {
+		if !other.Contain(x) {
+			d.Add(x)
+		}
+	}
+	return d
+}
+
+// PermissionSet is a hash set of the permissions granted to a session, so checks don't depend on the order
+// of a list. Granted permissions may contain wildcards, which Has, HasAll and HasAny match by MatchPermission,
+// while set operations work on the granted permissions as they are.
+type PermissionSet StringSet
+
+func NewPermissionSet(permissions []string) PermissionSet {
+	return PermissionSet(NewStringSet(permissions))
+}
+
+func (p PermissionSet) Add(permission string) {
+	StringSet(p).Add(permission)
+}
+
+func (p PermissionSet) Remove(permission string) {
+	StringSet(p).Remove(permission)
+}
+
+// Has checks if the permission is granted, in O(1) time unless it's only granted by a wildcard permission.
+func (p PermissionSet) Has(permission string) bool {
+	if StringSet(p).Contain(permission) {
+		return true
+	}
+
+	for granted := range p {
+		if MatchPermission(granted, permission) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasAll checks if all the permissions are granted, see Has.
+func (p PermissionSet) HasAll(permissions ...string) bool {
+	for _, permission := range permissions {
+		if !p.Has(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAny checks if any of the permissions is granted, see Has.
+func (p PermissionSet) HasAny(permissions ...string) bool {
+	for _, permission := range permissions {
+		if p.Has(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p PermissionSet) List() []string {
+	return StringSet(p).List()
+}
+
+// Clone copies the set, so the copy can be changed without affecting the set.
+func (p PermissionSet) Clone() PermissionSet {
+	return PermissionSet(StringSet(p).Clone())
+}
+
+// Union creates a set of permissions in either set.
+func (p PermissionSet) Union(other PermissionSet) PermissionSet {
+	return PermissionSet(StringSet(p).Union(StringSet(other)))
+}
+
+// Intersect creates a set of permissions in both sets.
+func (p PermissionSet) Intersect(other PermissionSet) PermissionSet {
+	return PermissionSet(StringSet(p).Intersect(StringSet(other)))
+}
+
+// Difference creates a set of permissions in the set but not in the other set.
+func (p PermissionSet) Difference(other PermissionSet) PermissionSet {
+	return PermissionSet(StringSet(p).Difference(StringSet(other)))
+}
+
+// isListed checks if the list has exactly the permissions of the set, see StringSet.isListed.
+func (p PermissionSet) isListed(permissions []string) bool {
+	return StringSet(p).isListed(permissions)
+}
@@ -0,0 +1,73 @@
+package osecure
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sortedList(p PermissionSet) string {
+	permissions := p.List()
+	sort.Strings(permissions)
+	return strings.Join(permissions, ",")
+}
+
+func TestPermissionSetHas(t *testing.T) {
+	p := NewPermissionSet([]string{"admin", "repo:*:read"})
+
+	for permission, want := range map[string]bool{
+		"admin":        true,
+		"repo:a:read":  true, // by wildcard
+		"repo:a:write": false,
+		"":             false,
+	} {
+		if got := p.Has(permission); got != want {
+			t.Errorf("Has(%q) = %v, want %v", permission, got, want)
+		}
+	}
+
+	if !p.HasAll("admin", "repo:a:read") || p.HasAll("admin", "repo:a:write") || !p.HasAll() {
+		t.Error("HasAll() mismatched")
+	}
+	if !p.HasAny("repo:a:write", "repo:a:read") || p.HasAny("repo:a:write") || p.HasAny() {
+		t.Error("HasAny() mismatched")
+	}
+}
+
+func TestPermissionSetOperations(t *testing.T) {
+	a := NewPermissionSet([]string{"a", "b", "repo:*:read"})
+	b := NewPermissionSet([]string{"b", "c", "repo:x:read"})
+
+	if got := sortedList(a.Union(b)); got != "a,b,c,repo:*:read,repo:x:read" {
+		t.Errorf("Union() = %s", got)
+	}
+	// set operations don't expand wildcards
+	if got := sortedList(a.Intersect(b)); got != "b" {
+		t.Errorf("Intersect() = %s", got)
+	}
+	if got := sortedList(a.Difference(b)); got != "a,repo:*:read" {
+		t.Errorf("Difference() = %s", got)
+	}
+
+	c := a.Clone()
+	c.Add("d")
+	c.Remove("a")
+	if got := sortedList(a); got != "a,b,repo:*:read" {
+		t.Errorf("set changed by its clone: %s", got)
+	}
+	if got := sortedList(c); got != "b,d,repo:*:read" {
+		t.Errorf("Clone() after changes = %s", got)
+	}
+}
+
+func TestPermissionSetOfSession(t *testing.T) {
+	data := NewAuthSessionData("alice", testClientID, nil, []string{"repo:*:read"}, time.Now().Add(time.Hour))
+
+	// mutating the list of permissions doesn't affect the checks
+	permissions := data.GetPermissions()
+	permissions[0] = "admin"
+	if data.HasPermission("admin") || !data.Permissions.Has("repo:a:read") || !data.HasPermission("repo:a:read") {
+		t.Errorf("permissions changed by the list: %v", data.GetPermissions())
+	}
+}
@@ -0,0 +1,160 @@
+package osecure
+
+import (
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxPreservedBodySize is the max size of form bodies preserved through the login flow,
+// if SetDeepLinkPreservation is called with zero, so the preserved request fits in a cookie.
+const DefaultMaxPreservedBodySize = 2 * 1024
+
+// deepLinkCookieSuffix is the suffix of the name of the cookie of the preserved request after the session name.
+const deepLinkCookieSuffix = "_deeplink"
+
+// DefaultResumeTemplate is the template of ResumeView, executed with ResumePageData.
+// The form is submitted by the user, so requests with side effects aren't replayed without confirmation.
+var DefaultResumeTemplate = template.Must(template.New("resume").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Continue</title>
+</head>
+<body>
+<form method="{{.Method}}" action="{{.Action}}">
+{{- range .Fields}}
+<input type="hidden" name="{{.Name}}" value="{{.Value}}">
+{{- end}}
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// ResumePageData is the data to execute the resume template.
+type ResumePageData struct {
+	Method string
+	Action string        // the request URI of the preserved request
+	Fields []ResumeField // in order of the form body
+}
+
+// ResumeField is a field of the form body of the preserved request.
+type ResumeField struct {
+	Name  string
+	Value string
+}
+
+// SetDeepLinkPreservation preserves POST requests, e.g. form submissions, which Secured redirects to log in,
+// so users land where they started instead of the page of the request URI only. The request URI and url-encoded
+// form body up to maxBodySize (DefaultMaxPreservedBodySize if zero) are kept in a cookie, and users
+// continue to resumePath after login, served by ResumeView to submit the request again. Requests with larger or
+// other bodies continue to their request URI as before. GET requests keep their query in the request URI anyway.
+// tmpl is DefaultResumeTemplate if nil. It should be called before serving requests.
+func (s *OAuthSession) SetDeepLinkPreservation(resumePath string, maxBodySize int, tmpl *template.Template) {
+	if tmpl == nil {
+		tmpl = DefaultResumeTemplate
+	}
+	s.resumePath = resumePath
+	s.maxPreservedBodySize = intOrDefault(maxBodySize, DefaultMaxPreservedBodySize)
+	s.resumeTemplate = tmpl
+}
+
+func (s *OAuthSession) deepLinkCookieName() string {
+	return s.name + deepLinkCookieSuffix
+}
+
+// preserveRequest keeps the request in the cookie if it should be resumed after login, returning the request
+// continuing to the resume path, or the request itself otherwise.
+func (s *OAuthSession) preserveRequest(w http.ResponseWriter, r *http.Request) *http.Request {
+	if s.resumePath == "" || r.Method != http.MethodPost || !IsLocalURI(r.RequestURI) {
+		return r
+	}
+
+	form := ""
+	if r.Body != nil && r.ContentLength != 0 {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/x-www-form-urlencoded" || r.ContentLength > int64(s.maxPreservedBodySize) {
+			return r
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(s.maxPreservedBodySize)+1))
+		if err != nil || len(body) > s.maxPreservedBodySize {
+			return r
+		}
+		if _, err := url.ParseQuery(string(body)); err != nil {
+			return r
+		}
+		form = string(body)
+	}
+
+	session, err := s.getCookieStore().New(r, s.deepLinkCookieName())
+	if err != nil {
+		return r
+	}
+	session.Values["method"] = r.Method
+	session.Values["uri"] = r.RequestURI
+	session.Values["form"] = form
+	session.Options.MaxAge = 0
+	err = session.Save(r, w)
+	if err != nil {
+		// e.g. too large for the cookie
+		return r
+	}
+
+	// the state handler takes the request URI as the continue URI
+	rr := r.WithContext(r.Context())
+	rr.RequestURI = s.resumePath
+	return rr
+}
+
+// ResumeView is a http handler at the resume path of SetDeepLinkPreservation, rendering the form to submit
+// the request preserved before login. It redirects to "/" if no request is preserved.
+func (s *OAuthSession) ResumeView(w http.ResponseWriter, r *http.Request) {
+	session, err := s.getCookieStore().Get(r, s.deepLinkCookieName())
+	method, _ := session.Values["method"].(string)
+	uri, _ := session.Values["uri"].(string)
+	form, _ := session.Values["form"].(string)
+	if _, cookieErr := r.Cookie(s.deepLinkCookieName()); cookieErr == nil {
+		// one-time
+		session.Options.MaxAge = -1
+		session.Save(r, w)
+	}
+	if err != nil || method != http.MethodPost || !IsLocalURI(uri) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	data := &ResumePageData{Method: method, Action: uri, Fields: parseFormFields(form)}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err = s.resumeTemplate.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseFormFields parses the url-encoded form in order, which url.ParseQuery loses.
+func parseFormFields(form string) []ResumeField {
+	var fields []ResumeField
+	for _, pair := range strings.Split(form, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value := pair, ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name, value = pair[:i], pair[i+1:]
+		}
+		name, err1 := url.QueryUnescape(name)
+		value, err2 := url.QueryUnescape(value)
+		if err1 == nil && err2 == nil {
+			fields = append(fields, ResumeField{Name: name, Value: value})
+		}
+	}
+	return fields
+}
@@ -0,0 +1,168 @@
+package osecure
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/rayark/osecure/v6/jwt"
+)
+
+// DPoPScheme is the authorization scheme of DPoP-bound access tokens.
+const DPoPScheme = "DPoP"
+
+// DefaultDPoPMaxAge is the max age of DPoP proofs.
+const DefaultDPoPMaxAge = time.Minute
+
+// DPoPValidator validates DPoP proofs of sender-constrained access tokens (RFC 9449).
+type DPoPValidator struct {
+	// MaxAge is the max age of proofs judged by "iat", DefaultDPoPMaxAge if zero.
+	MaxAge time.Duration
+	// ReplayCache rejects reused proofs by "jti".
+	ReplayCache ReplayCache
+	// Required rejects access tokens which are not DPoP-bound.
+	Required bool
+	// RequestURL gets the expected "htu" of the request, which is derived from Host header if nil.
+	// Set it if the server is behind a proxy which changes scheme, host or path.
+	RequestURL func(r *http.Request) string
+}
+
+// NewDPoPValidator creates validator with a MemoryReplayCache.
+func NewDPoPValidator() *DPoPValidator {
+	return &DPoPValidator{
+		ReplayCache: NewMemoryReplayCache(),
+	}
+}
+
+// SetDPoPValidator enables DPoP-bound access tokens, sent with "Authorization: DPoP <token>" and "DPoP" proof header.
+// It should be called before serving requests.
+func (s *OAuthSession) SetDPoPValidator(validator *DPoPValidator) {
+	s.dpopValidator = validator
+}
+
+func (v *DPoPValidator) maxAge() time.Duration {
+	if v.MaxAge > 0 {
+		return v.MaxAge
+	}
+	return DefaultDPoPMaxAge
+}
+
+func (v *DPoPValidator) requestURL(r *http.Request) string {
+	if v.RequestURL != nil {
+		return v.RequestURL(r)
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+func isAsymmetricAlgorithm(alg string) bool {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA":
+		return true
+	default:
+		return false
+	}
+}
+
+// validateProof validates the DPoP proof of the request for the access token, returns thumbprint of the proof key.
+func (v *DPoPValidator) validateProof(r *http.Request, accessToken string, leeway time.Duration) (string, error) {
+	proofs := r.Header[http.CanonicalHeaderKey("DPoP")]
+	if len(proofs) != 1 {
+		return "", ErrorInvalidDPoPProof
+	}
+
+	proof, err := jwt.Parse(proofs[0])
+	if err != nil {
+		return "", ErrorInvalidDPoPProof
+	}
+	if proof.Header.Type != "dpop+jwt" || !isAsymmetricAlgorithm(proof.Header.Algorithm) || proof.Header.JWK == nil {
+		return "", ErrorInvalidDPoPProof
+	}
+
+	publicKey, err := proof.Header.JWK.PublicKey()
+	if err != nil {
+		return "", ErrorInvalidDPoPProof
+	}
+	err = proof.Verify(publicKey)
+	if err != nil {
+		return "", ErrorInvalidDPoPProof
+	}
+
+	claims := proof.Claims
+	if claims.String("htm") != r.Method || claims.String("htu") != v.requestURL(r) {
+		return "", ErrorInvalidDPoPProof
+	}
+
+	issuedAt, ok := claims.Time("iat")
+	if !ok {
+		return "", ErrorInvalidDPoPProof
+	}
+	age := time.Since(issuedAt)
+	if age > v.maxAge()+leeway || age < -leeway {
+		return "", ErrorInvalidDPoPProof
+	}
+
+	sum := sha256.Sum256([]byte(accessToken))
+	ath := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(claims.String("ath")), []byte(ath)) != 1 {
+		return "", ErrorInvalidDPoPProof
+	}
+
+	jti := claims.String("jti")
+	if jti == "" {
+		return "", ErrorInvalidDPoPProof
+	}
+	if v.ReplayCache != nil {
+		unused, err := v.ReplayCache.Use(r.Context(), "dpop:"+jti, issuedAt.Add(v.maxAge()+2*leeway))
+		if err != nil {
+			return "", err
+		}
+		if !unused {
+			return "", ErrorInvalidDPoPProof
+		}
+	}
+
+	return proof.Header.JWK.Thumbprint()
+}
+
+// checkDPoPBinding checks DPoP-bound access tokens, whose "cnf" has "jkt" confirmation.
+func (s *OAuthSession) checkDPoPBinding(r *http.Request, accessToken string, isDPoP bool, extra map[string]interface{}) error {
+	jkt := getConfirmation(extra, "jkt")
+
+	if !isDPoP {
+		// DPoP-bound tokens can't be used as bearer tokens
+		if jkt != "" || (s.dpopValidator != nil && s.dpopValidator.Required) {
+			return ErrorInvalidDPoPProof
+		}
+		return nil
+	}
+
+	if jkt == "" {
+		return ErrorInvalidDPoPProof
+	}
+
+	thumbprint, err := s.dpopValidator.validateProof(r, accessToken, s.clockSkew)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(jkt)) != 1 {
+		return ErrorInvalidDPoPProof
+	}
+	return nil
+}
+
+// checkCookieDPoPBinding checks DPoP-bound access tokens of the session cookie, which still need the DPoP proof
+// on each request, so the cookie can't be used as a bearer session.
+func (s *OAuthSession) checkCookieDPoPBinding(r *http.Request, accessToken string, extra map[string]interface{}) error {
+	if getConfirmation(extra, "jkt") == "" {
+		return nil
+	}
+	isDPoP := s.dpopValidator != nil && r.Header.Get("DPoP") != ""
+	return s.checkDPoPBinding(r, accessToken, isDPoP, extra)
+}
@@ -0,0 +1,135 @@
+package osecure
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rayark/osecure/v6/jwt"
+)
+
+const testDPoPURL = "https://api.example.com/resource"
+
+func newTestDPoPKey(t *testing.T) (*ecdsa.PrivateKey, *jwt.JSONWebKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := jwt.NewJSONWebKey(&key.PublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, jwk
+}
+
+// newDPoPTestSession creates a session whose access tokens are bound to the key.
+func newDPoPTestSession(t *testing.T, jwk *jwt.JSONWebKey) *OAuthSession {
+	t.Helper()
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := newTestVerifier(nil)
+	verifier.IntrospectTokenFunc = func(ctx context.Context, accessToken string) (string, string, int64, map[string]interface{}, error) {
+		extra := map[string]interface{}{ExtraKeyConfirmation: map[string]interface{}{"jkt": thumbprint}}
+		return accessToken, testClientID, time.Now().Add(time.Hour).Unix(), extra, nil
+	}
+	s := newTestSession(t, verifier)
+	s.SetDPoPValidator(NewDPoPValidator())
+	return s
+}
+
+func newDPoPProof(t *testing.T, key *ecdsa.PrivateKey, jwk *jwt.JSONWebKey, claims jwt.Claims) string {
+	t.Helper()
+	proof, err := jwt.Sign(jwt.Header{Algorithm: "ES256", Type: "dpop+jwt", JWK: jwk}, claims, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func newDPoPClaims(accessToken string) jwt.Claims {
+	ath := sha256.Sum256([]byte(accessToken))
+	jti, _ := generateSessionID()
+	return jwt.Claims{
+		"htm": http.MethodGet,
+		"htu": testDPoPURL,
+		"iat": time.Now().Unix(),
+		"jti": jti,
+		"ath": base64.RawURLEncoding.EncodeToString(ath[:]),
+	}
+}
+
+func newDPoPRequest(scheme string, accessToken string, proof string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, testDPoPURL, nil)
+	r.Header.Set("Authorization", scheme+" "+accessToken)
+	if proof != "" {
+		r.Header.Set("DPoP", proof)
+	}
+	return r
+}
+
+func TestDPoP(t *testing.T) {
+	key, jwk := newTestDPoPKey(t)
+	otherKey, otherJWK := newTestDPoPKey(t)
+	s := newDPoPTestSession(t, jwk)
+
+	valid := newDPoPProof(t, key, jwk, newDPoPClaims("alice"))
+	w := httptest.NewRecorder()
+	data, err := s.Authorize(w, newDPoPRequest(DPoPScheme, "alice", valid))
+	if err != nil || data.UserID != "alice" {
+		t.Fatalf("valid proof: got %v, %v", data, err)
+	}
+	// the session isn't saved into the cookie, which would be a bearer session without the proof
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == s.name {
+			t.Errorf("DPoP-bound session saved into the cookie")
+		}
+	}
+
+	withClaim := func(name string, value interface{}) string {
+		claims := newDPoPClaims("alice")
+		claims[name] = value
+		return newDPoPProof(t, key, jwk, claims)
+	}
+
+	for name, r := range map[string]*http.Request{
+		"bound token as bearer":            newDPoPRequest("Bearer", "alice", ""),
+		"bound token with proof as bearer": newDPoPRequest("Bearer", "alice", newDPoPProof(t, key, jwk, newDPoPClaims("alice"))),
+		"without proof":                    newDPoPRequest(DPoPScheme, "alice", ""),
+		"replayed proof":                   newDPoPRequest(DPoPScheme, "alice", valid),
+		"proof of other key":               newDPoPRequest(DPoPScheme, "alice", newDPoPProof(t, otherKey, otherJWK, newDPoPClaims("alice"))),
+		"proof of other token":             newDPoPRequest(DPoPScheme, "alice", withClaim("ath", "other")),
+		"proof of other method":            newDPoPRequest(DPoPScheme, "alice", withClaim("htm", http.MethodPost)),
+		"proof of other URL":               newDPoPRequest(DPoPScheme, "alice", withClaim("htu", "https://api.example.com/other")),
+		"stale proof":                      newDPoPRequest(DPoPScheme, "alice", withClaim("iat", time.Now().Add(-time.Hour).Unix())),
+		"proof without jti":                newDPoPRequest(DPoPScheme, "alice", withClaim("jti", "")),
+	} {
+		_, err := s.Authorize(httptest.NewRecorder(), r)
+		if err == nil {
+			t.Errorf("%s: authorized", name)
+		}
+	}
+}
+
+func TestDPoPProofSignedByHMAC(t *testing.T) {
+	_, jwk := newTestDPoPKey(t)
+	s := newDPoPTestSession(t, jwk)
+
+	proof, err := jwt.Sign(jwt.Header{Algorithm: "HS256", Type: "dpop+jwt", JWK: jwk}, newDPoPClaims("alice"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Authorize(httptest.NewRecorder(), newDPoPRequest(DPoPScheme, "alice", proof))
+	if err == nil {
+		t.Error("proof signed by HMAC authorized")
+	}
+}
@@ -0,0 +1,84 @@
+package osecure
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrorResponseHandler writes the response of requests rejected by the middlewares, e.g. a branded page or
+// problem details, with the error why the request is rejected.
+type ErrorResponseHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// SetUnauthorizedHandler replaces http.Error of 401 responses of the middlewares, e.g. SecuredF of API routes and
+// RequireAALF. Headers like WWW-Authenticate are set before the handler is called. Non-API routes redirect
+// users to log in instead, see SetLoginRequiredHandler. It should be called before serving requests.
+func (s *OAuthSession) SetUnauthorizedHandler(handler ErrorResponseHandler) {
+	s.unauthorizedHandler = handler
+}
+
+// SetForbiddenHandler replaces http.Error of 403 responses of the middlewares, e.g. AuthorizedF, VerifyCSRFF
+// and SecuredF when permissions can't be got. It should be called before serving requests.
+func (s *OAuthSession) SetForbiddenHandler(handler ErrorResponseHandler) {
+	s.forbiddenHandler = handler
+}
+
+// SetLoginRequiredHandler replaces the redirect of unauthenticated users of non-API routes of SecuredF,
+// e.g. to render a page linking to LoginURL, or respond 401 to XHR requests. The error is ErrorSecondFactorRequired
+// for users pending the second factor, who are redirected to SecondFactorURL by default, otherwise the handler
+// can fall back to RedirectToLogin. It should be called before serving requests.
+func (s *OAuthSession) SetLoginRequiredHandler(handler ErrorResponseHandler) {
+	s.loginRequiredHandler = handler
+}
+
+func (s *OAuthSession) unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	if s.unauthorizedHandler != nil {
+		s.unauthorizedHandler(w, r, err)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
+func (s *OAuthSession) forbidden(w http.ResponseWriter, r *http.Request, err error) {
+	if s.forbiddenHandler != nil {
+		s.forbiddenHandler(w, r, err)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
+
+func (s *OAuthSession) loginRequired(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case s.loginRequiredHandler != nil:
+		s.loginRequiredHandler(w, r, err)
+	case errors.Is(err, ErrorSecondFactorRequired):
+		s.redirect(w, r, s.SecondFactorURL(r.RequestURI))
+	default:
+		s.RedirectToLogin(w, r)
+	}
+}
+
+// ProblemDetails is the ErrorResponseHandler writing problem details (RFC 7807) of the status code as
+// "application/problem+json", with the error message as "detail".
+func ProblemDetails(statusCode int) ErrorResponseHandler {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		problem := struct {
+			Type   string `json:"type"`
+			Title  string `json:"title"`
+			Status int    `json:"status"`
+			Detail string `json:"detail,omitempty"`
+		}{
+			Type:   "about:blank",
+			Title:  http.StatusText(statusCode),
+			Status: statusCode,
+		}
+		if err != nil {
+			problem.Detail = err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(&problem)
+	}
+}
@@ -0,0 +1,44 @@
+package osecure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoginRequiredHandler(t *testing.T) {
+	s := newTestSession(t, newTestVerifier(nil))
+	s.SetLoginPage("/login", nil)
+	handler := s.SecuredF(false)(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unauthenticated request passed")
+	})
+
+	// browsers are redirected to log in by default
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/page", nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login?continue=%2Fpage" {
+		t.Errorf("got %d to %q, want the redirect to log in", w.Code, w.Header().Get("Location"))
+	}
+
+	s.SetLoginRequiredHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+			ProblemDetails(http.StatusUnauthorized)(w, r, err)
+			return
+		}
+		s.RedirectToLogin(w, r)
+	})
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/page", nil)
+	r.Header.Set("X-Requested-With", "XMLHttpRequest")
+	handler(w, r)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("got %d %q, want the problem details of 401", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/page", nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login?continue=%2Fpage" {
+		t.Errorf("got %d to %q, want the redirect to log in by the handler", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -2,17 +2,83 @@ package osecure
 
 import (
 	"errors"
-	"fmt"
+	"net/http"
 	"strings"
 )
 
+// Error is a sentinel error of osecure, carrying the HTTP status hint of responding it, see ErrorStatusCode.
+type Error struct {
+	msg        string
+	statusCode int
+}
+
+func newError(msg string, statusCode int) error {
+	return &Error{msg: msg, statusCode: statusCode}
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// StatusCode is the HTTP status hint of the error.
+func (e *Error) StatusCode() int {
+	return e.statusCode
+}
+
 var (
-	ErrorInvalidSession                 = errors.New("invalid session")                       // Authorize()
-	ErrorInvalidAuthorizationSyntax     = errors.New("invalid authorization syntax")          // Authorize()
-	ErrorUnsupportedAuthorizationScheme = errors.New("unsupported authorization scheme")      // Authorize()
-	ErrorInvalidClientID                = errors.New("invalid client ID (audience of token)") // Authorize()
-	ErrorInvalidUserID                  = errors.New("invalid user ID (subject of token)")    // not used
+	ErrorInvalidSession                 = newError("invalid session", http.StatusUnauthorized)                                      // Authorize()
+	ErrorInvalidAuthorizationSyntax     = newError("invalid authorization syntax", http.StatusUnauthorized)                         // Authorize()
+	ErrorUnsupportedAuthorizationScheme = newError("unsupported authorization scheme", http.StatusUnauthorized)                     // Authorize()
+	ErrorInvalidClientID                = newError("invalid client ID (audience of token)", http.StatusUnauthorized)                // Authorize()
+	ErrorInvalidIssuer                  = newError("invalid issuer of token", http.StatusUnauthorized)                              // Authorize(), CallbackView()
+	ErrorAuthenticationTooOld           = newError("authentication is too old", http.StatusUnauthorized)                            // RequireRecentAuthF(), CallbackView()
+	ErrorSessionNotFound                = newError("session not found", http.StatusUnauthorized)                                    // SessionStore
+	ErrorSessionRevoked                 = newError("session is revoked", http.StatusUnauthorized)                                   // Authorize()
+	ErrorSessionStoreRequired           = newError("session store is required", http.StatusInternalServerError)                     // BackChannelLogoutHandler(), ListSessions()
+	ErrorInvalidLogoutToken             = newError("invalid logout token", http.StatusBadRequest)                                   // BackChannelLogoutHandler()
+	ErrorClientMismatch                 = newError("session is used by a different client", http.StatusUnauthorized)                // Authorize()
+	ErrorInvalidAPIKey                  = newError("invalid API key", http.StatusUnauthorized)                                      // APIKeyVerifier
+	ErrorCertificateMismatch            = newError("token is bound to another certificate", http.StatusUnauthorized)                // Authorize()
+	ErrorInvalidUserID                  = newError("invalid user ID (subject of token)", http.StatusUnauthorized)                   // not used
+	ErrorAccessDenied                   = newError("access denied", http.StatusForbidden)                                           // AuthorizedF()
+	ErrorInvalidDPoPProof               = newError("invalid DPoP proof", http.StatusUnauthorized)                                   // Authorize()
+	ErrorCookieTooLarge                 = newError("cookie is too large", http.StatusInternalServerError)                           // Authorize(), CallbackView()
+	ErrorInvalidEventToken              = newError("invalid security event token", http.StatusBadRequest)                           // BackChannelLogoutHandler(), PermissionChangeHandler()
+	ErrorInvalidationListRequired       = newError("invalidation list is required", http.StatusInternalServerError)                 // InvalidatePermissions()
+	ErrorUnknownTenant                  = newError("unknown tenant", http.StatusNotFound)                                           // TenantResolver
+	ErrorTenantMismatch                 = newError("session is of another tenant", http.StatusUnauthorized)                         // Authorize()
+	ErrorAlreadyImpersonating           = newError("already impersonating", http.StatusConflict)                                    // Impersonate()
+	ErrorNotImpersonating               = newError("not impersonating", http.StatusConflict)                                        // EndImpersonation()
+	ErrorTooManyFailures                = newError("too many failed attempts", http.StatusTooManyRequests)                          // Authorize(), CallbackView()
+	ErrorInvalidCSRFToken               = newError("invalid CSRF token", http.StatusForbidden)                                      // VerifyCSRFF()
+	ErrorUnknownProvider                = newError("unknown provider", http.StatusNotFound)                                         // LoginView()
+	ErrorTokenExpired                   = newError("token is expired", http.StatusUnauthorized)                                     // Authorize()
+	ErrorSessionExpired                 = newError("session is expired", http.StatusUnauthorized)                                   // Authorize()
+	ErrorCookieDecode                   = newError("cannot decode cookie", http.StatusUnauthorized)                                 // Authorize()
+	ErrorVerifierUnavailable            = newError("verifier is unavailable", http.StatusServiceUnavailable)                        // CircuitBreaker
+	ErrorSecondFactorRequired           = newError("second factor is required", http.StatusUnauthorized)                            // Authorize()
+	ErrorAssuranceLevelTooLow           = newError("authentication assurance level is too low", http.StatusUnauthorized)            // RequireAALF()
+	ErrorRememberMeNotFound             = newError("remember-me token not found", http.StatusUnauthorized)                          // RememberMeStore
+	ErrorRememberMeReused               = newError("remember-me token is reused", http.StatusUnauthorized)                          // Authorize()
+	ErrorRememberMeStoreRequired        = newError("remember-me store is required", http.StatusInternalServerError)                 // RememberMe()
+	ErrorTooManySessions                = newError("too many sessions", http.StatusForbidden)                                       // CallbackView()
+	ErrorNetworkNotAllowed              = newError("session is used from a disallowed network", http.StatusForbidden)               // Authorize()
+	ErrorReauthenticationRequired       = newError("reauthentication is required", http.StatusUnauthorized)                         // Authorize()
+	ErrorTokenTooLong                   = newError("token is too long", http.StatusUnauthorized)                                    // Authorize()
+	ErrorRequestCookieTooLarge          = newError("request cookie is too large", http.StatusUnauthorized)                          // Authorize()
+	ErrorCallbackTooLarge               = newError("callback request is too large", http.StatusRequestEntityTooLarge)               // CallbackView()
+	ErrorResourceMismatch               = newError("token is not issued for the resource", http.StatusUnauthorized)                 // RequireResourceF()
+	ErrorInvalidResponseIssuer          = newError("invalid issuer of authorization response", http.StatusUnauthorized)             // CallbackView()
+	ErrorTokenNotSenderConstrained      = newError("access token is not sender-constrained", http.StatusUnauthorized)               // Authorize(), CallbackView()
+	ErrorInvalidSignedURL               = newError("invalid signed URL", http.StatusUnauthorized)                                   // SignedURLF()
+	ErrorSignedURLExpired               = newError("signed URL is expired", http.StatusUnauthorized)                                // SignedURLF()
+	ErrorReplayCacheRequired            = newError("replay cache is required", http.StatusInternalServerError)                      // SignURL()
+	ErrorVerifierOnlySession            = newError("OAuth flow is performed by the issuer service", http.StatusInternalServerError) // StartOAuth(), EndOAuth()
+	ErrorSessionValuesTooLarge          = newError("session values are too large", http.StatusInternalServerError)                  // SetSessionValue()
+	ErrorInvalidSignedToken             = newError("invalid signed token", http.StatusUnauthorized)                                 // SignedToken
 
+	ErrorAudienceMismatch         = ErrorInvalidClientID     // alias of ErrorInvalidClientID
+	ErrorIntrospectionUnavailable = ErrorVerifierUnavailable // alias of ErrorVerifierUnavailable, see IsVerifierUnavailable
 )
 
 const (
@@ -22,10 +88,68 @@ const (
 	ErrorStringCannotIntrospectToken             = "cannot introspect token"
 	ErrorStringCannotGetPermission               = "cannot get permission"
 	ErrorStringInvalidState                      = "invalid state"
+	ErrorStringCannotAuthorize                   = "cannot authorize"
+	ErrorStringInvalidLogoutToken                = "invalid logout token"
+	ErrorStringCannotSaveSession                 = "cannot save session"
+	ErrorStringInvalidAPIKey                     = "invalid API key"
+	ErrorStringInvalidClientCertificate          = "invalid client certificate"
+	ErrorStringInvalidEventToken                 = "invalid event token"
+	ErrorStringLoginRejected                     = "login rejected"
+	ErrorStringCannotPushAuthorizationRequest    = "cannot push authorization request"
+	ErrorStringNonCompliantResponse              = "non-compliant response of auth server"
+	ErrorStringInvalidProxyAssertion             = "invalid identity assertion of proxy"
 )
 
+// WrappedError is the error wrapped by WrapError, Message is one of ErrorString constants.
+// Callers can branch on Message by errors.As instead of CompareErrorMessage, and on the cause by errors.Is.
+type WrappedError struct {
+	Message string
+	Err     error
+}
+
+func (e *WrappedError) Error() string {
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *WrappedError) Unwrap() error {
+	return e.Err
+}
+
 func WrapError(msg string, err error) error {
-	return fmt.Errorf("%s: %w", msg, err)
+	return &WrappedError{Message: msg, Err: err}
+}
+
+// wrappedErrorStatusCodes are HTTP status hints of WrappedError by Message, whose cause isn't an Error.
+// They are the statuses SecuredF and CallbackView respond.
+var wrappedErrorStatusCodes = map[string]int{
+	ErrorStringFailedToExchangeAuthorizationCode: http.StatusBadRequest,
+	ErrorStringUnableToSetCookie:                 http.StatusInternalServerError,
+	ErrorStringCannotGetPermission:               http.StatusForbidden,
+	ErrorStringInvalidState:                      http.StatusBadRequest,
+	ErrorStringCannotSaveSession:                 http.StatusInternalServerError,
+	ErrorStringLoginRejected:                     http.StatusForbidden,
+	ErrorStringNonCompliantResponse:              http.StatusBadRequest,
+}
+
+// ErrorStatusCode is the HTTP status hint of responding the error returned by osecure,
+// http.StatusUnauthorized if the error has no hint.
+// Failures of unavailable verifiers (see IsVerifierUnavailable) are hinted with http.StatusServiceUnavailable.
+func ErrorStatusCode(err error) int {
+	var sentinel *Error
+	if errors.As(err, &sentinel) {
+		return sentinel.StatusCode()
+	}
+	if IsVerifierUnavailable(err) {
+		return http.StatusServiceUnavailable
+	}
+
+	var wrapped *WrappedError
+	for e := err; errors.As(e, &wrapped); e = wrapped.Err {
+		if statusCode, found := wrappedErrorStatusCodes[wrapped.Message]; found {
+			return statusCode
+		}
+	}
+	return http.StatusUnauthorized
 }
 
 func CompareErrorMessage(err error, msg string) bool {
@@ -0,0 +1,116 @@
+package osecure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rayark/osecure/v6/state_handler"
+	"golang.org/x/oauth2"
+)
+
+// newCallbackTestSession creates a session whose token endpoint issues the code as the access token,
+// except code "invalid" which is rejected.
+func newCallbackTestSession(t *testing.T, verifier *TokenVerifier) *OAuthSession {
+	t.Helper()
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		code := r.FormValue("code")
+		if code == "invalid" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": code, "token_type": "Bearer", "expires_in": 3600})
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	return NewOAuthSession("osecure", newTestCookieConfig(), &OAuthConfig{ClientID: testClientID}, OAuthEndpoint{
+		AuthURL:  tokenServer.URL + "/authorize",
+		TokenURL: tokenServer.URL + "/token",
+	}, verifier, "https://example.com/callback", state_handler.SimpleStateHandler{})
+}
+
+// callbackStatus gets the status CallbackView reports to the continue URI, and the error.
+func callbackStatus(t *testing.T, s *OAuthSession, code string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/callback?state=%2F&code="+url.QueryEscape(code), nil)
+	s.CallbackView(w, r)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := url.ParseQuery(strings.TrimPrefix(location.Fragment, "?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _ := strconv.Atoi(query.Get("status"))
+	return status, query.Get("error")
+}
+
+func TestErrorStatusCodeOfCallbackView(t *testing.T) {
+	verifier := newTestVerifier(nil)
+	verifier.GetPermissionsFunc = func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+		if userID == "nopermission" {
+			return nil, errors.New("permission service is broken")
+		}
+		return nil, nil
+	}
+	s := newCallbackTestSession(t, verifier)
+	s.SetOnLogin(func(ctx context.Context, data *AuthSessionData) error {
+		if data.UserID == "rejected" {
+			return errors.New("user is suspended")
+		}
+		return nil
+	})
+
+	for code, want := range map[string]string{
+		"invalid":      ErrorStringFailedToExchangeAuthorizationCode,
+		"nopermission": ErrorStringCannotGetPermission,
+		"rejected":     ErrorStringLoginRejected,
+	} {
+		status, message := callbackStatus(t, s, code)
+		if !strings.HasPrefix(message, want+":") {
+			t.Errorf("%s: got error %q, want %q", code, message, want)
+			continue
+		}
+		err := WrapError(want, errors.New(strings.TrimPrefix(message, want+": ")))
+		if ErrorStatusCode(err) != status {
+			t.Errorf("%s: ErrorStatusCode() = %d, CallbackView responded %d", code, ErrorStatusCode(err), status)
+		}
+	}
+}
+
+func TestErrorStatusCodeOfSecuredF(t *testing.T) {
+	verifier := newTestVerifier(nil)
+	verifier.GetPermissionsFunc = func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+		if userID == "nopermission" {
+			return nil, errors.New("permission service is broken")
+		}
+		return nil, nil
+	}
+	s := newTestSession(t, verifier)
+
+	for _, accessToken := range []string{"nopermission", strings.Repeat("a", 64*1024)} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+		_, err := s.Authorize(httptest.NewRecorder(), r)
+		if err == nil {
+			t.Fatalf("%.16s: authorized", accessToken)
+		}
+
+		w := httptest.NewRecorder()
+		s.SecuredF(true)(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+		if ErrorStatusCode(err) != w.Code {
+			t.Errorf("%.16s: ErrorStatusCode(%v) = %d, SecuredF responded %d", accessToken, err, ErrorStatusCode(err), w.Code)
+		}
+	}
+}
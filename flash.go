@@ -0,0 +1,68 @@
+package osecure
+
+import (
+	"net/http"
+)
+
+// flashCookieSuffix is the suffix of the name of the flash cookie after the session name.
+const flashCookieSuffix = "_flash"
+
+func (s *OAuthSession) flashCookieName() string {
+	return s.name + flashCookieSuffix
+}
+
+// AddFlash adds a one-time message for the next page the user sees, e.g. after a redirect, which is read and
+// removed by Flashes. Messages are kept in a cookie apart from the session, so they survive the login redirect
+// and logout. The cookie is written into the response, so it must be called before writing the response body.
+func (s *OAuthSession) AddFlash(w http.ResponseWriter, r *http.Request, message string) error {
+	session, err := s.getCookieStore().Get(r, s.flashCookieName())
+	if err != nil {
+		// invalid cookie, e.g. of rotated keys, is replaced
+		session, err = s.getCookieStore().New(r, s.flashCookieName())
+		if err != nil {
+			return err
+		}
+	}
+	session.AddFlash(message)
+	// browser session cookie, messages aren't meant to last
+	session.Options.MaxAge = 0
+	err = session.Save(r, w)
+	if err != nil {
+		return WrapError(ErrorStringUnableToSetCookie, err)
+	}
+	return nil
+}
+
+// Flashes reads and removes the messages added by AddFlash, e.g. to render them in the page.
+func (s *OAuthSession) Flashes(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	if _, err := r.Cookie(s.flashCookieName()); err != nil {
+		return nil, nil
+	}
+
+	session, err := s.getCookieStore().Get(r, s.flashCookieName())
+	flashes := session.Flashes()
+	session.Options.MaxAge = -1
+	saveErr := session.Save(r, w)
+	if err != nil {
+		// the invalid cookie is deleted anyway
+		return nil, nil
+	}
+	if saveErr != nil {
+		return nil, WrapError(ErrorStringUnableToSetCookie, saveErr)
+	}
+
+	messages := make([]string, 0, len(flashes))
+	for _, flash := range flashes {
+		if message, ok := flash.(string); ok {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// SetLoginRequiredFlash adds the message by AddFlash when Secured redirects unauthenticated users to log in,
+// e.g. "You must log in to view that page.", shown by the page they continue to after login.
+// It should be called before serving requests.
+func (s *OAuthSession) SetLoginRequiredFlash(message string) {
+	s.loginRequiredFlash = message
+}
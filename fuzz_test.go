@@ -0,0 +1,145 @@
+package osecure
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func FuzzDeserializeAuthCookieData(f *testing.F) {
+	now := time.Unix(1700000000, 0)
+	for _, cookieData := range []*AuthSessionCookieData{
+		{Token: &oauth2.Token{AccessToken: "access"}},
+		{
+			Token:                &oauth2.Token{AccessToken: "access", TokenType: "Bearer", RefreshToken: "refresh", Expiry: now},
+			Permissions:          NewPermissionSet(benchmarkPermissions(10)),
+			PermissionsExpiresAt: now,
+			SessionCreatedAt:     now,
+			SessionID:            "sid",
+		},
+		newBenchmarkCookieData(benchmarkPermissions(1000)), // compressed
+	} {
+		data, err := serializeAuthCookieData(cookieData)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{cookieFormatJSON})
+	f.Add([]byte{cookieFormatGob})
+	f.Add([]byte{cookieFormatJSONGzip, 0x1f, 0x8b})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cookieData, err := deserializeAuthCookieData(data)
+		if err != nil {
+			return
+		}
+		if cookieData.Token == nil || cookieData.Token.AccessToken == "" {
+			t.Fatalf("deserialized cookie data without access token")
+		}
+
+		// decoded cookie data is serialized and deserialized again without changes
+		serialized, err := serializeAuthCookieData(cookieData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip, err := deserializeAuthCookieData(serialized)
+		if err != nil {
+			t.Fatalf("cannot deserialize serialized cookie data: %v", err)
+		}
+		reserialized, err := serializeAuthCookieData(roundTrip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(serialized, reserialized) {
+			t.Fatalf("round trip changed the cookie data: %q != %q", serialized, reserialized)
+		}
+	})
+}
+
+func FuzzGetBearerToken(f *testing.F) {
+	for _, header := range []string{
+		"Bearer token",
+		"bearer abc.def-ghi_jkl~mno+pqr/stu==",
+		"DPoP token",
+		"Basic dXNlcjpwYXNz",
+		"Bearer",
+		"Bearer ",
+		" token",
+		"Bearer  token",
+		"Bearer to ken",
+		"Bearer \xff",
+		"Bearer " + strings.Repeat("a", DefaultMaxTokenLength+1),
+	} {
+		f.Add(header)
+	}
+
+	s := newTestSession(f, newTestVerifier(nil))
+	s.SetDPoPValidator(NewDPoPValidator())
+
+	f.Fuzz(func(t *testing.T, header string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header["Authorization"] = []string{header}
+
+		token, isDPoP, err := s.getBearerToken(r)
+		if err != nil {
+			if token != "" {
+				t.Fatalf("token %q returned with error %v", token, err)
+			}
+			return
+		}
+		if !isToken68(token) || len(token) > DefaultMaxTokenLength {
+			t.Fatalf("invalid token %q accepted", token)
+		}
+
+		// the token is parsed the same again
+		scheme := "Bearer"
+		if isDPoP {
+			scheme = DPoPScheme
+		}
+		r.Header.Set("Authorization", scheme+" "+token)
+		again, againIsDPoP, err := s.getBearerToken(r)
+		if err != nil || again != token || againIsDPoP != isDPoP {
+			t.Fatalf("token %q parsed again as %q, %v, %v", token, again, againIsDPoP, err)
+		}
+	})
+}
+
+func FuzzIsLocalURI(f *testing.F) {
+	for _, uri := range []string{
+		"/",
+		"/path?query#fragment",
+		"//evil.example.com",
+		"/\\evil.example.com",
+		"https://evil.example.com",
+		"/%2F%2Fevil.example.com",
+		"",
+		"\t/path",
+	} {
+		f.Add(uri)
+	}
+
+	f.Fuzz(func(t *testing.T, uri string) {
+		if !IsLocalURI(uri) {
+			return
+		}
+
+		// local URIs resolve to this site, whatever the base URL is
+		base, _ := url.Parse("https://example.com/base/")
+		ref, err := url.Parse(uri)
+		if err != nil {
+			return
+		}
+		resolved := base.ResolveReference(ref)
+		if resolved.Scheme != "https" || resolved.Host != "example.com" {
+			t.Fatalf("local URI %q resolves to %q", uri, resolved)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+// Package osecure/gateway provides a forward-auth endpoint for reverse proxies,
+// e.g. nginx auth_request and Traefik forwardAuth, to protect apps without modifying them.
+package gateway
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rayark/osecure/v6"
+)
+
+// Identity headers of authorized responses, which proxies copy to requests of the app.
+const (
+	HeaderSubject     = "X-Auth-Subject"
+	HeaderClientID    = "X-Auth-Client-Id"
+	HeaderPermissions = "X-Auth-Permissions" // comma separated
+	HeaderRoles       = "X-Auth-Roles"       // comma separated
+	HeaderEmail       = "X-Auth-Email"
+	HeaderActor       = "X-Auth-Actor" // the real user during impersonation
+)
+
+// PermissionParameter is the query parameter of the endpoint for required permissions,
+// e.g. "auth_request /auth?permission=admin;" of nginx. It can be repeated to require all.
+const PermissionParameter = "permission"
+
+// Gateway is the forward-auth endpoint, which verifies the session cookie or bearer token of the request
+// and responds 200 with identity headers, 401 if unauthenticated, or 403 if permissions are missing.
+type Gateway struct {
+	Session *osecure.OAuthSession
+
+	// RedirectToLogin redirects unauthenticated browsers to log in instead of responding 401.
+	// It works with Traefik forwardAuth, which passes the response to the client,
+	// but not nginx auth_request, which takes only 2xx, 401 and 403.
+	// CallbackView of the session must be routed to the app's host.
+	RedirectToLogin bool
+}
+
+// New creates Gateway with the session.
+func New(s *osecure.OAuthSession) *Gateway {
+	return &Gateway{Session: s}
+}
+
+// originalRequest restores the method and URI of the request to the app,
+// from X-Original-Method and X-Original-URI (nginx) or X-Forwarded-Method and X-Forwarded-Uri (Traefik).
+func originalRequest(r *http.Request) *http.Request {
+	method := firstHeader(r, "X-Original-Method", "X-Forwarded-Method")
+	uri := firstHeader(r, "X-Original-URI", "X-Forwarded-Uri")
+	host := r.Header.Get("X-Forwarded-Host")
+
+	rr := r.WithContext(r.Context())
+	if method != "" {
+		rr.Method = method
+	}
+	if uri != "" {
+		rr.RequestURI = uri
+		if u, err := r.URL.Parse(uri); err == nil {
+			rr.URL = u
+		}
+	}
+	if host != "" {
+		rr.Host = host
+	}
+	return rr
+}
+
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	required := r.URL.Query()[PermissionParameter]
+	r = originalRequest(r)
+
+	data, err := g.Session.Authorize(w, r)
+	if err != nil {
+		switch {
+		case osecure.CompareErrorMessage(err, osecure.ErrorStringUnauthorized):
+			if g.RedirectToLogin && r.Header.Get("Authorization") == "" {
+				err = g.Session.StartOAuth(w, r)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+			} else {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+			}
+		case osecure.CompareErrorMessage(err, osecure.ErrorStringCannotGetPermission):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	for _, permission := range required {
+		if !data.HasPermission(permission) {
+			http.Error(w, osecure.ErrorAccessDenied.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	permissions := data.Permissions.List()
+	sort.Strings(permissions)
+
+	header := w.Header()
+	header.Set(HeaderSubject, data.UserID)
+	header.Set(HeaderClientID, data.ClientID)
+	header.Set(HeaderPermissions, strings.Join(permissions, ","))
+	if len(data.Roles) > 0 {
+		header.Set(HeaderRoles, strings.Join(data.Roles, ","))
+	}
+	if data.Email != "" {
+		header.Set(HeaderEmail, data.Email)
+	}
+	if data.ActorID != "" {
+		header.Set(HeaderActor, data.ActorID)
+	}
+	w.WriteHeader(http.StatusOK)
+}
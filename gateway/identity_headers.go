@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+)
+
+// Identity headers injected for upstream services by IdentityInjector.
+const (
+	IdentityHeaderPrefix      = "X-Osecure-"
+	IdentityHeaderSubject     = "X-Osecure-Subject"
+	IdentityHeaderPermissions = "X-Osecure-Permissions" // comma separated
+	IdentityHeaderTimestamp   = "X-Osecure-Timestamp"   // unix seconds, only if signed
+	IdentityHeaderSignature   = "X-Osecure-Signature"
+)
+
+// DefaultIdentityMaxAge is the default max age of signed identity headers.
+const DefaultIdentityMaxAge = time.Minute
+
+var (
+	ErrorMissingIdentity   = errors.New("missing identity headers")
+	ErrorInvalidSignature  = errors.New("invalid identity signature")
+	ErrorIdentityTooOld    = errors.New("identity headers are too old")
+	ErrorSigningKeyMissing = errors.New("signing key is required")
+)
+
+// IdentityInjector is a middleware of proxies, e.g. httputil.ReverseProxy wrapped by Secured,
+// which strips inbound identity headers so clients can't forge them,
+// and injects the identity of the session data of the request for upstream services.
+type IdentityInjector struct {
+	// SigningKey signs the identity headers by HMAC-SHA256 if not empty, see IdentityVerifier.
+	// The signature covers the method and the path, which the proxy must not rewrite.
+	SigningKey []byte
+}
+
+// identitySignature signs the identity along with the method and the path,
+// so it can't be replayed to other endpoints.
+func identitySignature(key []byte, method string, path string, subject string, permissions string, timestamp string) string {
+	mac := hmac.New(sha256.New, key)
+	for _, field := range []string{method, path, subject, permissions, timestamp} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{'\n'})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// stripIdentityHeaders removes all headers with IdentityHeaderPrefix.
+func stripIdentityHeaders(header http.Header) {
+	for key := range header {
+		if strings.HasPrefix(key, IdentityHeaderPrefix) {
+			delete(header, key)
+		}
+	}
+}
+
+// Middleware wraps the handler passing requests upstream.
+func (injector *IdentityInjector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(r.Context())
+		r.Header = r.Header.Clone()
+		stripIdentityHeaders(r.Header)
+
+		data, found := osecure.GetRequestSessionData(r)
+		if found && data != nil {
+			permissions := data.Permissions.List()
+			sort.Strings(permissions)
+			joined := strings.Join(permissions, ",")
+
+			r.Header.Set(IdentityHeaderSubject, data.UserID)
+			r.Header.Set(IdentityHeaderPermissions, joined)
+			if len(injector.SigningKey) > 0 {
+				timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+				r.Header.Set(IdentityHeaderTimestamp, timestamp)
+				r.Header.Set(IdentityHeaderSignature, identitySignature(injector.SigningKey, r.Method, r.URL.Path, data.UserID, joined, timestamp))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Identity is the identity of a request from the proxy, verified by IdentityVerifier.
+type Identity struct {
+	Subject     string
+	Permissions []string
+}
+
+// HasPermission checks if the identity has the permission, see osecure.MatchPermission.
+func (identity *Identity) HasPermission(permission string) bool {
+	for _, granted := range identity.Permissions {
+		if osecure.MatchPermission(granted, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityVerifier verifies identity headers signed by IdentityInjector on the receiving side.
+type IdentityVerifier struct {
+	SigningKey []byte
+	MaxAge     time.Duration // DefaultIdentityMaxAge if zero
+}
+
+// Verify verifies the identity headers of the request.
+func (verifier *IdentityVerifier) Verify(r *http.Request) (*Identity, error) {
+	if len(verifier.SigningKey) == 0 {
+		return nil, ErrorSigningKeyMissing
+	}
+
+	subject := r.Header.Get(IdentityHeaderSubject)
+	permissions := r.Header.Get(IdentityHeaderPermissions)
+	timestamp := r.Header.Get(IdentityHeaderTimestamp)
+	signature := r.Header.Get(IdentityHeaderSignature)
+	if subject == "" || timestamp == "" || signature == "" {
+		return nil, ErrorMissingIdentity
+	}
+
+	expected := identitySignature(verifier.SigningKey, r.Method, r.URL.Path, subject, permissions, timestamp)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, ErrorInvalidSignature
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrorInvalidSignature
+	}
+	maxAge := verifier.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultIdentityMaxAge
+	}
+	age := time.Since(time.Unix(sec, 0))
+	if age > maxAge || age < -maxAge {
+		return nil, ErrorIdentityTooOld
+	}
+
+	identity := &Identity{Subject: subject}
+	if permissions != "" {
+		identity.Permissions = strings.Split(permissions, ",")
+	}
+	return identity, nil
+}
+
+type contextKey int
+
+const contextKeyIdentity = contextKey(1)
+
+// GetRequestIdentity gets the identity verified by IdentityVerifier.Middleware from request context.
+func GetRequestIdentity(r *http.Request) (*Identity, bool) {
+	identity, ok := r.Context().Value(contextKeyIdentity).(*Identity)
+	return identity, ok
+}
+
+// Middleware responds 401 to requests without valid identity headers,
+// and attaches the identity to request context otherwise.
+func (verifier *IdentityVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := verifier.Verify(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyIdentity, identity)))
+	})
+}
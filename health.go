@@ -0,0 +1,167 @@
+package osecure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/securecookie"
+	"github.com/rayark/osecure/v6/jwt"
+)
+
+// Statuses of HealthReport and HealthCheckResult.
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+)
+
+// HealthCheck is a dependency check of HealthHandler, e.g. EndpointHealthCheck and JWKSHealthCheck.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthCheckResult is the result of a HealthCheck.
+type HealthCheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthReport is the response of HealthHandler.
+type HealthReport struct {
+	Status string              `json:"status"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+// EndpointHealthCheck checks the endpoint is reachable, responding without 5xx status,
+// e.g. the introspection endpoint with http.MethodPost.
+func EndpointHealthCheck(name string, method string, endpointURL string) HealthCheck {
+	client := newProbeClient()
+	return HealthCheck{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			return probeEndpoint(ctx, client, method, endpointURL)
+		},
+	}
+}
+
+// JWKSHealthCheck checks the JWK set document of the URL has at least one key.
+func JWKSHealthCheck(jwksURL string) HealthCheck {
+	client := newProbeClient()
+	return HealthCheck{
+		Name: "jwks endpoint",
+		Check: func(ctx context.Context) error {
+			req, err := http.NewRequest(http.MethodGet, jwksURL, nil)
+			if err != nil {
+				return err
+			}
+			req = req.WithContext(ctx)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			}
+
+			var keySet jwt.JSONWebKeySet
+			err = json.NewDecoder(resp.Body).Decode(&keySet)
+			if err != nil {
+				return err
+			}
+			if len(keySet.Keys) == 0 {
+				return errors.New("no keys")
+			}
+			return nil
+		},
+	}
+}
+
+// HealthHandler reports the health of auth dependencies for readiness probes,
+// responding HealthReport with 200 if all checks pass, or 503 otherwise.
+// The cookie keys and the token endpoint are always checked, as well as the issuer discovery if Issuer is set.
+// The introspection and JWKS endpoints are opaque to the session, they should be checked by EndpointHealthCheck and JWKSHealthCheck.
+func (s *OAuthSession) HealthHandler(checks ...HealthCheck) http.Handler {
+	client := newProbeClient()
+	builtinChecks := []HealthCheck{
+		{Name: "cookie keys", Check: s.checkCookieKeys},
+		{
+			Name: "token endpoint",
+			Check: func(ctx context.Context) error {
+				// a token request without grant responds 400 or 401 if the endpoint works
+				return probeEndpoint(ctx, client, http.MethodPost, s.getClient().Endpoint.TokenURL)
+			},
+		},
+	}
+	if s.issuer != "" {
+		builtinChecks = append(builtinChecks, HealthCheck{
+			Name: "issuer discovery",
+			Check: func(ctx context.Context) error {
+				return probeDiscovery(ctx, client, s.issuer)
+			},
+		})
+	}
+	checks = append(builtinChecks, checks...)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := &HealthReport{
+			Status: HealthStatusOK,
+			Checks: make([]HealthCheckResult, len(checks)),
+		}
+
+		var wg sync.WaitGroup
+		for i, check := range checks {
+			wg.Add(1)
+			go func(i int, check HealthCheck) {
+				defer wg.Done()
+				result := HealthCheckResult{Name: check.Name, Status: HealthStatusOK}
+				err := check.Check(r.Context())
+				if err != nil {
+					result.Status = HealthStatusFail
+					result.Error = err.Error()
+				}
+				report.Checks[i] = result
+			}(i, check)
+		}
+		wg.Wait()
+
+		statusCode := http.StatusOK
+		for _, result := range report.Checks {
+			if result.Status != HealthStatusOK {
+				report.Status = HealthStatusFail
+				statusCode = http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// checkCookieKeys checks the current cookie keys can encode and decode cookies.
+func (s *OAuthSession) checkCookieKeys(ctx context.Context) error {
+	codecs := s.getCookieStore().Codecs
+	encoded, err := securecookie.EncodeMulti(s.name, "health", codecs...)
+	if err != nil {
+		return err
+	}
+
+	var value string
+	err = securecookie.DecodeMulti(s.name, encoded, &value, codecs...)
+	if err != nil {
+		return err
+	}
+	if value != "health" {
+		return errors.New("decoded value mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package osecure
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/rayark/osecure/v6/jwt"
+)
+
+// Headers of signed identity assertions injected by identity-aware proxies.
+const (
+	CloudflareAccessAssertionHeader = "Cf-Access-Jwt-Assertion"
+	GoogleIAPAssertionHeader        = "X-Goog-Iap-Jwt-Assertion"
+)
+
+// GoogleIAPIssuer is the issuer of assertions of Google Cloud IAP.
+const GoogleIAPIssuer = "https://cloud.google.com/iap"
+
+// GoogleIAPKeySetURL is the JWK set of assertions of Google Cloud IAP.
+const GoogleIAPKeySetURL = "https://www.gstatic.com/iap/verify/public_key-jwk"
+
+// IdentityAwareProxyTokenType is the token type of session data authenticated by identity-aware proxies.
+const IdentityAwareProxyTokenType = "IdentityAwareProxy"
+
+// IdentityAwareProxy is a proxy in front of the server which authenticates users by itself,
+// and injects the identity as a signed JWT assertion in the header of requests.
+type IdentityAwareProxy struct {
+	Header   string
+	Issuer   string
+	Audience string // of the application behind the proxy
+	KeySet   jwt.KeySet
+}
+
+// CloudflareAccess is the proxy of Cloudflare Access of the team domain (e.g. "example.cloudflareaccess.com"),
+// whose audience is the application audience (AUD) tag.
+func CloudflareAccess(teamDomain string, audience string) IdentityAwareProxy {
+	issuer := "https://" + strings.TrimSuffix(strings.TrimPrefix(teamDomain, "https://"), "/")
+	return IdentityAwareProxy{
+		Header:   CloudflareAccessAssertionHeader,
+		Issuer:   issuer,
+		Audience: audience,
+		KeySet:   jwt.NewRemoteKeySet(issuer + "/cdn-cgi/access/certs"),
+	}
+}
+
+// GoogleIAP is the proxy of Google Cloud IAP, whose audience is "/projects/<project number>/global/backendServices/<id>"
+// for load balancers or "/projects/<project number>/apps/<project ID>" for App Engine.
+func GoogleIAP(audience string) IdentityAwareProxy {
+	return IdentityAwareProxy{
+		Header:   GoogleIAPAssertionHeader,
+		Issuer:   GoogleIAPIssuer,
+		Audience: audience,
+		KeySet:   jwt.NewRemoteKeySet(GoogleIAPKeySetURL),
+	}
+}
+
+// TrustIdentityAwareProxy authenticates requests by the identity assertions of the proxy instead of the OAuth flow,
+// producing the same session data as tokens: claims are mapped by the claims mapper, and permissions are got by
+// GetPermissionsFunc. The client ID is the client ID of the session, or "common_name" of service tokens of
+// Cloudflare Access, which authenticate as service accounts. Requests without the header are left to other
+// authentication, so the server must only be reachable through the proxy. It should be called before serving requests.
+func (s *OAuthSession) TrustIdentityAwareProxy(proxy IdentityAwareProxy) {
+	expected := jwt.Expected{Issuer: proxy.Issuer, Audiences: []string{proxy.Audience}, Leeway: s.clockSkew}
+
+	s.AddAuthenticator(AuthenticatorFunc(func(r *http.Request) (*AuthSessionData, error) {
+		assertion := r.Header.Get(proxy.Header)
+		if assertion == "" {
+			return nil, nil
+		}
+		if len(assertion) > s.maxTokenLength {
+			return nil, ErrorTokenTooLong
+		}
+
+		token, err := jwt.Parse(assertion)
+		if err != nil {
+			return nil, WrapError(ErrorStringInvalidProxyAssertion, err)
+		}
+		err = token.VerifyWithKeySet(r.Context(), proxy.KeySet)
+		if err != nil {
+			return nil, WrapError(ErrorStringInvalidProxyAssertion, err)
+		}
+		err = token.Claims.Validate(expected)
+		if err != nil {
+			return nil, WrapError(ErrorStringInvalidProxyAssertion, err)
+		}
+		expiresAt, ok := token.Claims.Int64("exp")
+		if !ok {
+			return nil, WrapError(ErrorStringInvalidProxyAssertion, jwt.ErrorMissingClaim)
+		}
+
+		userID := token.Claims.String("sub")
+		clientID := s.getClient().ClientID
+		if commonName := token.Claims.String("common_name"); userID == "" && commonName != "" {
+			userID, clientID = commonName, commonName
+		}
+		if userID == "" {
+			return nil, WrapError(ErrorStringInvalidProxyAssertion, jwt.ErrorMissingClaim)
+		}
+
+		extra := map[string]interface{}(token.Claims)
+		userID, clientID, permissions, profile := s.mapClaims(userID, clientID, extra)
+		data := &AuthSessionData{
+			UserID:                userID,
+			ClientID:              clientID,
+			AuthSessionCookieData: s.newAuthSessionCookieData(makeToken(IdentityAwareProxyTokenType, "", expiresAt).WithExtra(extra)),
+			Profile:               profile,
+			Extra:                 extra,
+		}
+		if permissions != nil {
+			data.setPermissions(permissions)
+			data.PermissionsExpiresAt = data.Token.Expiry
+		}
+
+		_, err = s.ensurePermUpdated(r.Context(), data)
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}))
+}
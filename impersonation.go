@@ -0,0 +1,134 @@
+package osecure
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// PermissionImpersonate is the permission required to impersonate other users by Impersonate.
+const PermissionImpersonate = "impersonate"
+
+// CanImpersonateFunc decides if the actor can impersonate the target, whose permissions aren't
+// a subset of the actor's. The target session data has the target user ID and permissions.
+type CanImpersonateFunc func(ctx context.Context, actor *AuthSessionData, target *AuthSessionData) (bool, error)
+
+// SetCanImpersonate sets the hook which allows impersonating users with permissions the actor doesn't have,
+// which is refused by Impersonate if the hook is not set. It should be called before serving requests.
+func (s *OAuthSession) SetCanImpersonate(canImpersonate CanImpersonateFunc) {
+	s.canImpersonate = canImpersonate
+}
+
+// Impersonate lets the current user, who must have PermissionImpersonate, act as the target user
+// until EndImpersonation. During impersonation, AuthSessionData.UserID is the target user with
+// the target's permissions, and AuthSessionData.ActorID is the real user.
+// GetPermissionsFunc is called with the target user ID and the real user's token,
+// so it must look permissions up by user ID for impersonation to get the target's permissions.
+// Targets with permissions the current user doesn't have are refused unless allowed by SetCanImpersonate,
+// so impersonation can't escalate privileges.
+// Impersonation is kept in the session cookie, so it only works with cookie sessions.
+func (s *OAuthSession) Impersonate(w http.ResponseWriter, r *http.Request, targetUserID string) error {
+	if targetUserID == "" {
+		return ErrorInvalidUserID
+	}
+
+	data, err := s.Authorize(w, r)
+	if err != nil {
+		return err
+	}
+	if data.ActorID != "" {
+		return ErrorAlreadyImpersonating
+	}
+	if !data.HasPermission(PermissionImpersonate) {
+		s.audit(r, AuditEventAccessDenied, data, ErrorAccessDenied)
+		return ErrorAccessDenied
+	}
+
+	allowed, err := s.isImpersonationAllowed(r.Context(), data, targetUserID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		s.audit(r, AuditEventAccessDenied, data, ErrorAccessDenied)
+		return ErrorAccessDenied
+	}
+
+	data.ImpersonatedUserID = targetUserID
+	err = s.resetImpersonation(w, r, data.AuthSessionCookieData)
+	if err != nil {
+		return err
+	}
+
+	data.impersonate()
+	s.audit(r, AuditEventImpersonationStart, data, nil)
+	return nil
+}
+
+// EndImpersonation reverts the session to the real user.
+func (s *OAuthSession) EndImpersonation(w http.ResponseWriter, r *http.Request) error {
+	data, err := s.Authorize(w, r)
+	if err != nil {
+		return err
+	}
+	if data.ActorID == "" {
+		return ErrorNotImpersonating
+	}
+
+	data.ImpersonatedUserID = ""
+	err = s.resetImpersonation(w, r, data.AuthSessionCookieData)
+	if err != nil {
+		return err
+	}
+
+	s.audit(r, AuditEventImpersonationEnd, data, nil)
+	return nil
+}
+
+// isImpersonationAllowed checks if the permissions of the target are a subset of the actor's,
+// or asks the CanImpersonateFunc otherwise.
+func (s *OAuthSession) isImpersonationAllowed(ctx context.Context, actor *AuthSessionData, targetUserID string) (bool, error) {
+	permissions, err := s.getPermissionsOnce(ctx, targetUserID, actor.ClientID, actor.Token)
+	if err != nil {
+		return false, WrapError(ErrorStringCannotGetPermission, err)
+	}
+	if actor.HasAllPermissions(permissions...) {
+		return true, nil
+	}
+	if s.canImpersonate == nil {
+		return false, nil
+	}
+
+	target := NewAuthSessionData(targetUserID, actor.ClientID, actor.Token, permissions, time.Now().Add(s.permissionExpireTime))
+	allowed, err := s.canImpersonate(ctx, actor, target)
+	if err != nil {
+		return false, WrapError(ErrorStringCannotAuthorize, err)
+	}
+	return allowed, nil
+}
+
+// resetImpersonation saves the impersonated user, dropping permissions of the previous user
+// so they are fetched for the new user on next request.
+func (s *OAuthSession) resetImpersonation(w http.ResponseWriter, r *http.Request, cookieData *AuthSessionCookieData) error {
+	cookieData.Permissions = NewPermissionSet(nil)
+	cookieData.PermissionsExpiresAt = time.Time{}
+
+	err := s.setAuthCookie(w, r, cookieData)
+	if err != nil {
+		return WrapError(ErrorStringUnableToSetCookie, err)
+	}
+	return nil
+}
+
+// impersonate switches the session data to the impersonated user, if any.
+func (data *AuthSessionData) impersonate() {
+	if data.ImpersonatedUserID == "" {
+		return
+	}
+	data.ActorID = data.UserID
+	data.UserID = data.ImpersonatedUserID
+}
+
+// IsImpersonating checks if the real user is acting as another user, see Impersonate.
+func (data *AuthSessionData) IsImpersonating() bool {
+	return data.ActorID != ""
+}
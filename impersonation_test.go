@@ -0,0 +1,107 @@
+package osecure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// newImpersonationTestVerifier accepts any token as the token of the user of the same name,
+// with the permissions of the user.
+func newImpersonationTestVerifier(userPermissions map[string][]string) *TokenVerifier {
+	verifier := newTestVerifier(nil)
+	verifier.GetPermissionsFunc = func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+		return userPermissions[userID], nil
+	}
+	return verifier
+}
+
+func impersonate(t *testing.T, s *OAuthSession, actor string, target string) (*http.Cookie, error) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/impersonate", nil)
+	r.AddCookie(newTestSessionCookie(t, s, actor))
+	w := httptest.NewRecorder()
+	err := s.Impersonate(w, r, target)
+
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == s.name {
+			cookie = c
+		}
+	}
+	return cookie, err
+}
+
+func TestImpersonate(t *testing.T) {
+	s := newTestSession(t, newImpersonationTestVerifier(map[string][]string{
+		"admin":   {PermissionImpersonate, "repo:*:read", "repo:*:write"},
+		"support": {PermissionImpersonate, "repo:*:read"},
+		"alice":   {"repo:a:read", "repo:a:write"},
+		"bob":     {"repo:b:read"},
+	}))
+
+	cookie, err := impersonate(t, s, "admin", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	data, err := s.Authorize(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.UserID != "alice" || data.ActorID != "admin" || !data.HasPermission("repo:a:write") || data.HasPermission(PermissionImpersonate) {
+		t.Errorf("impersonated session = %s by %s with %v", data.UserID, data.ActorID, data.GetPermissions())
+	}
+
+	for target, want := range map[string]error{
+		"bob":   nil,               // subset by wildcard
+		"alice": ErrorAccessDenied, // "repo:a:write" is not granted to support
+		"admin": ErrorAccessDenied,
+	} {
+		_, err := impersonate(t, s, "support", target)
+		if err != want {
+			t.Errorf("support impersonating %s: got %v, want %v", target, err, want)
+		}
+	}
+
+	_, err = impersonate(t, s, "alice", "bob")
+	if err != ErrorAccessDenied {
+		t.Errorf("impersonating without permission: got %v", err)
+	}
+}
+
+func TestImpersonateCanImpersonate(t *testing.T) {
+	s := newTestSession(t, newImpersonationTestVerifier(map[string][]string{
+		"support": {PermissionImpersonate, "repo:*:read"},
+		"alice":   {"repo:a:read", "repo:a:write"},
+		"admin":   {PermissionImpersonate, "admin"},
+	}))
+
+	var calls int
+	errPolicy := errors.New("policy error")
+	s.SetCanImpersonate(func(ctx context.Context, actor *AuthSessionData, target *AuthSessionData) (bool, error) {
+		calls++
+		if actor.UserID != "support" {
+			return false, errPolicy
+		}
+		return !target.HasPermission("admin"), nil
+	})
+
+	if _, err := impersonate(t, s, "support", "alice"); err != nil {
+		t.Errorf("impersonation allowed by hook: %v", err)
+	}
+	if _, err := impersonate(t, s, "support", "admin"); err != ErrorAccessDenied {
+		t.Errorf("impersonation refused by hook: got %v", err)
+	}
+	if _, err := impersonate(t, s, "admin", "alice"); !errors.Is(err, errPolicy) {
+		t.Errorf("error of hook: got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("hook called %d times, want 3", calls)
+	}
+}
@@ -0,0 +1,49 @@
+package osecure
+
+import (
+	"net/http"
+)
+
+// Default input limits, used when the corresponding OAuthConfig field is zero.
+// Legit tokens and cookies are far shorter, while hostile clients may send megabytes.
+const (
+	DefaultMaxTokenLength       = 16 * 1024
+	DefaultMaxCookieSize        = 8 * 1024
+	DefaultMaxCallbackQuerySize = 8 * 1024
+	DefaultMaxSessionValuesSize = 1024
+)
+
+func intOrDefault(n int, defaultN int) int {
+	if n > 0 {
+		return n
+	}
+	return defaultN
+}
+
+// checkCookieSize rejects the cookie of the name if its value is longer than MaxCookieSize,
+// before it's decoded and decrypted.
+func (s *OAuthSession) checkCookieSize(r *http.Request, name string) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil
+	}
+	if len(cookie.Value) > s.maxCookieSize {
+		return ErrorRequestCookieTooLarge
+	}
+	return nil
+}
+
+// limitCallbackRequest rejects callback requests with the query longer than MaxCallbackQuerySize,
+// and limits the form body of response_mode=form_post to the same size.
+func (s *OAuthSession) limitCallbackRequest(w http.ResponseWriter, r *http.Request) error {
+	if len(r.URL.RawQuery) > s.maxCallbackQuerySize {
+		return ErrorCallbackTooLarge
+	}
+	if r.Body != nil && r.Method == http.MethodPost {
+		if r.ContentLength > int64(s.maxCallbackQuerySize) {
+			return ErrorCallbackTooLarge
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, int64(s.maxCallbackQuerySize))
+	}
+	return nil
+}
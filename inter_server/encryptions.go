@@ -6,33 +6,41 @@ import (
 	"crypto/rand"
 )
 
-func encryptAESCTR(key []byte, plaintext []byte) ([]byte, error) {
+func encryptAESGCM(key []byte, plaintext []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[:aes.BlockSize]
-	rand.Read(iv)
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
 
-	stream := cipher.NewCTR(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], plaintext)
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
 
-	return ciphertext, nil
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
 }
 
-func decryptAESCTR(key []byte, ciphertext []byte) ([]byte, error) {
+func decryptAESGCM(key []byte, ciphertext []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
-	iv := ciphertext[:aes.BlockSize]
-	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
 
-	stream := cipher.NewCTR(block, iv)
-	stream.XORKeyStream(plaintext, ciphertext[aes.BlockSize:])
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrorInvalidServerToken
+	}
 
-	return plaintext, nil
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], nil)
 }
@@ -0,0 +1,69 @@
+package inter_server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+// ServerTokenScheme is the authorization scheme of server tokens, i.e. "Authorization: ServerToken <token>".
+const ServerTokenScheme = "ServerToken"
+
+func getServerToken(r *http.Request) (string, bool) {
+	authorizationData := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(authorizationData) != 2 || !strings.EqualFold(authorizationData[0], ServerTokenScheme) {
+		return "", false
+	}
+	return authorizationData[1], true
+}
+
+// Secured is a http middleware accepting server tokens as an alternative to bearer tokens.
+// sourcePermissions maps the allowed source client IDs to the permissions granted to them, server tokens of
+// other sources are rejected, so all server tokens are rejected if it's empty.
+// Requests without server token are passed to fallback (e.g. OAuthSession.SecuredH(true)),
+// or rejected if fallback is nil.
+// The session data of server token has the source client ID as both user ID and client ID, like service accounts.
+func (is *InterServer) Secured(fallback func(http.Handler) http.Handler, sourcePermissions map[string][]string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		var fallbackHandler http.Handler
+		if fallback != nil {
+			fallbackHandler = fallback(h)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, ok := getServerToken(r)
+			if !ok {
+				if fallbackHandler == nil {
+					http.Error(w, ErrorInvalidServerToken.Error(), http.StatusUnauthorized)
+					return
+				}
+				fallbackHandler.ServeHTTP(w, r)
+				return
+			}
+
+			token, err := is.VerifyServerToken(tokenString)
+			if err != nil {
+				http.Error(w, ErrorInvalidServerToken.Error(), http.StatusUnauthorized)
+				return
+			}
+			permissions, allowed := sourcePermissions[token.Source]
+			if !allowed {
+				http.Error(w, ErrorInvalidServerToken.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			expiresAt := time.Unix(token.ExpiryTime, 0)
+			sessionData := osecure.NewAuthSessionData(
+				token.Source,
+				token.Source,
+				&oauth2.Token{AccessToken: tokenString, TokenType: ServerTokenScheme, Expiry: expiresAt},
+				permissions,
+				expiresAt,
+			)
+			h.ServeHTTP(w, osecure.AttachRequestWithSessionData(r, sessionData))
+		})
+	}
+}
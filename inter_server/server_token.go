@@ -1,13 +1,17 @@
 package inter_server
 
 import (
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -31,6 +35,7 @@ type InterServer struct {
 type ServerTokenRequest struct {
 	TargetClientID string `json:"target_client_id"`
 	Timestamp      int64  `json:"timestamp"`
+	Nonce          string `json:"nonce"` // random, so the issuer rejects replayed requests
 }
 
 type ServerTokenReply struct {
@@ -41,14 +46,43 @@ type ServerTokenReply struct {
 
 type ServerToken struct {
 	Source     string `json:"source"`
+	Target     string `json:"target,omitempty"`
 	Timestamp  int64  `json:"timestamp"`
 	ExpiryTime int64  `json:"expiry_time"`
 }
 
+// Validate checks the client ID, server token URL, and the encryption key which is hex encoded AES key.
+// ServerTokenURL is required for servers which call other servers by GetServerToken,
+// and must be https except for loopback hosts.
+func (conf *InterServerConfig) Validate() error {
+	if conf.InterServerClientID == "" {
+		return errors.New("invalid config inter_server_client_id: required")
+	}
+
+	u, err := url.Parse(conf.ServerTokenURL)
+	if err != nil || !u.IsAbs() || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("invalid config server_token_url: not an absolute http(s) URL: %q", conf.ServerTokenURL)
+	}
+	if u.Scheme == "http" && !isLoopbackHost(u.Hostname()) {
+		return fmt.Errorf("invalid config server_token_url: https is required for non-loopback hosts: %q", conf.ServerTokenURL)
+	}
+
+	key, err := hex.DecodeString(conf.ServerTokenEncryptionKey)
+	if err != nil {
+		return fmt.Errorf("invalid config server_token_encryption_key: not hex: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("invalid config server_token_encryption_key: key length is %d bytes, expected [16 24 32]", len(key))
+	}
+	return nil
+}
+
 func NewInterServer(interServerConf *InterServerConfig) *InterServer {
 	serverTokenEncryptionKey, err := hex.DecodeString(interServerConf.ServerTokenEncryptionKey)
 	if err != nil {
-		panic(err)
+		panic(fmt.Errorf("invalid config server_token_encryption_key: not hex: %w", err))
 	}
 
 	return &InterServer{
@@ -88,12 +122,23 @@ func (is *InterServer) GetServerToken(targetClientID string) (*ServerTokenReply,
 }
 
 func (is *InterServer) DecryptServerToken(tokenString string, sourceClientID string) (*ServerToken, error) {
+	return is.VerifyServerToken(tokenString, sourceClientID)
+}
+
+// VerifyServerToken decrypts the server token issued to this server, and checks its expiry and target,
+// which must be this server.
+// If sourceClientIDs is not empty, the source of token must be one of them.
+func (is *InterServer) VerifyServerToken(tokenString string, sourceClientIDs ...string) (*ServerToken, error) {
 	token, err := is.readServerToken(tokenString)
 	if err != nil {
 		return nil, err
 	}
 
-	if token.Source != sourceClientID {
+	if len(sourceClientIDs) > 0 && !containString(sourceClientIDs, token.Source) {
+		return token, ErrorInvalidServerToken
+	}
+
+	if token.Target == "" || token.Target != is.interServerClientID {
 		return token, ErrorInvalidServerToken
 	}
 
@@ -104,10 +149,35 @@ func (is *InterServer) DecryptServerToken(tokenString string, sourceClientID str
 	return token, nil
 }
 
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func containString(a []string, x string) bool {
+	for _, y := range a {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
+
+// generateServerTokenRequest encrypts the request by AES-GCM, so the issuer authenticates this server by the key.
 func (is *InterServer) generateServerTokenRequest(targetClientID string) (string, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
 	serverTokenRequest := &ServerTokenRequest{
 		TargetClientID: targetClientID,
 		Timestamp:      time.Now().Unix(),
+		Nonce:          hex.EncodeToString(nonce),
 	}
 
 	jsonServerTokenRequest, err := json.Marshal(serverTokenRequest)
@@ -115,7 +185,7 @@ func (is *InterServer) generateServerTokenRequest(targetClientID string) (string
 		return "", err
 	}
 
-	encryptedServerTokenRequest, err := encryptAESCTR(is.serverTokenEncryptionKey, jsonServerTokenRequest)
+	encryptedServerTokenRequest, err := encryptAESGCM(is.serverTokenEncryptionKey, jsonServerTokenRequest)
 	if err != nil {
 		return "", err
 	}
@@ -125,36 +195,51 @@ func (is *InterServer) generateServerTokenRequest(targetClientID string) (string
 }
 
 func (is *InterServer) readServerTokenReply(secret string) (*ServerTokenReply, error) {
-	print(secret)
 	decodedSecret, err := base64.StdEncoding.DecodeString(secret)
 	if err != nil {
 		return nil, err
 	}
 
-	plaintext, err := decryptAESCTR(is.serverTokenEncryptionKey, decodedSecret)
+	plaintext, err := decryptAESGCM(is.serverTokenEncryptionKey, decodedSecret)
 	if err != nil {
-		return nil, err
+		return nil, ErrorInvalidServerToken
 	}
 
 	reply := &ServerTokenReply{}
-	json.Unmarshal(plaintext, reply)
+	err = json.Unmarshal(plaintext, reply)
+	if err != nil {
+		return nil, ErrorInvalidServerToken
+	}
 
 	return reply, nil
 }
 
 func (is *InterServer) readServerToken(secret string) (*ServerToken, error) {
-	decodedSecret, err := base64.StdEncoding.DecodeString(secret)
+	return readServerToken(is.serverTokenEncryptionKey, secret)
+}
+
+// readServerToken reads AES-GCM server tokens. Legacy AES-CTR server tokens without the prefix are rejected,
+// since they are not authenticated and their fields can be altered without the key.
+func readServerToken(key []byte, secret string) (*ServerToken, error) {
+	if !strings.HasPrefix(secret, serverTokenGCMPrefix) {
+		return nil, ErrorInvalidServerToken
+	}
+
+	decodedSecret, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(secret, serverTokenGCMPrefix))
 	if err != nil {
-		return nil, err
+		return nil, ErrorInvalidServerToken
 	}
 
-	plaintext, err := decryptAESCTR(is.serverTokenEncryptionKey, decodedSecret)
+	plaintext, err := decryptAESGCM(key, decodedSecret)
 	if err != nil {
-		return nil, err
+		return nil, ErrorInvalidServerToken
 	}
 
 	token := &ServerToken{}
-	json.Unmarshal(plaintext, token)
+	err = json.Unmarshal(plaintext, token)
+	if err != nil {
+		return nil, ErrorInvalidServerToken
+	}
 
 	return token, nil
 }
@@ -0,0 +1,198 @@
+package inter_server
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+)
+
+const (
+	// serverTokenGCMPrefix marks server tokens encrypted by AES-GCM, legacy AES-CTR tokens without it are rejected.
+	serverTokenGCMPrefix = "v2."
+
+	DefaultServerTokenTTL    = 10 * time.Minute
+	DefaultServerTokenMaxAge = time.Minute
+)
+
+var (
+	ErrorUnknownClient   = errors.New("unknown client")
+	ErrorExpiredRequest  = errors.New("expired server token request")
+	ErrorReplayedRequest = errors.New("replayed server token request")
+)
+
+// ServerTokenIssuer mints server tokens, it serves the ServerTokenURL of InterServer.
+// A server token for target is encrypted by the key of target, so only target can read it.
+type ServerTokenIssuer struct {
+	// LookupKey gets the server token encryption key of the client.
+	LookupKey func(clientID string) ([]byte, error)
+	// IsAllowed decides if source can get server tokens for target, all are allowed if nil.
+	IsAllowed func(sourceClientID string, targetClientID string) bool
+	// TTL of server tokens, DefaultServerTokenTTL if zero.
+	TTL time.Duration
+	// MaxRequestAge rejects old requests, DefaultServerTokenMaxAge if zero.
+	MaxRequestAge time.Duration
+	// ReplayCache rejects requests used before by their nonces, osecure.MemoryReplayCache if nil.
+	ReplayCache osecure.ReplayCache
+
+	replayCacheOnce sync.Once
+}
+
+// NewServerTokenIssuer creates issuer with hex encoded keys of clients.
+func NewServerTokenIssuer(hexKeys map[string]string) (*ServerTokenIssuer, error) {
+	keys := make(map[string][]byte)
+	for clientID, hexKey := range hexKeys {
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, err
+		}
+		keys[clientID] = key
+	}
+
+	return &ServerTokenIssuer{
+		LookupKey: func(clientID string) ([]byte, error) {
+			key, found := keys[clientID]
+			if !found {
+				return nil, ErrorUnknownClient
+			}
+			return key, nil
+		},
+	}, nil
+}
+
+func (iss *ServerTokenIssuer) ttl() time.Duration {
+	if iss.TTL > 0 {
+		return iss.TTL
+	}
+	return DefaultServerTokenTTL
+}
+
+func (iss *ServerTokenIssuer) maxRequestAge() time.Duration {
+	if iss.MaxRequestAge > 0 {
+		return iss.MaxRequestAge
+	}
+	return DefaultServerTokenMaxAge
+}
+
+func (iss *ServerTokenIssuer) replayCache() osecure.ReplayCache {
+	iss.replayCacheOnce.Do(func() {
+		if iss.ReplayCache == nil {
+			iss.ReplayCache = osecure.NewMemoryReplayCache()
+		}
+	})
+	return iss.ReplayCache
+}
+
+// MintServerToken mints a server token of source for target, encrypted by AES-GCM with the key of target.
+func (iss *ServerTokenIssuer) MintServerToken(sourceClientID string, targetClientID string) (*ServerTokenReply, error) {
+	if iss.IsAllowed != nil && !iss.IsAllowed(sourceClientID, targetClientID) {
+		return nil, ErrorPermissionDenied
+	}
+
+	targetKey, err := iss.LookupKey(targetClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token := &ServerToken{
+		Source:     sourceClientID,
+		Target:     targetClientID,
+		Timestamp:  now.Unix(),
+		ExpiryTime: now.Add(iss.ttl()).Unix(),
+	}
+
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := encryptAESGCM(targetKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServerTokenReply{
+		ServerToken: serverTokenGCMPrefix + base64.RawURLEncoding.EncodeToString(ciphertext),
+		Timestamp:   token.Timestamp,
+		ExpiryTime:  token.ExpiryTime,
+	}, nil
+}
+
+// ServeHTTP handles requests from InterServer.GetServerToken.
+// The request and the reply are encrypted by AES-GCM with the key of source client, which authenticates the source,
+// and each request is accepted once within MaxRequestAge.
+func (iss *ServerTokenIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	sourceClientID := r.PostFormValue("id")
+	sourceKey, err := iss.LookupKey(sourceClientID)
+	if err != nil {
+		http.Error(w, ErrorPermissionDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	decodedSecret, err := base64.StdEncoding.DecodeString(r.PostFormValue("secret"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// a request which can't be decrypted is encrypted with a wrong key, or altered
+	plaintext, err := decryptAESGCM(sourceKey, decodedSecret)
+	if err != nil {
+		http.Error(w, ErrorPermissionDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	var request ServerTokenRequest
+	err = json.Unmarshal(plaintext, &request)
+	if err != nil || request.Nonce == "" {
+		http.Error(w, ErrorPermissionDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	requestAge := time.Since(time.Unix(request.Timestamp, 0))
+	if requestAge > iss.maxRequestAge() || requestAge < -iss.maxRequestAge() {
+		http.Error(w, ErrorExpiredRequest.Error(), http.StatusForbidden)
+		return
+	}
+
+	expiresAt := time.Unix(request.Timestamp, 0).Add(iss.maxRequestAge())
+	isFirstUse, err := iss.replayCache().Use(r.Context(), "server_token_request:"+sourceClientID+":"+request.Nonce, expiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !isFirstUse {
+		http.Error(w, ErrorReplayedRequest.Error(), http.StatusForbidden)
+		return
+	}
+
+	reply, err := iss.MintServerToken(sourceClientID, request.TargetClientID)
+	if err != nil {
+		http.Error(w, ErrorPermissionDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	replyBytes, err := json.Marshal(reply)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encryptedReply, err := encryptAESGCM(sourceKey, replyBytes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(base64.StdEncoding.EncodeToString(encryptedReply)))
+}
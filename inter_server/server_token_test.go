@@ -0,0 +1,189 @@
+package inter_server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rayark/osecure/v6"
+)
+
+const (
+	testSourceKey = "000102030405060708090a0b0c0d0e0f"
+	testTargetKey = "101112131415161718191a1b1c1d1e1f"
+	testOtherKey  = "202122232425262728292a2b2c2d2e2f"
+)
+
+func newTestIssuer(t *testing.T) *httptest.Server {
+	t.Helper()
+	issuer, err := NewServerTokenIssuer(map[string]string{"source": testSourceKey, "target": testTargetKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(issuer)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestInterServer(clientID string, serverTokenURL string, key string) *InterServer {
+	return NewInterServer(&InterServerConfig{
+		InterServerClientID:      clientID,
+		ServerTokenURL:           serverTokenURL,
+		ServerTokenEncryptionKey: key,
+	})
+}
+
+func postServerTokenRequest(t *testing.T, serverTokenURL string, clientID string, secret string) int {
+	t.Helper()
+	resp, err := http.PostForm(serverTokenURL, url.Values{"id": {clientID}, "secret": {secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServerToken(t *testing.T) {
+	server := newTestIssuer(t)
+	source := newTestInterServer("source", server.URL, testSourceKey)
+	target := newTestInterServer("target", server.URL, testTargetKey)
+
+	reply, err := source.GetServerToken("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := target.VerifyServerToken(reply.ServerToken, "source")
+	if err != nil || token.Source != "source" || token.Target != "target" {
+		t.Fatalf("VerifyServerToken() = %+v, %v", token, err)
+	}
+
+	// the token is for target only
+	_, err = source.VerifyServerToken(reply.ServerToken)
+	if err != ErrorInvalidServerToken {
+		t.Errorf("token of target accepted by source: %v", err)
+	}
+	_, err = target.VerifyServerToken(reply.ServerToken, "other")
+	if err != ErrorInvalidServerToken {
+		t.Errorf("token of unlisted source accepted: %v", err)
+	}
+
+	tampered := []byte(reply.ServerToken)
+	tampered[len(tampered)/2] ^= 'A' ^ 'B'
+	_, err = target.VerifyServerToken(string(tampered))
+	if err != ErrorInvalidServerToken {
+		t.Errorf("tampered token accepted: %v", err)
+	}
+}
+
+func TestServerTokenRequestWrongKey(t *testing.T) {
+	server := newTestIssuer(t)
+	impostor := newTestInterServer("source", server.URL, testOtherKey)
+
+	_, err := impostor.GetServerToken("target")
+	if err != ErrorPermissionDenied {
+		t.Fatalf("request with a wrong key: got %v, want %v", err, ErrorPermissionDenied)
+	}
+}
+
+func TestServerTokenRequestTampered(t *testing.T) {
+	server := newTestIssuer(t)
+	source := newTestInterServer("source", server.URL, testSourceKey)
+
+	secret, err := source.generateServerTokenRequest("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the plaintext is predictable, so flipping bits of the ciphertext would change the target without integrity checks
+	for _, i := range []int{12, len(encrypted) / 2, len(encrypted) - 1} {
+		altered := append([]byte(nil), encrypted...)
+		altered[i] ^= 0x01
+		status := postServerTokenRequest(t, server.URL, "source", base64.StdEncoding.EncodeToString(altered))
+		if status != http.StatusForbidden {
+			t.Errorf("request altered at byte %d: got %d, want %d", i, status, http.StatusForbidden)
+		}
+	}
+}
+
+func TestServerTokenRequestReplayed(t *testing.T) {
+	server := newTestIssuer(t)
+	source := newTestInterServer("source", server.URL, testSourceKey)
+
+	secret, err := source.generateServerTokenRequest("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := postServerTokenRequest(t, server.URL, "source", secret); status != http.StatusOK {
+		t.Fatalf("first request: got %d", status)
+	}
+	if status := postServerTokenRequest(t, server.URL, "source", secret); status != http.StatusForbidden {
+		t.Fatalf("replayed request: got %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestInterServerConfigValidate(t *testing.T) {
+	for serverTokenURL, valid := range map[string]bool{
+		"https://auth.example.com/get_server_token": true,
+		"http://localhost:8000/get_server_token":    true,
+		"http://127.0.0.1:8000/get_server_token":    true,
+		"http://auth.example.com/get_server_token":  false,
+		"ftp://auth.example.com/get_server_token":   false,
+		"/get_server_token":                         false,
+	} {
+		conf := &InterServerConfig{
+			InterServerClientID:      "source",
+			ServerTokenURL:           serverTokenURL,
+			ServerTokenEncryptionKey: testSourceKey,
+		}
+		err := conf.Validate()
+		if (err == nil) != valid {
+			t.Errorf("Validate() of %q = %v", serverTokenURL, err)
+		}
+	}
+}
+
+func TestSecured(t *testing.T) {
+	server := newTestIssuer(t)
+	source := newTestInterServer("source", server.URL, testSourceKey)
+	target := newTestInterServer("target", server.URL, testTargetKey)
+
+	reply, err := source.GetServerToken("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		data, ok := osecure.GetRequestSessionData(r)
+		if !ok || !data.HasPermission("read") {
+			http.Error(w, "no permission", http.StatusForbidden)
+		}
+	}
+	for name, test := range map[string]struct {
+		sourcePermissions map[string][]string
+		authorization     string
+		want              int
+	}{
+		"allowed source":       {map[string][]string{"source": {"read"}}, "ServerToken " + reply.ServerToken, http.StatusOK},
+		"unlisted source":      {map[string][]string{"other": {"read"}}, "ServerToken " + reply.ServerToken, http.StatusUnauthorized},
+		"no sources":           {nil, "ServerToken " + reply.ServerToken, http.StatusUnauthorized},
+		"legacy token":         {map[string][]string{"source": {"read"}}, "ServerToken " + strings.TrimPrefix(reply.ServerToken, serverTokenGCMPrefix), http.StatusUnauthorized},
+		"without server token": {map[string][]string{"source": {"read"}}, "", http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		target.Secured(nil, test.sourcePermissions)(http.HandlerFunc(handler)).ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: got %d, want %d", name, w.Code, test.want)
+		}
+	}
+}
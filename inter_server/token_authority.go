@@ -0,0 +1,253 @@
+package inter_server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"github.com/rayark/osecure/v6/jwt"
+	"golang.org/x/oauth2"
+)
+
+// DefaultServiceTokenTTL is the lifetime of service tokens if TokenAuthority.TTL is zero.
+const DefaultServiceTokenTTL = time.Hour
+
+var (
+	ErrorUnknownSigningKey    = errors.New("unknown signing key")
+	ErrorSymmetricSigningKey  = errors.New("service tokens must be signed by asymmetric keys")
+	ErrorInvalidServiceSource = errors.New("source of service token is not allowed")
+)
+
+// SigningKey is a private key of TokenAuthority, whose public key verifies service tokens.
+type SigningKey struct {
+	KeyID     string
+	Algorithm string // e.g. "ES256", "PS256" or "EdDSA"
+	Key       crypto.Signer
+}
+
+// TokenAuthority mints and verifies service tokens of robots locally, which are JWTs signed by asymmetric keys,
+// as the alternative of server tokens from the ServerTokenURL. The claims are the fields of ServerToken:
+// "sub" is the source, "aud" is the target, "iat" is the timestamp and "exp" is the expiry time.
+// Keys are rotated by Rotate, tokens of previous keys are verified until the keys are retired.
+type TokenAuthority struct {
+	Issuer string
+	// TTL of service tokens, DefaultServiceTokenTTL if zero.
+	TTL time.Duration
+
+	mu      sync.RWMutex
+	current SigningKey
+	keys    map[string]SigningKey // of current and previous keys
+}
+
+// NewTokenAuthority creates TokenAuthority signing by the current key and verifying by it and the previous keys.
+func NewTokenAuthority(issuer string, current SigningKey, previous ...SigningKey) (*TokenAuthority, error) {
+	ta := &TokenAuthority{
+		Issuer: issuer,
+		keys:   make(map[string]SigningKey),
+	}
+	for _, key := range previous {
+		err := ta.addKey(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := ta.Rotate(current)
+	if err != nil {
+		return nil, err
+	}
+	return ta, nil
+}
+
+func (ta *TokenAuthority) addKey(key SigningKey) error {
+	if key.KeyID == "" || key.Key == nil {
+		return ErrorUnknownSigningKey
+	}
+	if key.Algorithm == "" || key.Algorithm[0] == 'H' {
+		return ErrorSymmetricSigningKey
+	}
+	ta.keys[key.KeyID] = key
+	return nil
+}
+
+// Rotate signs new tokens by the key, the previous current key still verifies tokens until retired.
+func (ta *TokenAuthority) Rotate(key SigningKey) error {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+
+	err := ta.addKey(key)
+	if err != nil {
+		return err
+	}
+	ta.current = key
+	return nil
+}
+
+// Retire stops verifying tokens of the previous key, which should be after the TTL since it's rotated out.
+// The current key can't be retired.
+func (ta *TokenAuthority) Retire(keyID string) error {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+
+	if _, found := ta.keys[keyID]; !found || keyID == ta.current.KeyID {
+		return ErrorUnknownSigningKey
+	}
+	delete(ta.keys, keyID)
+	return nil
+}
+
+func (ta *TokenAuthority) ttl() time.Duration {
+	if ta.TTL > 0 {
+		return ta.TTL
+	}
+	return DefaultServiceTokenTTL
+}
+
+// MintServiceToken mints a service token of source for target, which is the only audience of the token.
+func (ta *TokenAuthority) MintServiceToken(sourceClientID string, targetClientID string) (string, *ServerToken, error) {
+	ta.mu.RLock()
+	key := ta.current
+	ta.mu.RUnlock()
+
+	jti := make([]byte, 16)
+	_, err := rand.Read(jti)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	token := &ServerToken{
+		Source:     sourceClientID,
+		Target:     targetClientID,
+		Timestamp:  now.Unix(),
+		ExpiryTime: now.Add(ta.ttl()).Unix(),
+	}
+	claims := jwt.Claims{
+		"iss":       ta.Issuer,
+		"sub":       token.Source,
+		"aud":       token.Target,
+		"client_id": token.Source,
+		"iat":       token.Timestamp,
+		"exp":       token.ExpiryTime,
+		"jti":       hex.EncodeToString(jti),
+	}
+	tokenString, err := jwt.Sign(jwt.Header{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.KeyID}, claims, key.Key)
+	if err != nil {
+		return "", nil, err
+	}
+	return tokenString, token, nil
+}
+
+// KeySet is the key set of public keys of the current and previous keys.
+func (ta *TokenAuthority) KeySet() jwt.KeySet {
+	ta.mu.RLock()
+	defer ta.mu.RUnlock()
+
+	keySet := make(jwt.StaticKeySet, len(ta.keys))
+	for keyID, key := range ta.keys {
+		keySet[keyID] = key.Key.Public()
+	}
+	return keySet
+}
+
+// ServeHTTP serves the JWK set of public keys, so other servers verify service tokens by jwt.NewRemoteKeySet.
+func (ta *TokenAuthority) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ta.mu.RLock()
+	jwks := &jwt.JSONWebKeySet{Keys: make([]jwt.JSONWebKey, 0, len(ta.keys))}
+	for keyID, key := range ta.keys {
+		jwk, err := jwt.NewJSONWebKey(key.Key.Public(), keyID)
+		if err != nil {
+			continue
+		}
+		jwk.Use = "sig"
+		jwk.Algorithm = key.Algorithm
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+	ta.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	json.NewEncoder(w).Encode(jwks)
+}
+
+// Verifier is the ServiceTokenVerifier of the target with the keys of the authority.
+func (ta *TokenAuthority) Verifier(targetClientID string) *ServiceTokenVerifier {
+	return &ServiceTokenVerifier{Issuer: ta.Issuer, Audience: targetClientID, KeySet: ta}
+}
+
+// Keys implements jwt.KeySet with the current keys, so rotation takes effect on verifiers immediately.
+func (ta *TokenAuthority) Keys(ctx context.Context, keyID string) ([]crypto.PublicKey, error) {
+	return ta.KeySet().Keys(ctx, keyID)
+}
+
+// ServiceTokenVerifier verifies service tokens of TokenAuthority for the target server.
+type ServiceTokenVerifier struct {
+	Issuer   string
+	Audience string     // client ID of the target server
+	KeySet   jwt.KeySet // e.g. TokenAuthority or jwt.NewRemoteKeySet of its JWK set
+	Leeway   time.Duration
+}
+
+// Verify verifies the signature, issuer, audience and expiry of the service token.
+// If sourceClientIDs is not empty, the source of token must be one of them.
+func (v *ServiceTokenVerifier) Verify(ctx context.Context, tokenString string, sourceClientIDs ...string) (*ServerToken, error) {
+	token, err := jwt.Parse(tokenString)
+	if err != nil {
+		return nil, ErrorInvalidServerToken
+	}
+	if token.Header.Algorithm == "" || token.Header.Algorithm[0] == 'H' {
+		return nil, ErrorSymmetricSigningKey
+	}
+	err = token.VerifyWithKeySet(ctx, v.KeySet)
+	if err != nil {
+		return nil, err
+	}
+	err = token.Claims.Validate(jwt.Expected{Issuer: v.Issuer, Audiences: []string{v.Audience}, Leeway: v.Leeway})
+	if err != nil {
+		return nil, err
+	}
+
+	serverToken := &ServerToken{
+		Source: token.Claims.String("sub"),
+		Target: v.Audience,
+	}
+	serverToken.Timestamp, _ = token.Claims.Int64("iat")
+	expiryTime, ok := token.Claims.Int64("exp")
+	if !ok || serverToken.Source == "" {
+		return nil, jwt.ErrorMissingClaim
+	}
+	serverToken.ExpiryTime = expiryTime
+
+	if len(sourceClientIDs) > 0 && !containString(sourceClientIDs, serverToken.Source) {
+		return serverToken, ErrorInvalidServiceSource
+	}
+	return serverToken, nil
+}
+
+// TokenVerifier is the token verifier of service tokens for OAuthSession, whose session data has the source
+// as both user ID and client ID like service accounts. sourcePermissions maps the allowed sources to their permissions,
+// service tokens of other sources are rejected, so all service tokens are rejected if it's empty.
+func (v *ServiceTokenVerifier) TokenVerifier(sourcePermissions map[string][]string) *osecure.TokenVerifier {
+	return &osecure.TokenVerifier{
+		IntrospectTokenFunc: func(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+			token, err := v.Verify(ctx, accessToken)
+			if err != nil {
+				return "", "", 0, nil, err
+			}
+			if _, allowed := sourcePermissions[token.Source]; !allowed {
+				return "", "", 0, nil, ErrorInvalidServiceSource
+			}
+			extra = map[string]interface{}{osecure.ExtraKeyIssuer: v.Issuer}
+			return token.Source, token.Source, token.ExpiryTime, extra, nil
+		},
+		GetPermissionsFunc: func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+			return sourcePermissions[userID], nil
+		},
+	}
+}
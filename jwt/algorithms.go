@@ -0,0 +1,171 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"math/big"
+
+	_ "crypto/sha256" // register hash functions
+	_ "crypto/sha512"
+)
+
+func hashOf(alg string) (crypto.Hash, bool) {
+	switch alg {
+	case "RS256", "PS256", "ES256", "HS256":
+		return crypto.SHA256, true
+	case "RS384", "PS384", "ES384", "HS384":
+		return crypto.SHA384, true
+	case "RS512", "PS512", "ES512", "HS512":
+		return crypto.SHA512, true
+	default:
+		return 0, false
+	}
+}
+
+func digest(hash crypto.Hash, message []byte) []byte {
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+func verifySignature(alg string, key interface{}, message []byte, signature []byte) error {
+	if alg == "EdDSA" {
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrorInvalidKey
+		}
+		if !ed25519.Verify(publicKey, message, signature) {
+			return ErrorInvalidSignature
+		}
+		return nil
+	}
+
+	hash, ok := hashOf(alg)
+	if !ok {
+		return ErrorUnsupportedAlgorithm
+	}
+
+	switch alg[0] {
+	case 'H':
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return ErrorInvalidKey
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(message)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrorInvalidSignature
+		}
+		return nil
+
+	case 'R', 'P':
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrorInvalidKey
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(publicKey, hash, digest(hash, message), signature)
+		} else {
+			err = rsa.VerifyPSS(publicKey, hash, digest(hash, message), signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return ErrorInvalidSignature
+		}
+		return nil
+
+	case 'E':
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrorInvalidKey
+		}
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrorInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(publicKey, digest(hash, message), r, s) {
+			return ErrorInvalidSignature
+		}
+		return nil
+	}
+
+	return ErrorUnsupportedAlgorithm
+}
+
+func sign(alg string, key interface{}, message []byte) ([]byte, error) {
+	if alg == "EdDSA" {
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, ErrorInvalidKey
+		}
+		return ed25519.Sign(privateKey, message), nil
+	}
+
+	hash, ok := hashOf(alg)
+	if !ok {
+		return nil, ErrorUnsupportedAlgorithm
+	}
+
+	switch alg[0] {
+	case 'H':
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return nil, ErrorInvalidKey
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(message)
+		return mac.Sum(nil), nil
+
+	case 'R', 'P':
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, ErrorInvalidKey
+		}
+		if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+			return nil, ErrorInvalidKey
+		}
+		var opts crypto.SignerOpts = hash
+		if alg[0] == 'P' {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+		}
+		return signer.Sign(rand.Reader, digest(hash, message), opts)
+
+	case 'E':
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, ErrorInvalidKey
+		}
+		publicKey, ok := signer.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return nil, ErrorInvalidKey
+		}
+		der, err := signer.Sign(rand.Reader, digest(hash, message), hash)
+		if err != nil {
+			return nil, err
+		}
+
+		// convert ASN.1 signature to fixed size r || s
+		var sig struct {
+			R, S *big.Int
+		}
+		_, err = asn1.Unmarshal(der, &sig)
+		if err != nil {
+			return nil, err
+		}
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r, s := sig.R.Bytes(), sig.S.Bytes()
+		copy(signature[size-len(r):size], r)
+		copy(signature[2*size-len(s):], s)
+		return signature, nil
+	}
+
+	return nil, ErrorUnsupportedAlgorithm
+}
@@ -0,0 +1,99 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Claims is the claims set of token. Numbers are decoded as json.Number.
+type Claims map[string]interface{}
+
+func unmarshalClaims(b []byte, claims *Claims) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(claims)
+}
+
+// String gets a string claim, empty if it's absent or not a string.
+func (c Claims) String(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+
+// Strings gets a claim which is a string or an array of strings.
+func (c Claims) Strings(key string) []string {
+	switch v := c[key].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		a := make([]string, 0, len(v))
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				a = append(a, s)
+			}
+		}
+		return a
+	default:
+		return nil
+	}
+}
+
+// Int64 gets a numeric claim.
+func (c Claims) Int64(key string) (int64, bool) {
+	switch v := c[key].(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			f, err := v.Float64()
+			if err != nil {
+				return 0, false
+			}
+			return int64(f), true
+		}
+		return n, true
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// Time gets a NumericDate claim.
+func (c Claims) Time(key string) (time.Time, bool) {
+	n, ok := c.Int64(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(n, 0), true
+}
+
+// Map gets a JSON object claim.
+func (c Claims) Map(key string) map[string]interface{} {
+	m, _ := c[key].(map[string]interface{})
+	return m
+}
+
+// Has checks if the claim presents.
+func (c Claims) Has(key string) bool {
+	_, ok := c[key]
+	return ok
+}
+
+// HasAudience checks if "aud" contains any of the audiences.
+func (c Claims) HasAudience(audiences ...string) bool {
+	for _, aud := range c.Strings("aud") {
+		for _, audience := range audiences {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// JSONWebKey is a public key in JWK format (RFC 7517).
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// NewJSONWebKey converts a RSA, ECDSA or Ed25519 public key to JWK.
+func NewJSONWebKey(publicKey crypto.PublicKey, keyID string) (*JSONWebKey, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &JSONWebKey{
+			KeyType: "RSA",
+			KeyID:   keyID,
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		x, y := make([]byte, size), make([]byte, size)
+		xb, yb := key.X.Bytes(), key.Y.Bytes()
+		copy(x[size-len(xb):], xb)
+		copy(y[size-len(yb):], yb)
+		return &JSONWebKey{
+			KeyType: "EC",
+			KeyID:   keyID,
+			Curve:   key.Curve.Params().Name,
+			X:       base64.RawURLEncoding.EncodeToString(x),
+			Y:       base64.RawURLEncoding.EncodeToString(y),
+		}, nil
+	case ed25519.PublicKey:
+		return &JSONWebKey{
+			KeyType: "OKP",
+			KeyID:   keyID,
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	default:
+		return nil, ErrorInvalidKey
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, ErrorInvalidKey
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// PublicKey converts JWK to *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+func (k *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, ErrorInvalidKey
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrorInvalidKey
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, ErrorInvalidKey
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, ErrorInvalidKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrorInvalidKey
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, ErrorInvalidKey
+}
+
+// Thumbprint computes the base64url encoded SHA-256 JWK thumbprint (RFC 7638).
+func (k *JSONWebKey) Thumbprint() (string, error) {
+	var members string
+	switch k.KeyType {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Curve, k.X, k.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Curve, k.X)
+	default:
+		return "", ErrorInvalidKey
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// JSONWebKeySet is a JWK set document.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// KeySet provides the verification keys of tokens.
+type KeySet interface {
+	// Keys returns the candidate keys of keyID, or all keys if keyID is empty.
+	Keys(ctx context.Context, keyID string) ([]crypto.PublicKey, error)
+}
+
+// VerifyWithKeySet verifies the signature with any candidate key in the key set.
+func (t *Token) VerifyWithKeySet(ctx context.Context, keySet KeySet) error {
+	keys, err := keySet.Keys(ctx, t.Header.KeyID)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrorKeyNotFound
+	}
+
+	err = ErrorInvalidSignature
+	for _, key := range keys {
+		err = t.Verify(key)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// StaticKeySet is a fixed key set, mapping key IDs to public keys (or []byte for HMAC).
+type StaticKeySet map[string]crypto.PublicKey
+
+// NewStaticKeySet converts JWK set to StaticKeySet, keys of unsupported types are skipped.
+func NewStaticKeySet(jwks *JSONWebKeySet) StaticKeySet {
+	keySet := make(StaticKeySet)
+	for i := range jwks.Keys {
+		key, err := jwks.Keys[i].PublicKey()
+		if err != nil {
+			continue
+		}
+		keyID := jwks.Keys[i].KeyID
+		if keyID == "" {
+			keyID = fmt.Sprintf("#%d", i)
+		}
+		keySet[keyID] = key
+	}
+	return keySet
+}
+
+// Keys implements KeySet.
+func (ks StaticKeySet) Keys(ctx context.Context, keyID string) ([]crypto.PublicKey, error) {
+	if keyID != "" {
+		if key, found := ks[keyID]; found {
+			return []crypto.PublicKey{key}, nil
+		}
+		return nil, ErrorKeyNotFound
+	}
+
+	keys := make([]crypto.PublicKey, 0, len(ks))
+	for _, key := range ks {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// RemoteKeySet fetches and caches JWK set from a URL.
+// Unknown key IDs trigger a refetch, at most once per MinRefreshInterval.
+// Concurrent fetches are merged into one, and cached keys are served while fetching.
+type RemoteKeySet struct {
+	URL                string
+	HTTPClient         *http.Client
+	CacheTTL           time.Duration
+	MinRefreshInterval time.Duration
+
+	mu        sync.Mutex
+	keys      StaticKeySet
+	fetchedAt time.Time
+	group     singleflight.Group
+}
+
+// NewRemoteKeySet creates key set fetched from the JWKS URL, cached for an hour.
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		URL:                url,
+		CacheTTL:           time.Hour,
+		MinRefreshInterval: 10 * time.Second,
+	}
+}
+
+func (ks *RemoteKeySet) cached() (StaticKeySet, time.Time) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.keys, ks.fetchedAt
+}
+
+// Keys implements KeySet.
+func (ks *RemoteKeySet) Keys(ctx context.Context, keyID string) ([]crypto.PublicKey, error) {
+	keySet, fetchedAt := ks.cached()
+	if keySet == nil || time.Since(fetchedAt) > ks.CacheTTL {
+		fetched, err := ks.fetch(ctx)
+		if err != nil && keySet == nil {
+			return nil, err
+		}
+		if err == nil {
+			keySet = fetched
+		}
+	}
+
+	keys, err := keySet.Keys(ctx, keyID)
+	if err == ErrorKeyNotFound {
+		if _, fetchedAt := ks.cached(); time.Since(fetchedAt) > ks.MinRefreshInterval {
+			// the key may be rotated
+			keySet, err = ks.fetch(ctx)
+			if err != nil {
+				return nil, err
+			}
+			keys, err = keySet.Keys(ctx, keyID)
+		}
+	}
+	return keys, err
+}
+
+// Refresh refetches the JWK set.
+func (ks *RemoteKeySet) Refresh(ctx context.Context) error {
+	_, err := ks.fetch(ctx)
+	return err
+}
+
+// fetch fetches the JWK set and caches it, sharing the fetch with concurrent callers.
+func (ks *RemoteKeySet) fetch(ctx context.Context) (StaticKeySet, error) {
+	v, err, _ := ks.group.Do("", func() (interface{}, error) {
+		keySet, err := ks.fetchKeySet(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		ks.keys = keySet
+		ks.fetchedAt = time.Now()
+		return keySet, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(StaticKeySet), nil
+}
+
+func (ks *RemoteKeySet) fetchKeySet(ctx context.Context) (StaticKeySet, error) {
+	req, err := http.NewRequest(http.MethodGet, ks.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	client := ks.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS error: status code: %d", resp.StatusCode)
+	}
+
+	var jwks JSONWebKeySet
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticKeySet(&jwks), nil
+}
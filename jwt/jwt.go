@@ -0,0 +1,167 @@
+// Package osecure/jwt provides minimal JSON Web Token (JWS compact serialization) parsing, verification and signing.
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorMalformedToken       = errors.New("malformed token")
+	ErrorUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrorInvalidSignature     = errors.New("invalid signature")
+	ErrorInvalidKey           = errors.New("invalid key")
+	ErrorKeyNotFound          = errors.New("key not found")
+	ErrorTokenExpired         = errors.New("token is expired")
+	ErrorTokenNotYetValid     = errors.New("token is not valid yet")
+	ErrorInvalidIssuer        = errors.New("invalid issuer")
+	ErrorInvalidAudience      = errors.New("invalid audience")
+	ErrorMissingClaim         = errors.New("missing claim")
+)
+
+// Header is the JOSE header of token.
+type Header struct {
+	Algorithm string      `json:"alg"`
+	Type      string      `json:"typ,omitempty"`
+	KeyID     string      `json:"kid,omitempty"`
+	JWK       *JSONWebKey `json:"jwk,omitempty"`
+}
+
+// Token is a parsed but not necessarily verified token.
+type Token struct {
+	Raw    string
+	Header Header
+	Claims Claims
+
+	signingInput string
+	signature    []byte
+}
+
+// Parse parses the token without verifying its signature.
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrorMalformedToken
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrorMalformedToken
+	}
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrorMalformedToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrorMalformedToken
+	}
+
+	token := &Token{
+		Raw:          raw,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}
+	err = json.Unmarshal(headerBytes, &token.Header)
+	if err != nil {
+		return nil, ErrorMalformedToken
+	}
+	err = unmarshalClaims(claimsBytes, &token.Claims)
+	if err != nil {
+		return nil, ErrorMalformedToken
+	}
+
+	return token, nil
+}
+
+// IsJWT checks if the string looks like a JWS compact serialization, without verifying it.
+func IsJWT(raw string) bool {
+	_, err := Parse(raw)
+	return err == nil
+}
+
+// Verify verifies the signature with the key, which is a public key of the algorithm or []byte for HMAC.
+func (t *Token) Verify(key interface{}) error {
+	return verifySignature(t.Header.Algorithm, key, []byte(t.signingInput), t.signature)
+}
+
+// Expected are the expectations of registered claims.
+type Expected struct {
+	// Issuer is the expected "iss", not checked if empty.
+	Issuer string
+	// Audiences are the accepted "aud", at least one of them must present if not empty.
+	Audiences []string
+	// Leeway tolerates clock skew on "exp", "nbf" and "iat".
+	Leeway time.Duration
+	// Now is the time to validate against, time.Now() if zero.
+	Now time.Time
+}
+
+// Validate validates "exp", "nbf", "iat", "iss" and "aud" claims.
+// Time claims are optional, but must be numbers if present, otherwise ErrorMalformedToken is returned.
+func (c Claims) Validate(expected Expected) error {
+	now := expected.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	for _, key := range []string{"exp", "nbf", "iat"} {
+		if _, ok := c.Time(key); c.Has(key) && !ok {
+			return ErrorMalformedToken
+		}
+	}
+	if exp, ok := c.Time("exp"); ok && !now.Before(exp.Add(expected.Leeway)) {
+		return ErrorTokenExpired
+	}
+	if nbf, ok := c.Time("nbf"); ok && now.Add(expected.Leeway).Before(nbf) {
+		return ErrorTokenNotYetValid
+	}
+	if iat, ok := c.Time("iat"); ok && now.Add(expected.Leeway).Before(iat) {
+		// issued in the future
+		return ErrorTokenNotYetValid
+	}
+	if expected.Issuer != "" && c.String("iss") != expected.Issuer {
+		return ErrorInvalidIssuer
+	}
+	if len(expected.Audiences) > 0 && !c.HasAudience(expected.Audiences...) {
+		return ErrorInvalidAudience
+	}
+
+	return nil
+}
+
+func encodeSegment(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign signs the claims, which is Claims or any JSON-serializable struct.
+// The key is a crypto.Signer (RSA, ECDSA or Ed25519 private key) or []byte for HMAC, matching header.Algorithm.
+func Sign(header Header, claims interface{}, key interface{}) (string, error) {
+	if header.Type == "" {
+		header.Type = "JWT"
+	}
+
+	headerSegment, err := encodeSegment(header)
+	if err != nil {
+		return "", err
+	}
+	claimsSegment, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := headerSegment + "." + claimsSegment
+	signature, err := sign(header.Algorithm, key, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,205 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerifyAlgorithmConfusion(t *testing.T) {
+	key := newTestRSAKey(t)
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HMAC signed with the public key, which verifiers using the key as HMAC secret would accept
+	raw, err := Sign(Header{Algorithm: "HS256"}, Claims{"sub": "alice"}, publicKeyDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := token.Verify(&key.PublicKey); err != ErrorInvalidKey {
+		t.Errorf("HS256 token verified with RSA key: %v", err)
+	}
+
+	raw, err = Sign(Header{Algorithm: "RS256"}, Claims{"sub": "alice"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := token.Verify(&key.PublicKey); err != nil {
+		t.Errorf("RS256 token: %v", err)
+	}
+	if err := token.Verify(publicKeyDER); err != ErrorInvalidKey {
+		t.Errorf("RS256 token verified with HMAC secret: %v", err)
+	}
+}
+
+func TestVerifyNoneAlgorithm(t *testing.T) {
+	key := newTestRSAKey(t)
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`))
+
+	for _, raw := range []string{header + "." + claims + ".", header + "." + claims + ".c2lnbmF0dXJl"} {
+		token, err := Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := token.Verify(&key.PublicKey); err != ErrorUnsupportedAlgorithm {
+			t.Errorf("none token %q: got %v, want %v", raw, err, ErrorUnsupportedAlgorithm)
+		}
+		if err := token.Verify([]byte("secret")); err != ErrorUnsupportedAlgorithm {
+			t.Errorf("none token %q with HMAC secret: got %v, want %v", raw, err, ErrorUnsupportedAlgorithm)
+		}
+	}
+}
+
+func TestClaimsValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	expected := Expected{Leeway: time.Minute, Now: now}
+
+	for name, test := range map[string]struct {
+		claims string
+		want   error
+	}{
+		"valid":                 {`{"exp":1700000100,"nbf":1699999900,"iat":1699999900}`, nil},
+		"without time claims":   {`{}`, nil},
+		"expired":               {`{"exp":1699999900}`, ErrorTokenExpired},
+		"expired within leeway": {`{"exp":1699999990}`, nil},
+		"not yet valid":         {`{"nbf":1700000100}`, ErrorTokenNotYetValid},
+		"nbf within leeway":     {`{"nbf":1700000030}`, nil},
+		"issued in the future":  {`{"iat":1700000100}`, ErrorTokenNotYetValid},
+		"fractional exp":        {`{"exp":1700000100.5}`, nil},
+		"exp as string":         {`{"exp":"1700000100"}`, ErrorMalformedToken},
+		"nbf as string":         {`{"nbf":"1699999900"}`, ErrorMalformedToken},
+		"iat as object":         {`{"iat":{}}`, ErrorMalformedToken},
+		"null exp":              {`{"exp":null}`, ErrorMalformedToken},
+	} {
+		var claims Claims
+		if err := unmarshalClaims([]byte(test.claims), &claims); err != nil {
+			t.Fatal(err)
+		}
+		if err := claims.Validate(expected); err != test.want {
+			t.Errorf("%s: got %v, want %v", name, err, test.want)
+		}
+	}
+}
+
+func TestRemoteKeySetConcurrentFetch(t *testing.T) {
+	key := newTestRSAKey(t)
+	jwk, err := NewJSONWebKey(&key.PublicKey, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		json.NewEncoder(w).Encode(&JSONWebKeySet{Keys: []JSONWebKey{*jwk}})
+	}))
+	defer server.Close()
+
+	ks := NewRemoteKeySet(server.URL)
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ks.Keys(context.Background(), "key")
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+
+	// unknown keys don't refetch within MinRefreshInterval
+	if _, err := ks.Keys(context.Background(), "unknown"); err != ErrorKeyNotFound {
+		t.Errorf("unknown key: got %v, want %v", err, ErrorKeyNotFound)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched %d times after unknown key, want 1", n)
+	}
+}
+
+func TestRemoteKeySetServesCachedKeysWhileFetching(t *testing.T) {
+	key := newTestRSAKey(t)
+	jwk, err := NewJSONWebKey(&key.PublicKey, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(&JSONWebKeySet{Keys: []JSONWebKey{*jwk}})
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ks := NewRemoteKeySet(server.URL)
+	ks.MinRefreshInterval = 0
+	if _, err := ks.Keys(context.Background(), "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a slow refetch triggered by an unknown key doesn't block the known keys
+	go ks.Keys(context.Background(), "rotated")
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := ks.Keys(context.Background(), "key")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("cached keys blocked by the fetch")
+	}
+}
@@ -0,0 +1,130 @@
+package osecure
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SecretKeys are secrets which can be rotated at runtime.
+type SecretKeys struct {
+	// Cookie is the cookie keys, not changed if nil.
+	Cookie *CookieConfig `yaml:"cookie"`
+	// ClientSecret is the OAuth client secret, not changed if empty.
+	ClientSecret string `yaml:"client_secret"`
+	// SessionStoreKeys are base64 AES keys of EncryptedSessionStore, newest first, not changed if empty.
+	SessionStoreKeys []string `yaml:"session_store_keys"`
+}
+
+// KeyProvider provides current secrets, e.g. from files or secret managers.
+type KeyProvider interface {
+	LoadKeys(ctx context.Context) (*SecretKeys, error)
+}
+
+// KeyProviderFunc is a function implementing KeyProvider.
+type KeyProviderFunc func(ctx context.Context) (*SecretKeys, error)
+
+func (f KeyProviderFunc) LoadKeys(ctx context.Context) (*SecretKeys, error) {
+	return f(ctx)
+}
+
+// FileKeyProvider loads secrets from a YAML file, in the same format as the "cookie" and "client_secret" of Config,
+// e.g. a file mounted from Kubernetes secrets.
+type FileKeyProvider struct {
+	Path string
+}
+
+func (p *FileKeyProvider) LoadKeys(ctx context.Context) (*SecretKeys, error) {
+	b, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &SecretKeys{}
+	err = yaml.UnmarshalStrict(b, keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ReloadKeys loads secrets from the provider, and replaces the cookie keys and client secret without restarting.
+// Cookies encoded with the replaced keys become invalid, unless they are kept in CookieConfig.PreviousKeys.
+// The cookie format is kept if the reloaded CookieConfig.Format is empty, and reloading another format is rejected,
+// since cookies of the current format would become invalid.
+// Invalid secrets are rejected and the current secrets are kept.
+func (s *OAuthSession) ReloadKeys(ctx context.Context, provider KeyProvider) error {
+	keys, err := provider.LoadKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if keys.Cookie != nil {
+		err = keys.Cookie.Validate()
+		if err != nil {
+			return err
+		}
+		cookieConf := *keys.Cookie
+		if cookieConf.Format == "" {
+			cookieConf.Format = s.cookieFormat
+		} else if cookieConf.Format != s.cookieFormat {
+			return &ConfigError{Field: "format", Reason: fmt.Sprintf("cannot be changed from %q by reloading keys", s.cookieFormat)}
+		}
+		cookieStore, err := buildCookieStore(&cookieConf)
+		if err != nil {
+			return err
+		}
+		s.cookieStore.Store(cookieStore)
+	}
+
+	if keys.ClientSecret != "" {
+		client := *s.getClient()
+		client.ClientSecret = keys.ClientSecret
+		client.Scopes = append([]string(nil), client.Scopes...)
+		s.client.Store(&client)
+	}
+
+	return nil
+}
+
+// WatchKeys reloads secrets from the provider every interval until ctx is done.
+// For FileKeyProvider, the file is reloaded only if it's modified.
+// Errors of reloading are passed to onError, which can be nil.
+func (s *OAuthSession) WatchKeys(ctx context.Context, provider KeyProvider, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastModified time.Time
+	if fileProvider, ok := provider.(*FileKeyProvider); ok {
+		if info, err := os.Stat(fileProvider.Path); err == nil {
+			lastModified = info.ModTime()
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if fileProvider, ok := provider.(*FileKeyProvider); ok {
+			info, err := os.Stat(fileProvider.Path)
+			if err == nil && info.ModTime().Equal(lastModified) {
+				continue
+			}
+			if err == nil {
+				lastModified = info.ModTime()
+			}
+		}
+
+		err := s.ReloadKeys(ctx, provider)
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
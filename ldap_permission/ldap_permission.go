@@ -0,0 +1,122 @@
+// Package osecure/ldap_permission provides permission source mapping LDAP / Active Directory groups of the subject to permissions.
+package ldap_permission
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+// GroupResolver resolves groups (DNs or CNs) of the subject from the directory.
+// With go-ldap, it searches the user entry by a filter like "(sAMAccountName=<EscapeFilter(subject)>)"
+// and returns its "memberOf" values, or searches groups by "(member=<user DN>)" for nested groups.
+type GroupResolver interface {
+	ResolveGroups(ctx context.Context, subject string) ([]string, error)
+}
+
+// GroupResolverFunc is an adapter to use ordinary function as GroupResolver.
+type GroupResolverFunc func(ctx context.Context, subject string) ([]string, error)
+
+// ResolveGroups calls f(ctx, subject).
+func (f GroupResolverFunc) ResolveGroups(ctx context.Context, subject string) ([]string, error) {
+	return f(ctx, subject)
+}
+
+// Source is an osecure.PermissionSource granting permissions of the subject's groups by GroupPermissions,
+// keyed by group DNs or CNs (case-insensitive). Groups not in the table grant nothing.
+type Source struct {
+	Resolver         GroupResolver
+	GroupPermissions map[string][]string
+}
+
+// New creates Source cached by osecure.CachePermissionSource for the TTL,
+// osecure.DefaultPermissionExpireTime if zero, so directory lookups follow the permission TTL.
+func New(resolver GroupResolver, groupPermissions map[string][]string, ttl time.Duration) osecure.PermissionSource {
+	if ttl == 0 {
+		ttl = osecure.DefaultPermissionExpireTime
+	}
+	source := &Source{Resolver: resolver, GroupPermissions: groupPermissions}
+	return osecure.CachePermissionSource(source, ttl, 0)
+}
+
+// GetPermissions implements osecure.PermissionSource.
+func (src *Source) GetPermissions(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+	groups, err := src.Resolver.ResolveGroups(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve groups: %w", err)
+	}
+
+	table := make(map[string][]string, len(src.GroupPermissions))
+	for group, permissions := range src.GroupPermissions {
+		table[strings.ToLower(group)] = permissions
+	}
+
+	granted := make(osecure.StringSet)
+	for _, group := range groups {
+		permissions, found := table[strings.ToLower(group)]
+		if !found {
+			permissions = table[strings.ToLower(GroupCN(group))]
+		}
+		for _, permission := range permissions {
+			granted.Add(permission)
+		}
+	}
+
+	list := granted.List()
+	sort.Strings(list)
+	return list, nil
+}
+
+// GroupCN gets the CN of the group DN, e.g. "Admins" of "CN=Admins,OU=Groups,DC=example,DC=com".
+// The value is returned as is if it's not a DN starting with CN.
+func GroupCN(dn string) string {
+	if len(dn) < 3 || !strings.EqualFold(dn[:3], "cn=") {
+		return dn
+	}
+
+	var cn strings.Builder
+	for i := 3; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			// escaped like \, or by a hex pair like \2C
+			if i+2 < len(dn) && isHex(dn[i+1]) && isHex(dn[i+2]) {
+				b, _ := strconv.ParseUint(dn[i+1:i+3], 16, 8)
+				cn.WriteByte(byte(b))
+				i += 2
+			} else if i+1 < len(dn) {
+				i++
+				cn.WriteByte(dn[i])
+			}
+		case ',', '+':
+			return cn.String()
+		default:
+			cn.WriteByte(dn[i])
+		}
+	}
+	return cn.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// EscapeFilter escapes the value for LDAP search filters (RFC 4515), so subjects can't inject filters.
+func EscapeFilter(value string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&escaped, "\\%02x", c)
+		default:
+			escaped.WriteByte(c)
+		}
+	}
+	return escaped.String()
+}
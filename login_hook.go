@@ -0,0 +1,52 @@
+package osecure
+
+import (
+	"context"
+	"net/http"
+)
+
+// LoginHook is called when the user logs in, before the session cookie is issued,
+// e.g. to provision the user or sync the profile. Returning an error rejects the login.
+type LoginHook func(ctx context.Context, data *AuthSessionData) error
+
+// SetOnLogin sets the hook called by CallbackView and SaveToken after the token is verified.
+// Changes to the session data by the hook aren't kept. It should be called before serving requests.
+func (s *OAuthSession) SetOnLogin(hook LoginHook) {
+	s.onLogin = hook
+}
+
+// AuthorizeHook is called by Secured after the session is verified, before the wrapped handler,
+// e.g. to ban users or gate by maintenance mode. Returning an error vetoes the request,
+// responding the status code (403 if zero) with the error message.
+type AuthorizeHook func(r *http.Request, data *AuthSessionData) (statusCode int, err error)
+
+// SetOnAuthorize sets the hook called by SecuredF and SecuredH for every authorized request.
+// It should be called before serving requests.
+func (s *OAuthSession) SetOnAuthorize(hook AuthorizeHook) {
+	s.onAuthorize = hook
+}
+
+// runAuthorizeHook returns false if the hook vetoes the request, which has been responded.
+func (s *OAuthSession) runAuthorizeHook(w http.ResponseWriter, r *http.Request, data *AuthSessionData) bool {
+	if s.onAuthorize == nil {
+		return true
+	}
+
+	statusCode, err := s.onAuthorize(r, data)
+	if err == nil {
+		return true
+	}
+	if statusCode == 0 {
+		statusCode = http.StatusForbidden
+	}
+	s.audit(r, AuditEventAccessDenied, data, err)
+	switch statusCode {
+	case http.StatusUnauthorized:
+		s.unauthorized(w, r, err)
+	case http.StatusForbidden:
+		s.forbidden(w, r, err)
+	default:
+		http.Error(w, err.Error(), statusCode)
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package osecure
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultLoginTemplate is the template of LoginView, executed with LoginPageData.
+var DefaultLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in</title>
+</head>
+<body>
+<h1>Log in</h1>
+<ul>
+{{- range .Providers}}
+<li><a href="{{.URL}}">Log in with {{.DisplayName}}</a></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// LoginProvider is a provider to choose in the login page, which is an OAuthSession with its own endpoints.
+type LoginProvider struct {
+	Name        string        // value of the "provider" parameter of the login page
+	DisplayName string        // Name if empty
+	Session     *OAuthSession // the OAuthSession of LoginView if nil
+}
+
+// LoginPageData is the data to execute the login template.
+type LoginPageData struct {
+	Providers   []LoginPageProvider
+	ContinueURI string
+}
+
+// LoginPageProvider is a provider in LoginPageData.
+type LoginPageProvider struct {
+	Name        string
+	DisplayName string
+	URL         string // LoginURL of the provider
+}
+
+// SetLoginPage serves the login page by LoginView at the path, to which Secured redirects users instead of
+// the provider directly. The page lists the providers to choose, or only s if no provider is given.
+// tmpl is DefaultLoginTemplate if nil. It should be called before serving requests.
+func (s *OAuthSession) SetLoginPage(path string, tmpl *template.Template, providers ...LoginProvider) {
+	if tmpl == nil {
+		tmpl = DefaultLoginTemplate
+	}
+	if len(providers) == 0 {
+		providers = []LoginProvider{{Name: s.name}}
+	}
+	s.loginPath = path
+	s.loginTemplate = tmpl
+	s.loginProviders = providers
+}
+
+// LoginURL makes the URL of the login page to log in with the provider, the chooser if provider is empty,
+// and come back to continueURI afterwards.
+func (s *OAuthSession) LoginURL(provider string, continueURI string) string {
+	qry := url.Values{}
+	if provider != "" {
+		qry.Set("provider", provider)
+	}
+	if continueURI != "" {
+		qry.Set("continue", continueURI)
+	}
+	if len(qry) == 0 {
+		return s.loginPath
+	}
+	return s.loginPath + "?" + qry.Encode()
+}
+
+// IsLocalURI checks the URI is a path of this site, to avoid open redirects by continue parameters.
+func IsLocalURI(uri string) bool {
+	return strings.HasPrefix(uri, "/") && !strings.HasPrefix(uri, "//") && !strings.HasPrefix(uri, "/\\")
+}
+
+// LoginView is a http handler for the login page, see SetLoginPage.
+// It redirects to the provider given by the "provider" parameter, or renders the page to choose one.
+func (s *OAuthSession) LoginView(w http.ResponseWriter, r *http.Request) {
+	continueURI := r.FormValue("continue")
+	if !s.isAllowedContinueURI(continueURI) {
+		continueURI = "/"
+	}
+
+	name := r.FormValue("provider")
+	if name != "" {
+		for _, provider := range s.loginProviders {
+			if provider.Name != name {
+				continue
+			}
+			session := provider.Session
+			if session == nil {
+				session = s
+			}
+
+			// the state handler takes the request URI as the continue URI
+			rr := r.WithContext(r.Context())
+			rr.RequestURI = continueURI
+			err := session.StartOAuth(w, rr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		http.Error(w, ErrorUnknownProvider.Error(), http.StatusNotFound)
+		return
+	}
+
+	data := &LoginPageData{ContinueURI: continueURI}
+	for _, provider := range s.loginProviders {
+		displayName := provider.DisplayName
+		if displayName == "" {
+			displayName = provider.Name
+		}
+		data.Providers = append(data.Providers, LoginPageProvider{
+			Name:        provider.Name,
+			DisplayName: displayName,
+			URL:         s.LoginURL(provider.Name, continueURI),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := s.loginTemplate.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
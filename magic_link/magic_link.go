@@ -0,0 +1,305 @@
+// Package osecure/magic_link provides passwordless login by email links, which logs users in to osecure.OAuthSession,
+// for apps whose users don't have accounts of an identity provider.
+//
+// Users submit their email to RequestHandler, which sends a signed one-time login link by the Sender.
+// Opening the link and confirming logs the user in by VerifyHandler, which issues session tokens verified
+// by the TokenVerifier of the Provider. The OAuthSession must be created with the TokenVerifier,
+// and OAuthConfig.ClientID must be the ClientID of the Provider:
+//
+//	p, err := magic_link.New(conf)
+//	s := osecure.NewOAuthSession("email", cookieConf, &osecure.OAuthConfig{ClientID: conf.ClientID}, endpoint, p.TokenVerifier(getPermissions), callbackURL, nil)
+//	s.SetLoginPage("/login/email", loginTemplate)
+//	mux.Handle("/login/email/send", p.RequestHandler())
+//	mux.Handle("/login/email/verify", p.VerifyHandler(s))
+package magic_link
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+// Defaults of Config, used when the corresponding field is zero.
+const (
+	DefaultLinkLifetime    = 15 * time.Minute
+	DefaultSessionLifetime = 8 * time.Hour
+)
+
+var (
+	ErrorInvalidEmail = errors.New("invalid email address")
+	ErrorUnknownUser  = errors.New("unknown user")
+	ErrorInvalidLink  = errors.New("invalid or expired login link")
+	ErrorLinkUsed     = errors.New("login link is used")
+	ErrorInvalidToken = errors.New("invalid email session token")
+)
+
+// Sender sends the login link to the email address, e.g. by SMTP or a mail API.
+type Sender interface {
+	SendLoginLink(ctx context.Context, email string, link string) error
+}
+
+// SenderFunc is an adapter to use ordinary function as Sender.
+type SenderFunc func(ctx context.Context, email string, link string) error
+
+// SendLoginLink calls f(ctx, email, link).
+func (f SenderFunc) SendLoginLink(ctx context.Context, email string, link string) error {
+	return f(ctx, email, link)
+}
+
+// DefaultConfirmTemplate is the template of the page of login links, executed with ConfirmPageData.
+// Links are confirmed by posting the form, so link scanners of mail servers which fetch links don't use them up.
+var DefaultConfirmTemplate = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in</title>
+</head>
+<body>
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Log in as {{.Email}}</button>
+</form>
+</body>
+</html>
+`))
+
+// ConfirmPageData is the data to execute the confirm template.
+type ConfirmPageData struct {
+	Email string
+	Token string
+}
+
+// Config is the config of Provider.
+type Config struct {
+	ClientID  string // client ID of sessions
+	Issuer    string // issuer in extra data of sessions, ClientID if empty
+	VerifyURL string // absolute URL of VerifyHandler, the login link with the token
+
+	Sender Sender
+
+	// SigningKey signs login links and session tokens, at least 32 bytes.
+	SigningKey []byte
+
+	// ResolveUser maps the email to the user ID, returning ErrorUnknownUser if the user can't log in,
+	// to whom no link is sent. The email is the user ID if nil, so anyone receiving email can log in.
+	ResolveUser func(ctx context.Context, email string) (userID string, err error)
+
+	LinkLifetime    time.Duration // DefaultLinkLifetime if zero
+	SessionLifetime time.Duration // DefaultSessionLifetime if zero
+
+	// ConfirmTemplate is the page of login links, DefaultConfirmTemplate if nil.
+	ConfirmTemplate *template.Template
+
+	// ReplayCache keeps links used once, osecure.MemoryReplayCache if nil.
+	ReplayCache osecure.ReplayCache
+}
+
+// Provider is a passwordless login provider by email links.
+type Provider struct {
+	conf        Config
+	replayCache osecure.ReplayCache
+}
+
+// New creates Provider with the config.
+func New(conf Config) (*Provider, error) {
+	switch {
+	case conf.ClientID == "":
+		return nil, errors.New("client ID is required")
+	case conf.VerifyURL == "":
+		return nil, errors.New("verify URL is required")
+	case conf.Sender == nil:
+		return nil, errors.New("sender is required")
+	case len(conf.SigningKey) < 32:
+		return nil, errors.New("signing key must be at least 32 bytes")
+	}
+	if conf.Issuer == "" {
+		conf.Issuer = conf.ClientID
+	}
+	if conf.LinkLifetime == 0 {
+		conf.LinkLifetime = DefaultLinkLifetime
+	}
+	if conf.SessionLifetime == 0 {
+		conf.SessionLifetime = DefaultSessionLifetime
+	}
+	if conf.ConfirmTemplate == nil {
+		conf.ConfirmTemplate = DefaultConfirmTemplate
+	}
+
+	p := &Provider{
+		conf:        conf,
+		replayCache: conf.ReplayCache,
+	}
+	if p.replayCache == nil {
+		p.replayCache = osecure.NewMemoryReplayCache()
+	}
+	return p, nil
+}
+
+// normalizeEmail checks the email is a bare address, returned in lower case.
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return "", ErrorInvalidEmail
+	}
+	return strings.ToLower(email), nil
+}
+
+// SendLink sends the login link to the email, which logs in and continues to continueURI (a local URI).
+// It returns ErrorUnknownUser without sending if ResolveUser rejects the email.
+func (p *Provider) SendLink(ctx context.Context, email string, continueURI string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if !osecure.IsLocalURI(continueURI) {
+		continueURI = "/"
+	}
+
+	userID := email
+	if p.conf.ResolveUser != nil {
+		userID, err = p.conf.ResolveUser(ctx, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	link, err := p.linkURL(userID, email, continueURI, time.Now())
+	if err != nil {
+		return err
+	}
+	return p.conf.Sender.SendLoginLink(ctx, email, link)
+}
+
+// RequestHandler sends login links to the "email" posted, continuing to the "continue" parameter after logging in.
+// It responds 202 whether the user is known or not, so emails of users can't be enumerated.
+// Requests should be rate limited by email and client, since each of them sends an email.
+func (p *Provider) RequestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := p.SendLink(r.Context(), r.PostFormValue("email"), r.FormValue("continue"))
+		switch {
+		case err == nil, errors.Is(err, ErrorUnknownUser):
+			w.WriteHeader(http.StatusAccepted)
+		case err == ErrorInvalidEmail:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// VerifyHandler is the target of login links. GET shows the confirm page of the link, and posting it
+// uses the link, saves the session into the cookie of s, and redirects to the continue URI of the link.
+func (p *Provider) VerifyHandler(s *osecure.OAuthSession) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			link, err := p.parseLink(r.FormValue("token"), time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Referrer-Policy", "no-referrer")
+			err = p.conf.ConfirmTemplate.Execute(w, &ConfirmPageData{Email: link.Email, Token: r.FormValue("token")})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		case http.MethodPost:
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		link, err := p.useLink(r.Context(), r.PostFormValue("token"), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		token, expiresAt, err := p.issueToken(link, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = s.SaveToken(w, r, &oauth2.Token{AccessToken: token, TokenType: "Bearer", Expiry: expiresAt})
+		if err != nil {
+			http.Error(w, err.Error(), osecure.ErrorStatusCode(err))
+			return
+		}
+
+		http.Redirect(w, r, link.ContinueURI, http.StatusSeeOther)
+	})
+}
+
+// linkURL makes the login link of the user.
+func (p *Provider) linkURL(userID string, email string, continueURI string, now time.Time) (string, error) {
+	id, err := osecure.GenerateID(16)
+	if err != nil {
+		return "", err
+	}
+	token, err := p.signPayload(linkPrefix, &linkPayload{
+		ID:          id,
+		Subject:     userID,
+		Email:       email,
+		ContinueURI: continueURI,
+		ExpiresAt:   now.Add(p.conf.LinkLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	verifyURL, err := url.Parse(p.conf.VerifyURL)
+	if err != nil {
+		return "", err
+	}
+	qry := verifyURL.Query()
+	qry.Set("token", token)
+	verifyURL.RawQuery = qry.Encode()
+	return verifyURL.String(), nil
+}
+
+// useLink validates the token of the login link, which is used once.
+func (p *Provider) useLink(ctx context.Context, token string, now time.Time) (*linkPayload, error) {
+	link, err := p.parseLink(token, now)
+	if err != nil {
+		return nil, err
+	}
+
+	isFirstUse, err := p.replayCache.Use(ctx, "magic_link:"+link.ID, time.Unix(link.ExpiresAt, 0))
+	if err != nil {
+		return nil, err
+	}
+	if !isFirstUse {
+		return nil, ErrorLinkUsed
+	}
+	return link, nil
+}
+
+func (p *Provider) parseLink(token string, now time.Time) (*linkPayload, error) {
+	link := &linkPayload{}
+	err := p.parsePayload(linkPrefix, token, link)
+	if err != nil || link.ID == "" || now.Unix() >= link.ExpiresAt {
+		return nil, ErrorInvalidLink
+	}
+	if !osecure.IsLocalURI(link.ContinueURI) {
+		link.ContinueURI = "/"
+	}
+	return link, nil
+}
@@ -0,0 +1,164 @@
+package magic_link
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+)
+
+type testSender struct {
+	links map[string]string
+}
+
+func (sender *testSender) SendLoginLink(ctx context.Context, email string, link string) error {
+	sender.links[email] = link
+	return nil
+}
+
+func newTestProvider(t *testing.T) (*Provider, *testSender, *osecure.OAuthSession) {
+	t.Helper()
+	sender := &testSender{links: map[string]string{}}
+	p, err := New(Config{
+		ClientID:   "email",
+		VerifyURL:  "https://example.com/login/email/verify",
+		Sender:     sender,
+		SigningKey: []byte(strings.Repeat("k", 32)),
+		ResolveUser: func(ctx context.Context, email string) (string, error) {
+			if email != "alice@example.com" {
+				return "", ErrorUnknownUser
+			}
+			return "alice", nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookieConf := &osecure.CookieConfig{
+		AuthenticationKey: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32))),
+		EncryptionKey:     base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32))),
+	}
+	s := osecure.NewOAuthSession("email", cookieConf, &osecure.OAuthConfig{ClientID: "email"}, osecure.OAuthEndpoint{
+		AuthURL:  "https://example.com/login/email",
+		TokenURL: "https://example.com/login/email/token",
+	}, p.TokenVerifier(nil), "https://example.com/callback", nil)
+	return p, sender, s
+}
+
+func requestLink(p *Provider, email string, continueURI string) int {
+	form := url.Values{"email": {email}, "continue": {continueURI}}
+	r := httptest.NewRequest(http.MethodPost, "/login/email/send", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	p.RequestHandler().ServeHTTP(w, r)
+	return w.Code
+}
+
+func linkToken(t *testing.T, link string) string {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("token")
+}
+
+func postVerify(p *Provider, s *osecure.OAuthSession, token string) *httptest.ResponseRecorder {
+	form := url.Values{"token": {token}}
+	r := httptest.NewRequest(http.MethodPost, "/login/email/verify", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	p.VerifyHandler(s).ServeHTTP(w, r)
+	return w
+}
+
+func TestLoginLink(t *testing.T) {
+	p, sender, s := newTestProvider(t)
+
+	if code := requestLink(p, "Alice@Example.com", "/inbox"); code != http.StatusAccepted {
+		t.Fatalf("request: got %d", code)
+	}
+	token := linkToken(t, sender.links["alice@example.com"])
+
+	// opening the link only shows the confirm page
+	w := httptest.NewRecorder()
+	p.VerifyHandler(s).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login/email/verify?token="+url.QueryEscape(token), nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), token) || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("confirm page: got %d, cookies %v", w.Code, w.Result().Cookies())
+	}
+
+	w = postVerify(p, s, token)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/inbox" {
+		t.Fatalf("confirm: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	r := httptest.NewRequest(http.MethodGet, "/inbox", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	data, err := s.Verify(r)
+	if err != nil || data.UserID != "alice" || data.Extra[osecure.ExtraKeyEmail] != "alice@example.com" {
+		t.Fatalf("session: got %+v, %v", data, err)
+	}
+
+	if w := postVerify(p, s, token); w.Code != http.StatusUnauthorized {
+		t.Errorf("reused link: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLoginLinkRequest(t *testing.T) {
+	p, sender, _ := newTestProvider(t)
+
+	// unknown users get the same response, without email
+	if code := requestLink(p, "mallory@example.com", "/"); code != http.StatusAccepted {
+		t.Errorf("unknown user: got %d, want %d", code, http.StatusAccepted)
+	}
+	if _, sent := sender.links["mallory@example.com"]; sent {
+		t.Error("link sent to unknown user")
+	}
+	if code := requestLink(p, "Alice <alice@example.com>", "/"); code != http.StatusBadRequest {
+		t.Errorf("address with name: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// links don't redirect to other sites
+	requestLink(p, "alice@example.com", "https://evil.example.com/")
+	link, err := p.parseLink(linkToken(t, sender.links["alice@example.com"]), time.Now())
+	if err != nil || link.ContinueURI != "/" {
+		t.Errorf("continue URI of other site: got %+v, %v", link, err)
+	}
+}
+
+func TestLoginLinkRejected(t *testing.T) {
+	p, sender, s := newTestProvider(t)
+	requestLink(p, "alice@example.com", "/")
+	token := linkToken(t, sender.links["alice@example.com"])
+
+	if _, err := p.parseLink(token, time.Now().Add(DefaultLinkLifetime)); err != ErrorInvalidLink {
+		t.Errorf("expired link: got %v, want %v", err, ErrorInvalidLink)
+	}
+	if w := postVerify(p, s, token[:len(token)-2]+"AA"); w.Code != http.StatusUnauthorized {
+		t.Errorf("tampered link: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// login links aren't session tokens
+	if _, _, _, _, err := p.introspectToken(context.Background(), token); err == nil {
+		t.Error("login link accepted as session token")
+	}
+	link, err := p.parseLink(token, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionToken, _, err := p.issueToken(link, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := postVerify(p, s, sessionToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("session token used as login link: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
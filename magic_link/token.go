@@ -0,0 +1,96 @@
+package magic_link
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+const (
+	linkPrefix    = "ml."
+	sessionPrefix = "mls."
+)
+
+type linkPayload struct {
+	ID          string `json:"jti"`
+	Subject     string `json:"sub"`
+	Email       string `json:"email"`
+	ContinueURI string `json:"continue,omitempty"`
+	ExpiresAt   int64  `json:"exp"`
+}
+
+type sessionPayload struct {
+	Subject   string `json:"sub"`
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"exp"`
+	AuthTime  int64  `json:"auth_time"`
+}
+
+// signedToken signs tokens of the prefix by the key derived for the prefix,
+// so login links and session tokens can't be used as each other.
+func (p *Provider) signedToken(prefix string) osecure.SignedToken {
+	mac := hmac.New(sha256.New, p.conf.SigningKey)
+	mac.Write([]byte("osecure/magic_link " + prefix))
+	return osecure.SignedToken{Prefix: prefix, Key: mac.Sum(nil)}
+}
+
+func (p *Provider) signPayload(prefix string, payload interface{}) (string, error) {
+	return p.signedToken(prefix).Sign(payload)
+}
+
+func (p *Provider) parsePayload(prefix string, token string, payload interface{}) error {
+	err := p.signedToken(prefix).Parse(token, payload)
+	if err != nil {
+		return ErrorInvalidToken
+	}
+	return nil
+}
+
+// issueToken issues the session token of the user of the login link.
+func (p *Provider) issueToken(link *linkPayload, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(p.conf.SessionLifetime)
+	token, err := p.signPayload(sessionPrefix, &sessionPayload{
+		Subject:   link.Subject,
+		Email:     link.Email,
+		ExpiresAt: expiresAt.Unix(),
+		AuthTime:  now.Unix(),
+	})
+	return token, expiresAt, err
+}
+
+// TokenVerifier is the token verifier of session tokens issued by VerifyHandler, for the OAuthSession of the Provider.
+// Extra data has the issuer, auth_time and osecure.ExtraKeyEmail.
+// Permissions are got by getPermissions, none if it's nil.
+func (p *Provider) TokenVerifier(getPermissions osecure.GetPermissionsFunc) *osecure.TokenVerifier {
+	if getPermissions == nil {
+		getPermissions = func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+			return nil, nil
+		}
+	}
+	return &osecure.TokenVerifier{
+		IntrospectTokenFunc: p.introspectToken,
+		GetPermissionsFunc:  getPermissions,
+	}
+}
+
+func (p *Provider) introspectToken(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+	payload := &sessionPayload{}
+	err = p.parsePayload(sessionPrefix, accessToken, payload)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	if time.Now().Unix() >= payload.ExpiresAt {
+		return "", "", 0, nil, ErrorInvalidToken
+	}
+
+	extra = map[string]interface{}{
+		osecure.ExtraKeyIssuer:   p.conf.Issuer,
+		osecure.ExtraKeyAuthTime: payload.AuthTime,
+		osecure.ExtraKeyEmail:    payload.Email,
+	}
+	return payload.Subject, p.conf.ClientID, payload.ExpiresAt, extra, nil
+}
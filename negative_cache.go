@@ -0,0 +1,121 @@
+package osecure
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultNegativeCacheSize is the default max number of cached introspection failures, see OAuthConfig.NegativeCacheTTL.
+const DefaultNegativeCacheSize = 10000
+
+// negativeCache remembers introspection failures of tokens, keyed by hashes so tokens aren't kept in memory.
+type negativeCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]negativeCacheEntry
+}
+
+type negativeCacheEntry struct {
+	err       error
+	expiresAt time.Time
+}
+
+func newNegativeCache(ttl time.Duration, size int) *negativeCache {
+	if ttl <= 0 {
+		return nil
+	}
+	if size <= 0 {
+		size = DefaultNegativeCacheSize
+	}
+	return &negativeCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[[sha256.Size]byte]negativeCacheEntry),
+	}
+}
+
+func (cache *negativeCache) get(key [sha256.Size]byte) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, found := cache.entries[key]
+	if !found {
+		return nil
+	}
+	if !entry.expiresAt.After(time.Now()) {
+		delete(cache.entries, key)
+		return nil
+	}
+	return entry.err
+}
+
+// len is the number of cached failures, zero if the cache is disabled.
+func (cache *negativeCache) len() int {
+	if cache == nil {
+		return 0
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return len(cache.entries)
+}
+
+func (cache *negativeCache) add(key [sha256.Size]byte, err error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	if len(cache.entries) >= cache.size {
+		for k, entry := range cache.entries {
+			if !entry.expiresAt.After(now) {
+				delete(cache.entries, k)
+			}
+		}
+	}
+	if len(cache.entries) >= cache.size {
+		// evict an arbitrary entry, failures are cheap to find again
+		for k := range cache.entries {
+			delete(cache.entries, k)
+			break
+		}
+	}
+	cache.entries[key] = negativeCacheEntry{err: err, expiresAt: now.Add(cache.ttl)}
+}
+
+// introspectToken calls IntrospectTokenFunc, returning cached results of the token if SetIntrospectionCache is set,
+// and cached failures of the token if negative cache is enabled.
+// Failures by cancellation of the context or unavailability of the verifier aren't cached.
+func (s *OAuthSession) introspectToken(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+	key := sha256.Sum256([]byte(accessToken))
+	if s.introspectionCache != nil {
+		if result, found := s.getCachedIntrospection(ctx, key); found {
+			return result.UserID, result.ClientID, result.ExpiresAt, result.Extra, nil
+		}
+	}
+
+	if s.negativeCache != nil {
+		err = s.negativeCache.get(key)
+		if err != nil {
+			return "", "", 0, nil, err
+		}
+	}
+
+	userID, clientID, expiresAt, extra, err = s.introspectTokenOnce(ctx, key, accessToken)
+	if err != nil {
+		if s.negativeCache != nil && !errors.Is(err, context.Canceled) && !IsVerifierUnavailable(err) {
+			s.negativeCache.add(key, err)
+		}
+		return "", "", 0, nil, err
+	}
+
+	if s.introspectionCache != nil {
+		s.cacheIntrospection(ctx, key, &cachedIntrospection{UserID: userID, ClientID: clientID, ExpiresAt: expiresAt, Extra: extra})
+	}
+	return userID, clientID, expiresAt, extra, nil
+}
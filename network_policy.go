@@ -0,0 +1,102 @@
+package osecure
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NetworkDecision is the decision of NetworkPolicy on the network a session is used from.
+type NetworkDecision int
+
+const (
+	NetworkAllow          NetworkDecision = iota
+	NetworkReject                         // responds 403 with ErrorNetworkNotAllowed
+	NetworkReauthenticate                 // rejects the session with ErrorReauthenticationRequired, users log in again
+)
+
+// NetworkPolicy decides if the session may be used from the IP of the client, per session,
+// so policies can depend on the user, e.g. admins only from the office network.
+// Policies can return NetworkReauthenticate unless data.AuthTime is recent, so users log in again from the new network.
+type NetworkPolicy interface {
+	CheckNetwork(ctx context.Context, data *AuthSessionData, ip net.IP) NetworkDecision
+}
+
+// NetworkPolicyFunc is an adapter to use ordinary function as NetworkPolicy.
+type NetworkPolicyFunc func(ctx context.Context, data *AuthSessionData, ip net.IP) NetworkDecision
+
+// CheckNetwork calls f(ctx, data, ip).
+func (f NetworkPolicyFunc) CheckNetwork(ctx context.Context, data *AuthSessionData, ip net.IP) NetworkDecision {
+	return f(ctx, data, ip)
+}
+
+// AllowCIDRs is NetworkPolicy which allows sessions from the CIDR ranges only, rejecting others by the decision.
+func AllowCIDRs(decision NetworkDecision, cidrs ...string) (NetworkPolicy, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return NetworkPolicyFunc(func(ctx context.Context, data *AuthSessionData, ip net.IP) NetworkDecision {
+		for _, network := range networks {
+			if ip != nil && network.Contains(ip) {
+				return NetworkAllow
+			}
+		}
+		return decision
+	}), nil
+}
+
+// GeoIPFunc looks up the ISO 3166-1 alpha-2 country code of the IP, e.g. by a GeoIP database.
+type GeoIPFunc func(ctx context.Context, ip net.IP) (country string, err error)
+
+// AllowCountries is NetworkPolicy which allows sessions from the countries only, rejecting others by the decision.
+// IPs which can't be looked up are rejected too.
+func AllowCountries(lookup GeoIPFunc, decision NetworkDecision, countries ...string) NetworkPolicy {
+	allowed := make(StringSet)
+	for _, country := range countries {
+		allowed.Add(strings.ToUpper(country))
+	}
+
+	return NetworkPolicyFunc(func(ctx context.Context, data *AuthSessionData, ip net.IP) NetworkDecision {
+		if ip == nil {
+			return decision
+		}
+		country, err := lookup(ctx, ip)
+		if err != nil || !allowed.Contain(strings.ToUpper(country)) {
+			return decision
+		}
+		return NetworkAllow
+	})
+}
+
+// SetNetworkPolicy restricts networks which sessions and bearer tokens are used from.
+// It should be called before serving requests.
+func (s *OAuthSession) SetNetworkPolicy(policy NetworkPolicy) {
+	s.networkPolicy = policy
+}
+
+// checkNetwork applies the network policy to the session.
+func (s *OAuthSession) checkNetwork(r *http.Request, data *AuthSessionData) error {
+	if s.networkPolicy == nil {
+		return nil
+	}
+
+	ip := s.clientIP(r)
+	switch s.networkPolicy.CheckNetwork(r.Context(), data, ip) {
+	case NetworkAllow:
+		return nil
+	case NetworkReauthenticate:
+		s.audit(r, AuditEventAccessDenied, data, fmt.Errorf("%w: %v", ErrorReauthenticationRequired, ip))
+		return WrapError(ErrorStringUnauthorized, ErrorReauthenticationRequired)
+	default:
+		s.audit(r, AuditEventAccessDenied, data, fmt.Errorf("%w: %v", ErrorNetworkNotAllowed, ip))
+		return ErrorNetworkNotAllowed
+	}
+}
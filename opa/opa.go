@@ -0,0 +1,125 @@
+// Package osecure/opa provides authorizer which delegates decisions to Open Policy Agent.
+package opa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rayark/osecure/v6"
+)
+
+// Input is the document passed to the policy as `input`.
+type Input struct {
+	Subject     string              `json:"subject"`
+	ClientID    string              `json:"client_id"`
+	Permissions []string            `json:"permissions"`
+	Method      string              `json:"method"`
+	Host        string              `json:"host"`
+	Path        string              `json:"path"`
+	PathParts   []string            `json:"path_parts"`
+	Query       map[string][]string `json:"query"`
+}
+
+// NewInput builds the policy input from session data and request attributes.
+func NewInput(sessionData *osecure.AuthSessionData, attributes *osecure.RequestAttributes) *Input {
+	permissions := sessionData.GetPermissions()
+	sort.Strings(permissions)
+
+	return &Input{
+		Subject:     sessionData.GetUserID(),
+		ClientID:    sessionData.GetClientID(),
+		Permissions: permissions,
+		Method:      attributes.Method,
+		Host:        attributes.Host,
+		Path:        attributes.Path,
+		PathParts:   strings.Split(strings.Trim(attributes.Path, "/"), "/"),
+		Query:       attributes.Query,
+	}
+}
+
+// Evaluator evaluates the policy decision of an input.
+// Client queries an OPA server, embedded Rego queries can be plugged in with EvaluatorFunc.
+type Evaluator interface {
+	Evaluate(ctx context.Context, input *Input) (bool, error)
+}
+
+// EvaluatorFunc is an adapter to use ordinary function as Evaluator.
+type EvaluatorFunc func(ctx context.Context, input *Input) (bool, error)
+
+// Evaluate calls f(ctx, input).
+func (f EvaluatorFunc) Evaluate(ctx context.Context, input *Input) (bool, error) {
+	return f(ctx, input)
+}
+
+// Authorizer is an osecure.Authorizer which asks the evaluator for decisions.
+type Authorizer struct {
+	Evaluator Evaluator
+}
+
+// NewAuthorizer creates authorizer with the evaluator.
+func NewAuthorizer(evaluator Evaluator) *Authorizer {
+	return &Authorizer{Evaluator: evaluator}
+}
+
+// IsAllowed implements osecure.Authorizer.
+func (a *Authorizer) IsAllowed(ctx context.Context, sessionData *osecure.AuthSessionData, attributes *osecure.RequestAttributes) (bool, error) {
+	return a.Evaluator.Evaluate(ctx, NewInput(sessionData, attributes))
+}
+
+// Client queries a boolean decision from the data API of an OPA server (e.g. a sidecar).
+type Client struct {
+	// URL is the base URL of OPA server, e.g. "http://localhost:8181".
+	URL string
+	// Decision is the path of the decision document, e.g. "osecure/authz/allow".
+	Decision string
+	// HTTPClient is used to send requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Evaluate implements Evaluator. An undefined decision is treated as denied.
+func (c *Client) Evaluate(ctx context.Context, input *Input) (bool, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return false, err
+	}
+
+	url := strings.TrimRight(c.URL, "/") + "/v1/data/" + strings.Trim(c.Decision, "/")
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("OPA error: status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Result *bool `json:"result"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return false, err
+	}
+
+	if result.Result == nil {
+		return false, nil
+	}
+	return *result.Result, nil
+}
@@ -0,0 +1,118 @@
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+func newTestSessionData(userID string, permissions ...string) *osecure.AuthSessionData {
+	expiresAt := time.Now().Add(time.Hour)
+	return osecure.NewAuthSessionData(userID, "client", &oauth2.Token{AccessToken: "token", Expiry: expiresAt}, permissions, expiresAt)
+}
+
+func TestNewInput(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/api/items/1?fields=name", nil)
+	input := NewInput(newTestSessionData("alice", "writer", "reader"), osecure.NewRequestAttributes(r))
+
+	want := &Input{
+		Subject:     "alice",
+		ClientID:    "client",
+		Permissions: []string{"reader", "writer"},
+		Method:      http.MethodGet,
+		Host:        "example.com",
+		Path:        "/api/items/1",
+		PathParts:   []string{"api", "items", "1"},
+		Query:       map[string][]string{"fields": {"name"}},
+	}
+	if !reflect.DeepEqual(input, want) {
+		t.Errorf("NewInput() = %+v, want %+v", input, want)
+	}
+}
+
+// newTestOPAServer serves the decision "osecure/authz/allow", which allows GET for "reader",
+// and leaves the decision undefined for "undefined".
+func newTestOPAServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/data/osecure/authz/allow" {
+			http.NotFound(w, r)
+			return
+		}
+		var body struct {
+			Input Input `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if body.Input.Subject == "undefined" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		allowed := false
+		for _, permission := range body.Input.Permissions {
+			if permission == "reader" && body.Input.Method == http.MethodGet {
+				allowed = true
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"result": allowed})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	server := newTestOPAServer(t)
+	authorizer := NewAuthorizer(&Client{URL: server.URL + "/", Decision: "/osecure/authz/allow"})
+
+	for name, test := range map[string]struct {
+		sessionData *osecure.AuthSessionData
+		method      string
+		want        bool
+	}{
+		"allowed":   {newTestSessionData("bob", "reader"), http.MethodGet, true},
+		"denied":    {newTestSessionData("bob", "reader"), http.MethodPost, false},
+		"no role":   {newTestSessionData("bob"), http.MethodGet, false},
+		"undefined": {newTestSessionData("undefined", "reader"), http.MethodGet, false},
+	} {
+		r := httptest.NewRequest(test.method, "/api/items", nil)
+		allowed, err := authorizer.IsAllowed(context.Background(), test.sessionData, osecure.NewRequestAttributes(r))
+		if err != nil || allowed != test.want {
+			t.Errorf("%s: IsAllowed() = %v, %v, want %v", name, allowed, err, test.want)
+		}
+	}
+}
+
+func TestClientError(t *testing.T) {
+	server := newTestOPAServer(t)
+	authorizer := NewAuthorizer(&Client{URL: server.URL, Decision: "osecure/authz/unknown"})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	allowed, err := authorizer.IsAllowed(context.Background(), newTestSessionData("bob", "reader"), osecure.NewRequestAttributes(r))
+	if allowed || err == nil {
+		t.Errorf("IsAllowed() of OPA error = %v, %v", allowed, err)
+	}
+}
+
+func TestEvaluatorFunc(t *testing.T) {
+	var got *Input
+	authorizer := NewAuthorizer(EvaluatorFunc(func(ctx context.Context, input *Input) (bool, error) {
+		got = input
+		return input.Subject == "alice", nil
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	allowed, err := authorizer.IsAllowed(context.Background(), newTestSessionData("alice"), osecure.NewRequestAttributes(r))
+	if !allowed || err != nil || got == nil || got.Path != "/api/items" {
+		t.Errorf("IsAllowed() = %v, %v with input %+v", allowed, err, got)
+	}
+}
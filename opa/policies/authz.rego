@@ -0,0 +1,24 @@
+# Example policy for osecure/opa.
+# Query it with opa.Client{URL: "http://localhost:8181", Decision: "osecure/authz/allow"}.
+package osecure.authz
+
+default allow = false
+
+# administrators can do everything
+allow {
+	input.permissions[_] == "admin"
+}
+
+# read-only access for users with "<resource>:read"
+allow {
+	input.method == "GET"
+	resource := input.path_parts[1]
+	input.path_parts[0] == "api"
+	input.permissions[_] == concat(":", [resource, "read"])
+}
+
+# users can modify their own profile
+allow {
+	input.method == "PUT"
+	input.path_parts == ["api", "users", input.subject]
+}
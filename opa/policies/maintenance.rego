@@ -0,0 +1,13 @@
+# Example policy denying all non-GET requests during maintenance,
+# toggled by loading {"maintenance": true} into data.osecure.config.
+package osecure.maintenance
+
+default allow = false
+
+allow {
+	not data.osecure.config.maintenance
+}
+
+allow {
+	input.method == "GET"
+}
@@ -5,27 +5,49 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/oauth2"
 
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"github.com/rayark/osecure/v6/jwt"
+	"golang.org/x/sync/singleflight"
 )
 
+// Default expiration settings, used when the corresponding OAuthConfig field is zero.
+const (
+	DefaultSessionExpireTime    = 24 * time.Hour
+	DefaultPermissionExpireTime = 10 * time.Minute
+	DefaultSessionMaxLifetime   = 7 * 24 * time.Hour
+)
+
+// Deprecated: expiration settings are per OAuthSession now, see OAuthConfig.SessionExpireTime
+// and OAuthConfig.PermissionExpireTime. These are the defaults in seconds.
 const (
 	SessionExpireTime    = 86400
 	PermissionExpireTime = 600
 )
 
+// slidingSessionRefreshInterval avoids rewriting the cookie on every request in sliding session mode.
+const slidingSessionRefreshInterval = time.Minute
+
 type contextKey int
 
 const (
-	contextKeySessionData = contextKey(1)
+	contextKeySessionData        = contextKey(1)
+	contextKeyRestoredCookieData = contextKey(2) // session restored by the remember-me token
+	contextKeyAuthCodeOptions    = contextKey(3) // see WithAuthCodeOptions
+	contextKeyExchangeOptions    = contextKey(4) // see WithExchangeOptions
 )
 
 func init() {
@@ -34,27 +56,38 @@ func init() {
 
 type AuthSessionCookieData struct {
 	Token                *oauth2.Token
-	Permissions          StringSet
+	Permissions          PermissionSet
 	PermissionsExpiresAt time.Time
+	SessionCreatedAt     time.Time
+	SessionExpiresAt     time.Time // zero if the session lasts as long as the token
+	AuthTime             time.Time // zero if the user didn't log in through CallbackView
+	IDToken              string    // kept only if EndSessionEndpoint is configured
+	SessionID            string    // ID of SessionRecord if session store is enabled
+	ClientUserAgentHash  string    // see OAuthConfig.ClientBinding
+	ClientNetworkHash    string
+	Tenant               string // see MultiTenantSession
+	ImpersonatedUserID   string // see Impersonate
+	SecondFactorAt       time.Time
+	LastSeen             ClientObservation
+	Values               map[string]json.RawMessage // of the application, see SetSessionValue
+
+	// Permissions in order as of the last serialization, reused while Permissions is unchanged
+	sortedPermissions []string
 }
 
-func newAuthSessionCookieData(token *oauth2.Token) *AuthSessionCookieData {
-	if token.Expiry.IsZero() {
-		token.Expiry = time.Now().Add(time.Duration(SessionExpireTime) * time.Second)
-	}
-	return &AuthSessionCookieData{
-		Token:                token,
-		Permissions:          NewStringSet(nil),
-		PermissionsExpiresAt: time.Time{}, // Zero time
-	}
+// isTokenExpired checks token expiry, tolerating clock skew up to leeway.
+func (cookieData *AuthSessionCookieData) isTokenExpired(leeway time.Duration) bool {
+	return !cookieData.Token.Expiry.Add(leeway).After(time.Now())
 }
 
-func (cookieData *AuthSessionCookieData) isTokenExpired() bool {
-	return !cookieData.Token.Expiry.After(time.Now())
+// isSessionExpired checks session expiry of sliding session, tolerating clock skew up to leeway.
+func (cookieData *AuthSessionCookieData) isSessionExpired(leeway time.Duration) bool {
+	return !cookieData.SessionExpiresAt.IsZero() && !cookieData.SessionExpiresAt.Add(leeway).After(time.Now())
 }
 
-func (cookieData *AuthSessionCookieData) isPermissionsExpired() bool {
-	return !cookieData.PermissionsExpiresAt.After(time.Now())
+// isPermissionsExpired checks permission expiry, tolerating clock skew up to leeway.
+func (cookieData *AuthSessionCookieData) isPermissionsExpired(leeway time.Duration) bool {
+	return !cookieData.PermissionsExpiresAt.Add(leeway).After(time.Now())
 }
 
 // GetPermissions lists the permissions of the current user and client.
@@ -63,14 +96,78 @@ func (cookieData *AuthSessionCookieData) GetPermissions() []string {
 }
 
 // HasPermission checks if the current user has such permission.
+// Granted permissions may contain wildcards, see MatchPermission for the matching rules.
 func (cookieData *AuthSessionCookieData) HasPermission(permission string) bool {
-	return cookieData.Permissions.Contain(permission)
+	return cookieData.Permissions.Has(permission)
+}
+
+// HasAllPermissions checks if the current user has all the permissions, see HasPermission.
+func (cookieData *AuthSessionCookieData) HasAllPermissions(permissions ...string) bool {
+	return cookieData.Permissions.HasAll(permissions...)
+}
+
+// HasAnyPermission checks if the current user has any of the permissions, see HasPermission.
+func (cookieData *AuthSessionCookieData) HasAnyPermission(permissions ...string) bool {
+	return cookieData.Permissions.HasAny(permissions...)
 }
 
 type AuthSessionData struct {
-	UserID   string
+	UserID   string // the impersonated user during impersonation
 	ClientID string
+	ActorID  string // the real user during impersonation, see Impersonate
+	AAL      AAL    // see RequireAALF
 	*AuthSessionCookieData
+
+	// Profile of the user, filled from extra data of token introspection, see ExtraKeyRoles and SetClaimsMapper.
+	Profile
+	Extra map[string]interface{} // all extra data returned by IntrospectTokenFunc
+}
+
+// NewAuthSessionData creates session data which is not from cookie or OAuth token introspection,
+// e.g. for alternative authentication methods. The permissions are valid until permissionsExpiresAt.
+func NewAuthSessionData(userID string, clientID string, token *oauth2.Token, permissions []string, permissionsExpiresAt time.Time) *AuthSessionData {
+	return &AuthSessionData{
+		UserID:   userID,
+		ClientID: clientID,
+		AAL:      AAL1,
+		AuthSessionCookieData: &AuthSessionCookieData{
+			Token:                token,
+			Permissions:          NewPermissionSet(permissions),
+			PermissionsExpiresAt: permissionsExpiresAt,
+			SessionCreatedAt:     time.Now(),
+		},
+	}
+}
+
+// Clone copies the session data, so the copy can be changed without affecting the session data.
+// Session data is owned by its request and isn't safe for concurrent changes,
+// so it should be cloned before being shared with other goroutines, e.g. cached by an Authenticator.
+func (data *AuthSessionData) Clone() *AuthSessionData {
+	dataCopy := *data
+	if data.AuthSessionCookieData != nil {
+		cookieData := *data.AuthSessionCookieData
+		if cookieData.Token != nil {
+			token := *cookieData.Token
+			cookieData.Token = &token
+		}
+		cookieData.Permissions = cookieData.Permissions.Clone()
+		if cookieData.Values != nil {
+			cookieData.Values = make(map[string]json.RawMessage, len(data.Values))
+			for k, v := range data.Values {
+				cookieData.Values[k] = v
+			}
+		}
+		dataCopy.AuthSessionCookieData = &cookieData
+	}
+	dataCopy.Roles = append([]string(nil), data.Roles...)
+	dataCopy.Groups = append([]string(nil), data.Groups...)
+	if data.Extra != nil {
+		dataCopy.Extra = make(map[string]interface{}, len(data.Extra))
+		for k, v := range data.Extra {
+			dataCopy.Extra[k] = v
+		}
+	}
+	return &dataCopy
 }
 
 // GetUserID get user ID of the current user session.
@@ -83,12 +180,38 @@ func (data *AuthSessionData) GetClientID() string {
 	return data.ClientID
 }
 
+// HasRole checks if the user has the role.
+func (data *AuthSessionData) HasRole(role string) bool {
+	for _, r := range data.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// InGroup checks if the user is a member of the group.
+func (data *AuthSessionData) InGroup(group string) bool {
+	for _, g := range data.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
 // GetRequestSessionData get session data from request context.
 func GetRequestSessionData(r *http.Request) (*AuthSessionData, bool) {
 	sessionData, ok := r.Context().Value(contextKeySessionData).(*AuthSessionData)
 	return sessionData, ok
 }
 
+// IsAuthenticated checks if the request of the context is authenticated, e.g. by MaybeSecuredF.
+func IsAuthenticated(ctx context.Context) bool {
+	sessionData, _ := ctx.Value(contextKeySessionData).(*AuthSessionData)
+	return sessionData != nil
+}
+
 // AttachRequestWithSessionData append session data into request context.
 func AttachRequestWithSessionData(r *http.Request, sessionData *AuthSessionData) *http.Request {
 	contextWithSessionData := context.WithValue(r.Context(), contextKeySessionData, sessionData)
@@ -101,6 +224,22 @@ func AttachRequestWithSessionData(r *http.Request, sessionData *AuthSessionData)
 type CookieConfig struct {
 	AuthenticationKey string `yaml:"authentication_key" env:"akey"`
 	EncryptionKey     string `yaml:"encryption_key" env:"ekey"`
+
+	// PreviousKeys are rotated out keys, newest first. Cookies encoded with them are still accepted,
+	// while new cookies are always encoded with AuthenticationKey and EncryptionKey.
+	PreviousKeys []CookieKeyPair `yaml:"previous_keys"`
+
+	// Format is the format of the session cookie, CookieFormatSecureCookie if empty. With CookieFormatJSONHMAC,
+	// the session cookie is signed as HMACCookieCodec by the keys of HMACCookieKey of the authentication keys,
+	// so services in other languages validate sessions, while cookies of the previous format are still accepted.
+	// The refresh token isn't saved into the session cookie of CookieFormatJSONHMAC.
+	Format string `yaml:"format" env:"cookie_format"`
+}
+
+// CookieKeyPair is a pair of cookie keys, in the same encoding as CookieConfig.
+type CookieKeyPair struct {
+	AuthenticationKey string `yaml:"authentication_key"`
+	EncryptionKey     string `yaml:"encryption_key"`
 }
 
 // OAuthConfig is a config of osecure.
@@ -108,16 +247,219 @@ type OAuthConfig struct {
 	ClientID     string   `yaml:"client_id" env:"client_id"`
 	ClientSecret string   `yaml:"client_secret" env:"client_secret"`
 	Scopes       []string `yaml:"scopes" env:"scopes"`
+
+	// PrefetchPermissions stores the permissions fetched in CallbackView into the cookie,
+	// so the first protected request after login doesn't fetch them again.
+	PrefetchPermissions bool `yaml:"prefetch_permissions" env:"prefetch_permissions"`
+
+	// AcceptedAudiences are client IDs accepted as audience of tokens besides ClientID.
+	AcceptedAudiences []string `yaml:"accepted_audiences" env:"accepted_audiences"`
+
+	// Issuer is the expected issuer of tokens. If set, tokens whose "iss" extra data differs are rejected.
+	Issuer string `yaml:"issuer" env:"issuer"`
+
+	// SessionExpireTime is the session lifetime for tokens without expiry, DefaultSessionExpireTime if zero.
+	// PermissionExpireTime is how long permissions are cached in the cookie, DefaultPermissionExpireTime if zero.
+	SessionExpireTime    time.Duration `yaml:"session_expire_time" env:"session_expire_time"`
+	PermissionExpireTime time.Duration `yaml:"permission_expire_time" env:"permission_expire_time"`
+
+	// SlidingSession extends the session expiry by SessionExpireTime on each authorized request,
+	// until SessionMaxLifetime (DefaultSessionMaxLifetime if zero) after login.
+	SlidingSession     bool          `yaml:"sliding_session" env:"sliding_session"`
+	SessionMaxLifetime time.Duration `yaml:"session_max_lifetime" env:"session_max_lifetime"`
+
+	// AuthCodeParams are extra parameters of authorization requests, e.g. access_type: offline for Google
+	// or domain_hint for Azure AD, and ExchangeParams are extra parameters of token requests,
+	// e.g. audience or resource. They can be overridden per request by WithAuthCodeOptions and WithExchangeOptions.
+	AuthCodeParams map[string]string `yaml:"auth_code_params"`
+	ExchangeParams map[string]string `yaml:"exchange_params"`
+
+	// PushedAuthorizationRequestEndpoint is the PAR endpoint (RFC 9126) of the auth server. If set,
+	// parameters of authorization requests are pushed to it, and users are redirected with the request URI.
+	PushedAuthorizationRequestEndpoint string `yaml:"pushed_authorization_request_endpoint" env:"pushed_authorization_request_endpoint"`
+
+	// PKCE sends the S256 code challenge (RFC 7636) in authorization requests, with the code verifier kept in a cookie.
+	PKCE bool `yaml:"pkce" env:"pkce"`
+
+	// ComplianceProfile is the security profile to enforce, e.g. ComplianceProfileFAPI2, which implies PKCE.
+	ComplianceProfile ComplianceProfile `yaml:"compliance_profile" env:"compliance_profile"`
+
+	// Resources are URIs of the APIs the tokens are requested for (RFC 8707 resource indicators),
+	// see RequireResourceF for checking the audience of tokens per route.
+	Resources []string `yaml:"resources" env:"resources"`
+
+	// EndSessionEndpoint is the RP-initiated logout endpoint of OpenID provider.
+	// If set, LogOut also terminates the session of the provider.
+	EndSessionEndpoint string `yaml:"end_session_endpoint" env:"end_session_endpoint"`
+
+	// ClientBinding binds the auth cookie to attributes of the client, see ClientBindingStrict etc.
+	// Cookies presented by a client with different attributes are rejected, mitigating stolen cookie replay.
+	ClientBinding string `yaml:"client_binding" env:"client_binding"`
+
+	// RequireCertificateBoundTokens rejects bearer tokens which are not bound to the mTLS client certificate (RFC 8705).
+	// Tokens with "x5t#S256" confirmation are always checked against the client certificate.
+	RequireCertificateBoundTokens bool `yaml:"require_certificate_bound_tokens" env:"require_certificate_bound_tokens"`
+
+	// MinimalCookie keeps only the session ID in the cookie, storing the token and permissions in the session store,
+	// so cookies are tiny and token material never reaches the browser. It requires SetSessionStore.
+	// Sessions are only created by CallbackView, requests with bearer tokens don't get cookies.
+	MinimalCookie bool `yaml:"minimal_cookie" env:"minimal_cookie"`
+
+	// MaxSessionsPerSubject limits simultaneous sessions of a subject, unlimited if zero. It requires SetSessionStore.
+	// SessionLimitPolicy decides what happens when the limit is reached, SessionLimitEvictOldest by default.
+	MaxSessionsPerSubject int    `yaml:"max_sessions_per_subject" env:"max_sessions_per_subject"`
+	SessionLimitPolicy    string `yaml:"session_limit_policy" env:"session_limit_policy"`
+
+	// NegativeCacheTTL caches introspection failures of tokens for the duration, disabled if zero,
+	// so clients replaying dead tokens don't cause an introspection call per request.
+	// NegativeCacheSize is the max number of cached failures, DefaultNegativeCacheSize if zero.
+	NegativeCacheTTL  time.Duration `yaml:"negative_cache_ttl" env:"negative_cache_ttl"`
+	NegativeCacheSize int           `yaml:"negative_cache_size" env:"negative_cache_size"`
+
+	// PermissionRefreshAhead refreshes permissions in background when they expire within the duration,
+	// serving the cached permissions meanwhile, so users don't wait for fetching permissions. Disabled if zero.
+	PermissionRefreshAhead time.Duration `yaml:"permission_refresh_ahead" env:"permission_refresh_ahead"`
+
+	// MaxRetries is the max number of retries of transient failures (5xx, timeouts etc.) of the code exchange,
+	// introspection and permission fetches, with exponential backoff from RetryBackoff up to RetryMaxBackoff.
+	// Retries are disabled if zero.
+	MaxRetries      int           `yaml:"max_retries" env:"max_retries"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff" env:"retry_backoff"`         // DefaultRetryBackoff if zero
+	RetryMaxBackoff time.Duration `yaml:"retry_max_backoff" env:"retry_max_backoff"` // DefaultRetryMaxBackoff if zero
+
+	// MaxTokenLength, MaxCookieSize and MaxCallbackQuerySize limit the length of bearer tokens, the auth cookie,
+	// and the query (or form body) of callback requests, rejecting oversized input before parsing it.
+	// DefaultMaxTokenLength etc. if zero.
+	MaxTokenLength       int `yaml:"max_token_length" env:"max_token_length"`
+	MaxCookieSize        int `yaml:"max_cookie_size" env:"max_cookie_size"`
+	MaxCallbackQuerySize int `yaml:"max_callback_query_size" env:"max_callback_query_size"`
+
+	// RedirectStatusCode is the status code of auth redirects, e.g. to the provider, the login page and back to
+	// the continue URI after login: 302, 303 or 307, DefaultRedirectStatusCode if zero. RedirectInterstitial
+	// redirects by a small page submitting a form by itself instead, e.g. for browsers which don't send cookies
+	// on cross-site redirects.
+	RedirectStatusCode   int  `yaml:"redirect_status_code" env:"redirect_status_code"`
+	RedirectInterstitial bool `yaml:"redirect_interstitial" env:"redirect_interstitial"`
+
+	// MaxSessionValuesSize limits the total size of keys and JSON values of SetSessionValue,
+	// DefaultMaxSessionValuesSize if zero, so application data doesn't push the cookie over the size limit.
+	MaxSessionValuesSize int `yaml:"max_session_values_size" env:"max_session_values_size"`
+
+	// RememberMeLifetime is the lifetime of remember-me tokens, DefaultRememberMeLifetime if zero, see SetRememberMeStore.
+	RememberMeLifetime time.Duration `yaml:"remember_me_lifetime" env:"remember_me_lifetime"`
+
+	// ClockSkew is the leeway applied to token and permission expiry checks,
+	// tolerating clock drift between the servers and the OAuth provider.
+	ClockSkew time.Duration `yaml:"clock_skew" env:"clock_skew"`
+
+	// SSOCookieDomain issues the session cookie at the parent domain, e.g. "example.com", so services of its
+	// subdomains (app.example.com, admin.example.com) share the session without separate logins. The services must
+	// share the session name and the cookie keys. Other cookies, e.g. the state cookie, are still host-only.
+	// SSOTrustedAudiences are client IDs of the other services, whose sessions are accepted from the session cookie,
+	// while bearer tokens of them are still rejected unless in AcceptedAudiences.
+	SSOCookieDomain     string   `yaml:"sso_cookie_domain" env:"sso_cookie_domain"`
+	SSOTrustedAudiences []string `yaml:"sso_trusted_audiences" env:"sso_trusted_audiences"`
+
+	// ExternalURL is the base URL of this service seen by browsers, e.g. "https://admin.example.com", whose scheme
+	// and host make absolute URLs of requests, e.g. the continue URL of verifier-only sessions, behind proxies which
+	// terminate TLS or change the host. The scheme and host of requests are used if empty.
+	ExternalURL string `yaml:"external_url" env:"external_url"`
 }
 
+// AudienceValidator checks if the client ID (audience of token) is accepted.
+type AudienceValidator func(clientID string) bool
+
 type OAuthEndpoint oauth2.Endpoint
 
 type OAuthSession struct {
-	name          string
-	cookieStore   *sessions.CookieStore
-	client        *oauth2.Config
-	tokenVerifier *TokenVerifier
-	stateHandler  StateHandler
+	name                 string
+	cookieStore          atomic.Value // *sessions.CookieStore, replaced by ReloadKeys
+	cookieFormat         string       // of CookieConfig, kept by ReloadKeys
+	cookieCodec          CookieCodec
+	ssoCookieDomain      string
+	ssoTrustedAudiences  StringSet
+	externalURL          *url.URL  // of OAuthConfig.ExternalURL, nil if not set
+	issuerLoginURL       string    // of verifier-only sessions, see NewVerifierSession
+	satelliteHosts       StringSet // see SetSatelliteHosts
+	loginRequiredFlash   string    // see SetLoginRequiredFlash
+	unauthorizedHandler  ErrorResponseHandler
+	forbiddenHandler     ErrorResponseHandler
+	loginRequiredHandler ErrorResponseHandler
+	client               atomic.Value // *oauth2.Config, replaced by ReloadKeys
+	clientID             string
+	tokenVerifier        *TokenVerifier
+	stateHandler         StateHandler
+	prefetchPermissions  bool
+	acceptedAudiences    StringSet
+	audienceValidator    AudienceValidator
+	issuer               string
+	clockSkew            time.Duration
+	sessionExpireTime    time.Duration
+	permissionExpireTime time.Duration
+	slidingSession       bool
+	sessionMaxLifetime   time.Duration
+	endSessionEndpoint   string
+	sessionStore         SessionStore
+	revocationList       RevocationList
+	clientBinding        string
+	authenticators       []Authenticator
+	dpopValidator        *DPoPValidator
+	minimalCookie        bool
+
+	requireCertificateBoundTokens bool
+	permissionInvalidationList    RevocationList
+	claimsMapper                  ClaimsMapper
+	rateLimiter                   *RateLimiter
+	bruteForceDetector            *BruteForceDetector
+	loginPath                     string // see SetLoginPage
+	loginTemplate                 *template.Template
+	resumePath                    string // see SetDeepLinkPreservation
+	resumeTemplate                *template.Template
+	maxPreservedBodySize          int
+	loginProviders                []LoginProvider
+	onLogin                       LoginHook
+	onAuthorize                   AuthorizeHook
+	canImpersonate                CanImpersonateFunc
+	negativeCache                 *negativeCache
+	introspectionCache            *TieredCache
+	introspectionGroup            singleflight.Group
+	permissionGroup               singleflight.Group
+	permissionRefreshAhead        time.Duration
+	refreshedPermissions          refreshedPermissionsCache
+	circuitBreaker                *CircuitBreaker
+	maxRetries                    int
+	retryBackoff                  time.Duration
+	retryMaxBackoff               time.Duration
+	secondFactorPath              string // see SetSecondFactor
+	mfaEnforcer                   MFAEnforcer
+	acrLevels                     map[string]AAL
+	rememberMeStore               RememberMeStore
+	rememberMeLifetime            time.Duration
+	maxSessionsPerSubject         int
+	sessionLimitPolicy            string
+	networkPolicy                 NetworkPolicy
+	clientIPFunc                  ClientIPFunc
+	anomalyDetector               *AnomalyDetector
+	maxTokenLength                int
+	maxCookieSize                 int
+	maxSessionValuesSize          int
+	redirectStatusCode            int
+	redirectInterstitial          bool
+	maxCallbackQuerySize          int
+	authCodeParams                []oauth2.AuthCodeOption
+	exchangeParams                []oauth2.AuthCodeOption
+	resources                     []string
+	requestObjectSigner           *RequestObjectSigner
+	signedURLReplayCache          ReplayCache
+	tokenExtractors               []TokenExtractor
+	clientAssertionSigner         *ClientAssertionSigner
+	tenant                        string // set by MultiTenantSession
+	auditSink                     AuditSink
+	onAuditError                  func(error)
+
+	pushedAuthorizationRequestEndpoint string
+	pkce                               bool
+	complianceProfile                  ComplianceProfile
 }
 
 // NewOAuthSession creates osecure session.
@@ -130,13 +472,121 @@ func NewOAuthSession(name string, cookieConf *CookieConfig, oauthConf *OAuthConf
 		RedirectURL:  callbackURL,
 	}
 
-	return &OAuthSession{
-		name:          name,
-		cookieStore:   newCookieStore(cookieConf),
-		client:        client,
-		tokenVerifier: tokenVerifier,
-		stateHandler:  stateHandler,
+	s := &OAuthSession{
+		name:                 name,
+		clientID:             oauthConf.ClientID,
+		tokenVerifier:        tokenVerifier,
+		stateHandler:         stateHandler,
+		prefetchPermissions:  oauthConf.PrefetchPermissions,
+		acceptedAudiences:    NewStringSet(oauthConf.AcceptedAudiences),
+		issuer:               oauthConf.Issuer,
+		clockSkew:            oauthConf.ClockSkew,
+		sessionExpireTime:    durationOrDefault(oauthConf.SessionExpireTime, DefaultSessionExpireTime),
+		permissionExpireTime: durationOrDefault(oauthConf.PermissionExpireTime, DefaultPermissionExpireTime),
+		slidingSession:       oauthConf.SlidingSession,
+		sessionMaxLifetime:   durationOrDefault(oauthConf.SessionMaxLifetime, DefaultSessionMaxLifetime),
+		endSessionEndpoint:   oauthConf.EndSessionEndpoint,
+		clientBinding:        oauthConf.ClientBinding,
+		minimalCookie:        oauthConf.MinimalCookie,
+		ssoCookieDomain:      strings.TrimPrefix(oauthConf.SSOCookieDomain, "."),
+		ssoTrustedAudiences:  NewStringSet(oauthConf.SSOTrustedAudiences),
+
+		requireCertificateBoundTokens: oauthConf.RequireCertificateBoundTokens,
+		negativeCache:                 newNegativeCache(oauthConf.NegativeCacheTTL, oauthConf.NegativeCacheSize),
+		permissionRefreshAhead:        oauthConf.PermissionRefreshAhead,
+		maxRetries:                    oauthConf.MaxRetries,
+		retryBackoff:                  oauthConf.RetryBackoff,
+		retryMaxBackoff:               oauthConf.RetryMaxBackoff,
+		rememberMeLifetime:            durationOrDefault(oauthConf.RememberMeLifetime, DefaultRememberMeLifetime),
+		maxSessionsPerSubject:         oauthConf.MaxSessionsPerSubject,
+		sessionLimitPolicy:            oauthConf.SessionLimitPolicy,
+		maxTokenLength:                intOrDefault(oauthConf.MaxTokenLength, DefaultMaxTokenLength),
+		maxCookieSize:                 intOrDefault(oauthConf.MaxCookieSize, DefaultMaxCookieSize),
+		maxSessionValuesSize:          intOrDefault(oauthConf.MaxSessionValuesSize, DefaultMaxSessionValuesSize),
+		redirectStatusCode:            intOrDefault(oauthConf.RedirectStatusCode, DefaultRedirectStatusCode),
+		redirectInterstitial:          oauthConf.RedirectInterstitial,
+		maxCallbackQuerySize:          intOrDefault(oauthConf.MaxCallbackQuerySize, DefaultMaxCallbackQuerySize),
+		authCodeParams:                authParamOptions(oauthConf.AuthCodeParams),
+		exchangeParams:                authParamOptions(oauthConf.ExchangeParams),
+		resources:                     oauthConf.Resources,
+
+		pushedAuthorizationRequestEndpoint: oauthConf.PushedAuthorizationRequestEndpoint,
+		pkce:                               oauthConf.PKCE || oauthConf.ComplianceProfile == ComplianceProfileFAPI2,
+		complianceProfile:                  oauthConf.ComplianceProfile,
+	}
+	if oauthConf.ExternalURL != "" {
+		s.externalURL, _ = url.Parse(oauthConf.ExternalURL)
 	}
+	s.cookieStore.Store(newCookieStore(cookieConf))
+	s.cookieFormat = CookieFormatSecureCookie
+	if cookieConf != nil && cookieConf.Format != "" {
+		s.cookieFormat = cookieConf.Format
+	}
+	s.client.Store(client)
+	return s
+}
+
+func (s *OAuthSession) getCookieStore() *sessions.CookieStore {
+	return s.cookieStore.Load().(*sessions.CookieStore)
+}
+
+func (s *OAuthSession) getClient() *oauth2.Config {
+	return s.client.Load().(*oauth2.Config)
+}
+
+func durationOrDefault(d time.Duration, defaultDuration time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return defaultDuration
+}
+
+// SetAudienceValidator replaces the audience check of tokens,
+// which accepts ClientID and AcceptedAudiences by default.
+// It should be called before serving requests.
+func (s *OAuthSession) SetAudienceValidator(validator AudienceValidator) {
+	s.audienceValidator = validator
+}
+
+func (s *OAuthSession) newAuthSessionCookieData(token *oauth2.Token) *AuthSessionCookieData {
+	now := time.Now()
+	cookieData := &AuthSessionCookieData{
+		Token:                token,
+		Permissions:          NewPermissionSet(nil),
+		PermissionsExpiresAt: time.Time{}, // Zero time
+		SessionCreatedAt:     now,
+		Tenant:               s.tenant,
+	}
+
+	if s.slidingSession {
+		cookieData.SessionExpiresAt = now.Add(s.sessionExpireTime)
+		if token.Expiry.IsZero() {
+			token.Expiry = now.Add(s.sessionMaxLifetime)
+		}
+	} else if token.Expiry.IsZero() {
+		token.Expiry = now.Add(s.sessionExpireTime)
+	}
+
+	return cookieData
+}
+
+// slideSession extends the session expiry in sliding session mode, returns true if it's extended.
+func (s *OAuthSession) slideSession(data *AuthSessionData) bool {
+	if !s.slidingSession || data.SessionExpiresAt.IsZero() {
+		return false
+	}
+
+	expiresAt := time.Now().Add(s.sessionExpireTime)
+	maxExpiresAt := data.SessionCreatedAt.Add(s.sessionMaxLifetime)
+	if expiresAt.After(maxExpiresAt) {
+		expiresAt = maxExpiresAt
+	}
+	if expiresAt.Sub(data.SessionExpiresAt) < slidingSessionRefreshInterval {
+		return false
+	}
+
+	data.SessionExpiresAt = expiresAt
+	return true
 }
 
 func (s *OAuthSession) isServiceAccount(userID, clientID string) bool {
@@ -144,30 +594,75 @@ func (s *OAuthSession) isServiceAccount(userID, clientID string) bool {
 }
 
 func (s *OAuthSession) isValidClientID(clientID string) bool {
-	return clientID == s.client.ClientID
+	if s.audienceValidator != nil {
+		return s.audienceValidator(clientID)
+	}
+	return clientID == s.clientID || s.acceptedAudiences.Contain(clientID)
+}
+
+func (s *OAuthSession) isValidIssuer(extra map[string]interface{}) bool {
+	if s.issuer == "" {
+		return true
+	}
+	issuer, _ := extra[ExtraKeyIssuer].(string)
+	return issuer == s.issuer
 }
 
 func (s *OAuthSession) getAuthSessionDataFromRequest(r *http.Request) (*AuthSessionData, bool, error) {
 	var accessToken string
 	var isTokenFromAuthorizationHeader bool
+	var isDPoP bool
 
-	cookieData := s.retrieveAuthCookie(r)
-	if cookieData == nil || cookieData.isTokenExpired() {
-		var err error
-		accessToken, err = s.getBearerToken(r)
+	// failures of bearer tokens count for rate limiting
+	fail := func(userID string, err error) error {
+		if isTokenFromAuthorizationHeader {
+			return &verificationFailure{UserID: userID, Err: err}
+		}
+		return err
+	}
+
+	cookieData, err := s.loadAuthCookie(r)
+	if cookieData == nil || cookieData.isTokenExpired(s.clockSkew) || cookieData.isSessionExpired(s.clockSkew) {
+		cookieErr := err
+		accessToken, isDPoP, err = s.extractToken(r)
 		if err != nil {
 			return nil, false, err
 		}
+		if accessToken == "" {
+			// report why the cookie isn't accepted
+			switch {
+			case cookieErr != nil:
+				return nil, false, cookieErr
+			case cookieData != nil && cookieData.isSessionExpired(s.clockSkew):
+				return nil, false, ErrorSessionExpired
+			case cookieData != nil:
+				return nil, false, ErrorTokenExpired
+			}
+			return nil, false, ErrorInvalidAuthorizationSyntax
+		}
 
 		isTokenFromAuthorizationHeader = true
+
+		err = s.checkIPRateLimit(r)
+		if err != nil {
+			return nil, false, err
+		}
 	} else {
 		accessToken = cookieData.Token.AccessToken
 		isTokenFromAuthorizationHeader = false
 	}
 
-	userID, clientID, expiresAt, extra, err := s.tokenVerifier.IntrospectTokenFunc(r.Context(), accessToken)
+	userID, clientID, expiresAt, extra, err := s.introspectToken(r.Context(), accessToken)
 	if err != nil {
-		return nil, false, WrapError(ErrorStringCannotIntrospectToken, err)
+		return nil, false, fail("", WrapError(ErrorStringCannotIntrospectToken, err))
+	}
+	userID, clientID, permissions, profile := s.mapClaims(userID, clientID, extra)
+
+	if isTokenFromAuthorizationHeader {
+		err = s.checkSubjectRateLimit(r.Context(), userID)
+		if err != nil {
+			return nil, false, err
+		}
 	}
 
 	// restore token extra data whenever token is new or retrieved from cookie
@@ -179,7 +674,8 @@ func (s *OAuthSession) getAuthSessionDataFromRequest(r *http.Request) (*AuthSess
 	}
 	token = token.WithExtra(extra)
 	if isTokenFromAuthorizationHeader {
-		cookieData = newAuthSessionCookieData(token)
+		cookieData = s.newAuthSessionCookieData(token)
+		s.bindClient(r, cookieData)
 	} else {
 		cookieData.Token = token
 	}
@@ -188,49 +684,120 @@ func (s *OAuthSession) getAuthSessionDataFromRequest(r *http.Request) (*AuthSess
 		UserID:                userID,
 		ClientID:              clientID,
 		AuthSessionCookieData: cookieData,
+		Profile:               profile,
+		Extra:                 extra,
+	}
+	if permissions != nil && data.ImpersonatedUserID == "" {
+		// permissions in claims are as fresh as the token
+		data.setPermissions(permissions)
+		data.PermissionsExpiresAt = token.Expiry
+	}
+
+	if !s.isValidClientID(data.ClientID) && !s.isServiceAccount(data.UserID, data.ClientID) &&
+		(isTokenFromAuthorizationHeader || !s.ssoTrustedAudiences.Contain(data.ClientID)) {
+		return nil, false, fail(userID, ErrorInvalidClientID)
+	}
+
+	if isTokenFromAuthorizationHeader {
+		err = s.checkSenderConstraint(extra)
+		if err != nil {
+			return nil, false, fail(userID, err)
+		}
+		err = s.checkCertificateBinding(r, extra)
+		if err != nil {
+			return nil, false, fail(userID, err)
+		}
+		err = s.checkDPoPBinding(r, accessToken, isDPoP, extra)
+		if err != nil {
+			return nil, false, fail(userID, err)
+		}
+	} else {
+		err = s.checkSessionRecord(r.Context(), cookieData)
+		if err != nil {
+			return nil, false, err
+		}
+		err = s.checkRevocationList(r.Context(), userID, cookieData)
+		if err != nil {
+			return nil, false, err
+		}
+		err = s.checkClientBinding(r, cookieData)
+		if err != nil {
+			return nil, false, err
+		}
+		err = s.checkCookieCertificateBinding(r, extra)
+		if err != nil {
+			return nil, false, err
+		}
+		err = s.checkCookieDPoPBinding(r, accessToken, extra)
+		if err != nil {
+			return nil, false, err
+		}
+		if cookieData.Tenant != s.tenant {
+			return nil, false, ErrorTenantMismatch
+		}
 	}
 
-	if !s.isValidClientID(data.ClientID) && !s.isServiceAccount(data.UserID, data.ClientID) {
-		return nil, false, ErrorInvalidClientID
+	if !s.isValidIssuer(extra) {
+		return nil, false, fail(userID, ErrorInvalidIssuer)
 	}
 
+	data.impersonate()
 	return data, isTokenFromAuthorizationHeader, nil
 }
 
 func (s *OAuthSession) ensurePermUpdated(ctx context.Context, data *AuthSessionData) (bool, error) {
-	if !data.isPermissionsExpired() {
-		return false, nil
+	if !data.isPermissionsExpired(s.clockSkew) {
+		isInvalidated, err := s.isPermissionsInvalidated(ctx, data)
+		if err != nil {
+			return false, WrapError(ErrorStringCannotGetPermission, err)
+		}
+		if !isInvalidated {
+			return s.refreshPermissionsAhead(data), nil
+		}
 	}
 
-	permissions, err := s.tokenVerifier.GetPermissionsFunc(ctx, data.UserID, data.ClientID, data.Token)
+	permissions, err := s.getPermissionsOnce(ctx, data.UserID, data.ClientID, data.Token)
 	if err != nil {
+		if len(data.Permissions) > 0 && s.circuitBreaker != nil && IsVerifierUnavailable(err) && s.circuitBreaker.isFailingOpen() {
+			// fail open by the stale permissions
+			return false, nil
+		}
 		return false, WrapError(ErrorStringCannotGetPermission, err)
 	}
 
-	data.Permissions = NewStringSet(permissions)
-	data.PermissionsExpiresAt = time.Now().Add(time.Duration(PermissionExpireTime) * time.Second)
+	data.setPermissions(permissions)
+	data.PermissionsExpiresAt = time.Now().Add(s.permissionExpireTime)
 
 	return true, nil
 }
 
 // Authorize authorize user by verifying cookie or bearer token.
 // if user is authorized, return valid session data. else, return error.
+// It's the same as VerifyAndRefresh.
 func (s *OAuthSession) Authorize(w http.ResponseWriter, r *http.Request) (*AuthSessionData, error) {
-	data, isTokenFromAuthorizationHeader, err := s.getAuthSessionDataFromRequest(r)
+	return s.VerifyAndRefresh(w, r)
+}
+
+// VerifyAndRefresh verifies the session cookie or bearer token of the request,
+// and saves refreshed permissions, extended sliding session and sessions of new bearer tokens into the cookie.
+func (s *OAuthSession) VerifyAndRefresh(w http.ResponseWriter, r *http.Request) (*AuthSessionData, error) {
+	data, err := s.verifyAuthenticators(r)
 	if err != nil {
-		return nil, WrapError(ErrorStringUnauthorized, err)
+		return nil, err
 	}
-	if data == nil || data.isTokenExpired() {
-		return nil, WrapError(ErrorStringUnauthorized, ErrorInvalidSession)
+	if data != nil {
+		return data, nil
 	}
 
-	var isPermissionUpdated bool
-	isPermissionUpdated, err = s.ensurePermUpdated(r.Context(), data)
+	r = s.restoreRememberMe(w, r)
+	data, isCookieDataModified, err := s.verifySession(r)
 	if err != nil {
 		return nil, err
 	}
 
-	isCookieDataModified := isTokenFromAuthorizationHeader || isPermissionUpdated
+	if s.slideSession(data) {
+		isCookieDataModified = true
+	}
 
 	if isCookieDataModified {
 		err = s.setAuthCookie(w, r, data.AuthSessionCookieData)
@@ -242,6 +809,89 @@ func (s *OAuthSession) Authorize(w http.ResponseWriter, r *http.Request) (*AuthS
 	return data, nil
 }
 
+// VerifyRequest verifies the request like Authorize, but never writes cookies,
+// so streaming handlers (e.g. Server-Sent Events) which have sent headers can check the session mid-stream.
+// It's the same as Verify.
+func (s *OAuthSession) VerifyRequest(r *http.Request) (*AuthSessionData, error) {
+	return s.Verify(r)
+}
+
+// Verify verifies the session cookie or bearer token of the request without a http.ResponseWriter,
+// so background jobs and interceptors can validate requests too. It never writes cookies,
+// permissions fetched again are not saved, and sliding sessions are not extended.
+func (s *OAuthSession) Verify(r *http.Request) (*AuthSessionData, error) {
+	data, err := s.verifyAuthenticators(r)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		return data, nil
+	}
+
+	data, _, err = s.verifySession(r)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifySession verifies the session cookie or bearer token and updates permissions,
+// returns if the cookie data is modified and should be saved.
+func (s *OAuthSession) verifySession(r *http.Request) (*AuthSessionData, bool, error) {
+	data, isCookieDataModified, err := s.verifyFirstFactor(r)
+	if err != nil {
+		return nil, false, err
+	}
+	if s.IsSecondFactorPending(data) {
+		return nil, false, WrapError(ErrorStringUnauthorized, ErrorSecondFactorRequired)
+	}
+	return data, isCookieDataModified, nil
+}
+
+// verifyFirstFactor verifies the session like verifySession, accepting sessions pending the second factor.
+func (s *OAuthSession) verifyFirstFactor(r *http.Request) (*AuthSessionData, bool, error) {
+	data, isTokenFromAuthorizationHeader, err := s.getAuthSessionDataFromRequest(r)
+	if err != nil {
+		if userID, isFailure := failedSubject(err); isFailure {
+			s.recordFailure(r, userID)
+		}
+		return nil, false, WrapError(ErrorStringUnauthorized, err)
+	}
+	if data == nil || data.isTokenExpired(s.clockSkew) || data.isSessionExpired(s.clockSkew) {
+		return nil, false, WrapError(ErrorStringUnauthorized, ErrorInvalidSession)
+	}
+	err = s.checkNetwork(r, data)
+	if err != nil {
+		return nil, false, err
+	}
+	isLastSeenUpdated := false
+	if isTokenFromAuthorizationHeader {
+		s.resetBruteForce(r, data.UserID)
+	} else {
+		isLastSeenUpdated, err = s.checkAnomalies(r, data)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	isPermissionUpdated, err := s.ensurePermUpdated(r.Context(), data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if isPermissionUpdated && !isTokenFromAuthorizationHeader {
+		s.audit(r, AuditEventPermissionsRefresh, data, nil)
+	}
+
+	data.AAL = s.assuranceLevel(data)
+
+	if isTokenFromAuthorizationHeader && isSenderConstrained(data.Extra) {
+		// sessions of sender-constrained tokens aren't saved into the cookie, which would skip proof-of-possession
+		return data, false, nil
+	}
+	return data, isTokenFromAuthorizationHeader || isPermissionUpdated || isLastSeenUpdated, nil
+}
+
 // SecuredF is a http middleware for http.HandlerFunc to check if the current user has logged in.
 func (s *OAuthSession) SecuredF(isAPI bool) func(http.HandlerFunc) http.HandlerFunc {
 	return func(h http.HandlerFunc) http.HandlerFunc {
@@ -249,21 +899,67 @@ func (s *OAuthSession) SecuredF(isAPI bool) func(http.HandlerFunc) http.HandlerF
 			sessionData, err := s.Authorize(w, r)
 			if err != nil {
 				switch {
+				case s.isServiceUnavailable(err):
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				case errors.Is(err, ErrorTooManyFailures):
+					http.Error(w, err.Error(), http.StatusTooManyRequests)
+				case errors.Is(err, ErrorNetworkNotAllowed):
+					s.forbidden(w, r, err)
 				case CompareErrorMessage(err, ErrorStringUnauthorized):
 					if isAPI {
-						http.Error(w, err.Error(), http.StatusUnauthorized)
+						s.unauthorized(w, r, err)
 					} else {
-						err = s.StartOAuth(w, r)
-						if err != nil {
-							http.Error(w, err.Error(), http.StatusInternalServerError)
-						}
+						s.loginRequired(w, r, err)
 					}
 				case CompareErrorMessage(err, ErrorStringCannotGetPermission):
-					http.Error(w, err.Error(), http.StatusForbidden)
+					s.audit(r, AuditEventAccessDenied, nil, err)
+					s.forbidden(w, r, err)
 				default:
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 				}
-			} else {
+			} else if s.runAuthorizeHook(w, r, sessionData) {
+				requestInner := AttachRequestWithSessionData(r, sessionData)
+				h(w, requestInner)
+			}
+		}
+	}
+}
+
+// RedirectToLogin redirects unauthenticated users to the login page, the issuer of verifier-only sessions,
+// or the provider directly, and then back to the request URI.
+func (s *OAuthSession) RedirectToLogin(w http.ResponseWriter, r *http.Request) {
+	r = s.preserveRequest(w, r)
+	if s.loginRequiredFlash != "" {
+		err := s.AddFlash(w, r, s.loginRequiredFlash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	switch {
+	case s.IsVerifierOnly():
+		s.redirect(w, r, s.issuerLoginRedirectURL(r))
+	case s.loginPath != "":
+		s.redirect(w, r, s.LoginURL("", r.RequestURI))
+	default:
+		err := s.StartOAuth(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// MaybeSecuredF is a http middleware for http.HandlerFunc to verify the session if present,
+// but lets requests through unauthenticated, without session data, if not or invalid.
+// Handlers can check it by IsAuthenticated, e.g. to render pages differently for guests.
+func (s *OAuthSession) MaybeSecuredF() func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			sessionData, err := s.Authorize(w, r)
+			if err != nil {
+				h(w, r)
+			} else if s.runAuthorizeHook(w, r, sessionData) {
 				requestInner := AttachRequestWithSessionData(r, sessionData)
 				h(w, requestInner)
 			}
@@ -271,6 +967,14 @@ func (s *OAuthSession) SecuredF(isAPI bool) func(http.HandlerFunc) http.HandlerF
 	}
 }
 
+// MaybeSecuredH is a http middleware for http.Handler to verify the session if present,
+// but lets requests through unauthenticated.
+func (s *OAuthSession) MaybeSecuredH() func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.Handler(s.MaybeSecuredF()(h.ServeHTTP))
+	}
+}
+
 // SecuredH is a http middleware for http.Handler to check if the current user has logged in.
 func (s *OAuthSession) SecuredH(isAPI bool) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
@@ -280,65 +984,194 @@ func (s *OAuthSession) SecuredH(isAPI bool) func(http.Handler) http.Handler {
 
 // StartOAuth redirect to endpoint of OAuth service provider for OAuth flow.
 func (s *OAuthSession) StartOAuth(w http.ResponseWriter, r *http.Request) error {
-	state, err := s.stateHandler.Generate(s.cookieStore, w, r)
+	if s.IsVerifierOnly() {
+		return ErrorVerifierOnlySession
+	}
+	state, err := s.stateHandler.Generate(s.getCookieStore(), w, r)
 	if err != nil {
 		return err
 	}
+	// the login is no longer for an abandoned step-up
+	s.takeStepUp(w, r)
 
-	http.Redirect(w, r, s.client.AuthCodeURL(state), http.StatusSeeOther)
+	authURL, err := s.authorizationURL(w, r, state)
+	if err != nil {
+		return err
+	}
+
+	s.redirect(w, r, authURL)
 	return nil
 }
 
 // EndOAuth finish OAuth flow.
 // it will verify state, exchange from authorization code to token, set cookie to make user logged in.
 func (s *OAuthSession) EndOAuth(w http.ResponseWriter, r *http.Request) (string, *oauth2.Token, error) {
+	if s.IsVerifierOnly() {
+		return "", nil, ErrorVerifierOnlySession
+	}
+	err := s.limitCallbackRequest(w, r)
+	if err != nil {
+		return "", nil, err
+	}
+
 	code := r.FormValue("code")
 	state := r.FormValue("state")
 
-	continueURI, err := s.stateHandler.Verify(s.cookieStore, w, r, state)
+	continueURI, err := s.stateHandler.Verify(s.getCookieStore(), w, r, state)
+	if err != nil {
+		return "", nil, WrapError(ErrorStringInvalidState, err)
+	}
+	verifierOpts, err := s.codeVerifierOptions(w, r)
 	if err != nil {
 		return "", nil, WrapError(ErrorStringInvalidState, err)
 	}
+	err = s.checkAuthorizationResponse(r)
+	if err != nil {
+		return "", nil, WrapError(ErrorStringNonCompliantResponse, err)
+	}
 
 	var token *oauth2.Token
-	token, err = s.client.Exchange(r.Context(), code)
+	err = s.retry(r.Context(), func() error {
+		var err error
+		token, err = s.getClient().Exchange(s.clientContext(r.Context()), code, append(s.exchangeOptions(r), verifierOpts...)...)
+		return RedactError(err, code)
+	})
 	if err != nil {
 		return "", nil, WrapError(ErrorStringFailedToExchangeAuthorizationCode, err)
 	}
+	err = s.checkTokenResponse(token)
+	if err != nil {
+		return "", nil, WrapError(ErrorStringNonCompliantResponse, err)
+	}
 
 	return continueURI, token, nil
 }
 
-func (s *OAuthSession) verifyAndSaveToken(w http.ResponseWriter, r *http.Request, token *oauth2.Token) error {
-	userID, clientID, _, _, err := s.tokenVerifier.IntrospectTokenFunc(r.Context(), token.AccessToken)
+// SaveToken verifies the token obtained without CallbackView, e.g. by native apps or in tests,
+// and saves it into the session cookie as if the user logged in.
+func (s *OAuthSession) SaveToken(w http.ResponseWriter, r *http.Request, token *oauth2.Token) error {
+	return s.verifyAndSaveToken(w, r, token, time.Time{})
+}
+
+// verifyAndSaveToken verifies the token and saves it into the session cookie.
+// stepUpAt is the time of StepUp if the token is of its callback, zero otherwise.
+func (s *OAuthSession) verifyAndSaveToken(w http.ResponseWriter, r *http.Request, token *oauth2.Token, stepUpAt time.Time) error {
+	var userID, clientID string
+	var extra map[string]interface{}
+	err := s.callVerifier(r.Context(), func() error {
+		var err error
+		userID, clientID, _, extra, err = s.tokenVerifier.IntrospectTokenFunc(r.Context(), token.AccessToken)
+		return RedactError(err, token.AccessToken)
+	})
 	if err != nil {
 		return WrapError(ErrorStringCannotIntrospectToken, err)
 	}
-	_, err = s.tokenVerifier.GetPermissionsFunc(r.Context(), userID, clientID, token)
+	err = s.checkSubjectRateLimit(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if !s.isValidIssuer(extra) {
+		return WrapError(ErrorStringCannotIntrospectToken, &verificationFailure{UserID: userID, Err: ErrorInvalidIssuer})
+	}
+	err = s.checkSenderConstraint(extra)
 	if err != nil {
-		return WrapError(ErrorStringCannotGetPermission, err)
+		return WrapError(ErrorStringCannotIntrospectToken, &verificationFailure{UserID: userID, Err: err})
+	}
+	if !stepUpAt.IsZero() {
+		err = s.checkStepUpAuthTime(extra, stepUpAt)
+		if err != nil {
+			return WrapError(ErrorStringNonCompliantResponse, &verificationFailure{UserID: userID, Err: err})
+		}
+	}
+	userID, clientID, permissions, profile := s.mapClaims(userID, clientID, extra)
+	if permissions == nil {
+		permissions, err = s.getPermissionsOnce(r.Context(), userID, clientID, token)
+		if err != nil {
+			return WrapError(ErrorStringCannotGetPermission, err)
+		}
+	}
+	cookie := s.newAuthSessionCookieData(token)
+	s.bindClient(r, cookie)
+	cookie.AuthTime = getExtraTime(extra, ExtraKeyAuthTime)
+	if cookie.AuthTime.IsZero() {
+		// the login has just completed, unlike StepUp which requires auth_time
+		cookie.AuthTime = time.Now()
+	}
+	if s.endSessionEndpoint != "" {
+		// id_token_hint of RP-initiated logout
+		cookie.IDToken, _ = token.Extra("id_token").(string)
+	}
+
+	// session data of the login, with the permissions even if they aren't kept in the cookie
+	loginCookie := *cookie
+	loginCookie.Permissions = NewPermissionSet(permissions)
+	loginData := &AuthSessionData{
+		UserID:                userID,
+		ClientID:              clientID,
+		AuthSessionCookieData: &loginCookie,
+		Profile:               profile,
+		Extra:                 extra,
+	}
+	if s.onLogin != nil {
+		err = s.onLogin(r.Context(), loginData)
+		if err != nil {
+			s.audit(r, AuditEventAccessDenied, loginData, err)
+			return WrapError(ErrorStringLoginRejected, err)
+		}
+	}
+
+	if s.sessionStore != nil {
+		err = s.registerSession(r.Context(), cookie, userID, clientID, getProviderSessionID(token))
+		if err != nil {
+			return WrapError(ErrorStringCannotSaveSession, err)
+		}
+	}
+	if s.prefetchPermissions {
+		// permissions are already known here, keep them so the next request doesn't fetch them again
+		cookie.setPermissions(permissions)
+		cookie.PermissionsExpiresAt = time.Now().Add(s.permissionExpireTime)
 	}
-	cookie := newAuthSessionCookieData(token)
 	err = s.setAuthCookie(w, r, cookie)
 	if err != nil {
 		return WrapError(ErrorStringUnableToSetCookie, err)
 	}
+	s.resetBruteForce(r, userID)
+	s.audit(r, AuditEventLogin, loginData, nil)
 	return nil
 }
 
 // CallbackView is a http handler for the authentication redirection of the auth server.
 func (s *OAuthSession) CallbackView(w http.ResponseWriter, r *http.Request) {
+	err := s.checkIPRateLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	stepUpAt := s.takeStepUp(w, r)
 	continueURI, token, err := s.EndOAuth(w, r)
 	statusCode := http.StatusOK
 	if err == nil {
-		err = s.verifyAndSaveToken(w, r, token)
+		err = s.verifyAndSaveToken(w, r, token, stepUpAt)
 	}
 	if err != nil {
+		s.audit(r, AuditEventLoginFailure, nil, err)
+		userID, _ := failedSubject(err)
+		s.recordFailure(r, userID)
 		switch {
+		case s.isServiceUnavailable(err):
+			statusCode = http.StatusServiceUnavailable
+		case errors.Is(err, ErrorTooManyFailures):
+			statusCode = http.StatusTooManyRequests
+		case CompareErrorMessage(err, ErrorStringLoginRejected), errors.Is(err, ErrorTooManySessions),
+			CompareErrorMessage(err, ErrorStringCannotGetPermission):
+			statusCode = http.StatusForbidden
+		case errors.Is(err, ErrorCallbackTooLarge):
+			statusCode = http.StatusRequestEntityTooLarge
 		case CompareErrorMessage(err, ErrorStringInvalidState):
 			fallthrough
 		case CompareErrorMessage(err, ErrorStringFailedToExchangeAuthorizationCode),
-			CompareErrorMessage(err, ErrorStringCannotGetPermission):
+			CompareErrorMessage(err, ErrorStringNonCompliantResponse):
 			statusCode = http.StatusBadRequest
 		default:
 			statusCode = http.StatusInternalServerError
@@ -351,11 +1184,43 @@ func (s *OAuthSession) CallbackView(w http.ResponseWriter, r *http.Request) {
 		qry.Add("error", err.Error())
 	}
 	uri.Fragment += "?" + qry.Encode()
-	http.Redirect(w, r, uri.String(), http.StatusSeeOther)
+	s.redirect(w, r, uri.String())
+}
+
+// getProviderSessionID reads "sid" of the ID token returned along with the token.
+// The ID token comes from the token endpoint directly, so its signature isn't verified here.
+func getProviderSessionID(token *oauth2.Token) string {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return ""
+	}
+	idToken, err := jwt.Parse(rawIDToken)
+	if err != nil {
+		return ""
+	}
+	return idToken.Claims.String("sid")
 }
 
 // ClearSession clear session.
 func (s *OAuthSession) ClearSession(w http.ResponseWriter, r *http.Request) error {
+	s.auditLogout(r)
+
+	cookieData := s.retrieveAuthCookie(r)
+	if cookieData != nil && cookieData.Token != nil {
+		s.invalidateIntrospection(r.Context(), cookieData.Token.AccessToken)
+	}
+
+	if s.sessionStore != nil && cookieData != nil && cookieData.SessionID != "" {
+		err := s.sessionStore.Delete(r.Context(), cookieData.SessionID)
+		if err != nil {
+			return WrapError(ErrorStringCannotSaveSession, err)
+		}
+	}
+
+	if s.rememberMeStore != nil {
+		s.forgetMe(w, r)
+	}
+
 	err := s.deleteAuthCookie(w, r)
 	if err != nil {
 		err = WrapError(ErrorStringUnableToSetCookie, err)
@@ -364,17 +1229,60 @@ func (s *OAuthSession) ClearSession(w http.ResponseWriter, r *http.Request) erro
 }
 
 // LogOut is a http handler to log out the user.
+// If EndSessionEndpoint is configured, the user is redirected to the provider to log out there too,
+// and comes back to redirect afterwards.
 func (s *OAuthSession) LogOut(redirect string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cookieData := s.retrieveAuthCookie(r)
+
 		err := s.ClearSession(w, r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else if s.endSessionEndpoint != "" {
+			s.redirect(w, r, s.endSessionURL(r, cookieData, redirect))
 		} else {
-			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			s.redirect(w, r, redirect)
 		}
 	}
 }
 
+func (s *OAuthSession) endSessionURL(r *http.Request, cookieData *AuthSessionCookieData, postLogoutRedirectURI string) string {
+	uri, err := url.Parse(s.endSessionEndpoint)
+	if err != nil {
+		return postLogoutRedirectURI
+	}
+
+	qry := uri.Query()
+	qry.Set("client_id", s.clientID)
+	if cookieData != nil && cookieData.IDToken != "" {
+		qry.Set("id_token_hint", cookieData.IDToken)
+	}
+	if postLogoutRedirectURI != "" {
+		qry.Set("post_logout_redirect_uri", s.absoluteURL(r, postLogoutRedirectURI))
+	}
+	uri.RawQuery = qry.Encode()
+
+	return uri.String()
+}
+
+// absoluteURL resolves uri against the URL of the request.
+func (s *OAuthSession) absoluteURL(r *http.Request, uri string) string {
+	ref, err := url.Parse(uri)
+	if err != nil || ref.IsAbs() {
+		return uri
+	}
+
+	base := &url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
+	if r.TLS != nil {
+		base.Scheme = "https"
+	}
+	if s.externalURL != nil {
+		base.Scheme = s.externalURL.Scheme
+		base.Host = s.externalURL.Host
+	}
+	return base.ResolveReference(ref).String()
+}
+
 func makeToken(tokenType string, accessToken string, expiresAt int64) *oauth2.Token {
 	return &oauth2.Token{
 		AccessToken: accessToken,
@@ -387,82 +1295,197 @@ func makeBearerToken(accessToken string, expiresAt int64) *oauth2.Token {
 	return makeToken("Bearer", accessToken, expiresAt)
 }
 
-func (s *OAuthSession) getBearerToken(r *http.Request) (string, error) {
+// getBearerToken gets the access token in Authorization header,
+// isDPoP is true if it's sent with DPoP scheme, which is accepted only if DPoP is enabled.
+func (s *OAuthSession) getBearerToken(r *http.Request) (token string, isDPoP bool, err error) {
 	authorizationHeaderValue := r.Header.Get("Authorization")
 
 	authorizationData := strings.SplitN(authorizationHeaderValue, " ", 2)
-	if len(authorizationData) != 2 {
-		return "", ErrorInvalidAuthorizationSyntax
+	if len(authorizationData) != 2 || authorizationData[0] == "" {
+		return "", false, ErrorInvalidAuthorizationSyntax
 	}
 
 	tokenType := authorizationData[0]
-	if !strings.EqualFold(tokenType, "bearer") {
-		return "", ErrorUnsupportedAuthorizationScheme
+	switch {
+	case strings.EqualFold(tokenType, "bearer"):
+		isDPoP = false
+	case s.dpopValidator != nil && strings.EqualFold(tokenType, DPoPScheme):
+		isDPoP = true
+	default:
+		return "", false, ErrorUnsupportedAuthorizationScheme
+	}
+
+	bearerToken := strings.TrimLeft(authorizationData[1], " ")
+	if len(bearerToken) > s.maxTokenLength {
+		return "", false, ErrorTokenTooLong
+	}
+	if !isToken68(bearerToken) {
+		return "", false, ErrorInvalidAuthorizationSyntax
 	}
+	return bearerToken, isDPoP, nil
+}
 
-	bearerToken := authorizationData[1]
-	return bearerToken, nil
+// isToken68 checks the syntax of credentials of RFC 7235 section 2.1, which bearer tokens follow,
+// rejecting tokens with spaces, control characters or non-ASCII bytes before they're passed to the verifier.
+func isToken68(token string) bool {
+	trimmed := strings.TrimRight(token, "=")
+	if trimmed == "" {
+		return false
+	}
+	for i := 0; i < len(trimmed); i++ {
+		c := trimmed[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		case c == '-' || c == '.' || c == '_' || c == '~' || c == '+' || c == '/':
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 func (s *OAuthSession) retrieveAuthCookie(r *http.Request) *AuthSessionCookieData {
-	session, err := s.cookieStore.Get(r, s.name)
+	cookieData, _ := s.loadAuthCookie(r)
+	return cookieData
+}
+
+// loadAuthCookie gets the cookie data, returning nil without error if there's no cookie,
+// or an error of ErrorCookieDecode if the cookie is invalid.
+func (s *OAuthSession) loadAuthCookie(r *http.Request) (*AuthSessionCookieData, error) {
+	if cookieData, ok := r.Context().Value(contextKeyRestoredCookieData).(*AuthSessionCookieData); ok {
+		return cookieData, nil
+	}
+
+	err := s.checkCookieSize(r, s.name)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.getAuthCookieStore().Get(r, s.name)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("%w: %v", ErrorCookieDecode, err)
+	}
+
+	if s.minimalCookie {
+		sessionID, _ := session.Values["sid"].(string)
+		if sessionID == "" {
+			return nil, nil
+		}
+		return s.loadSessionPayload(r.Context(), sessionID), nil
 	}
 
 	v, found := session.Values["auth"]
 	if !found {
-		return nil
+		return nil, nil
 	}
 
-	cookieData, ok := v.(*AuthSessionCookieData)
-	if !ok {
-		return nil
+	cookieData, err := deserializeAuthCookieData(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorCookieDecode, err)
 	}
 
-	return cookieData
+	return cookieData, nil
 }
 
 func (s *OAuthSession) setAuthCookie(w http.ResponseWriter, r *http.Request, cookieData *AuthSessionCookieData) error {
-	session, err := s.cookieStore.New(r, s.name)
+	session, err := s.getAuthCookieStore().New(r, s.name)
 	if err != nil {
 		return err
 	}
-	session.Values["auth"] = cookieData
+	if s.minimalCookie {
+		if s.sessionStore == nil {
+			return ErrorSessionStoreRequired
+		}
+		if cookieData.SessionID == "" {
+			// not logged in through CallbackView
+			return nil
+		}
+		err = s.saveSessionPayload(r.Context(), cookieData)
+		if err != nil {
+			return err
+		}
+		session.Values["sid"] = cookieData.SessionID
+	} else {
+		session.Values["auth"], err = serializeAuthCookieData(cookieData)
+		if err != nil {
+			return err
+		}
+	}
+	if s.rememberMeStore != nil {
+		// session cookie, the remember-me cookie persists
+		session.Options.MaxAge = 0
+	}
 	err = session.Save(r, w)
+	if err != nil && isCookieTooLarge(err) {
+		return ErrorCookieTooLarge
+	}
 	return err
 }
 
 func (s *OAuthSession) deleteAuthCookie(w http.ResponseWriter, r *http.Request) error {
-	session, err := s.cookieStore.Get(r, s.name)
+	session, err := s.getAuthCookieStore().Get(r, s.name)
 	if err != nil {
 		return err
 	}
 	delete(session.Values, "auth")
+	delete(session.Values, "sid")
 	session.Options.MaxAge = -1
 	err = session.Save(r, w)
 	return err
 }
 
 func newCookieStore(conf *CookieConfig) *sessions.CookieStore {
-	var authenticationKey, encryptionKey []byte
+	cookieStore, err := buildCookieStore(conf)
+	if err != nil {
+		panic(err)
+	}
+	return cookieStore
+}
 
-	if conf != nil {
-		var err error
+// buildCookieStore creates cookie store with codecs of the current keys followed by previous keys,
+// so cookies are encoded with the current keys and decoded with any of them.
+func buildCookieStore(conf *CookieConfig) (*sessions.CookieStore, error) {
+	if conf == nil {
+		return sessions.NewCookieStore(securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32)), nil
+	}
+
+	pairs := append([]CookieKeyPair{{AuthenticationKey: conf.AuthenticationKey, EncryptionKey: conf.EncryptionKey}}, conf.PreviousKeys...)
+	keyPairs := make([][]byte, 0, 2*len(pairs))
+	for i, pair := range pairs {
+		field := ""
+		if i > 0 {
+			field = fmt.Sprintf("previous_keys[%d].", i-1)
+		}
 
-		authenticationKey, err = base64.StdEncoding.DecodeString(conf.AuthenticationKey)
+		authenticationKey, err := base64.StdEncoding.DecodeString(pair.AuthenticationKey)
 		if err != nil {
-			panic(err)
+			return nil, &ConfigError{Field: field + "authentication_key", Reason: "not standard base64", Err: err}
 		}
 
-		encryptionKey, err = base64.StdEncoding.DecodeString(conf.EncryptionKey)
+		encryptionKey, err := base64.StdEncoding.DecodeString(pair.EncryptionKey)
 		if err != nil {
-			panic(err)
+			return nil, &ConfigError{Field: field + "encryption_key", Reason: "not standard base64", Err: err}
 		}
-	} else {
-		authenticationKey = securecookie.GenerateRandomKey(64)
-		encryptionKey = securecookie.GenerateRandomKey(32)
+
+		keyPairs = append(keyPairs, authenticationKey, encryptionKey)
 	}
 
-	return sessions.NewCookieStore(authenticationKey, encryptionKey)
+	cookieStore := sessions.NewCookieStore(keyPairs...)
+	if conf.Format == CookieFormatJSONHMAC {
+		hmacKeys := make([][]byte, 0, len(pairs))
+		for i := 0; i < len(keyPairs); i += 2 {
+			if len(keyPairs[i]) < 32 {
+				return nil, &ConfigError{Field: "format", Reason: "authentication keys must be at least 32 bytes", Err: ErrorInvalidCookieCodecKey}
+			}
+			hmacKeys = append(hmacKeys, HMACCookieKey(keyPairs[i]))
+		}
+		codec, err := NewHMACCookieCodec(hmacKeys...)
+		if err != nil {
+			return nil, &ConfigError{Field: "format", Reason: "authentication keys must be at least 32 bytes", Err: err}
+		}
+		// values of other cookies are rejected by the codec and encoded by the following codecs
+		cookieStore.Codecs = append([]securecookie.Codec{cookieCodecAdapter{codec: codec, omitRefreshToken: true}}, cookieStore.Codecs...)
+		// the payload isn't encrypted, which is kept from scripts of pages
+		cookieStore.Options.HttpOnly = true
+	}
+	return cookieStore, nil
 }
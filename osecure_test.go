@@ -0,0 +1,40 @@
+package osecure
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const testClientID = "client"
+
+func newTestCookieConfig() *CookieConfig {
+	return &CookieConfig{
+		AuthenticationKey: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32))),
+		EncryptionKey:     base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32))),
+	}
+}
+
+// newTestVerifier accepts any token as the token of the user of the same name, with the permissions.
+func newTestVerifier(permissions []string) *TokenVerifier {
+	return &TokenVerifier{
+		IntrospectTokenFunc: func(ctx context.Context, accessToken string) (string, string, int64, map[string]interface{}, error) {
+			return accessToken, testClientID, time.Now().Add(time.Hour).Unix(), nil, nil
+		},
+		GetPermissionsFunc: func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+			return permissions, nil
+		},
+	}
+}
+
+func newTestSession(tb testing.TB, verifier *TokenVerifier) *OAuthSession {
+	tb.Helper()
+	return NewOAuthSession("osecure", newTestCookieConfig(), &OAuthConfig{ClientID: testClientID}, OAuthEndpoint{
+		AuthURL:  "https://auth.example.com/authorize",
+		TokenURL: "https://auth.example.com/token",
+	}, verifier, "https://example.com/callback", nil)
+}
@@ -0,0 +1,200 @@
+// Package osecuretest provides utilities for testing handlers protected by osecure,
+// without standing up an OAuth provider.
+package osecuretest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+// ClientID is the client ID of sessions created by NewOAuthSession.
+const ClientID = "osecuretest"
+
+// DefaultTokenLifetime is the lifetime of tokens issued by FakeVerifier without ExpiresAt.
+const DefaultTokenLifetime = time.Hour
+
+var (
+	ErrorUnknownToken = errors.New("unknown token")
+)
+
+// Identity is the identity which an access token of FakeVerifier represents.
+type Identity struct {
+	UserID      string
+	ClientID    string // ClientID if empty
+	Permissions []string
+	ExpiresAt   time.Time // DefaultTokenLifetime after issued if zero
+	Extra       map[string]interface{}
+}
+
+// FakeVerifier is a token verifier which knows tokens issued by itself only.
+// It's safe for concurrent use.
+type FakeVerifier struct {
+	mu     sync.RWMutex
+	tokens map[string]Identity
+}
+
+// NewFakeVerifier creates a verifier without any token.
+func NewFakeVerifier() *FakeVerifier {
+	return &FakeVerifier{
+		tokens: make(map[string]Identity),
+	}
+}
+
+// AddToken makes the access token represent the identity.
+func (v *FakeVerifier) AddToken(accessToken string, identity Identity) {
+	if identity.ClientID == "" {
+		identity.ClientID = ClientID
+	}
+	if identity.ExpiresAt.IsZero() {
+		identity.ExpiresAt = time.Now().Add(DefaultTokenLifetime)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tokens[accessToken] = identity
+}
+
+// IssueToken issues a random access token for the identity.
+func (v *FakeVerifier) IssueToken(identity Identity) string {
+	accessToken := randomString()
+	v.AddToken(accessToken, identity)
+	return accessToken
+}
+
+// RevokeToken makes the access token invalid.
+func (v *FakeVerifier) RevokeToken(accessToken string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.tokens, accessToken)
+}
+
+// SetPermissions replaces permissions of the access token.
+func (v *FakeVerifier) SetPermissions(accessToken string, permissions []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if identity, found := v.tokens[accessToken]; found {
+		identity.Permissions = permissions
+		v.tokens[accessToken] = identity
+	}
+}
+
+func (v *FakeVerifier) lookup(accessToken string) (Identity, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	identity, found := v.tokens[accessToken]
+	if !found || !identity.ExpiresAt.After(time.Now()) {
+		return Identity{}, ErrorUnknownToken
+	}
+	return identity, nil
+}
+
+// IntrospectToken is an osecure.IntrospectTokenFunc.
+func (v *FakeVerifier) IntrospectToken(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+	identity, err := v.lookup(accessToken)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+
+	extra = make(map[string]interface{}, len(identity.Extra))
+	for key, value := range identity.Extra {
+		extra[key] = value
+	}
+	return identity.UserID, identity.ClientID, identity.ExpiresAt.Unix(), extra, nil
+}
+
+// GetPermissions is an osecure.GetPermissionsFunc.
+func (v *FakeVerifier) GetPermissions(ctx context.Context, userID string, clientID string, token *oauth2.Token) (permissions []string, err error) {
+	identity, err := v.lookup(token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), identity.Permissions...), nil
+}
+
+// TokenVerifier returns the osecure.TokenVerifier backed by v.
+func (v *FakeVerifier) TokenVerifier() *osecure.TokenVerifier {
+	return &osecure.TokenVerifier{
+		IntrospectTokenFunc: v.IntrospectToken,
+		GetPermissionsFunc:  v.GetPermissions,
+	}
+}
+
+// NewOAuthSession creates an OAuth session with random cookie keys, trusting tokens of the verifier.
+// The OAuth endpoints are not reachable, so the login flow can't be tested with it.
+func NewOAuthSession(verifier *FakeVerifier) *osecure.OAuthSession {
+	return osecure.NewOAuthSession(
+		"osecuretest",
+		nil,
+		&osecure.OAuthConfig{
+			ClientID: ClientID,
+		},
+		osecure.OAuthEndpoint{
+			AuthURL:  "http://osecuretest.invalid/authorize",
+			TokenURL: "http://osecuretest.invalid/token",
+		},
+		verifier.TokenVerifier(),
+		"http://osecuretest.invalid/auth/callback",
+		nil,
+	)
+}
+
+// MintCookie creates a valid session cookie of the access token, which is verified by the token verifier of s.
+func MintCookie(s *osecure.OAuthSession, accessToken string) (*http.Cookie, error) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	err := s.SaveToken(w, r, &oauth2.Token{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		return nil, osecure.ErrorInvalidSession
+	}
+	return cookies[0], nil
+}
+
+// Login issues an access token of the subject and permissions, and adds its session cookie to the request,
+// so the request passes protected handlers of s.
+func Login(s *osecure.OAuthSession, verifier *FakeVerifier, r *http.Request, userID string, permissions ...string) error {
+	accessToken := verifier.IssueToken(Identity{
+		UserID:      userID,
+		Permissions: permissions,
+	})
+
+	cookie, err := MintCookie(s, accessToken)
+	if err != nil {
+		return err
+	}
+	r.AddCookie(cookie)
+	return nil
+}
+
+// NewSessionData creates session data of the subject and permissions, valid for DefaultTokenLifetime.
+func NewSessionData(userID string, permissions ...string) *osecure.AuthSessionData {
+	expiresAt := time.Now().Add(DefaultTokenLifetime)
+	token := &oauth2.Token{
+		AccessToken: "osecuretest",
+		TokenType:   "Bearer",
+		Expiry:      expiresAt,
+	}
+	return osecure.NewAuthSessionData(userID, ClientID, token, permissions, expiresAt)
+}
+
+// WithTestSession attaches session data to the request, as if it passed SecuredF,
+// for testing handlers which read osecure.GetRequestSessionData directly.
+func WithTestSession(r *http.Request, data *osecure.AuthSessionData) *http.Request {
+	return osecure.AttachRequestWithSessionData(r, data)
+}
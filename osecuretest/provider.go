@@ -0,0 +1,402 @@
+package osecuretest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	osecure_contrib "github.com/rayark/osecure/v6/contrib"
+	"github.com/rayark/osecure/v6/jwt"
+	"golang.org/x/oauth2"
+)
+
+// Default client of Provider.
+const (
+	ProviderClientID     = "osecuretest-client"
+	ProviderClientSecret = "osecuretest-secret"
+)
+
+// Paths of Provider endpoints.
+const (
+	ProviderAuthorizePath     = "/authorize"
+	ProviderTokenPath         = "/token"
+	ProviderIntrospectionPath = "/introspect"
+	ProviderJWKSPath          = "/jwks"
+	ProviderDiscoveryPath     = "/.well-known/openid-configuration"
+)
+
+type providerGrant struct {
+	userID      string
+	clientID    string
+	redirectURI string
+	nonce       string
+	sessionID   string
+	scope       string
+	authTime    time.Time
+	expiresAt   time.Time
+}
+
+// Provider is an in-process OAuth 2.0 / OpenID Connect provider for integration tests.
+// Its authorization endpoint approves the login immediately, as the user set by SetLoginUser,
+// or the user given in "login_hint" parameter.
+type Provider struct {
+	Server *httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	// TokenLifetime is the lifetime of issued access tokens, DefaultTokenLifetime if zero.
+	TokenLifetime time.Duration
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu          sync.Mutex
+	users       map[string][]string // user ID -> permissions
+	loginUserID string
+	codes       map[string]*providerGrant
+	tokens      map[string]*providerGrant
+}
+
+// NewProvider starts a provider with a client of ProviderClientID and ProviderClientSecret.
+// It should be closed by Close after testing.
+func NewProvider() *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	p := &Provider{
+		ClientID:     ProviderClientID,
+		ClientSecret: ProviderClientSecret,
+		key:          key,
+		keyID:        randomString(),
+		users:        make(map[string][]string),
+		codes:        make(map[string]*providerGrant),
+		tokens:       make(map[string]*providerGrant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ProviderAuthorizePath, p.authorize)
+	mux.HandleFunc(ProviderTokenPath, p.token)
+	mux.HandleFunc(ProviderIntrospectionPath, p.introspect)
+	mux.HandleFunc(ProviderJWKSPath, p.jwks)
+	mux.HandleFunc(ProviderDiscoveryPath, p.discovery)
+	p.Server = httptest.NewServer(mux)
+
+	return p
+}
+
+// Close shuts down the provider.
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// Issuer is the issuer identifier of the provider.
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+// Endpoint is the OAuth endpoint of the provider.
+func (p *Provider) Endpoint() osecure.OAuthEndpoint {
+	return osecure.OAuthEndpoint{
+		AuthURL:  p.Server.URL + ProviderAuthorizePath,
+		TokenURL: p.Server.URL + ProviderTokenPath,
+	}
+}
+
+// OAuthConfig is the config of the provider's client.
+func (p *Provider) OAuthConfig() *osecure.OAuthConfig {
+	return &osecure.OAuthConfig{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Scopes:       []string{"openid"},
+		Issuer:       p.Issuer(),
+	}
+}
+
+// TokenVerifier introspects tokens by the introspection endpoint,
+// and gets permissions of users added by AddUser.
+func (p *Provider) TokenVerifier() *osecure.TokenVerifier {
+	return &osecure.TokenVerifier{
+		IntrospectTokenFunc: osecure_contrib.Introspection(p.Server.URL+ProviderIntrospectionPath, p.ClientID, p.ClientSecret),
+		GetPermissionsFunc:  p.GetPermissions,
+	}
+}
+
+// KeySet is the key set to verify ID tokens and logout tokens issued by the provider.
+func (p *Provider) KeySet() jwt.KeySet {
+	return jwt.StaticKeySet{p.keyID: &p.key.PublicKey}
+}
+
+// AddUser adds or updates the user with the permissions.
+func (p *Provider) AddUser(userID string, permissions ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users[userID] = permissions
+}
+
+// SetLoginUser sets the user who logs in by the authorization endpoint.
+func (p *Provider) SetLoginUser(userID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loginUserID = userID
+}
+
+// RevokeUser revokes all tokens of the user.
+func (p *Provider) RevokeUser(userID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for accessToken, grant := range p.tokens {
+		if grant.userID == userID {
+			delete(p.tokens, accessToken)
+		}
+	}
+}
+
+// GetPermissions is an osecure.GetPermissionsFunc returning permissions of users added by AddUser.
+func (p *Provider) GetPermissions(ctx context.Context, userID string, clientID string, token *oauth2.Token) (permissions []string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.users[userID]...), nil
+}
+
+// LogoutToken issues a back-channel logout token of the user and the session ID ("sid" of ID token) to the client.
+func (p *Provider) LogoutToken(userID string, sessionID string) (string, error) {
+	now := time.Now()
+	claims := jwt.Claims{
+		"iss":    p.Issuer(),
+		"aud":    p.ClientID,
+		"iat":    now.Unix(),
+		"exp":    now.Add(2 * time.Minute).Unix(),
+		"jti":    randomString(),
+		"events": map[string]interface{}{osecure.BackChannelLogoutEvent: map[string]interface{}{}},
+	}
+	if userID != "" {
+		claims["sub"] = userID
+	}
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
+	return jwt.Sign(jwt.Header{Algorithm: "RS256", Type: "logout+jwt", KeyID: p.keyID}, claims, p.key)
+}
+
+func (p *Provider) tokenLifetime() time.Duration {
+	if p.TokenLifetime > 0 {
+		return p.TokenLifetime
+	}
+	return DefaultTokenLifetime
+}
+
+func (p *Provider) authenticateClient(r *http.Request) bool {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostFormValue("client_id")
+		clientSecret = r.PostFormValue("client_secret")
+	}
+	return clientID == p.ClientID && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.ClientSecret)) == 1
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" {
+		writeAuthorizationError(w, r, redirectURI, "unauthorized_client", query.Get("state"))
+		return
+	}
+
+	p.mu.Lock()
+	userID := query.Get("login_hint")
+	if userID == "" {
+		userID = p.loginUserID
+	}
+	_, found := p.users[userID]
+	if !found {
+		p.mu.Unlock()
+		writeAuthorizationError(w, r, redirectURI, "access_denied", query.Get("state"))
+		return
+	}
+
+	code := randomString()
+	p.codes[code] = &providerGrant{
+		userID:      userID,
+		clientID:    p.ClientID,
+		redirectURI: redirectURI.String(),
+		nonce:       query.Get("nonce"),
+		sessionID:   randomString(),
+		scope:       query.Get("scope"),
+		authTime:    time.Now(),
+	}
+	p.mu.Unlock()
+
+	values := redirectURI.Query()
+	values.Set("code", code)
+	if state := query.Get("state"); state != "" {
+		values.Set("state", state)
+	}
+	redirectURI.RawQuery = values.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func writeAuthorizationError(w http.ResponseWriter, r *http.Request, redirectURI *url.URL, code string, state string) {
+	values := redirectURI.Query()
+	values.Set("error", code)
+	if state != "" {
+		values.Set("state", state)
+	}
+	redirectURI.RawQuery = values.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeTokenError(w http.ResponseWriter, statusCode int, code string) {
+	writeJSON(w, statusCode, map[string]string{"error": code})
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeTokenError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	if !p.authenticateClient(r) {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	p.mu.Lock()
+	code := r.PostFormValue("code")
+	grant, found := p.codes[code]
+	delete(p.codes, code)
+	if !found || grant.redirectURI != r.PostFormValue("redirect_uri") {
+		p.mu.Unlock()
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	grant.expiresAt = now.Add(p.tokenLifetime())
+	accessToken := randomString()
+	p.tokens[accessToken] = grant
+	p.mu.Unlock()
+
+	claims := jwt.Claims{
+		"iss":       p.Issuer(),
+		"sub":       grant.userID,
+		"aud":       grant.clientID,
+		"iat":       now.Unix(),
+		"exp":       grant.expiresAt.Unix(),
+		"auth_time": grant.authTime.Unix(),
+		"sid":       grant.sessionID,
+	}
+	if grant.nonce != "" {
+		claims["nonce"] = grant.nonce
+	}
+	idToken, err := jwt.Sign(jwt.Header{Algorithm: "RS256", KeyID: p.keyID}, claims, p.key)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(p.tokenLifetime() / time.Second),
+		"scope":        grant.scope,
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeTokenError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	if !p.authenticateClient(r) {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	p.mu.Lock()
+	grant, found := p.tokens[r.PostFormValue("token")]
+	p.mu.Unlock()
+	if !found || !grant.expiresAt.After(time.Now()) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"active": false})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"active":    true,
+		"iss":       p.Issuer(),
+		"sub":       grant.userID,
+		"client_id": grant.clientID,
+		"exp":       grant.expiresAt.Unix(),
+		"auth_time": grant.authTime.Unix(),
+		"scope":     grant.scope,
+		"sid":       grant.sessionID,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	jwk, err := jwt.NewJSONWebKey(&p.key.PublicKey, p.keyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jwk.Use = "sig"
+	jwk.Algorithm = "RS256"
+
+	writeJSON(w, http.StatusOK, &jwt.JSONWebKeySet{Keys: []jwt.JSONWebKey{*jwk}})
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Server.URL + ProviderAuthorizePath,
+		"token_endpoint":                        p.Server.URL + ProviderTokenPath,
+		"introspection_endpoint":                p.Server.URL + ProviderIntrospectionPath,
+		"jwks_uri":                              p.Server.URL + ProviderJWKSPath,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"backchannel_logout_supported":          true,
+		"backchannel_logout_session_supported":  true,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
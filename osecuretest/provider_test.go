@@ -0,0 +1,123 @@
+package osecuretest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/rayark/osecure/v6"
+	"github.com/rayark/osecure/v6/state_handler"
+)
+
+func TestProviderLoginFlow(t *testing.T) {
+	p := NewProvider()
+	defer p.Close()
+	p.AddUser("alice", "repo:read")
+	p.SetLoginUser("alice")
+
+	mux := http.NewServeMux()
+	app := httptest.NewServer(mux)
+	defer app.Close()
+
+	s := osecure.NewOAuthSession("app", nil, p.OAuthConfig(), p.Endpoint(), p.TokenVerifier(), app.URL+"/auth/callback",
+		state_handler.JSONStateHandler{CookieName: "app_state"})
+	s.SetLoginPage("/login", nil)
+	mux.HandleFunc("/login", s.LoginView)
+	mux.HandleFunc("/auth/callback", s.CallbackView)
+	mux.HandleFunc("/protected", s.SecuredF(false)(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := osecure.GetRequestSessionData(r)
+		if !ok || !data.HasPermission("repo:read") {
+			http.Error(w, "no permission", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(data.UserID))
+	}))
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+
+	// LoginView -> authorization endpoint -> CallbackView -> the continue URI
+	resp, err := client.Get(app.URL + "/login?provider=app&continue=/protected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "alice" {
+		t.Fatalf("got %d %q after login, want 200 %q", resp.StatusCode, body, "alice")
+	}
+	if resp.Request.URL.Path != "/protected" {
+		t.Errorf("landed on %s, want /protected", resp.Request.URL.Path)
+	}
+
+	appURL, err := url.Parse(app.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, cookie := range jar.Cookies(appURL) {
+		if cookie.Name == "app" && cookie.Value != "" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("session cookie isn't issued")
+	}
+
+	// the session cookie alone passes protected handlers
+	resp, err = client.Get(app.URL + "/protected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/protected" {
+		t.Errorf("got %d at %s with the session cookie", resp.StatusCode, resp.Request.URL.Path)
+	}
+}
+
+func TestProviderLoginFlowDenied(t *testing.T) {
+	p := NewProvider()
+	defer p.Close()
+	p.SetLoginUser("mallory") // not added
+
+	mux := http.NewServeMux()
+	app := httptest.NewServer(mux)
+	defer app.Close()
+
+	s := osecure.NewOAuthSession("app", nil, p.OAuthConfig(), p.Endpoint(), p.TokenVerifier(), app.URL+"/auth/callback",
+		state_handler.JSONStateHandler{CookieName: "app_state"})
+	s.SetLoginPage("/login", nil)
+	mux.HandleFunc("/login", s.LoginView)
+	mux.HandleFunc("/auth/callback", s.CallbackView)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+
+	resp, err := client.Get(app.URL + "/login?provider=app&continue=/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Errorf("login of unknown user succeeded at %s", resp.Request.URL)
+	}
+
+	appURL, _ := url.Parse(app.URL)
+	for _, cookie := range jar.Cookies(appURL) {
+		if cookie.Name == "app" {
+			t.Error("session cookie issued for denied login")
+		}
+	}
+}
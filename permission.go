@@ -0,0 +1,56 @@
+package osecure
+
+import (
+	"strings"
+)
+
+const (
+	PermissionSeparator = ":"
+	PermissionWildcard  = "*"
+)
+
+// MatchPermission checks if the granted permission covers the required permission.
+//
+// Permissions are split into segments by ":" and compared segment by segment.
+// A "*" segment in the granted permission matches any single segment of the required permission,
+// and a trailing "*" matches all the remaining segments (at least one).
+// For example, "billing:*" covers "billing:read" and "billing:invoice:write",
+// "repo:*:read" covers "repo:osecure:read" but not "repo:osecure:write",
+// and "*" alone covers everything.
+// Wildcards in the required permission have no special meaning.
+func MatchPermission(granted string, required string) bool {
+	if granted == required {
+		return true
+	}
+	if !strings.Contains(granted, PermissionWildcard) {
+		return false
+	}
+
+	// compare segment by segment without splitting, since permissions are checked on every request
+	for {
+		grantedSegment, grantedRest, hasMoreGranted := nextPermissionSegment(granted)
+		requiredSegment, requiredRest, hasMoreRequired := nextPermissionSegment(required)
+
+		if grantedSegment == PermissionWildcard {
+			if !hasMoreGranted {
+				return true
+			}
+		} else if grantedSegment != requiredSegment {
+			return false
+		}
+
+		if !hasMoreGranted || !hasMoreRequired {
+			return hasMoreGranted == hasMoreRequired
+		}
+		granted, required = grantedRest, requiredRest
+	}
+}
+
+// nextPermissionSegment splits the first segment from the rest of the permission.
+func nextPermissionSegment(permission string) (segment string, rest string, hasMore bool) {
+	i := strings.Index(permission, PermissionSeparator)
+	if i < 0 {
+		return permission, "", false
+	}
+	return permission[:i], permission[i+len(PermissionSeparator):], true
+}
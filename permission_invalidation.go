@@ -0,0 +1,78 @@
+package osecure
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rayark/osecure/v6/jwt"
+)
+
+// PermissionsChangedEvent is the event type of security event tokens notifying permission changes of the subject.
+const PermissionsChangedEvent = "urn:osecure:event:permissions-changed"
+
+// SetPermissionInvalidationList enables dropping cached permissions by InvalidatePermissions.
+// The list records the time before which permissions of users are stale, MemoryRevocationList can be used,
+// but a shared list (e.g. in Redis) is required for multiple instances.
+// It should be called before serving requests.
+func (s *OAuthSession) SetPermissionInvalidationList(list RevocationList) {
+	s.permissionInvalidationList = list
+}
+
+// InvalidatePermissions drops the cached permissions of the user,
+// so they're fetched again on the next request instead of waiting until PermissionExpireTime.
+func (s *OAuthSession) InvalidatePermissions(ctx context.Context, userID string) error {
+	if s.permissionInvalidationList == nil {
+		return ErrorInvalidationListRequired
+	}
+	return s.permissionInvalidationList.RevokeBefore(ctx, userID, time.Now())
+}
+
+// isPermissionsInvalidated checks if the cached permissions are fetched before they're invalidated.
+func (s *OAuthSession) isPermissionsInvalidated(ctx context.Context, data *AuthSessionData) (bool, error) {
+	if s.permissionInvalidationList == nil || data.PermissionsExpiresAt.IsZero() {
+		return false, nil
+	}
+
+	invalidatedBefore, err := s.permissionInvalidationList.RevokedBefore(ctx, data.UserID)
+	if err != nil {
+		return false, err
+	}
+	fetchedAt := data.PermissionsExpiresAt.Add(-s.permissionExpireTime)
+	return !invalidatedBefore.IsZero() && !fetchedAt.After(invalidatedBefore), nil
+}
+
+// PermissionChangeHandler is a http handler receiving security event tokens of PermissionsChangedEvent
+// in "token" form parameter, pushed by the auth server when permissions of the token's "sub" are changed.
+// Tokens are verified with keys of the auth server, the same as BackChannelLogoutHandler.
+// It requires SetPermissionInvalidationList.
+func (s *OAuthSession) PermissionChangeHandler(keySet jwt.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.permissionInvalidationList == nil {
+			http.Error(w, ErrorInvalidationListRequired.Error(), http.StatusNotImplemented)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, err := s.validateEventToken(r, keySet, r.PostFormValue("token"), PermissionsChangedEvent)
+		if err == nil && claims.String("sub") == "" {
+			err = ErrorInvalidEventToken
+		}
+		if err != nil {
+			writeBackChannelLogoutError(w, WrapError(ErrorStringInvalidEventToken, err))
+			return
+		}
+
+		err = s.InvalidatePermissions(r.Context(), claims.String("sub"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	}
+}
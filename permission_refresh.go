@@ -0,0 +1,124 @@
+package osecure
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// backgroundRefreshTimeout limits permission fetches in background, which outlive the request.
+const backgroundRefreshTimeout = 30 * time.Second
+
+// refreshedPermissions are permissions fetched in background, waiting for the next request to save them.
+// A nil entry marks the refresh in progress.
+type refreshedPermissions struct {
+	permissions []string
+	fetchedAt   time.Time
+}
+
+// refreshedPermissionsCache keeps refreshed permissions by keys of getPermissionsOnce.
+type refreshedPermissionsCache struct {
+	mu        sync.Mutex
+	entries   map[string]*refreshedPermissions
+	pruneSize int
+}
+
+// take removes and returns the entry, found is false if there's neither an entry nor a refresh in progress.
+func (cache *refreshedPermissionsCache) take(key string) (refreshed *refreshedPermissions, found bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	refreshed, found = cache.entries[key]
+	if refreshed != nil {
+		delete(cache.entries, key)
+	}
+	return refreshed, found
+}
+
+// start marks the refresh in progress, returns false if it's in progress already.
+func (cache *refreshedPermissionsCache) start(key string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, found := cache.entries[key]; found {
+		return false
+	}
+	if cache.entries == nil {
+		cache.entries = make(map[string]*refreshedPermissions)
+	}
+	cache.entries[key] = nil
+	return true
+}
+
+// len is the number of refreshed permissions not taken yet, including refreshes in progress.
+func (cache *refreshedPermissionsCache) len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return len(cache.entries)
+}
+
+// finish stores the refreshed permissions, or drops the mark if refreshed is nil,
+// pruning entries not taken within maxAge.
+func (cache *refreshedPermissionsCache) finish(key string, refreshed *refreshedPermissions, maxAge time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if refreshed == nil {
+		delete(cache.entries, key)
+		return
+	}
+	cache.entries[key] = refreshed
+
+	// prune stale entries of users gone when the cache doubles since the last pruning
+	if len(cache.entries) > 2*cache.pruneSize+1024 {
+		for key, refreshed := range cache.entries {
+			if refreshed != nil && time.Since(refreshed.fetchedAt) > maxAge {
+				delete(cache.entries, key)
+			}
+		}
+		cache.pruneSize = len(cache.entries)
+	}
+}
+
+// refreshPermissionsAhead applies permissions refreshed in background to the session data, returns true if applied.
+// Otherwise, it starts refreshing in background if they expire within PermissionRefreshAhead.
+func (s *OAuthSession) refreshPermissionsAhead(data *AuthSessionData) bool {
+	if s.permissionRefreshAhead <= 0 || data.Token == nil {
+		return false
+	}
+	if data.PermissionsExpiresAt.Equal(data.Token.Expiry) {
+		// permissions in claims are as fresh as the token
+		return false
+	}
+
+	key := permissionKey(data.UserID, data.ClientID, data.Token)
+	refreshed, found := s.refreshedPermissions.take(key)
+	if found {
+		if refreshed == nil || time.Since(refreshed.fetchedAt) > s.permissionExpireTime {
+			return false
+		}
+		data.setPermissions(refreshed.permissions)
+		data.PermissionsExpiresAt = refreshed.fetchedAt.Add(s.permissionExpireTime)
+		return true
+	}
+
+	if time.Until(data.PermissionsExpiresAt) > s.permissionRefreshAhead || !s.refreshedPermissions.start(key) {
+		return false
+	}
+
+	userID, clientID, token := data.UserID, data.ClientID, data.Token
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundRefreshTimeout)
+		defer cancel()
+
+		permissions, err := s.getPermissionsOnce(ctx, userID, clientID, token)
+		if err != nil {
+			// fetched synchronously when expired
+			s.refreshedPermissions.finish(key, nil, s.permissionExpireTime)
+			return
+		}
+		s.refreshedPermissions.finish(key, &refreshedPermissions{permissions: permissions, fetchedAt: time.Now()}, s.permissionExpireTime)
+	}()
+	return false
+}
@@ -0,0 +1,141 @@
+package osecure
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultPermissionCacheSize is the default max number of users cached by CachePermissionSource.
+const DefaultPermissionCacheSize = 10000
+
+// PermissionSource provides permissions of users, e.g. from the IdP or an entitlement database.
+// Sources can be composed by MergePermissionSources, OverridePermissionSources and CachePermissionSource,
+// and used as TokenVerifier.GetPermissionsFunc by their GetPermissions method.
+type PermissionSource interface {
+	GetPermissions(ctx context.Context, userID string, clientID string, token *oauth2.Token) (permissions []string, err error)
+}
+
+// GetPermissions calls f(ctx, userID, clientID, token), so GetPermissionsFunc is a PermissionSource.
+func (f GetPermissionsFunc) GetPermissions(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+	return f(ctx, userID, clientID, token)
+}
+
+// MergePermissionSources unions permissions of all the sources, sorted and without duplicates.
+// It fails if any source fails, so users don't lose permissions silently.
+func MergePermissionSources(sources ...PermissionSource) PermissionSource {
+	return GetPermissionsFunc(func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+		merged := make(StringSet)
+		for _, source := range sources {
+			permissions, err := source.GetPermissions(ctx, userID, clientID, token)
+			if err != nil {
+				return nil, err
+			}
+			for _, permission := range permissions {
+				merged.Add(permission)
+			}
+		}
+
+		list := merged.List()
+		sort.Strings(list)
+		return list, nil
+	})
+}
+
+// OverridePermissionSources takes permissions of the first source which knows the user,
+// i.e. returns non-nil permissions. An empty non-nil list overrides the following sources with no permission.
+// Permissions are nil if no source knows the user.
+func OverridePermissionSources(sources ...PermissionSource) PermissionSource {
+	return GetPermissionsFunc(func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+		for _, source := range sources {
+			permissions, err := source.GetPermissions(ctx, userID, clientID, token)
+			if err != nil {
+				return nil, err
+			}
+			if permissions != nil {
+				return permissions, nil
+			}
+		}
+		return nil, nil
+	})
+}
+
+// CachePermissionSource caches permissions of the source by user and client for the TTL,
+// keeping up to size users (DefaultPermissionCacheSize if zero). Failures aren't cached.
+// Permissions of the session are cached for OAuthConfig.PermissionExpireTime already,
+// it's for slow sources shared by many sessions of the same user, or composed with other sources.
+func CachePermissionSource(source PermissionSource, ttl time.Duration, size int) PermissionSource {
+	if size <= 0 {
+		size = DefaultPermissionCacheSize
+	}
+	cache := &permissionSourceCache{
+		source:  source,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]permissionSourceCacheEntry),
+	}
+	return GetPermissionsFunc(cache.getPermissions)
+}
+
+type permissionSourceCache struct {
+	source PermissionSource
+	ttl    time.Duration
+	size   int
+
+	mu      sync.Mutex
+	entries map[string]permissionSourceCacheEntry
+}
+
+type permissionSourceCacheEntry struct {
+	permissions []string
+	expiresAt   time.Time
+}
+
+func (cache *permissionSourceCache) getPermissions(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+	key := userID + "\x00" + clientID
+
+	cache.mu.Lock()
+	entry, found := cache.entries[key]
+	cache.mu.Unlock()
+	if found && entry.expiresAt.After(time.Now()) {
+		return copyPermissions(entry.permissions), nil
+	}
+
+	permissions, err := cache.source.GetPermissions(ctx, userID, clientID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	if len(cache.entries) >= cache.size {
+		for k, entry := range cache.entries {
+			if !entry.expiresAt.After(now) {
+				delete(cache.entries, k)
+			}
+		}
+	}
+	if len(cache.entries) >= cache.size {
+		// evict an arbitrary entry
+		for k := range cache.entries {
+			delete(cache.entries, k)
+			break
+		}
+	}
+	cache.entries[key] = permissionSourceCacheEntry{permissions: copyPermissions(permissions), expiresAt: now.Add(cache.ttl)}
+
+	return permissions, nil
+}
+
+// copyPermissions copies the list, keeping nil as nil for OverridePermissionSources.
+func copyPermissions(permissions []string) []string {
+	if permissions == nil {
+		return nil
+	}
+	return append([]string{}, permissions...)
+}
@@ -0,0 +1,157 @@
+package osecure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rayark/osecure/v6/jwt"
+	"golang.org/x/oauth2"
+)
+
+// DefaultRequestObjectLifetime is the lifetime of request objects if RequestObjectSigner.Lifetime is zero.
+const DefaultRequestObjectLifetime = 5 * time.Minute
+
+// RequestObjectSigner signs authorization requests as request objects (JAR, RFC 9101), see SetRequestObjectSigner.
+type RequestObjectSigner struct {
+	Algorithm string      // e.g. "PS256" or "ES256" for FAPI
+	KeyID     string      // "kid" of the key registered at the auth server
+	Key       interface{} // the private key, see jwt.Sign
+
+	// Lifetime is the time until the request object expires, DefaultRequestObjectLifetime if zero.
+	Lifetime time.Duration
+}
+
+// SetRequestObjectSigner sends authorization requests as request objects signed by the signer,
+// whose audience is OAuthConfig.Issuer (the authorization endpoint if empty). It can be combined with
+// OAuthConfig.PushedAuthorizationRequestEndpoint. It should be called before serving requests.
+func (s *OAuthSession) SetRequestObjectSigner(signer *RequestObjectSigner) {
+	s.requestObjectSigner = signer
+}
+
+// authorizationURL is the URL users are redirected to for the authorization request, which carries the parameters,
+// a request object of them, or a request URI of them pushed to the auth server.
+func (s *OAuthSession) authorizationURL(w http.ResponseWriter, r *http.Request, state string, opts ...oauth2.AuthCodeOption) (string, error) {
+	challengeOpts, err := s.codeChallengeOptions(w, r)
+	if err != nil {
+		return "", err
+	}
+	authURL := s.authCodeURL(r, state, concatAuthCodeOptions(opts, challengeOpts)...)
+	if s.requestObjectSigner == nil && s.pushedAuthorizationRequestEndpoint == "" {
+		return authURL, nil
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	params := u.Query()
+	clientID := params.Get("client_id")
+
+	if s.requestObjectSigner != nil {
+		requestObject, err := s.signRequestObject(params)
+		if err != nil {
+			return "", err
+		}
+		params = url.Values{"client_id": {clientID}, "request": {requestObject}}
+	}
+
+	if s.pushedAuthorizationRequestEndpoint != "" {
+		requestURI, err := s.pushAuthorizationRequest(r, params)
+		if err != nil {
+			return "", WrapError(ErrorStringCannotPushAuthorizationRequest, err)
+		}
+		params = url.Values{"client_id": {clientID}, "request_uri": {requestURI}}
+	}
+
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+// signRequestObject signs the parameters of the authorization request as a request object.
+func (s *OAuthSession) signRequestObject(params url.Values) (string, error) {
+	signer := s.requestObjectSigner
+	if !s.isCompliantSigningAlgorithm(signer.Algorithm) {
+		return "", fmt.Errorf("algorithm %q of request object is not allowed by compliance profile %s", signer.Algorithm, s.complianceProfile)
+	}
+	audience := s.issuer
+	if audience == "" {
+		audience = s.getClient().Endpoint.AuthURL
+	}
+	jti, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		"iss": params.Get("client_id"),
+		"aud": audience,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(durationOrDefault(signer.Lifetime, DefaultRequestObjectLifetime)).Unix(),
+		"jti": jti,
+	}
+	for name, values := range params {
+		if len(values) == 1 {
+			claims[name] = values[0]
+		} else {
+			claims[name] = values
+		}
+	}
+
+	return jwt.Sign(jwt.Header{Algorithm: signer.Algorithm, Type: "oauth-authz-req+jwt", KeyID: signer.KeyID}, claims, signer.Key)
+}
+
+// pushAuthorizationRequest pushes the parameters to the PAR endpoint (RFC 9126), returning the request URI.
+// The client authenticates by the client assertion if set, otherwise client_secret_basic if it has a secret.
+func (s *OAuthSession) pushAuthorizationRequest(r *http.Request, params url.Values) (string, error) {
+	client := s.getClient()
+	if s.clientAssertionSigner != nil {
+		err := s.clientAssertionSigner.Authenticate(params, s.pushedAuthorizationRequestEndpoint)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.pushedAuthorizationRequestEndpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(r.Context())
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.clientAssertionSigner == nil && client.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(client.ClientID), url.QueryEscape(client.ClientSecret))
+	}
+
+	httpClient := http.DefaultClient
+	if c, ok := r.Context().Value(oauth2.HTTPClient).(*http.Client); ok {
+		httpClient = c
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		RequestURI       string `json:"request_uri"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("status code: %d, error: %s %s", resp.StatusCode, result.Error, result.ErrorDescription)}
+	}
+	if err != nil {
+		return "", err
+	}
+	if result.RequestURI == "" {
+		return "", fmt.Errorf("no request_uri in response")
+	}
+	return result.RequestURI, nil
+}
@@ -0,0 +1,157 @@
+package osecure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// These tests are meant to be run with -race, which reports unsynchronized access of shared session data.
+
+const raceTestGoroutines = 8
+
+func runConcurrently(n int, f func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// newTestSessionCookie logs in the user of the token, returning the session cookie.
+func newTestSessionCookie(t *testing.T, s *OAuthSession, accessToken string) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	token := &oauth2.Token{AccessToken: accessToken, Expiry: time.Now().Add(time.Hour)}
+	err := s.setAuthCookie(w, httptest.NewRequest(http.MethodGet, "/", nil), s.newAuthSessionCookieData(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Result().Cookies()[0]
+}
+
+func TestConcurrentVerifyAndRefresh(t *testing.T) {
+	s := newTestSession(t, newTestVerifier([]string{"repo:*:read", "admin"}))
+	cache, err := NewTieredCache(context.Background(), TieredCacheConfig{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetIntrospectionCache(cache)
+	cookie := newTestSessionCookie(t, s, "user")
+
+	runConcurrently(raceTestGoroutines, func(i int) {
+		for j := 0; j < 20; j++ {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if i%2 == 0 {
+				r.AddCookie(cookie)
+			} else {
+				r.Header.Set("Authorization", "Bearer user")
+			}
+
+			data, err := s.VerifyAndRefresh(httptest.NewRecorder(), r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !data.HasPermission("repo:osecure:read") || !data.HasPermission("admin") || data.HasPermission("repo:osecure:write") {
+				t.Errorf("permissions %v", data.GetPermissions())
+				return
+			}
+		}
+	})
+}
+
+func TestConcurrentSharedSessionData(t *testing.T) {
+	s := newTestSession(t, newTestVerifier(nil))
+	token := &oauth2.Token{AccessToken: "user", Expiry: time.Now().Add(time.Hour)}
+	data := NewAuthSessionData("user", testClientID, token, []string{"repo:*:read", "admin"}, time.Now().Add(time.Hour))
+
+	// session data shared by concurrent handlers is only read, including by saving it into cookies
+	runConcurrently(raceTestGoroutines, func(i int) {
+		for j := 0; j < 100; j++ {
+			if !data.HasPermission("repo:osecure:read") || data.HasPermission("repo:osecure:write") {
+				t.Error("wrong permissions")
+				return
+			}
+			data.GetPermissions()
+			_, err := serializeAuthCookieData(data.AuthSessionCookieData)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			err = s.setAuthCookie(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), data.AuthSessionCookieData)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			data.Clone().setPermissions([]string{"other"})
+		}
+	})
+}
+
+func TestConcurrentAuthenticatorSessionData(t *testing.T) {
+	s := newTestSession(t, newTestVerifier(nil))
+	token := &oauth2.Token{AccessToken: "key", Expiry: time.Now().Add(time.Hour)}
+	shared := NewAuthSessionData("robot", "robot", token, []string{"admin"}, time.Now().Add(time.Hour))
+	s.AddAuthenticator(AuthenticatorFunc(func(r *http.Request) (*AuthSessionData, error) {
+		return shared, nil
+	}))
+
+	runConcurrently(raceTestGoroutines, func(i int) {
+		for j := 0; j < 20; j++ {
+			data, err := s.VerifyAndRefresh(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if data == shared || !data.HasPermission("admin") {
+				t.Error("session data of authenticator isn't cloned")
+				return
+			}
+		}
+	})
+}
+
+func TestConcurrentReloadKeys(t *testing.T) {
+	s := newTestSession(t, newTestVerifier([]string{"admin"}))
+	cookie := newTestSessionCookie(t, s, "user")
+
+	// the current keys are reloaded, so the cookie stays valid
+	provider := KeyProviderFunc(func(ctx context.Context) (*SecretKeys, error) {
+		return &SecretKeys{Cookie: newTestCookieConfig(), ClientSecret: "secret"}, nil
+	})
+
+	runConcurrently(raceTestGoroutines, func(i int) {
+		for j := 0; j < 20; j++ {
+			if i == 0 {
+				err := s.ReloadKeys(context.Background(), provider)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				continue
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(cookie)
+			data, err := s.VerifyAndRefresh(httptest.NewRecorder(), r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !data.HasPermission("admin") {
+				t.Error("wrong permissions")
+				return
+			}
+		}
+	})
+}
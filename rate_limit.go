@@ -0,0 +1,195 @@
+package osecure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimitWindow is the default window of RateLimiter.
+const DefaultRateLimitWindow = 15 * time.Minute
+
+// RateLimitStore counts failures of keys in fixed windows, e.g. in memory or Redis.
+type RateLimitStore interface {
+	// Count gets the number of failures of the key in the current window.
+	Count(ctx context.Context, key string) (int, error)
+	// Add adds a failure to the key, starting a window of the duration if there isn't one, returns the new number.
+	Add(ctx context.Context, key string, window time.Duration) (int, error)
+	// Reset removes all failures of the key.
+	Reset(ctx context.Context, key string) error
+}
+
+// RateLimiter locks out clients and subjects with too many failed authentication attempts
+// (bearer token verification and CallbackView) in a window, until the window ends.
+// Errors of the store don't lock anyone out.
+type RateLimiter struct {
+	Store                 RateLimitStore
+	Window                time.Duration // DefaultRateLimitWindow if zero
+	MaxFailuresPerIP      int           // unlimited if zero
+	MaxFailuresPerSubject int           // unlimited if zero
+}
+
+// NewRateLimiter creates RateLimiter with MemoryRateLimitStore.
+func NewRateLimiter(maxFailuresPerIP int, maxFailuresPerSubject int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Store:                 NewMemoryRateLimitStore(),
+		Window:                window,
+		MaxFailuresPerIP:      maxFailuresPerIP,
+		MaxFailuresPerSubject: maxFailuresPerSubject,
+	}
+}
+
+// SetRateLimiter enables rate limiting of authentication failures.
+// It should be called before serving requests.
+func (s *OAuthSession) SetRateLimiter(limiter *RateLimiter) {
+	s.rateLimiter = limiter
+}
+
+func (s *OAuthSession) rateLimitIPKey(r *http.Request) string {
+	if ip := s.clientIP(r); ip != nil {
+		return "ip:" + ip.String()
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+func rateLimitSubjectKey(userID string) string {
+	return "sub:" + userID
+}
+
+func (limiter *RateLimiter) isLocked(ctx context.Context, key string, maxFailures int) bool {
+	if maxFailures <= 0 {
+		return false
+	}
+	count, err := limiter.Store.Count(ctx, key)
+	return err == nil && count >= maxFailures
+}
+
+func (limiter *RateLimiter) add(ctx context.Context, key string, maxFailures int) {
+	if maxFailures <= 0 {
+		return
+	}
+	window := limiter.Window
+	if window == 0 {
+		window = DefaultRateLimitWindow
+	}
+	limiter.Store.Add(ctx, key, window)
+}
+
+// checkIPRateLimit fails if the client of the request is locked out.
+func (s *OAuthSession) checkIPRateLimit(r *http.Request) error {
+	if s.rateLimiter != nil && s.rateLimiter.isLocked(r.Context(), s.rateLimitIPKey(r), s.rateLimiter.MaxFailuresPerIP) {
+		return ErrorTooManyFailures
+	}
+	return nil
+}
+
+// checkSubjectRateLimit fails if the user is locked out.
+func (s *OAuthSession) checkSubjectRateLimit(ctx context.Context, userID string) error {
+	if s.rateLimiter != nil && s.rateLimiter.isLocked(ctx, rateLimitSubjectKey(userID), s.rateLimiter.MaxFailuresPerSubject) {
+		return ErrorTooManyFailures
+	}
+	return nil
+}
+
+// verificationFailure is a failed authentication attempt counted by RateLimiter,
+// with the user ID if the subject is known.
+type verificationFailure struct {
+	UserID string
+	Err    error
+}
+
+func (e *verificationFailure) Error() string {
+	return e.Err.Error()
+}
+
+func (e *verificationFailure) Unwrap() error {
+	return e.Err
+}
+
+// failedSubject gets the user ID of the verification failure, returns false if err isn't a counted failure.
+func failedSubject(err error) (string, bool) {
+	var failure *verificationFailure
+	if !errors.As(err, &failure) {
+		return "", false
+	}
+	return failure.UserID, true
+}
+
+// recordFailure counts the failed authentication attempt of the client, and of the user if userID isn't empty.
+func (s *OAuthSession) recordFailure(r *http.Request, userID string) {
+	s.detectBruteForce(r, userID)
+
+	if s.rateLimiter == nil {
+		return
+	}
+
+	s.rateLimiter.add(r.Context(), s.rateLimitIPKey(r), s.rateLimiter.MaxFailuresPerIP)
+	if userID != "" {
+		s.rateLimiter.add(r.Context(), rateLimitSubjectKey(userID), s.rateLimiter.MaxFailuresPerSubject)
+	}
+}
+
+// MemoryRateLimitStore is a RateLimitStore in memory.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	entries   map[string]*rateLimitEntry
+	pruneSize int
+}
+
+type rateLimitEntry struct {
+	count     int
+	expiresAt time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		entries: make(map[string]*rateLimitEntry),
+	}
+}
+
+func (store *MemoryRateLimitStore) Count(ctx context.Context, key string) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, found := store.entries[key]
+	if !found || !entry.expiresAt.After(time.Now()) {
+		return 0, nil
+	}
+	return entry.count, nil
+}
+
+func (store *MemoryRateLimitStore) Add(ctx context.Context, key string, window time.Duration) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	entry, found := store.entries[key]
+	if !found || !entry.expiresAt.After(now) {
+		entry = &rateLimitEntry{expiresAt: now.Add(window)}
+		store.entries[key] = entry
+	}
+	entry.count++
+
+	// prune expired entries when the store doubles since the last pruning
+	if len(store.entries) > 2*store.pruneSize+1024 {
+		for key, entry := range store.entries {
+			if !entry.expiresAt.After(now) {
+				delete(store.entries, key)
+			}
+		}
+		store.pruneSize = len(store.entries)
+	}
+
+	return entry.count, nil
+}
+
+func (store *MemoryRateLimitStore) Reset(ctx context.Context, key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.entries, key)
+	return nil
+}
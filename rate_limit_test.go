@@ -0,0 +1,102 @@
+package osecure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFailingTestVerifier rejects tokens with the "invalid" prefix, and issues tokens "other-client-<user>"
+// to another client, which is a failure of the user.
+func newFailingTestVerifier() *TokenVerifier {
+	verifier := newTestVerifier(nil)
+	verifier.IntrospectTokenFunc = func(ctx context.Context, accessToken string) (string, string, int64, map[string]interface{}, error) {
+		if strings.HasPrefix(accessToken, "invalid") {
+			return "", "", 0, nil, errors.New("token is not active")
+		}
+		if strings.HasPrefix(accessToken, "other-client-") {
+			return strings.TrimPrefix(accessToken, "other-client-"), "other", time.Now().Add(time.Hour).Unix(), nil, nil
+		}
+		return accessToken, testClientID, time.Now().Add(time.Hour).Unix(), nil, nil
+	}
+	return verifier
+}
+
+func verifyBearer(s *OAuthSession, accessToken string, remoteAddr string) error {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	_, err := s.Verify(r)
+	return err
+}
+
+func TestRateLimitPerIP(t *testing.T) {
+	s := newTestSession(t, newFailingTestVerifier())
+	s.SetRateLimiter(NewRateLimiter(2, 0, time.Minute))
+
+	for i := 0; i < 2; i++ {
+		err := verifyBearer(s, "invalid", "192.0.2.1:1234")
+		if err == nil || errors.Is(err, ErrorTooManyFailures) {
+			t.Fatalf("attempt %d: got %v", i, err)
+		}
+	}
+	if err := verifyBearer(s, "alice", "192.0.2.1:1234"); !errors.Is(err, ErrorTooManyFailures) {
+		t.Errorf("locked out client: got %v, want %v", err, ErrorTooManyFailures)
+	}
+	if err := verifyBearer(s, "alice", "192.0.2.2:1234"); err != nil {
+		t.Errorf("other client: %v", err)
+	}
+}
+
+func TestRateLimitPerSubject(t *testing.T) {
+	s := newTestSession(t, newFailingTestVerifier())
+	s.SetRateLimiter(NewRateLimiter(0, 2, time.Minute))
+
+	// failures of the user from different clients
+	verifyBearer(s, "other-client-alice", "192.0.2.1:1234")
+	verifyBearer(s, "other-client-alice", "192.0.2.2:1234")
+	// failures without the subject don't count for anyone
+	verifyBearer(s, "invalid", "192.0.2.3:1234")
+
+	if err := verifyBearer(s, "alice", "192.0.2.4:1234"); !errors.Is(err, ErrorTooManyFailures) {
+		t.Errorf("locked out user: got %v, want %v", err, ErrorTooManyFailures)
+	}
+	if err := verifyBearer(s, "bob", "192.0.2.1:1234"); err != nil {
+		t.Errorf("other user: %v", err)
+	}
+}
+
+func TestRateLimitWindow(t *testing.T) {
+	s := newTestSession(t, newFailingTestVerifier())
+	s.SetRateLimiter(NewRateLimiter(1, 0, 50*time.Millisecond))
+
+	verifyBearer(s, "invalid", "192.0.2.1:1234")
+	if err := verifyBearer(s, "alice", "192.0.2.1:1234"); !errors.Is(err, ErrorTooManyFailures) {
+		t.Fatalf("locked out client: got %v, want %v", err, ErrorTooManyFailures)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := verifyBearer(s, "alice", "192.0.2.1:1234"); err != nil {
+		t.Errorf("after the window: %v", err)
+	}
+}
+
+func TestRateLimitCallback(t *testing.T) {
+	s := newCallbackTestSession(t, newTestVerifier(nil))
+	s.SetRateLimiter(NewRateLimiter(2, 0, time.Minute))
+
+	for i := 0; i < 2; i++ {
+		if status, _ := callbackStatus(t, s, "invalid"); status != http.StatusBadRequest {
+			t.Fatalf("attempt %d: got %d", i, status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.CallbackView(w, httptest.NewRequest(http.MethodGet, "/callback?state=%2F&code=alice", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("locked out client: got %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,50 @@
+package osecure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// Redact masks the secret (e.g. a token) for error messages and logs,
+// keeping a short hash so occurrences of the same secret can still be correlated.
+func Redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return "[redacted:" + hex.EncodeToString(sum[:4]) + "]"
+}
+
+// RedactError masks the secrets in the message of the error, also in URL encoded form,
+// e.g. a token in the query of a failed request URL. The error chain is kept for errors.Is and errors.As.
+func RedactError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err, secrets: secrets}
+}
+
+type redactedError struct {
+	err     error
+	secrets []string
+}
+
+func (e *redactedError) Error() string {
+	msg := e.err.Error()
+	for _, secret := range e.secrets {
+		if secret == "" {
+			continue
+		}
+		msg = strings.Replace(msg, secret, Redact(secret), -1)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			msg = strings.Replace(msg, escaped, Redact(secret), -1)
+		}
+	}
+	return msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,64 @@
+package osecure
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+// DefaultRedirectStatusCode is the status code of auth redirects if OAuthConfig.RedirectStatusCode is zero.
+const DefaultRedirectStatusCode = http.StatusSeeOther
+
+// InterstitialTemplate is the template of the interstitial page of OAuthConfig.RedirectInterstitial, executed with
+// InterstitialPageData. It submits the form by itself, with the button for browsers without scripts.
+var InterstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="referrer" content="no-referrer">
+<title>Redirecting</title>
+</head>
+<body onload="document.forms[0].submit()">
+<form method="GET" action="{{.Action}}">
+{{- range .Fields}}
+<input type="hidden" name="{{.Name}}" value="{{.Value}}">
+{{- end}}
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// InterstitialPageData is the data to execute InterstitialTemplate. Submitting GET forms replaces the query of the
+// action, so the query of the URL is in Fields, while the fragment is kept in Action.
+type InterstitialPageData struct {
+	Action string
+	Fields []ResumeField
+}
+
+// redirect redirects auth flows, e.g. to the provider, the login page and back to the continue URI, by
+// RedirectStatusCode, or by the interstitial page if RedirectInterstitial.
+func (s *OAuthSession) redirect(w http.ResponseWriter, r *http.Request, uri string) {
+	if !s.redirectInterstitial {
+		http.Redirect(w, r, uri, s.redirectStatusCode)
+		return
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		http.Redirect(w, r, uri, s.redirectStatusCode)
+		return
+	}
+	data := &InterstitialPageData{Fields: parseFormFields(u.RawQuery)}
+	u.RawQuery = ""
+	u.ForceQuery = false
+	data.Action = u.String()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err = InterstitialTemplate.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
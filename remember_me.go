@@ -0,0 +1,285 @@
+package osecure
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRememberMeLifetime is the lifetime of remember-me tokens if OAuthConfig.RememberMeLifetime is zero.
+const DefaultRememberMeLifetime = 30 * 24 * time.Hour
+
+// RememberMeRecord is the server-side record of a remember-me token. The token is the series ID and a verifier,
+// which is rotated whenever the token is used, so a stolen token is detected when both the thief and the user use it.
+type RememberMeRecord struct {
+	ID           string // series ID, kept across rotations
+	VerifierHash []byte // SHA-256 of the current verifier
+	UserID       string
+	ClientID     string
+	Payload      []byte // serialized session data, with the refresh token if any
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// RememberMeStore keeps remember-me records.
+// Load returns ErrorRememberMeNotFound if the record doesn't exist or is expired.
+// DeleteByUser deletes all records of the user, so revoked users can't restore their sessions.
+type RememberMeStore interface {
+	Save(ctx context.Context, record *RememberMeRecord) error
+	Load(ctx context.Context, id string) (*RememberMeRecord, error)
+	Delete(ctx context.Context, id string) error
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// MemoryRememberMeStore is a RememberMeStore in memory, suitable for single instance deployment and tests.
+type MemoryRememberMeStore struct {
+	mu      sync.Mutex
+	records map[string]*RememberMeRecord
+}
+
+// NewMemoryRememberMeStore creates an empty MemoryRememberMeStore.
+func NewMemoryRememberMeStore() *MemoryRememberMeStore {
+	return &MemoryRememberMeStore{
+		records: make(map[string]*RememberMeRecord),
+	}
+}
+
+func (store *MemoryRememberMeStore) Save(ctx context.Context, record *RememberMeRecord) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	recordCopy := *record
+	store.records[record.ID] = &recordCopy
+	return nil
+}
+
+func (store *MemoryRememberMeStore) Load(ctx context.Context, id string) (*RememberMeRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	record, found := store.records[id]
+	if !found {
+		return nil, ErrorRememberMeNotFound
+	}
+	if !record.ExpiresAt.After(time.Now()) {
+		delete(store.records, id)
+		return nil, ErrorRememberMeNotFound
+	}
+
+	recordCopy := *record
+	return &recordCopy, nil
+}
+
+func (store *MemoryRememberMeStore) Delete(ctx context.Context, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.records, id)
+	return nil
+}
+
+func (store *MemoryRememberMeStore) DeleteByUser(ctx context.Context, userID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, record := range store.records {
+		if record.UserID == userID {
+			delete(store.records, id)
+		}
+	}
+	return nil
+}
+
+// SetRememberMeStore enables remember-me. The auth cookie becomes a session cookie, which browsers delete when closed,
+// and users who opt in by RememberMe get a persistent remember-me cookie lasting OAuthConfig.RememberMeLifetime,
+// which restores the session on their next visit. It should be called before serving requests.
+func (s *OAuthSession) SetRememberMeStore(store RememberMeStore) {
+	s.rememberMeStore = store
+}
+
+func (s *OAuthSession) rememberMeCookieName() string {
+	return s.name + "_remember"
+}
+
+// RememberMe issues the remember-me cookie for the session of the request, e.g. from the page users continue to
+// after logging in with "remember me" checked. It requires SetRememberMeStore.
+func (s *OAuthSession) RememberMe(w http.ResponseWriter, r *http.Request) error {
+	if s.rememberMeStore == nil {
+		return ErrorRememberMeStoreRequired
+	}
+
+	data, err := s.Verify(r)
+	if err != nil {
+		return err
+	}
+
+	seriesID, err := generateSessionID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	record := &RememberMeRecord{
+		ID:        seriesID,
+		UserID:    data.UserID,
+		ClientID:  data.ClientID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.rememberMeLifetime),
+	}
+
+	s.forgetMe(w, r)
+	return s.saveRememberMe(w, r, record, data.AuthSessionCookieData)
+}
+
+// saveRememberMe rotates the verifier of the record, saving the session data into it and the token into the cookie.
+func (s *OAuthSession) saveRememberMe(w http.ResponseWriter, r *http.Request, record *RememberMeRecord, cookieData *AuthSessionCookieData) error {
+	verifier, err := generateSessionID()
+	if err != nil {
+		return err
+	}
+	verifierHash := sha256.Sum256([]byte(verifier))
+	record.VerifierHash = verifierHash[:]
+
+	payload := *cookieData
+	payload.SessionID = ""
+	record.Payload, err = serializeAuthCookieData(&payload)
+	if err != nil {
+		return err
+	}
+
+	err = s.rememberMeStore.Save(r.Context(), record)
+	if err != nil {
+		return WrapError(ErrorStringCannotSaveSession, err)
+	}
+
+	cookie := s.newRememberMeCookie(record.ID + "." + verifier)
+	cookie.Expires = record.ExpiresAt
+	cookie.MaxAge = int(time.Until(record.ExpiresAt) / time.Second)
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+func (s *OAuthSession) newRememberMeCookie(value string) *http.Cookie {
+	options := s.getCookieStore().Options
+	return &http.Cookie{
+		Name:     s.rememberMeCookieName(),
+		Value:    value,
+		Path:     options.Path,
+		Domain:   options.Domain,
+		Secure:   options.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// forgetMe deletes the remember-me token of the request, if any.
+func (s *OAuthSession) forgetMe(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(s.rememberMeCookieName())
+	if err != nil {
+		return
+	}
+
+	seriesID := strings.SplitN(cookie.Value, ".", 2)[0]
+	if seriesID != "" {
+		s.rememberMeStore.Delete(r.Context(), seriesID)
+	}
+
+	expired := s.newRememberMeCookie("")
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+}
+
+// restoreRememberMe restores the session of the remember-me token if the request has no session,
+// setting the auth cookie and rotating the token. The restored session is attached to the returned request,
+// which is the request itself if the session isn't restored.
+func (s *OAuthSession) restoreRememberMe(w http.ResponseWriter, r *http.Request) *http.Request {
+	if s.rememberMeStore == nil || s.hasRequestToken(r) {
+		return r
+	}
+	cookie, err := r.Cookie(s.rememberMeCookieName())
+	if err != nil || len(cookie.Value) > s.maxCookieSize {
+		return r
+	}
+	if cookieData, err := s.loadAuthCookie(r); err == nil && cookieData != nil && !cookieData.isTokenExpired(s.clockSkew) && !cookieData.isSessionExpired(s.clockSkew) {
+		return r
+	}
+
+	cookieData, err := s.useRememberMe(w, r, cookie.Value)
+	if err != nil {
+		if err == ErrorRememberMeReused {
+			s.audit(r, AuditEventAccessDenied, nil, err)
+		}
+		if !IsVerifierUnavailable(err) && err != ErrorTooManySessions {
+			// the token is kept during outages of the provider, or until other sessions end
+			s.forgetMe(w, r)
+		}
+		return r
+	}
+
+	err = s.setAuthCookie(w, r, cookieData)
+	if err != nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), contextKeyRestoredCookieData, cookieData))
+}
+
+// useRememberMe verifies the remember-me token and rotates it, returning the session data to restore,
+// with the token refreshed if it's expired. Reusing a rotated token deletes the series, since the token is stolen.
+func (s *OAuthSession) useRememberMe(w http.ResponseWriter, r *http.Request, value string) (*AuthSessionCookieData, error) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrorRememberMeNotFound
+	}
+	record, err := s.rememberMeStore.Load(r.Context(), parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	verifierHash := sha256.Sum256([]byte(parts[1]))
+	if subtle.ConstantTimeCompare(verifierHash[:], record.VerifierHash) != 1 {
+		s.rememberMeStore.Delete(r.Context(), record.ID)
+		return nil, ErrorRememberMeReused
+	}
+
+	cookieData, err := deserializeAuthCookieData(record.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if cookieData.isTokenExpired(s.clockSkew) {
+		if cookieData.Token.RefreshToken == "" {
+			return nil, ErrorTokenExpired
+		}
+		err = s.callVerifier(r.Context(), func() error {
+			token, err := s.getClient().TokenSource(s.clientContext(r.Context()), cookieData.Token).Token()
+			if err != nil {
+				return RedactError(err, cookieData.Token.AccessToken, cookieData.Token.RefreshToken)
+			}
+			cookieData.Token = token
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.slidingSession {
+		cookieData.SessionExpiresAt = time.Now().Add(s.sessionExpireTime)
+	}
+	cookieData.PermissionsExpiresAt = time.Time{}
+
+	if s.sessionStore != nil {
+		err = s.registerSession(r.Context(), cookieData, record.UserID, record.ClientID, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.saveRememberMe(w, r, record, cookieData)
+	if err != nil {
+		return nil, err
+	}
+	s.audit(r, AuditEventRememberMe, &AuthSessionData{UserID: record.UserID, ClientID: record.ClientID, AuthSessionCookieData: cookieData}, nil)
+	return cookieData, nil
+}
@@ -0,0 +1,38 @@
+package osecure
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRevokeSessionsForSubjectRememberMe(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSession(t, newTestVerifier(nil))
+	s.SetRevocationList(NewMemoryRevocationList(time.Hour))
+	store := NewMemoryRememberMeStore()
+	s.SetRememberMeStore(store)
+
+	expiresAt := time.Now().Add(time.Hour)
+	for _, record := range []*RememberMeRecord{
+		{ID: "alice-laptop", UserID: "alice", ExpiresAt: expiresAt},
+		{ID: "alice-phone", UserID: "alice", ExpiresAt: expiresAt},
+		{ID: "bob-laptop", UserID: "bob", ExpiresAt: expiresAt},
+	} {
+		if err := store.Save(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.RevokeSessionsForSubject(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"alice-laptop", "alice-phone"} {
+		if _, err := store.Load(ctx, id); err != ErrorRememberMeNotFound {
+			t.Errorf("%s: remember-me of revoked user kept: %v", id, err)
+		}
+	}
+	if _, err := store.Load(ctx, "bob-laptop"); err != nil {
+		t.Errorf("remember-me of other user deleted: %v", err)
+	}
+}
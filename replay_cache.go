@@ -0,0 +1,50 @@
+package osecure
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReplayCache remembers one-time identifiers (e.g. "jti" of DPoP proofs) until they expire.
+type ReplayCache interface {
+	// Use marks the identifier as used until expiresAt, returns false if it has been used.
+	Use(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryReplayCache is a ReplayCache in memory.
+type MemoryReplayCache struct {
+	mu        sync.Mutex
+	entries   map[string]time.Time
+	pruneSize int
+}
+
+// NewMemoryReplayCache creates an empty MemoryReplayCache.
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{
+		entries: make(map[string]time.Time),
+	}
+}
+
+func (cache *MemoryReplayCache) Use(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	if usedUntil, found := cache.entries[id]; found && usedUntil.After(now) {
+		return false, nil
+	}
+	cache.entries[id] = expiresAt
+
+	// prune expired entries when the cache doubles since the last pruning
+	if len(cache.entries) > 2*cache.pruneSize+1024 {
+		for id, usedUntil := range cache.entries {
+			if !usedUntil.After(now) {
+				delete(cache.entries, id)
+			}
+		}
+		cache.pruneSize = len(cache.entries)
+	}
+
+	return true, nil
+}
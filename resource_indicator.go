@@ -0,0 +1,96 @@
+package osecure
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+// ExtraKeyAudience is the key of the audience of the token in extra data of token introspection,
+// a string or a list of strings, checked by RequireResourceF.
+const ExtraKeyAudience = "aud"
+
+// validateResources checks resource indicators are absolute URIs without fragment (RFC 8707 section 2).
+func validateResources(errs *ConfigErrors, field string, resources []string) {
+	for _, resource := range resources {
+		u, err := url.Parse(resource)
+		if err != nil {
+			errs.add(field, fmt.Sprintf("malformed URI %q", resource), err)
+			continue
+		}
+		if !u.IsAbs() || u.Fragment != "" {
+			errs.add(field, fmt.Sprintf("%q is not an absolute URI without fragment", resource), nil)
+		}
+	}
+}
+
+// authCodeURL is the URL of the authorization request, requesting OAuthConfig.Resources unless the options do.
+func (s *OAuthSession) authCodeURL(r *http.Request, state string, opts ...oauth2.AuthCodeOption) string {
+	authURL := s.getClient().AuthCodeURL(state, s.authCodeOptions(r, opts...)...)
+	if len(s.resources) == 0 {
+		return authURL
+	}
+
+	// oauth2 sets a single value of each parameter, while resources are repeated
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return authURL
+	}
+	query := u.Query()
+	if _, found := query["resource"]; found {
+		return authURL
+	}
+	query["resource"] = s.resources
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// resourceExchangeOptions requests the token for the resource in the token request if only one is configured.
+// With multiple resources, the token is requested for all resources granted by the authorization.
+func (s *OAuthSession) resourceExchangeOptions() []oauth2.AuthCodeOption {
+	if len(s.resources) != 1 {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("resource", s.resources[0])}
+}
+
+// HasAudience checks if the token is issued for the audience (e.g. a resource URI), by ExtraKeyAudience.
+func (data *AuthSessionData) HasAudience(audience string) bool {
+	for _, aud := range getExtraStrings(data.Extra, ExtraKeyAudience) {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireResourceF is a http middleware for http.HandlerFunc to check if the current user has logged in
+// with a token issued for any of the resources (RFC 8707), for routes of APIs sharing the session.
+// Requests with tokens of other audiences get 401 with error "invalid_token".
+func (s *OAuthSession) RequireResourceF(isAPI bool, resources ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return s.SecuredF(isAPI)(func(w http.ResponseWriter, r *http.Request) {
+			sessionData, _ := GetRequestSessionData(r)
+			for _, resource := range resources {
+				if sessionData.HasAudience(resource) {
+					h(w, r)
+					return
+				}
+			}
+
+			s.audit(r, AuditEventAccessDenied, sessionData, ErrorResourceMismatch)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="token is not issued for the resource"`)
+			s.unauthorized(w, r, ErrorResourceMismatch)
+		})
+	}
+}
+
+// RequireResourceH is a http middleware for http.Handler to check if the current user has logged in
+// with a token issued for any of the resources.
+func (s *OAuthSession) RequireResourceH(isAPI bool, resources ...string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.Handler(s.RequireResourceF(isAPI, resources...)(h.ServeHTTP))
+	}
+}
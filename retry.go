@@ -0,0 +1,49 @@
+package osecure
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Default backoff of retries, used when RetryBackoff or RetryMaxBackoff of OAuthConfig is zero.
+const (
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 2 * time.Second
+)
+
+// isRetryable checks if the call failed transiently, e.g. 5xx responses and timeouts.
+// Rejections of the token (401, active=false etc.) and calls stopped by the circuit breaker aren't retried.
+func isRetryable(err error) bool {
+	return IsVerifierUnavailable(err) && !errors.Is(err, errCircuitOpen)
+}
+
+// retry calls fn until it succeeds or fails by a non-retryable error, up to MaxRetries more times,
+// waiting for exponential backoff with jitter between calls.
+func (s *OAuthSession) retry(ctx context.Context, fn func() error) error {
+	backoff := durationOrDefault(s.retryBackoff, DefaultRetryBackoff)
+	maxBackoff := durationOrDefault(s.retryMaxBackoff, DefaultRetryMaxBackoff)
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= s.maxRetries || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		// wait for a random duration between the half and the whole backoff
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
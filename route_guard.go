@@ -0,0 +1,124 @@
+package osecure
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// RouteGuard declares auth requirements of routes, built by Guard, e.g.
+//
+//	s.Guard().Permissions("admin").Methods("POST").Paths("/api/admin/*").Handler(h)
+//
+// Requests matching the methods and the paths must be logged in and meet the requirements,
+// others are passed to the handler without checking.
+type RouteGuard struct {
+	session     *OAuthSession
+	isAPI       bool
+	permissions []string
+	roles       []string
+	methods     []string
+	paths       []string
+}
+
+// Guard starts declaring auth requirements of routes, which require login only until more are added.
+func (s *OAuthSession) Guard() *RouteGuard {
+	return &RouteGuard{session: s}
+}
+
+// API responds 401 to requests not logged in, instead of redirecting to log in.
+func (g *RouteGuard) API() *RouteGuard {
+	g.isAPI = true
+	return g
+}
+
+// Permissions requires all the permissions.
+func (g *RouteGuard) Permissions(permissions ...string) *RouteGuard {
+	g.permissions = append(g.permissions, permissions...)
+	return g
+}
+
+// Roles requires all the roles, see AuthSessionData.HasRole.
+func (g *RouteGuard) Roles(roles ...string) *RouteGuard {
+	g.roles = append(g.roles, roles...)
+	return g
+}
+
+// Methods limits the requirements to requests of the methods, all methods if not set.
+func (g *RouteGuard) Methods(methods ...string) *RouteGuard {
+	for _, method := range methods {
+		g.methods = append(g.methods, strings.ToUpper(method))
+	}
+	return g
+}
+
+// Paths limits the requirements to requests of the path patterns, all paths if not set.
+// Patterns are of path.Match, and a trailing "/*" matches all paths under the prefix.
+func (g *RouteGuard) Paths(patterns ...string) *RouteGuard {
+	g.paths = append(g.paths, patterns...)
+	return g
+}
+
+func matchPathPattern(pattern string, requestPath string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(requestPath, pattern[:len(pattern)-1])
+	}
+	matched, _ := path.Match(pattern, requestPath)
+	return matched
+}
+
+// isGuarded checks if the requirements apply to the request.
+func (g *RouteGuard) isGuarded(r *http.Request) bool {
+	if len(g.methods) > 0 && !containsString(g.methods, r.Method) {
+		return false
+	}
+	if len(g.paths) == 0 {
+		return true
+	}
+	for _, pattern := range g.paths {
+		if matchPathPattern(pattern, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowed makes RouteGuard an Authorizer checking the permissions and the roles.
+func (g *RouteGuard) IsAllowed(ctx context.Context, sessionData *AuthSessionData, attributes *RequestAttributes) (bool, error) {
+	if !sessionData.HasAllPermissions(g.permissions...) {
+		return false, nil
+	}
+	for _, role := range g.roles {
+		if !sessionData.HasRole(role) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// HandlerFunc guards the http.HandlerFunc.
+func (g *RouteGuard) HandlerFunc(h http.HandlerFunc) http.HandlerFunc {
+	guarded := g.session.AuthorizedF(g.isAPI, g)(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.isGuarded(r) {
+			guarded(w, r)
+		} else {
+			h(w, r)
+		}
+	}
+}
+
+// Handler guards the http.Handler.
+func (g *RouteGuard) Handler(h http.Handler) http.Handler {
+	return g.HandlerFunc(h.ServeHTTP)
+}
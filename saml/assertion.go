@@ -0,0 +1,212 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+const (
+	statusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// Identity is the identity asserted by the identity provider.
+type Identity struct {
+	Subject      string // NameID
+	SessionIndex string
+	AuthnInstant time.Time
+	Attributes   map[string][]string
+	ExpiresAt    time.Time // expiry of the session
+
+	AssertionID        string
+	AssertionExpiresAt time.Time
+}
+
+type samlResponse struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+	ID           string   `xml:"ID,attr"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Destination  string   `xml:"Destination,attr"`
+	Issuer       string   `xml:"Issuer"`
+	Status       struct {
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"StatusCode"`
+		StatusMessage string `xml:"StatusMessage"`
+	} `xml:"Status"`
+	Assertion *samlAssertion `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+}
+
+type samlAssertion struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	ID      string   `xml:"ID,attr"`
+	Issuer  string   `xml:"Issuer"`
+	Subject struct {
+		NameID               string `xml:"NameID"`
+		SubjectConfirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				InResponseTo string    `xml:"InResponseTo,attr"`
+				Recipient    string    `xml:"Recipient,attr"`
+				NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions struct {
+		NotBefore            time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter         time.Time `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"Audience"`
+		} `xml:"AudienceRestriction"`
+	} `xml:"Conditions"`
+	AuthnStatement struct {
+		AuthnInstant        time.Time `xml:"AuthnInstant,attr"`
+		SessionIndex        string    `xml:"SessionIndex,attr"`
+		SessionNotOnOrAfter time.Time `xml:"SessionNotOnOrAfter,attr"`
+	} `xml:"AuthnStatement"`
+	AttributeStatement struct {
+		Attributes []struct {
+			Name   string   `xml:"Name,attr"`
+			Values []string `xml:"AttributeValue"`
+		} `xml:"Attribute"`
+	} `xml:"AttributeStatement"`
+}
+
+// validateResponse validates the response to the request ID, whose signed element is signedXML.
+func (sp *ServiceProvider) validateResponse(responseXML []byte, signedXML []byte, requestID string, now time.Time) (*Identity, error) {
+	// the status is read from the envelope, which may be unsigned
+	var envelope samlResponse
+	err := xml.Unmarshal(responseXML, &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidResponse, err)
+	}
+	if envelope.Status.StatusCode.Value != statusSuccess {
+		return nil, fmt.Errorf("%w: status %s %s", ErrorInvalidResponse, envelope.Status.StatusCode.Value, envelope.Status.StatusMessage)
+	}
+
+	assertion, err := sp.signedAssertion(signedXML, requestID)
+	if err != nil {
+		return nil, err
+	}
+	return sp.validateAssertion(assertion, requestID, now)
+}
+
+// signedAssertion parses the assertion of the signed Response or the signed Assertion.
+func (sp *ServiceProvider) signedAssertion(signedXML []byte, requestID string) (*samlAssertion, error) {
+	root, err := rootElementName(signedXML)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidResponse, err)
+	}
+
+	switch root {
+	case "Response":
+		var response samlResponse
+		err = xml.Unmarshal(signedXML, &response)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrorInvalidResponse, err)
+		}
+		switch {
+		case response.Assertion == nil:
+			return nil, fmt.Errorf("%w: no assertion", ErrorInvalidResponse)
+		case response.InResponseTo != requestID:
+			return nil, ErrorUnknownRequest
+		case response.Destination != "" && response.Destination != sp.conf.ACSURL:
+			return nil, fmt.Errorf("%w: destination %q", ErrorInvalidResponse, response.Destination)
+		case response.Issuer != "" && response.Issuer != sp.conf.IdPEntityID:
+			return nil, fmt.Errorf("%w: issuer %q", ErrorInvalidResponse, response.Issuer)
+		}
+		return response.Assertion, nil
+	case "Assertion":
+		var assertion samlAssertion
+		err = xml.Unmarshal(signedXML, &assertion)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrorInvalidResponse, err)
+		}
+		return &assertion, nil
+	default:
+		return nil, fmt.Errorf("%w: signed element %s", ErrorInvalidResponse, root)
+	}
+}
+
+func (sp *ServiceProvider) validateAssertion(assertion *samlAssertion, requestID string, now time.Time) (*Identity, error) {
+	skew := sp.conf.ClockSkew
+
+	switch {
+	case assertion.ID == "":
+		return nil, fmt.Errorf("%w: no assertion ID", ErrorInvalidResponse)
+	case assertion.Issuer != sp.conf.IdPEntityID:
+		return nil, fmt.Errorf("%w: issuer %q", ErrorInvalidResponse, assertion.Issuer)
+	case assertion.Subject.NameID == "":
+		return nil, fmt.Errorf("%w: no subject", ErrorInvalidResponse)
+	case !assertion.Conditions.NotBefore.IsZero() && assertion.Conditions.NotBefore.After(now.Add(skew)):
+		return nil, fmt.Errorf("%w: assertion is not yet valid", ErrorInvalidResponse)
+	case !assertion.Conditions.NotOnOrAfter.IsZero() && !assertion.Conditions.NotOnOrAfter.After(now.Add(-skew)):
+		return nil, fmt.Errorf("%w: assertion is expired", ErrorInvalidResponse)
+	case len(assertion.Conditions.AudienceRestrictions) == 0:
+		return nil, fmt.Errorf("%w: no audience restriction", ErrorInvalidResponse)
+	}
+
+	// all restrictions must be satisfied
+	for _, restriction := range assertion.Conditions.AudienceRestrictions {
+		if !containsString(restriction.Audiences, sp.conf.EntityID) {
+			return nil, fmt.Errorf("%w: audience %q", ErrorInvalidResponse, restriction.Audiences)
+		}
+	}
+
+	var confirmedUntil time.Time
+	for _, confirmation := range assertion.Subject.SubjectConfirmations {
+		data := confirmation.Data
+		if confirmation.Method == confirmationBearer &&
+			data.Recipient == sp.conf.ACSURL &&
+			data.InResponseTo == requestID &&
+			data.NotOnOrAfter.After(now.Add(-skew)) {
+			confirmedUntil = data.NotOnOrAfter
+			break
+		}
+	}
+	if confirmedUntil.IsZero() {
+		return nil, fmt.Errorf("%w: subject is not confirmed", ErrorInvalidResponse)
+	}
+
+	identity := &Identity{
+		Subject:            assertion.Subject.NameID,
+		SessionIndex:       assertion.AuthnStatement.SessionIndex,
+		AuthnInstant:       assertion.AuthnStatement.AuthnInstant,
+		Attributes:         make(map[string][]string),
+		ExpiresAt:          now.Add(sp.conf.SessionLifetime),
+		AssertionID:        assertion.ID,
+		AssertionExpiresAt: confirmedUntil.Add(skew),
+	}
+	sessionNotOnOrAfter := assertion.AuthnStatement.SessionNotOnOrAfter
+	if !sessionNotOnOrAfter.IsZero() && sessionNotOnOrAfter.Before(identity.ExpiresAt) {
+		identity.ExpiresAt = sessionNotOnOrAfter
+	}
+	for _, attribute := range assertion.AttributeStatement.Attributes {
+		identity.Attributes[attribute.Name] = append(identity.Attributes[attribute.Name], attribute.Values...)
+	}
+	return identity, nil
+}
+
+func rootElementName(b []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func containsString(a []string, x string) bool {
+	for _, s := range a {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
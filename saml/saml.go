@@ -0,0 +1,307 @@
+// Package osecure/saml provides SAML 2.0 service provider (SP-initiated SSO with HTTP-Redirect and HTTP-POST bindings),
+// which logs users in to osecure.OAuthSession, so SAML identity providers work with the same Secured middleware
+// and permission model.
+//
+// Validated assertions are exchanged for session tokens issued by the ServiceProvider, which are verified by
+// its TokenVerifier like OAuth access tokens. The OAuthSession must be created with the TokenVerifier,
+// and OAuthConfig.ClientID must be the EntityID of the ServiceProvider:
+//
+//	sp, err := saml.New(conf)
+//	s := osecure.NewOAuthSession("saml", cookieConf, &osecure.OAuthConfig{ClientID: conf.EntityID}, endpoint, sp.TokenVerifier(getPermissions), callbackURL, nil)
+//	s.SetLoginPage("/saml/login", nil)
+//	mux.Handle("/saml/login", sp.LoginHandler())
+//	mux.Handle("/saml/acs", sp.ACSHandler(s))
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+// Defaults of Config, used when the corresponding field is zero.
+const (
+	DefaultSessionLifetime = 8 * time.Hour
+	DefaultClockSkew       = 3 * time.Minute
+	DefaultRequestLifetime = 10 * time.Minute
+)
+
+// RequestCookieName is the cookie carrying the pending AuthnRequest between LoginHandler and ACSHandler.
+const RequestCookieName = "osecure_saml_request"
+
+var (
+	ErrorInvalidResponse   = errors.New("invalid SAML response")
+	ErrorUnknownRequest    = errors.New("SAML response is not of a pending request")
+	ErrorAssertionReplayed = errors.New("SAML assertion is replayed")
+	ErrorInvalidToken      = errors.New("invalid SAML session token")
+)
+
+// SignatureValidator validates the XML signature of the SAML response with certificates of the identity provider,
+// and returns the signed element (the Response or the Assertion), serialized. Only the returned XML is trusted,
+// which protects against signature wrapping attacks. It's usually an adapter of an XML-DSig library,
+// e.g. goxmldsig's ValidationContext.Validate, which verifies the enveloped signature with exclusive canonicalization.
+type SignatureValidator interface {
+	ValidateSignature(responseXML []byte) (signedXML []byte, err error)
+}
+
+// SignatureValidatorFunc is an adapter to use ordinary function as SignatureValidator.
+type SignatureValidatorFunc func(responseXML []byte) (signedXML []byte, err error)
+
+// ValidateSignature calls f(responseXML).
+func (f SignatureValidatorFunc) ValidateSignature(responseXML []byte) ([]byte, error) {
+	return f(responseXML)
+}
+
+// Config is the config of ServiceProvider.
+type Config struct {
+	EntityID    string // entity ID of the SP, the audience of assertions and the client ID of sessions
+	ACSURL      string // URL of ACSHandler
+	IdPEntityID string // entity ID (issuer) of the identity provider
+	IdPSSOURL   string // single sign-on URL of the identity provider, with HTTP-Redirect binding
+
+	SignatureValidator SignatureValidator
+
+	// SessionKey signs session tokens and pending requests, at least 32 bytes.
+	SessionKey []byte
+
+	// SessionLifetime is the lifetime of session tokens, DefaultSessionLifetime if zero,
+	// shortened to SessionNotOnOrAfter of the assertion.
+	SessionLifetime time.Duration
+
+	ClockSkew time.Duration // DefaultClockSkew if zero
+
+	// AttributeMap renames attributes in extra data of sessions, e.g. to osecure.ExtraKeyRoles.
+	// Attributes are kept with their names by default.
+	AttributeMap map[string]string
+
+	// ReplayCache rejects assertions used before, osecure.MemoryReplayCache if nil.
+	ReplayCache osecure.ReplayCache
+}
+
+// ServiceProvider is a SAML 2.0 service provider.
+type ServiceProvider struct {
+	conf          Config
+	requestCookie *securecookie.SecureCookie
+	replayCache   osecure.ReplayCache
+}
+
+// New creates ServiceProvider with the config.
+func New(conf Config) (*ServiceProvider, error) {
+	switch {
+	case conf.EntityID == "":
+		return nil, errors.New("entity ID is required")
+	case conf.ACSURL == "":
+		return nil, errors.New("ACS URL is required")
+	case conf.IdPEntityID == "":
+		return nil, errors.New("IdP entity ID is required")
+	case conf.IdPSSOURL == "":
+		return nil, errors.New("IdP SSO URL is required")
+	case conf.SignatureValidator == nil:
+		return nil, errors.New("signature validator is required")
+	case len(conf.SessionKey) < 32:
+		return nil, errors.New("session key must be at least 32 bytes")
+	}
+	if conf.SessionLifetime == 0 {
+		conf.SessionLifetime = DefaultSessionLifetime
+	}
+	if conf.ClockSkew == 0 {
+		conf.ClockSkew = DefaultClockSkew
+	}
+
+	sp := &ServiceProvider{
+		conf:          conf,
+		requestCookie: securecookie.New(conf.SessionKey, nil).MaxAge(int(DefaultRequestLifetime.Seconds())),
+		replayCache:   conf.ReplayCache,
+	}
+	if sp.replayCache == nil {
+		sp.replayCache = osecure.NewMemoryReplayCache()
+	}
+	return sp, nil
+}
+
+type pendingRequest struct {
+	ID          string
+	ContinueURI string
+}
+
+// LoginHandler starts SP-initiated SSO, redirecting to the identity provider with an AuthnRequest.
+// Users are redirected back to the "continue" parameter (a local URI) after logging in, like osecure.LoginView.
+func (sp *ServiceProvider) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		continueURI := r.FormValue("continue")
+		if !osecure.IsLocalURI(continueURI) {
+			continueURI = "/"
+		}
+
+		redirectURL, err := sp.startSSO(w, continueURI)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	})
+}
+
+func (sp *ServiceProvider) startSSO(w http.ResponseWriter, continueURI string) (string, error) {
+	id, err := osecure.GenerateID(20)
+	if err != nil {
+		return "", err
+	}
+	id = "_" + id // IDs must not start with a digit (xs:ID)
+
+	encoded, err := sp.requestCookie.Encode(RequestCookieName, &pendingRequest{ID: id, ContinueURI: continueURI})
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     RequestCookieName,
+		Value:    encoded,
+		Path:     "/",
+		MaxAge:   int(DefaultRequestLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(sp.conf.ACSURL, "https://"),
+		SameSite: http.SameSiteNoneMode, // posted back by the identity provider cross-site
+	})
+
+	request, err := sp.authnRequest(id, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	ssoURL, err := url.Parse(sp.conf.IdPSSOURL)
+	if err != nil {
+		return "", err
+	}
+	qry := ssoURL.Query()
+	qry.Set("SAMLRequest", request)
+	ssoURL.RawQuery = qry.Encode()
+	return ssoURL.String(), nil
+}
+
+// authnRequest builds the AuthnRequest, deflated and base64 encoded for HTTP-Redirect binding.
+func (sp *ServiceProvider) authnRequest(id string, now time.Time) (string, error) {
+	var request bytes.Buffer
+	fmt.Fprintf(&request, `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST">`,
+		escapeXML(id), now.UTC().Format(time.RFC3339), escapeXML(sp.conf.IdPSSOURL), escapeXML(sp.conf.ACSURL))
+	fmt.Fprintf(&request, `<saml:Issuer>%s</saml:Issuer>`, escapeXML(sp.conf.EntityID))
+	request.WriteString(`<samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`)
+
+	var deflated bytes.Buffer
+	writer, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+	_, err = writer.Write(request.Bytes())
+	if err != nil {
+		return "", err
+	}
+	err = writer.Close()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(deflated.Bytes()), nil
+}
+
+// ACSHandler is the assertion consumer service receiving SAML responses with HTTP-POST binding.
+// It validates the assertion, saves the session into the cookie of s, and redirects to the continue URI of the login.
+// Unsolicited (IdP-initiated) responses are rejected.
+func (sp *ServiceProvider) ACSHandler(s *osecure.OAuthSession) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		pending, err := sp.takePendingRequest(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		identity, err := sp.consumeResponse(r, pending.ID, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		token, err := sp.issueToken(identity)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = s.SaveToken(w, r, &oauth2.Token{AccessToken: token, TokenType: "Bearer", Expiry: identity.ExpiresAt})
+		if err != nil {
+			http.Error(w, err.Error(), osecure.ErrorStatusCode(err))
+			return
+		}
+
+		http.Redirect(w, r, pending.ContinueURI, http.StatusSeeOther)
+	})
+}
+
+// takePendingRequest gets the pending request from the cookie, which is deleted so the request is used once.
+func (sp *ServiceProvider) takePendingRequest(w http.ResponseWriter, r *http.Request) (*pendingRequest, error) {
+	cookie, err := r.Cookie(RequestCookieName)
+	if err != nil {
+		return nil, ErrorUnknownRequest
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     RequestCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cookie.Secure,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	pending := &pendingRequest{}
+	err = sp.requestCookie.Decode(RequestCookieName, cookie.Value, pending)
+	if err != nil {
+		return nil, ErrorUnknownRequest
+	}
+	return pending, nil
+}
+
+// consumeResponse validates the SAML response of the request ID.
+func (sp *ServiceProvider) consumeResponse(r *http.Request, requestID string, now time.Time) (*Identity, error) {
+	responseXML, err := base64.StdEncoding.DecodeString(r.PostFormValue("SAMLResponse"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidResponse, err)
+	}
+
+	signedXML, err := sp.conf.SignatureValidator.ValidateSignature(responseXML)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidResponse, err)
+	}
+
+	identity, err := sp.validateResponse(responseXML, signedXML, requestID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	isFirstUse, err := sp.replayCache.Use(r.Context(), "saml:"+identity.AssertionID, identity.AssertionExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !isFirstUse {
+		return nil, ErrorAssertionReplayed
+	}
+	return identity, nil
+}
+
+func escapeXML(s string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(s))
+	return escaped.String()
+}
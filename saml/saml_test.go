@@ -0,0 +1,292 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+)
+
+const (
+	testEntityID    = "https://sp.example.com"
+	testACSURL      = "https://sp.example.com/saml/acs"
+	testIdPEntityID = "https://idp.example.com"
+)
+
+// testAssertion are the fields of the test response, which is valid for the request ID by default.
+type testAssertion struct {
+	ID           string
+	Issuer       string
+	Subject      string
+	Audience     string
+	Recipient    string
+	InResponseTo string
+	NotBefore    time.Time
+	NotOnOrAfter time.Time
+	Status       string
+}
+
+func newTestAssertion(requestID string) *testAssertion {
+	now := time.Now()
+	return &testAssertion{
+		ID:           "_assertion-" + requestID,
+		Issuer:       testIdPEntityID,
+		Subject:      "alice",
+		Audience:     testEntityID,
+		Recipient:    testACSURL,
+		InResponseTo: requestID,
+		NotBefore:    now.Add(-time.Minute),
+		NotOnOrAfter: now.Add(5 * time.Minute),
+		Status:       statusSuccess,
+	}
+}
+
+func (a *testAssertion) assertionXML() string {
+	return fmt.Sprintf(`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="%s" Version="2.0">`+
+		`<saml:Issuer>%s</saml:Issuer>`+
+		`<saml:Subject><saml:NameID>%s</saml:NameID>`+
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">`+
+		`<saml:SubjectConfirmationData InResponseTo="%s" Recipient="%s" NotOnOrAfter="%s"/>`+
+		`</saml:SubjectConfirmation></saml:Subject>`+
+		`<saml:Conditions NotBefore="%s" NotOnOrAfter="%s"><saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction></saml:Conditions>`+
+		`<saml:AuthnStatement AuthnInstant="%s" SessionIndex="session-1"/>`+
+		`<saml:AttributeStatement><saml:Attribute Name="groups"><saml:AttributeValue>admin</saml:AttributeValue><saml:AttributeValue>dev</saml:AttributeValue></saml:Attribute></saml:AttributeStatement>`+
+		`</saml:Assertion>`,
+		a.ID, a.Issuer, a.Subject, a.InResponseTo, a.Recipient, a.NotOnOrAfter.UTC().Format(time.RFC3339),
+		a.NotBefore.UTC().Format(time.RFC3339), a.NotOnOrAfter.UTC().Format(time.RFC3339), a.Audience,
+		time.Now().UTC().Format(time.RFC3339))
+}
+
+func (a *testAssertion) responseXML() string {
+	return fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_response" InResponseTo="%s" Destination="%s">`+
+		`<saml:Issuer>%s</saml:Issuer><samlp:Status><samlp:StatusCode Value="%s"/></samlp:Status>%s</samlp:Response>`,
+		a.InResponseTo, testACSURL, testIdPEntityID, a.Status, a.assertionXML())
+}
+
+// newTestServiceProvider creates a SP whose signature validator trusts the whole response,
+// or the signed element returned by signed if it's not nil.
+func newTestServiceProvider(t *testing.T, signed func(responseXML []byte) []byte) *ServiceProvider {
+	t.Helper()
+	sp, err := New(Config{
+		EntityID:    testEntityID,
+		ACSURL:      testACSURL,
+		IdPEntityID: testIdPEntityID,
+		IdPSSOURL:   "https://idp.example.com/sso",
+		SignatureValidator: SignatureValidatorFunc(func(responseXML []byte) ([]byte, error) {
+			if signed != nil {
+				return signed(responseXML), nil
+			}
+			return responseXML, nil
+		}),
+		SessionKey: []byte(strings.Repeat("k", 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+func newTestOAuthSession(sp *ServiceProvider) *osecure.OAuthSession {
+	cookieConf := &osecure.CookieConfig{
+		AuthenticationKey: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32))),
+		EncryptionKey:     base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32))),
+	}
+	return osecure.NewOAuthSession("saml", cookieConf, &osecure.OAuthConfig{ClientID: testEntityID}, osecure.OAuthEndpoint{
+		AuthURL:  "https://sp.example.com/saml/login",
+		TokenURL: "https://sp.example.com/saml/token",
+	}, sp.TokenVerifier(nil), "https://sp.example.com/callback", nil)
+}
+
+var requestIDPattern = regexp.MustCompile(`ID="([^"]+)"`)
+
+// startLogin starts the login, returning the ID of the AuthnRequest and the pending request cookie.
+func startLogin(t *testing.T, sp *ServiceProvider, continueURI string) (string, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	sp.LoginHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/saml/login?continue="+url.QueryEscape(continueURI), nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("login: got %d", w.Code)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deflated, err := base64.StdEncoding.DecodeString(location.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := requestIDPattern.FindSubmatch(request)
+	if match == nil {
+		t.Fatalf("no ID in AuthnRequest %s", request)
+	}
+	return string(match[1]), w.Result().Cookies()[0]
+}
+
+func postResponse(sp *ServiceProvider, s *osecure.OAuthSession, cookie *http.Cookie, responseXML string) *httptest.ResponseRecorder {
+	form := url.Values{"SAMLResponse": {base64.StdEncoding.EncodeToString([]byte(responseXML))}}
+	r := httptest.NewRequest(http.MethodPost, testACSURL, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	sp.ACSHandler(s).ServeHTTP(w, r)
+	return w
+}
+
+func TestLogin(t *testing.T) {
+	sp := newTestServiceProvider(t, nil)
+	s := newTestOAuthSession(sp)
+
+	requestID, cookie := startLogin(t, sp, "/dashboard")
+	w := postResponse(sp, s, cookie, newTestAssertion(requestID).responseXML())
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("ACS: got %d to %q: %s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name != RequestCookieName {
+			r.AddCookie(cookie)
+		}
+	}
+	data, err := s.Verify(r)
+	if err != nil || data.UserID != "alice" || data.Extra[ExtraKeySessionIndex] != "session-1" {
+		t.Fatalf("session: got %+v, %v", data, err)
+	}
+
+	// the assertion is used once, even with the cookie of the pending request
+	if w := postResponse(sp, s, cookie, newTestAssertion(requestID).responseXML()); w.Code == http.StatusSeeOther {
+		t.Error("response replayed with the pending request")
+	}
+}
+
+func TestLoginUnsolicited(t *testing.T) {
+	sp := newTestServiceProvider(t, nil)
+	s := newTestOAuthSession(sp)
+
+	if w := postResponse(sp, s, nil, newTestAssertion("_unsolicited").responseXML()); w.Code != http.StatusBadRequest {
+		t.Errorf("unsolicited response: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	_, cookie := startLogin(t, sp, "/")
+	if w := postResponse(sp, s, cookie, newTestAssertion("_other").responseXML()); w.Code != http.StatusUnauthorized {
+		t.Errorf("response to other request: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	sp := newTestServiceProvider(t, nil)
+	const requestID = "_request"
+	now := time.Now()
+
+	for name, alter := range map[string]func(a *testAssertion){
+		"other audience":       func(a *testAssertion) { a.Audience = "https://other.example.com" },
+		"other issuer":         func(a *testAssertion) { a.Issuer = "https://evil.example.com" },
+		"other recipient":      func(a *testAssertion) { a.Recipient = "https://other.example.com/acs" },
+		"other request":        func(a *testAssertion) { a.InResponseTo = "_other" },
+		"expired":              func(a *testAssertion) { a.NotOnOrAfter = now.Add(-time.Hour) },
+		"not yet valid":        func(a *testAssertion) { a.NotBefore = now.Add(time.Hour) },
+		"without subject":      func(a *testAssertion) { a.Subject = "" },
+		"without assertion ID": func(a *testAssertion) { a.ID = "" },
+		"failure status":       func(a *testAssertion) { a.Status = "urn:oasis:names:tc:SAML:2.0:status:Requester" },
+	} {
+		assertion := newTestAssertion(requestID)
+		alter(assertion)
+		responseXML := []byte(assertion.responseXML())
+		_, err := sp.validateResponse(responseXML, responseXML, requestID, now)
+		if !errors.Is(err, ErrorInvalidResponse) && !errors.Is(err, ErrorUnknownRequest) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	responseXML := []byte(newTestAssertion(requestID).responseXML())
+	identity, err := sp.validateResponse(responseXML, responseXML, requestID, now)
+	if err != nil || identity.Subject != "alice" || len(identity.Attributes["groups"]) != 2 {
+		t.Errorf("valid response: got %+v, %v", identity, err)
+	}
+}
+
+func TestConsumeResponseReplayed(t *testing.T) {
+	sp := newTestServiceProvider(t, nil)
+	const requestID = "_request"
+	form := url.Values{"SAMLResponse": {base64.StdEncoding.EncodeToString([]byte(newTestAssertion(requestID).responseXML()))}}
+
+	consume := func() error {
+		r := httptest.NewRequest(http.MethodPost, testACSURL, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		_, err := sp.consumeResponse(r, requestID, time.Now())
+		return err
+	}
+	if err := consume(); err != nil {
+		t.Fatal(err)
+	}
+	if err := consume(); err != ErrorAssertionReplayed {
+		t.Errorf("replayed assertion: got %v, want %v", err, ErrorAssertionReplayed)
+	}
+}
+
+func TestSignatureWrapping(t *testing.T) {
+	const requestID = "_request"
+	signedAssertion := newTestAssertion(requestID)
+
+	// only the assertion of alice is signed, the response carries another one of admin
+	sp := newTestServiceProvider(t, func(responseXML []byte) []byte {
+		return []byte(signedAssertion.assertionXML())
+	})
+	wrapped := newTestAssertion(requestID)
+	wrapped.Subject = "admin"
+
+	responseXML := []byte(wrapped.responseXML())
+	signedXML, err := sp.conf.SignatureValidator.ValidateSignature(responseXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := sp.validateResponse(responseXML, signedXML, requestID, time.Now())
+	if err != nil || identity.Subject != "alice" {
+		t.Errorf("got %+v, %v, want the signed subject", identity, err)
+	}
+}
+
+func TestTokenVerifier(t *testing.T) {
+	sp := newTestServiceProvider(t, nil)
+	verifier := sp.TokenVerifier(nil)
+
+	token, err := sp.issueToken(&Identity{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, clientID, _, _, err := verifier.IntrospectTokenFunc(context.Background(), token)
+	if err != nil || userID != "alice" || clientID != testEntityID {
+		t.Errorf("got %q %q %v", userID, clientID, err)
+	}
+
+	expired, err := sp.issueToken(&Identity{Subject: "alice", ExpiresAt: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{
+		"expired":  expired,
+		"tampered": token[:len(token)-2] + "AA",
+	} {
+		if _, _, _, _, err := verifier.IntrospectTokenFunc(context.Background(), token); err == nil {
+			t.Errorf("%s token accepted", name)
+		}
+	}
+}
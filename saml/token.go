@@ -0,0 +1,99 @@
+package saml
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+const tokenPrefix = "saml."
+
+// ExtraKeySessionIndex is the key of SessionIndex of the assertion in extra data of sessions.
+const ExtraKeySessionIndex = "sid"
+
+type tokenPayload struct {
+	Subject      string              `json:"sub"`
+	ExpiresAt    int64               `json:"exp"`
+	AuthTime     int64               `json:"auth_time,omitempty"`
+	SessionIndex string              `json:"sid,omitempty"`
+	Attributes   map[string][]string `json:"attrs,omitempty"`
+}
+
+// signedToken signs session tokens by the key derived from the session key, separated from the key of pending requests.
+func (sp *ServiceProvider) signedToken() osecure.SignedToken {
+	mac := hmac.New(sha256.New, sp.conf.SessionKey)
+	mac.Write([]byte("osecure/saml session token"))
+	return osecure.SignedToken{Prefix: tokenPrefix, Key: mac.Sum(nil)}
+}
+
+// issueToken issues the session token of the identity.
+func (sp *ServiceProvider) issueToken(identity *Identity) (string, error) {
+	payload := &tokenPayload{
+		Subject:      identity.Subject,
+		ExpiresAt:    identity.ExpiresAt.Unix(),
+		SessionIndex: identity.SessionIndex,
+		Attributes:   identity.Attributes,
+	}
+	if !identity.AuthnInstant.IsZero() {
+		payload.AuthTime = identity.AuthnInstant.Unix()
+	}
+	return sp.signedToken().Sign(payload)
+}
+
+func (sp *ServiceProvider) parseToken(token string) (*tokenPayload, error) {
+	payload := &tokenPayload{}
+	err := sp.signedToken().Parse(token, payload)
+	if err != nil || time.Now().Unix() >= payload.ExpiresAt {
+		return nil, ErrorInvalidToken
+	}
+	return payload, nil
+}
+
+// TokenVerifier is the token verifier of session tokens issued by ACSHandler, for the OAuthSession of the SP.
+// Extra data has the issuer (IdPEntityID), auth_time, ExtraKeySessionIndex and attributes (see Config.AttributeMap),
+// single values of attributes are strings and multiple values are lists.
+// Permissions are got by getPermissions, or can be mapped from attributes by osecure.SetClaimsMapper if it's nil.
+func (sp *ServiceProvider) TokenVerifier(getPermissions osecure.GetPermissionsFunc) *osecure.TokenVerifier {
+	if getPermissions == nil {
+		getPermissions = func(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+			return nil, nil
+		}
+	}
+	return &osecure.TokenVerifier{
+		IntrospectTokenFunc: sp.introspectToken,
+		GetPermissionsFunc:  getPermissions,
+	}
+}
+
+func (sp *ServiceProvider) introspectToken(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+	payload, err := sp.parseToken(accessToken)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+
+	extra = make(map[string]interface{}, len(payload.Attributes)+3)
+	for name, values := range payload.Attributes {
+		key := name
+		if mapped, found := sp.conf.AttributeMap[name]; found {
+			key = mapped
+		}
+		if len(values) == 1 {
+			extra[key] = values[0]
+		} else {
+			extra[key] = values
+		}
+	}
+	extra[osecure.ExtraKeyIssuer] = sp.conf.IdPEntityID
+	if payload.AuthTime != 0 {
+		extra[osecure.ExtraKeyAuthTime] = payload.AuthTime
+	}
+	if payload.SessionIndex != "" {
+		extra[ExtraKeySessionIndex] = payload.SessionIndex
+	}
+
+	return payload.Subject, sp.conf.EntityID, payload.ExpiresAt, extra, nil
+}
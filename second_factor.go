@@ -0,0 +1,111 @@
+package osecure
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SetSecondFactor requires a second factor (e.g. osecure/webauthn) after users log in through CallbackView.
+// Sessions are rejected with ErrorSecondFactorRequired until CompleteSecondFactor sets SecondFactorAt of the session,
+// users are redirected to the path with the "continue" parameter, and API clients get 401.
+// Bearer tokens aren't affected, and all sessions require the second factor unless SetMFAEnforcer is called.
+// It should be called before serving requests.
+func (s *OAuthSession) SetSecondFactor(path string) {
+	s.secondFactorPath = path
+}
+
+// MFAEnforcer decides which sessions require the second factor of SetSecondFactor, e.g. osecure/totp.
+type MFAEnforcer interface {
+	RequiresSecondFactor(data *AuthSessionData) bool
+}
+
+// MFAEnforcerFunc is an adapter to use ordinary function as MFAEnforcer.
+type MFAEnforcerFunc func(data *AuthSessionData) bool
+
+// RequiresSecondFactor calls f(data).
+func (f MFAEnforcerFunc) RequiresSecondFactor(data *AuthSessionData) bool {
+	return f(data)
+}
+
+// RequireMFAClaim is MFAEnforcer which requires the second factor unless the token claims MFA, see HasMFAClaim.
+var RequireMFAClaim MFAEnforcer = MFAEnforcerFunc(func(data *AuthSessionData) bool {
+	return !HasMFAClaim(data.Extra)
+})
+
+// HasMFAClaim checks if the identity provider authenticated the user with multiple factors,
+// by "amr" containing "mfa" (RFC 8176) or "mfa" being true in the claims.
+func HasMFAClaim(claims map[string]interface{}) bool {
+	if mfa, _ := claims["mfa"].(bool); mfa {
+		return true
+	}
+	return hasAMR(claims, "mfa")
+}
+
+// hasAMR checks if "amr" of the claims contains the authentication method.
+func hasAMR(claims map[string]interface{}, method string) bool {
+	switch amr := claims["amr"].(type) {
+	case []interface{}:
+		for _, m := range amr {
+			if m == method {
+				return true
+			}
+		}
+	case []string:
+		for _, m := range amr {
+			if m == method {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetMFAEnforcer sets the MFAEnforcer deciding which sessions require the second factor, e.g. RequireMFAClaim
+// so users already passed MFA of the identity provider aren't asked again. It should be called before serving requests.
+func (s *OAuthSession) SetMFAEnforcer(enforcer MFAEnforcer) {
+	s.mfaEnforcer = enforcer
+}
+
+// IsSecondFactorPending checks if the session logged in interactively without passing the required second factor.
+func (s *OAuthSession) IsSecondFactorPending(data *AuthSessionData) bool {
+	if s.secondFactorPath == "" || data.AuthTime.IsZero() || !data.SecondFactorAt.IsZero() {
+		return false
+	}
+	return s.mfaEnforcer == nil || s.mfaEnforcer.RequiresSecondFactor(data)
+}
+
+// SecondFactorURL is the URL of the second factor page, redirecting to continueURI after passing it.
+func (s *OAuthSession) SecondFactorURL(continueURI string) string {
+	if continueURI == "" {
+		return s.secondFactorPath
+	}
+	return s.secondFactorPath + "?" + url.Values{"continue": {continueURI}}.Encode()
+}
+
+// VerifyPendingSecondFactor verifies the request like VerifyRequest, but accepts sessions pending the second factor,
+// for handlers of the second factor. It never writes cookies.
+func (s *OAuthSession) VerifyPendingSecondFactor(r *http.Request) (*AuthSessionData, error) {
+	data, _, err := s.verifyFirstFactor(r)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// CompleteSecondFactor marks the session of the request passed the second factor, saving it into the cookie.
+func (s *OAuthSession) CompleteSecondFactor(w http.ResponseWriter, r *http.Request) error {
+	data, _, err := s.verifyFirstFactor(r)
+	if err != nil {
+		return err
+	}
+
+	data.SecondFactorAt = time.Now()
+	err = s.setAuthCookie(w, r, data.AuthSessionCookieData)
+	if err != nil {
+		return WrapError(ErrorStringUnableToSetCookie, err)
+	}
+
+	s.audit(r, AuditEventSecondFactor, data, nil)
+	return nil
+}
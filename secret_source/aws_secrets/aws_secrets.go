@@ -0,0 +1,105 @@
+// Package osecure/secret_source/aws_secrets fetches osecure secrets from AWS Secrets Manager.
+// The secret string is a JSON object of secret_source keys.
+package aws_secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rayark/osecure/v6/aws_sigv4"
+	"github.com/rayark/osecure/v6/secret_source"
+)
+
+// Client reads a secret of AWS Secrets Manager.
+type Client struct {
+	// Region is the AWS region, AWS_REGION if empty.
+	Region string
+	// SecretID is the ARN or name of the secret.
+	SecretID string
+	// VersionStage is the staging label of the version, AWSCURRENT if empty.
+	VersionStage string
+	// Credentials gets AWS credentials, aws_sigv4.CredentialsFromEnv if nil.
+	Credentials func(ctx context.Context) (aws_sigv4.Credentials, error)
+
+	HTTPClient *http.Client
+}
+
+// NewSource creates source of the secret, with region and credentials from environment variables.
+func NewSource(secretID string) *secret_source.Source {
+	client := &Client{SecretID: secretID}
+	return secret_source.NewSource(client.Fetch)
+}
+
+func (c *Client) region() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	return os.Getenv("AWS_REGION")
+}
+
+func (c *Client) credentials(ctx context.Context) (aws_sigv4.Credentials, error) {
+	if c.Credentials != nil {
+		return c.Credentials(ctx)
+	}
+	return aws_sigv4.CredentialsFromEnv()
+}
+
+// Fetch is a secret_source.FetchFunc calling GetSecretValue.
+func (c *Client) Fetch(ctx context.Context) (map[string]string, error) {
+	creds, err := c.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input := map[string]string{"SecretId": c.SecretID}
+	if c.VersionStage != "" {
+		input["VersionStage"] = c.VersionStage
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	region := c.region()
+	req, err := http.NewRequest(http.MethodPost, "https://secretsmanager."+region+".amazonaws.com/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	aws_sigv4.SignRequest(req, body, creds, region, "secretsmanager", time.Now())
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("AWS Secrets Manager error: status code: %d, body: %s", resp.StatusCode, b)
+	}
+
+	var result struct {
+		SecretString string `json:"SecretString"`
+	}
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return nil, err
+	}
+	return secret_source.ParseJSON([]byte(result.SecretString))
+}
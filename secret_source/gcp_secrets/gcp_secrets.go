@@ -0,0 +1,148 @@
+// Package osecure/secret_source/gcp_secrets fetches osecure secrets from Google Cloud Secret Manager.
+// The secret payload is a JSON object of secret_source keys.
+package gcp_secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/rayark/osecure/v6/secret_source"
+	"golang.org/x/oauth2"
+)
+
+// MetadataTokenURL is the token endpoint of the default service account on Google Cloud.
+const MetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// Client reads a secret version of Secret Manager.
+type Client struct {
+	Project string
+	Secret  string
+	// Version is the secret version, "latest" if empty.
+	Version string
+	// TokenSource provides access tokens, MetadataTokenSource if nil.
+	TokenSource oauth2.TokenSource
+
+	HTTPClient *http.Client
+}
+
+// NewSource creates source of the latest version of the secret, authenticated as the default service account.
+func NewSource(project string, secret string) *secret_source.Source {
+	client := &Client{Project: project, Secret: secret}
+	return secret_source.NewSource(client.Fetch)
+}
+
+func (c *Client) version() string {
+	if c.Version != "" {
+		return c.Version
+	}
+	return "latest"
+}
+
+func (c *Client) httpClient(ctx context.Context) *http.Client {
+	tokenSource := c.TokenSource
+	if tokenSource == nil {
+		tokenSource = MetadataTokenSource(ctx, c.HTTPClient)
+	}
+	if c.HTTPClient != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, c.HTTPClient)
+	}
+	return oauth2.NewClient(ctx, tokenSource)
+}
+
+// Fetch is a secret_source.FetchFunc accessing the secret version.
+func (c *Client) Fetch(ctx context.Context) (map[string]string, error) {
+	url := fmt.Sprintf("https://secretmanager.googleapis.com/v1/projects/%s/secrets/%s/versions/%s:access", c.Project, c.Secret, c.version())
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.httpClient(ctx).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Secret Manager error: status code: %d, body: %s", resp.StatusCode, b)
+	}
+
+	var result struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(result.Payload.Data)
+	if err != nil {
+		return nil, err
+	}
+	return secret_source.ParseJSON(data)
+}
+
+type metadataTokenSource struct {
+	ctx        context.Context
+	httpClient *http.Client
+}
+
+// MetadataTokenSource gets access tokens of the default service account from the metadata server.
+func MetadataTokenSource(ctx context.Context, httpClient *http.Client) oauth2.TokenSource {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return oauth2.ReuseTokenSource(nil, &metadataTokenSource{ctx: ctx, httpClient: httpClient})
+}
+
+func (ts *metadataTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest(http.MethodGet, MetadataTokenURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ts.ctx)
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := ts.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata server error: status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return makeToken(result.AccessToken, result.TokenType, result.ExpiresIn), nil
+}
+
+func makeToken(accessToken string, tokenType string, expiresIn int64) *oauth2.Token {
+	token := &oauth2.Token{
+		AccessToken: accessToken,
+		TokenType:   tokenType,
+	}
+	if expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token
+}
@@ -0,0 +1,143 @@
+// Package osecure/secret_source provides osecure.KeyProvider backed by secret stores, with caching and rotation callbacks.
+// Secrets are flat key-value pairs with the following keys:
+//
+//	authentication_key, encryption_key                     cookie keys, as CookieConfig
+//	previous_authentication_key, previous_encryption_key   optional, the previous cookie keys during rotation
+//	client_secret                                          optional, the OAuth client secret
+package secret_source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+)
+
+// Keys of secrets.
+const (
+	KeyAuthenticationKey         = "authentication_key"
+	KeyEncryptionKey             = "encryption_key"
+	KeyPreviousAuthenticationKey = "previous_authentication_key"
+	KeyPreviousEncryptionKey     = "previous_encryption_key"
+	KeyClientSecret              = "client_secret"
+	KeySessionStoreKey           = "session_store_key"
+	KeyPreviousSessionStoreKey   = "previous_session_store_key"
+)
+
+// DefaultTTL is how long fetched secrets are cached.
+const DefaultTTL = 5 * time.Minute
+
+var (
+	ErrorEmptySecret = errors.New("secret has no cookie keys, client secret or session store key")
+)
+
+// FetchFunc fetches secret values from the secret store.
+type FetchFunc func(ctx context.Context) (map[string]string, error)
+
+// Source is an osecure.KeyProvider which caches secrets fetched by FetchFunc for TTL.
+type Source struct {
+	Fetch FetchFunc
+	// TTL is how long fetched secrets are cached, DefaultTTL if zero.
+	TTL time.Duration
+	// OnRotate is called when the fetched secrets differ from the previous ones, can be nil.
+	OnRotate func(keys *osecure.SecretKeys)
+
+	mu        sync.Mutex
+	keys      *osecure.SecretKeys
+	fetchedAt time.Time
+}
+
+// NewSource creates source with DefaultTTL.
+func NewSource(fetch FetchFunc) *Source {
+	return &Source{
+		Fetch: fetch,
+	}
+}
+
+func (s *Source) ttl() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return DefaultTTL
+}
+
+// LoadKeys returns cached secrets, or fetches them if expired.
+func (s *Source) LoadKeys(ctx context.Context) (*osecure.SecretKeys, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keys != nil && time.Since(s.fetchedAt) < s.ttl() {
+		return s.keys, nil
+	}
+
+	values, err := s.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseSecretKeys(values)
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := s.keys != nil && !reflect.DeepEqual(s.keys, keys)
+	s.keys = keys
+	s.fetchedAt = time.Now()
+
+	if rotated && s.OnRotate != nil {
+		s.OnRotate(keys)
+	}
+	return keys, nil
+}
+
+// Invalidate drops the cached secrets, so the next LoadKeys fetches them.
+func (s *Source) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+}
+
+// ParseSecretKeys converts secret values to osecure.SecretKeys.
+func ParseSecretKeys(values map[string]string) (*osecure.SecretKeys, error) {
+	keys := &osecure.SecretKeys{
+		ClientSecret: values[KeyClientSecret],
+	}
+
+	if values[KeyAuthenticationKey] != "" || values[KeyEncryptionKey] != "" {
+		keys.Cookie = &osecure.CookieConfig{
+			AuthenticationKey: values[KeyAuthenticationKey],
+			EncryptionKey:     values[KeyEncryptionKey],
+		}
+		if values[KeyPreviousAuthenticationKey] != "" || values[KeyPreviousEncryptionKey] != "" {
+			keys.Cookie.PreviousKeys = []osecure.CookieKeyPair{{
+				AuthenticationKey: values[KeyPreviousAuthenticationKey],
+				EncryptionKey:     values[KeyPreviousEncryptionKey],
+			}}
+		}
+	}
+
+	for _, key := range []string{KeySessionStoreKey, KeyPreviousSessionStoreKey} {
+		if values[key] != "" {
+			keys.SessionStoreKeys = append(keys.SessionStoreKeys, values[key])
+		}
+	}
+
+	if keys.Cookie == nil && keys.ClientSecret == "" && len(keys.SessionStoreKeys) == 0 {
+		return nil, ErrorEmptySecret
+	}
+	return keys, nil
+}
+
+// ParseJSON parses a secret stored as JSON object of string values.
+func ParseJSON(b []byte) (map[string]string, error) {
+	var values map[string]string
+	err := json.Unmarshal(b, &values)
+	if err != nil {
+		return nil, fmt.Errorf("secret is not a JSON object of strings: %w", err)
+	}
+	return values, nil
+}
@@ -0,0 +1,101 @@
+// Package osecure/secret_source/vault fetches osecure secrets from HashiCorp Vault KV version 2 secrets engine.
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rayark/osecure/v6/secret_source"
+)
+
+// DefaultMount is the default mount path of KV secrets engine.
+const DefaultMount = "secret"
+
+// Client reads a secret of KV version 2 secrets engine.
+type Client struct {
+	// Address is the address of Vault, e.g. https://vault.example.com:8200. VAULT_ADDR if empty.
+	Address string
+	// Token is the Vault token. VAULT_TOKEN if empty.
+	Token string
+	// Mount is the mount path of KV secrets engine, DefaultMount if empty.
+	Mount string
+	// Path is the path of the secret in the secrets engine.
+	Path string
+	// Namespace is the Vault Enterprise namespace, can be empty.
+	Namespace string
+
+	HTTPClient *http.Client
+}
+
+// NewSource creates source of the secret at the path of the default KV mount, with VAULT_ADDR and VAULT_TOKEN.
+func NewSource(path string) *secret_source.Source {
+	client := &Client{Path: path}
+	return secret_source.NewSource(client.Fetch)
+}
+
+func (c *Client) address() string {
+	if c.Address != "" {
+		return strings.TrimSuffix(c.Address, "/")
+	}
+	return strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+}
+
+func (c *Client) token() string {
+	if c.Token != "" {
+		return c.Token
+	}
+	return os.Getenv("VAULT_TOKEN")
+}
+
+func (c *Client) mount() string {
+	if c.Mount != "" {
+		return strings.Trim(c.Mount, "/")
+	}
+	return DefaultMount
+}
+
+// Fetch is a secret_source.FetchFunc reading the latest version of the secret.
+func (c *Client) Fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.address()+"/v1/"+c.mount()+"/data/"+strings.TrimPrefix(c.Path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", c.token())
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return nil, fmt.Errorf("vault error: status code: %d, errors: %s", resp.StatusCode, strings.Join(result.Errors, "; "))
+	}
+
+	var result struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Data.Data, nil
+}
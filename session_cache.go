@@ -0,0 +1,362 @@
+package osecure
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of TieredCacheConfig.
+const (
+	DefaultLocalCacheSize = 10000
+	DefaultLocalCacheTTL  = 10 * time.Second
+	DefaultSharedCacheTTL = 5 * time.Minute
+)
+
+// SharedCache is a cache shared by instances, e.g. Redis with GET, SET EX and DEL.
+// Get returns nil without error if the key doesn't exist.
+type SharedCache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// InvalidationBus broadcasts invalidated keys to all instances, e.g. Redis pub/sub.
+// Subscribe registers the handler of keys published by any instance until the context is done, without blocking.
+type InvalidationBus interface {
+	Publish(ctx context.Context, key string) error
+	Subscribe(ctx context.Context, handler func(key string)) error
+}
+
+// TieredCacheConfig configures TieredCache.
+type TieredCacheConfig struct {
+	// LocalSize is the max number of entries in the local LRU cache, DefaultLocalCacheSize if zero.
+	LocalSize int
+	// LocalTTL bounds how long entries stay in the local cache, DefaultLocalCacheTTL if zero.
+	// It's the max staleness if an invalidation message is lost.
+	LocalTTL time.Duration
+	// SharedTTL bounds how long entries stay in the shared cache, DefaultSharedCacheTTL if zero.
+	SharedTTL time.Duration
+}
+
+// TieredCacheStats are counters of TieredCache lookups.
+type TieredCacheStats struct {
+	LocalEntries int   `json:"local_entries"`
+	LocalHits    int64 `json:"local_hits"`
+	SharedHits   int64 `json:"shared_hits"`
+	Misses       int64 `json:"misses"`
+}
+
+// TieredCache is a cache with an in-process LRU cache fronting a SharedCache,
+// whose entries are dropped on all instances by the InvalidationBus when invalidated.
+// Failures of the shared cache and the bus are treated as misses, the cache is only an optimization.
+type TieredCache struct {
+	localTTL  time.Duration
+	sharedTTL time.Duration
+	local     *lruCache
+	shared    SharedCache
+	bus       InvalidationBus
+
+	localHits  int64
+	sharedHits int64
+	misses     int64
+}
+
+// NewTieredCache creates TieredCache subscribing invalidations from the bus until the context is done.
+// The shared cache and the bus can be nil for a single instance, leaving only the local cache.
+func NewTieredCache(ctx context.Context, conf TieredCacheConfig, shared SharedCache, bus InvalidationBus) (*TieredCache, error) {
+	size := conf.LocalSize
+	if size <= 0 {
+		size = DefaultLocalCacheSize
+	}
+	cache := &TieredCache{
+		localTTL:  durationOrDefault(conf.LocalTTL, DefaultLocalCacheTTL),
+		sharedTTL: durationOrDefault(conf.SharedTTL, DefaultSharedCacheTTL),
+		local:     newLRUCache(size),
+		shared:    shared,
+		bus:       bus,
+	}
+
+	if bus != nil {
+		err := bus.Subscribe(ctx, cache.local.delete)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cache, nil
+}
+
+// Get gets the value of the key from the local cache, then the shared cache, returns nil if it's not cached.
+func (cache *TieredCache) Get(ctx context.Context, key string) []byte {
+	value := cache.local.get(key)
+	if value != nil {
+		atomic.AddInt64(&cache.localHits, 1)
+		return value
+	}
+
+	if cache.shared != nil {
+		value, err := cache.shared.Get(ctx, key)
+		if err == nil && value != nil {
+			atomic.AddInt64(&cache.sharedHits, 1)
+			cache.local.add(key, value, cache.localTTL)
+			return value
+		}
+	}
+
+	atomic.AddInt64(&cache.misses, 1)
+	return nil
+}
+
+// Set caches the value of the key for the TTL, capped by LocalTTL and SharedTTL of each tier.
+func (cache *TieredCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	cache.local.add(key, value, minDuration(ttl, cache.localTTL))
+	if cache.shared != nil {
+		cache.shared.Set(ctx, key, value, minDuration(ttl, cache.sharedTTL))
+	}
+}
+
+// Invalidate drops the key from the shared cache and the local caches of all instances.
+func (cache *TieredCache) Invalidate(ctx context.Context, key string) error {
+	cache.local.delete(key)
+
+	if cache.shared != nil {
+		err := cache.shared.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	if cache.bus != nil {
+		return cache.bus.Publish(ctx, key)
+	}
+	return nil
+}
+
+// Stats gets the current TieredCacheStats.
+func (cache *TieredCache) Stats() TieredCacheStats {
+	return TieredCacheStats{
+		LocalEntries: cache.local.len(),
+		LocalHits:    atomic.LoadInt64(&cache.localHits),
+		SharedHits:   atomic.LoadInt64(&cache.sharedHits),
+		Misses:       atomic.LoadInt64(&cache.misses),
+	}
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// lruCache is a size bounded cache evicting the least recently used entry.
+type lruCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List // of *lruEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (cache *lruCache) get(key string) []byte {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, found := cache.entries[key]
+	if !found {
+		return nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !entry.expiresAt.After(time.Now()) {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+		return nil
+	}
+	cache.order.MoveToFront(element)
+	return entry.value
+}
+
+func (cache *lruCache) add(key string, value []byte, ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry := &lruEntry{key: key, value: value, expiresAt: time.Now().Add(ttl)}
+	if element, found := cache.entries[key]; found {
+		element.Value = entry
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(entry)
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (cache *lruCache) delete(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, found := cache.entries[key]; found {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+	}
+}
+
+func (cache *lruCache) len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.order.Len()
+}
+
+// CachedSessionStore is a SessionStore decorator caching loaded records in a TieredCache,
+// so verifying sessions doesn't hit the store on every request. Saving or deleting a record invalidates it
+// on all instances. Wrap it by EncryptedSessionStore rather than the other way around,
+// so payloads in the shared cache are encrypted.
+type CachedSessionStore struct {
+	store SessionStore
+	cache *TieredCache
+}
+
+// NewCachedSessionStore creates CachedSessionStore of the store.
+func NewCachedSessionStore(store SessionStore, cache *TieredCache) *CachedSessionStore {
+	return &CachedSessionStore{store: store, cache: cache}
+}
+
+func sessionCacheKey(id string) string {
+	return "osecure:session:" + id
+}
+
+func (store *CachedSessionStore) Save(ctx context.Context, record *SessionRecord) error {
+	err := store.store.Save(ctx, record)
+	if err != nil {
+		return err
+	}
+	return store.cache.Invalidate(ctx, sessionCacheKey(record.ID))
+}
+
+func (store *CachedSessionStore) SaveIfExists(ctx context.Context, record *SessionRecord) error {
+	err := store.store.SaveIfExists(ctx, record)
+	if err != nil {
+		return err
+	}
+	return store.cache.Invalidate(ctx, sessionCacheKey(record.ID))
+}
+
+func (store *CachedSessionStore) Load(ctx context.Context, id string) (*SessionRecord, error) {
+	key := sessionCacheKey(id)
+	if value := store.cache.Get(ctx, key); value != nil {
+		record := &SessionRecord{}
+		err := json.Unmarshal(value, record)
+		if err == nil && (record.ExpiresAt.IsZero() || record.ExpiresAt.After(time.Now())) {
+			return record, nil
+		}
+	}
+
+	record, err := store.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ttl := store.cache.sharedTTL
+	if !record.ExpiresAt.IsZero() {
+		ttl = time.Until(record.ExpiresAt)
+	}
+	if value, err := json.Marshal(record); err == nil {
+		store.cache.Set(ctx, key, value, ttl)
+	}
+	return record, nil
+}
+
+func (store *CachedSessionStore) Delete(ctx context.Context, id string) error {
+	err := store.store.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+	return store.cache.Invalidate(ctx, sessionCacheKey(id))
+}
+
+// Find lists matched records from the store, which isn't cached.
+func (store *CachedSessionStore) Find(ctx context.Context, query SessionQuery) ([]*SessionRecord, error) {
+	return store.store.Find(ctx, query)
+}
+
+// SetIntrospectionCache caches successful introspection results of tokens in the cache until the tokens expire,
+// so instances share results instead of introspecting on every request. Tokens revoked at the auth server
+// are accepted until their entries expire (bounded by TieredCacheConfig), but logging out by ClearSession
+// invalidates the token of the session. It should be called before serving requests.
+func (s *OAuthSession) SetIntrospectionCache(cache *TieredCache) {
+	s.introspectionCache = cache
+}
+
+// cachedIntrospection is the cached form of IntrospectionResult.
+type cachedIntrospection struct {
+	UserID    string                 `json:"sub"`
+	ClientID  string                 `json:"aud"`
+	ExpiresAt int64                  `json:"exp"`
+	Extra     map[string]interface{} `json:"extra,omitempty"`
+}
+
+func introspectionCacheKey(key [sha256.Size]byte) string {
+	return "osecure:introspection:" + hex.EncodeToString(key[:])
+}
+
+func (s *OAuthSession) getCachedIntrospection(ctx context.Context, key [sha256.Size]byte) (*cachedIntrospection, bool) {
+	value := s.introspectionCache.Get(ctx, introspectionCacheKey(key))
+	if value == nil {
+		return nil, false
+	}
+
+	result := &cachedIntrospection{}
+	err := json.Unmarshal(value, result)
+	if err != nil || (result.ExpiresAt > 0 && time.Unix(result.ExpiresAt, 0).Before(time.Now())) {
+		return nil, false
+	}
+	return result, true
+}
+
+func (s *OAuthSession) cacheIntrospection(ctx context.Context, key [sha256.Size]byte, result *cachedIntrospection) {
+	ttl := s.introspectionCache.sharedTTL
+	if result.ExpiresAt > 0 {
+		ttl = time.Until(time.Unix(result.ExpiresAt, 0))
+	}
+
+	value, err := json.Marshal(result)
+	if err != nil {
+		// extra data not serializable
+		return
+	}
+	s.introspectionCache.Set(ctx, introspectionCacheKey(key), value, ttl)
+}
+
+// invalidateIntrospection drops the cached introspection result of the token, if any.
+func (s *OAuthSession) invalidateIntrospection(ctx context.Context, accessToken string) {
+	if s.introspectionCache == nil || accessToken == "" {
+		return
+	}
+	s.introspectionCache.Invalidate(ctx, introspectionCacheKey(sha256.Sum256([]byte(accessToken))))
+}
@@ -0,0 +1,226 @@
+package osecure
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// payloadFormatAESGCM is the first byte of encrypted payloads, followed by the nonce and the sealed payload.
+// Serialized session data never starts with it, so payloads stored before enabling encryption are still readable.
+const payloadFormatAESGCM = byte(0xe1)
+
+var (
+	errNoSessionStoreKeys = errors.New("no session store keys")
+	errPayloadDecryption  = errors.New("cannot decrypt session payload")
+)
+
+// payloadCipher encrypts payloads of records with AES-GCM, authenticating the record ID,
+// so payloads can't be swapped between records.
+type payloadCipher struct {
+	provider KeyProvider
+	aeads    atomic.Value // []cipher.AEAD, newest first
+}
+
+func newPayloadCipher(ctx context.Context, provider KeyProvider) (*payloadCipher, error) {
+	c := &payloadCipher{provider: provider}
+	err := c.reloadKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *payloadCipher) reloadKeys(ctx context.Context) error {
+	keys, err := c.provider.LoadKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if len(keys.SessionStoreKeys) == 0 {
+		if c.aeads.Load() != nil {
+			// not changed
+			return nil
+		}
+		return errNoSessionStoreKeys
+	}
+
+	aeads := make([]cipher.AEAD, 0, len(keys.SessionStoreKeys))
+	for i, encodedKey := range keys.SessionStoreKeys {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return &ConfigError{Field: fmt.Sprintf("session_store_keys[%d]", i), Reason: "not standard base64", Err: err}
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return &ConfigError{Field: fmt.Sprintf("session_store_keys[%d]", i), Reason: "invalid AES key", Err: err}
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		aeads = append(aeads, aead)
+	}
+	c.aeads.Store(aeads)
+	return nil
+}
+
+func (c *payloadCipher) seal(id string, payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return payload, nil
+	}
+
+	aead := c.aeads.Load().([]cipher.AEAD)[0]
+	sealed := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(payload)+aead.Overhead())
+	sealed[0] = payloadFormatAESGCM
+	_, err := rand.Read(sealed[1:])
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, sealed[1:], payload, []byte(id)), nil
+}
+
+func (c *payloadCipher) open(id string, sealed []byte) ([]byte, error) {
+	if len(sealed) == 0 || sealed[0] != payloadFormatAESGCM {
+		// stored before enabling encryption
+		return sealed, nil
+	}
+
+	for _, aead := range c.aeads.Load().([]cipher.AEAD) {
+		if len(sealed) < 1+aead.NonceSize() {
+			break
+		}
+		nonce := sealed[1 : 1+aead.NonceSize()]
+		payload, err := aead.Open(nil, nonce, sealed[1+aead.NonceSize():], []byte(id))
+		if err == nil {
+			return payload, nil
+		}
+	}
+	return nil, errPayloadDecryption
+}
+
+// EncryptedSessionStore is a SessionStore decorator which encrypts payloads of records by AES-GCM
+// with SessionStoreKeys of the KeyProvider, so token material isn't stored in plaintext, e.g. in Redis or SQL.
+// Payloads are decrypted by any of the keys, and encrypted by the first one.
+type EncryptedSessionStore struct {
+	store  SessionStore
+	cipher *payloadCipher
+}
+
+// NewEncryptedSessionStore creates EncryptedSessionStore of the store, loading keys from the provider.
+func NewEncryptedSessionStore(ctx context.Context, store SessionStore, provider KeyProvider) (*EncryptedSessionStore, error) {
+	c, err := newPayloadCipher(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedSessionStore{store: store, cipher: c}, nil
+}
+
+// ReloadKeys loads keys from the provider again, e.g. periodically along with OAuthSession.WatchKeys.
+// Keys are kept if the provider has no SessionStoreKeys.
+func (store *EncryptedSessionStore) ReloadKeys(ctx context.Context) error {
+	return store.cipher.reloadKeys(ctx)
+}
+
+func (store *EncryptedSessionStore) Save(ctx context.Context, record *SessionRecord) error {
+	payload, err := store.cipher.seal(record.ID, record.Payload)
+	if err != nil {
+		return err
+	}
+	recordCopy := *record
+	recordCopy.Payload = payload
+	return store.store.Save(ctx, &recordCopy)
+}
+
+func (store *EncryptedSessionStore) SaveIfExists(ctx context.Context, record *SessionRecord) error {
+	payload, err := store.cipher.seal(record.ID, record.Payload)
+	if err != nil {
+		return err
+	}
+	recordCopy := *record
+	recordCopy.Payload = payload
+	return store.store.SaveIfExists(ctx, &recordCopy)
+}
+
+func (store *EncryptedSessionStore) Load(ctx context.Context, id string) (*SessionRecord, error) {
+	record, err := store.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	record.Payload, err = store.cipher.open(record.ID, record.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (store *EncryptedSessionStore) Delete(ctx context.Context, id string) error {
+	return store.store.Delete(ctx, id)
+}
+
+// Find lists matched records, without their payloads which are only needed by Load.
+func (store *EncryptedSessionStore) Find(ctx context.Context, query SessionQuery) ([]*SessionRecord, error) {
+	records, err := store.store.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		record.Payload = nil
+	}
+	return records, nil
+}
+
+// EncryptedRememberMeStore is a RememberMeStore decorator which encrypts payloads of records like EncryptedSessionStore,
+// since they carry refresh tokens.
+type EncryptedRememberMeStore struct {
+	store  RememberMeStore
+	cipher *payloadCipher
+}
+
+// NewEncryptedRememberMeStore creates EncryptedRememberMeStore of the store, loading keys from the provider.
+func NewEncryptedRememberMeStore(ctx context.Context, store RememberMeStore, provider KeyProvider) (*EncryptedRememberMeStore, error) {
+	c, err := newPayloadCipher(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedRememberMeStore{store: store, cipher: c}, nil
+}
+
+// ReloadKeys loads keys from the provider again. Keys are kept if the provider has no SessionStoreKeys.
+func (store *EncryptedRememberMeStore) ReloadKeys(ctx context.Context) error {
+	return store.cipher.reloadKeys(ctx)
+}
+
+func (store *EncryptedRememberMeStore) Save(ctx context.Context, record *RememberMeRecord) error {
+	payload, err := store.cipher.seal(record.ID, record.Payload)
+	if err != nil {
+		return err
+	}
+	recordCopy := *record
+	recordCopy.Payload = payload
+	return store.store.Save(ctx, &recordCopy)
+}
+
+func (store *EncryptedRememberMeStore) Load(ctx context.Context, id string) (*RememberMeRecord, error) {
+	record, err := store.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	record.Payload, err = store.cipher.open(record.ID, record.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (store *EncryptedRememberMeStore) Delete(ctx context.Context, id string) error {
+	return store.store.Delete(ctx, id)
+}
+
+func (store *EncryptedRememberMeStore) DeleteByUser(ctx context.Context, userID string) error {
+	return store.store.DeleteByUser(ctx, userID)
+}
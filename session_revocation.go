@@ -0,0 +1,143 @@
+package osecure
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RevocationList revokes cookie sessions by user, covering sessions which are not in a session store.
+type RevocationList interface {
+	// RevokeBefore revokes the sessions of the user created before t.
+	RevokeBefore(ctx context.Context, userID string, t time.Time) error
+	// RevokedBefore returns the time before which sessions of the user are revoked, zero time if none.
+	RevokedBefore(ctx context.Context, userID string) (time.Time, error)
+}
+
+// MemoryRevocationList is a RevocationList in memory.
+// Entries are dropped after Retention, which should be longer than the max session lifetime.
+type MemoryRevocationList struct {
+	Retention time.Duration
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+// NewMemoryRevocationList creates an empty MemoryRevocationList.
+func NewMemoryRevocationList(retention time.Duration) *MemoryRevocationList {
+	return &MemoryRevocationList{
+		Retention: retention,
+		entries:   make(map[string]time.Time),
+	}
+}
+
+func (list *MemoryRevocationList) RevokeBefore(ctx context.Context, userID string, t time.Time) error {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	if t.After(list.entries[userID]) {
+		list.entries[userID] = t
+	}
+
+	// prune expired entries
+	if list.Retention > 0 {
+		for id, revokedBefore := range list.entries {
+			if time.Since(revokedBefore) > list.Retention {
+				delete(list.entries, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (list *MemoryRevocationList) RevokedBefore(ctx context.Context, userID string) (time.Time, error) {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	return list.entries[userID], nil
+}
+
+// SetRevocationList enables revoking cookie sessions by user through RevokeSessionsForSubject.
+// It should be called before serving requests.
+func (s *OAuthSession) SetRevocationList(list RevocationList) {
+	s.revocationList = list
+}
+
+// checkRevocationList checks if the cookie session is not revoked.
+func (s *OAuthSession) checkRevocationList(ctx context.Context, userID string, cookieData *AuthSessionCookieData) error {
+	if s.revocationList == nil {
+		return nil
+	}
+
+	revokedBefore, err := s.revocationList.RevokedBefore(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !revokedBefore.IsZero() && !cookieData.SessionCreatedAt.After(revokedBefore) {
+		return ErrorSessionRevoked
+	}
+	return nil
+}
+
+// ListSessions lists the active sessions of the user in the session store.
+// Payloads of records are omitted since they carry tokens.
+func (s *OAuthSession) ListSessions(ctx context.Context, userID string) ([]*SessionRecord, error) {
+	if s.sessionStore == nil {
+		return nil, ErrorSessionStoreRequired
+	}
+	records, err := s.sessionStore.Find(ctx, SessionQuery{UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		record.Payload = nil
+	}
+	return records, nil
+}
+
+// RevokeSession revokes a session in the session store by ID.
+func (s *OAuthSession) RevokeSession(ctx context.Context, id string) error {
+	if s.sessionStore == nil {
+		return ErrorSessionStoreRequired
+	}
+	return s.sessionStore.Delete(ctx, id)
+}
+
+// RevokeSessionsForSubject revokes all sessions of the user, i.e. logs out all devices.
+// Sessions in the session store are deleted, and cookie sessions created until now are revoked
+// by the revocation list. At least one of them must be enabled.
+// Remember-me tokens of the user are deleted too, otherwise they would restore the sessions.
+func (s *OAuthSession) RevokeSessionsForSubject(ctx context.Context, userID string) error {
+	if s.sessionStore == nil && s.revocationList == nil {
+		return ErrorSessionStoreRequired
+	}
+
+	if s.revocationList != nil {
+		err := s.revocationList.RevokeBefore(ctx, userID, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.sessionStore != nil {
+		records, err := s.sessionStore.Find(ctx, SessionQuery{UserID: userID})
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			err = s.sessionStore.Delete(ctx, record.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.rememberMeStore != nil {
+		err := s.rememberMeStore.DeleteByUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,267 @@
+package osecure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionRecord is the server-side record of a session logged in through CallbackView.
+type SessionRecord struct {
+	ID                string    `json:"id"`
+	UserID            string    `json:"user_id"`
+	ClientID          string    `json:"client_id"`
+	ProviderSessionID string    `json:"provider_session_id,omitempty"` // "sid" of the OpenID provider session, if known
+	CreatedAt         time.Time `json:"created_at"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	Payload           []byte    `json:"payload,omitempty"` // serialized session data in minimal cookie mode, see OAuthConfig.MinimalCookie
+}
+
+// SessionQuery selects session records, empty fields match anything.
+type SessionQuery struct {
+	UserID            string
+	ProviderSessionID string
+}
+
+func (q SessionQuery) match(record *SessionRecord) bool {
+	return (q.UserID == "" || q.UserID == record.UserID) &&
+		(q.ProviderSessionID == "" || q.ProviderSessionID == record.ProviderSessionID)
+}
+
+// SessionStore keeps server-side session records, so sessions can be listed and revoked.
+// Load returns ErrorSessionNotFound if the record doesn't exist or is expired.
+// SaveIfExists saves the record only if it still exists, otherwise returns ErrorSessionNotFound. It must be atomic,
+// e.g. a conditional update, so a record deleted concurrently isn't saved back.
+type SessionStore interface {
+	Save(ctx context.Context, record *SessionRecord) error
+	SaveIfExists(ctx context.Context, record *SessionRecord) error
+	Load(ctx context.Context, id string) (*SessionRecord, error)
+	Delete(ctx context.Context, id string) error
+	Find(ctx context.Context, query SessionQuery) ([]*SessionRecord, error)
+}
+
+// MemorySessionStore is a SessionStore in memory, suitable for single instance deployment and tests.
+type MemorySessionStore struct {
+	mu      sync.Mutex
+	records map[string]*SessionRecord
+}
+
+// NewMemorySessionStore creates an empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		records: make(map[string]*SessionRecord),
+	}
+}
+
+func (store *MemorySessionStore) Save(ctx context.Context, record *SessionRecord) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	recordCopy := *record
+	store.records[record.ID] = &recordCopy
+	return nil
+}
+
+func (store *MemorySessionStore) SaveIfExists(ctx context.Context, record *SessionRecord) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	existing, found := store.records[record.ID]
+	if !found || (!existing.ExpiresAt.IsZero() && !existing.ExpiresAt.After(time.Now())) {
+		return ErrorSessionNotFound
+	}
+
+	recordCopy := *record
+	store.records[record.ID] = &recordCopy
+	return nil
+}
+
+func (store *MemorySessionStore) Load(ctx context.Context, id string) (*SessionRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	record, found := store.records[id]
+	if !found {
+		return nil, ErrorSessionNotFound
+	}
+	if !record.ExpiresAt.IsZero() && !record.ExpiresAt.After(time.Now()) {
+		delete(store.records, id)
+		return nil, ErrorSessionNotFound
+	}
+
+	recordCopy := *record
+	return &recordCopy, nil
+}
+
+func (store *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.records, id)
+	return nil
+}
+
+// Find lists matched records ordered by creation time, and drops expired records.
+func (store *MemorySessionStore) Find(ctx context.Context, query SessionQuery) ([]*SessionRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	records := []*SessionRecord{}
+	for id, record := range store.records {
+		if !record.ExpiresAt.IsZero() && !record.ExpiresAt.After(now) {
+			delete(store.records, id)
+			continue
+		}
+		if query.match(record) {
+			recordCopy := *record
+			records = append(records, &recordCopy)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records, nil
+}
+
+// SetSessionStore enables server-side session records for sessions logged in through CallbackView.
+// Sessions whose record is deleted are rejected. It should be called before serving requests.
+func (s *OAuthSession) SetSessionStore(store SessionStore) {
+	s.sessionStore = store
+}
+
+func generateSessionID() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Policies of OAuthConfig.SessionLimitPolicy, applied when a subject logs in with MaxSessionsPerSubject sessions.
+const (
+	SessionLimitEvictOldest = ""       // the oldest sessions are revoked
+	SessionLimitReject      = "reject" // the login is rejected with ErrorTooManySessions
+)
+
+func (s *OAuthSession) registerSession(ctx context.Context, cookieData *AuthSessionCookieData, userID string, clientID string, providerSessionID string) error {
+	err := s.enforceSessionLimit(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	id, err := generateSessionID()
+	if err != nil {
+		return err
+	}
+
+	record := &SessionRecord{
+		ID:                id,
+		UserID:            userID,
+		ClientID:          clientID,
+		ProviderSessionID: providerSessionID,
+		CreatedAt:         cookieData.SessionCreatedAt,
+		ExpiresAt:         cookieData.Token.Expiry,
+	}
+	err = s.sessionStore.Save(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	cookieData.SessionID = id
+	return nil
+}
+
+// enforceSessionLimit makes room for a new session of the subject, see OAuthConfig.MaxSessionsPerSubject.
+func (s *OAuthSession) enforceSessionLimit(ctx context.Context, userID string) error {
+	if s.maxSessionsPerSubject <= 0 {
+		return nil
+	}
+
+	records, err := s.sessionStore.Find(ctx, SessionQuery{UserID: userID})
+	if err != nil {
+		return err
+	}
+	excess := len(records) - s.maxSessionsPerSubject + 1
+	if excess <= 0 {
+		return nil
+	}
+	if s.sessionLimitPolicy == SessionLimitReject {
+		return ErrorTooManySessions
+	}
+
+	// records are ordered by creation time
+	for _, record := range records[:excess] {
+		err = s.sessionStore.Delete(ctx, record.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSessionRecord checks if the session is not revoked.
+func (s *OAuthSession) checkSessionRecord(ctx context.Context, cookieData *AuthSessionCookieData) error {
+	if s.sessionStore == nil || cookieData.SessionID == "" || s.minimalCookie {
+		// in minimal cookie mode, the session data is loaded from the record
+		return nil
+	}
+
+	_, err := s.sessionStore.Load(ctx, cookieData.SessionID)
+	if err == ErrorSessionNotFound {
+		return ErrorSessionRevoked
+	}
+	return err
+}
+
+// loadSessionPayload loads the session data kept in the session record in minimal cookie mode,
+// returns nil if the record doesn't exist.
+func (s *OAuthSession) loadSessionPayload(ctx context.Context, sessionID string) *AuthSessionCookieData {
+	if s.sessionStore == nil {
+		return nil
+	}
+
+	record, err := s.sessionStore.Load(ctx, sessionID)
+	if err != nil || len(record.Payload) == 0 {
+		return nil
+	}
+
+	cookieData, err := deserializeAuthCookieData(record.Payload)
+	if err != nil {
+		return nil
+	}
+	cookieData.SessionID = record.ID
+	return cookieData
+}
+
+// saveSessionPayload saves the session data into its session record in minimal cookie mode.
+// The record is saved only if it still exists, so a session revoked meanwhile isn't restored.
+func (s *OAuthSession) saveSessionPayload(ctx context.Context, cookieData *AuthSessionCookieData) error {
+	if s.sessionStore == nil {
+		return ErrorSessionStoreRequired
+	}
+
+	record, err := s.sessionStore.Load(ctx, cookieData.SessionID)
+	if err == ErrorSessionNotFound {
+		return ErrorSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+
+	record.Payload, err = serializeAuthCookieData(cookieData)
+	if err != nil {
+		return err
+	}
+	record.ExpiresAt = cookieData.Token.Expiry
+	err = s.sessionStore.SaveIfExists(ctx, record)
+	if err == ErrorSessionNotFound {
+		return ErrorSessionRevoked
+	}
+	return err
+}
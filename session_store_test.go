@@ -0,0 +1,44 @@
+package osecure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// revokingSessionStore deletes records right after loading them, as if revoked concurrently.
+type revokingSessionStore struct {
+	*MemorySessionStore
+}
+
+func (store revokingSessionStore) Load(ctx context.Context, id string) (*SessionRecord, error) {
+	record, err := store.MemorySessionStore.Load(ctx, id)
+	if err == nil {
+		store.Delete(ctx, id)
+	}
+	return record, err
+}
+
+func TestSaveSessionPayloadRevoked(t *testing.T) {
+	ctx := context.Background()
+	store := revokingSessionStore{NewMemorySessionStore()}
+	s := newTestSession(t, newTestVerifier(nil))
+	s.SetSessionStore(store)
+
+	expiresAt := time.Now().Add(time.Hour)
+	err := store.Save(ctx, &SessionRecord{ID: "session", UserID: "alice", ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookieData := &AuthSessionCookieData{SessionID: "session", Token: &oauth2.Token{AccessToken: "alice", Expiry: expiresAt}}
+	err = s.saveSessionPayload(ctx, cookieData)
+	if err != ErrorSessionRevoked {
+		t.Errorf("got %v, want %v", err, ErrorSessionRevoked)
+	}
+	if _, err := store.MemorySessionStore.Load(ctx, "session"); err != ErrorSessionNotFound {
+		t.Errorf("revoked session saved back: %v", err)
+	}
+}
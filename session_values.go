@@ -0,0 +1,81 @@
+package osecure
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// sessionCookieData gets the cookie data of the session of the request, verified by Secured if it passed, or
+// from the session cookie otherwise.
+func (s *OAuthSession) sessionCookieData(r *http.Request) (*AuthSessionCookieData, error) {
+	if data, ok := GetRequestSessionData(r); ok && data.AuthSessionCookieData != nil {
+		return data.AuthSessionCookieData, nil
+	}
+	cookieData, err := s.loadAuthCookie(r)
+	if err != nil {
+		return nil, err
+	}
+	if cookieData == nil || cookieData.isTokenExpired(s.clockSkew) || cookieData.isSessionExpired(s.clockSkew) {
+		return nil, ErrorInvalidSession
+	}
+	return cookieData, nil
+}
+
+// SetSessionValue stores the value of the application as JSON in the session of the request under the key,
+// e.g. preferences or a cart ID, so it's kept in the session cookie (or the session store if MinimalCookie)
+// without another cookie. The value is deleted if nil. Values last as long as the session, and are dropped when
+// the user logs in again. The total size of keys and values is limited by MaxSessionValuesSize.
+// The cookie is written into the response, so it must be called before writing the response body.
+func (s *OAuthSession) SetSessionValue(w http.ResponseWriter, r *http.Request, key string, value interface{}) error {
+	cookieData, err := s.sessionCookieData(r)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]json.RawMessage, len(cookieData.Values)+1)
+	size := 0
+	for k, v := range cookieData.Values {
+		if k != key {
+			values[k] = v
+			size += len(k) + len(v)
+		}
+	}
+	if value != nil {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		values[key] = raw
+		size += len(key) + len(raw)
+	}
+	if size > s.maxSessionValuesSize {
+		return ErrorSessionValuesTooLarge
+	}
+	if len(values) == 0 {
+		values = nil
+	}
+
+	cookieData.Values = values
+	err = s.setAuthCookie(w, r, cookieData)
+	if err != nil {
+		return WrapError(ErrorStringUnableToSetCookie, err)
+	}
+	return nil
+}
+
+// GetSessionValue decodes the value of the key set by SetSessionValue into dst, returning false if not set.
+func (s *OAuthSession) GetSessionValue(r *http.Request, key string, dst interface{}) (bool, error) {
+	cookieData, err := s.sessionCookieData(r)
+	if err != nil {
+		return false, err
+	}
+	raw, found := cookieData.Values[key]
+	if !found {
+		return false, nil
+	}
+	err = json.Unmarshal(raw, dst)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
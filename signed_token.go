@@ -0,0 +1,67 @@
+package osecure
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// SignedToken signs and parses tokens of JSON payloads issued and verified by the same service,
+// e.g. session tokens of login providers, in the form of Prefix + base64url(payload) + "." + base64url(HMAC-SHA256).
+type SignedToken struct {
+	Prefix string // distinguishes kinds of tokens signed by the same key, which are rejected as each other
+	Key    []byte
+}
+
+func (t SignedToken) sign(encoded string) string {
+	mac := hmac.New(sha256.New, t.Key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign encodes the payload as JSON and signs it.
+func (t SignedToken) Sign(payload interface{}) (string, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	encoded := t.Prefix + base64.RawURLEncoding.EncodeToString(b)
+	return encoded + "." + t.sign(encoded), nil
+}
+
+// Parse verifies the token and decodes its payload, returning ErrorInvalidSignedToken if it's invalid.
+// Expiration of the payload is checked by callers.
+func (t SignedToken) Parse(token string, payload interface{}) error {
+	i := strings.LastIndexByte(token, '.')
+	if !strings.HasPrefix(token, t.Prefix) || i < len(t.Prefix) {
+		return ErrorInvalidSignedToken
+	}
+	encoded, signature := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(t.sign(encoded))) {
+		return ErrorInvalidSignedToken
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(encoded, t.Prefix))
+	if err != nil {
+		return ErrorInvalidSignedToken
+	}
+	err = json.Unmarshal(b, payload)
+	if err != nil {
+		return ErrorInvalidSignedToken
+	}
+	return nil
+}
+
+// GenerateID generates a random ID of the bytes, hex encoded.
+func GenerateID(size int) (string, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
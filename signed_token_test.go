@@ -0,0 +1,44 @@
+package osecure
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignedToken(t *testing.T) {
+	type payload struct {
+		Subject string `json:"sub"`
+	}
+	key := []byte(strings.Repeat("k", 32))
+	signer := SignedToken{Prefix: "t.", Key: key}
+
+	token, err := signer.Sign(&payload{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "t.eyJzdWIiOiJhbGljZSJ9."; !strings.HasPrefix(token, want) {
+		t.Fatalf("token %q doesn't start with %q", token, want)
+	}
+
+	var parsed payload
+	err = signer.Parse(token, &parsed)
+	if err != nil || parsed.Subject != "alice" {
+		t.Fatalf("Parse() = %+v, %v", parsed, err)
+	}
+
+	for name, test := range map[string]struct {
+		signer SignedToken
+		token  string
+	}{
+		"other prefix":      {SignedToken{Prefix: "u.", Key: key}, token},
+		"other key":         {SignedToken{Prefix: "t.", Key: []byte(strings.Repeat("x", 32))}, token},
+		"tampered payload":  {signer, strings.Replace(token, "eyJzdWIiOiJhbGljZSJ9", "eyJzdWIiOiJtYWxsb3J5In0", 1)},
+		"without signature": {signer, token[:strings.LastIndexByte(token, '.')]},
+		"prefix only":       {signer, "t."},
+	} {
+		err := test.signer.Parse(test.token, &payload{})
+		if err != ErrorInvalidSignedToken {
+			t.Errorf("%s: Parse() = %v, want %v", name, err, ErrorInvalidSignedToken)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package osecure
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// SignedURLParam is the query parameter of the signature of signed URLs.
+const SignedURLParam = "osecure_signature"
+
+// DefaultSignedURLTTL is the lifetime of signed URLs if SignedURLGrant.TTL is zero.
+const DefaultSignedURLTTL = 5 * time.Minute
+
+// signedURLName is the name authenticated along with signatures, so they can't be used as cookies.
+const signedURLName = "osecure_signed_url"
+
+// SignedURLGrant is what a signed URL grants, see SignURL.
+type SignedURLGrant struct {
+	UserID      string
+	Permissions []string      // the permission scope, which should be as narrow as possible
+	TTL         time.Duration // DefaultSignedURLTTL if zero
+	OneTime     bool          // the URL is accepted only once, which requires SetSignedURLReplayCache
+}
+
+// signedURLPayload is the payload of signatures.
+type signedURLPayload struct {
+	Path        string
+	UserID      string
+	Permissions []string
+	ExpiresAt   int64
+	Nonce       string // of one-time URLs
+}
+
+// SetSignedURLReplayCache enables one-time signed URLs, whose nonces are remembered by the cache until they expire.
+// It should be called before serving requests.
+func (s *OAuthSession) SetSignedURLReplayCache(cache ReplayCache) {
+	s.signedURLReplayCache = cache
+}
+
+// SignURL mints the URL with a signature granting the subject and the permissions to the path of the URL,
+// e.g. for file downloads by browsers which can't send Authorization header. The signature is authenticated and
+// encrypted by the cookie keys, and validated by SignedURLF. Other query parameters aren't signed.
+func (s *OAuthSession) SignURL(rawURL string, grant SignedURLGrant) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	payload := &signedURLPayload{
+		Path:        u.Path,
+		UserID:      grant.UserID,
+		Permissions: grant.Permissions,
+		ExpiresAt:   time.Now().Add(durationOrDefault(grant.TTL, DefaultSignedURLTTL)).Unix(),
+	}
+	if grant.OneTime {
+		if s.signedURLReplayCache == nil {
+			return "", ErrorReplayCacheRequired
+		}
+		payload.Nonce, err = generateSessionID()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	signature, err := securecookie.EncodeMulti(signedURLName, payload, s.getCookieStore().Codecs...)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set(SignedURLParam, signature)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// verifySignedURL validates the signature of the request, returning the session data it grants.
+func (s *OAuthSession) verifySignedURL(r *http.Request) (*AuthSessionData, error) {
+	signature := r.URL.Query().Get(SignedURLParam)
+	if signature == "" || len(signature) > s.maxTokenLength {
+		return nil, ErrorInvalidSignedURL
+	}
+
+	payload := &signedURLPayload{}
+	err := securecookie.DecodeMulti(signedURLName, signature, payload, s.getCookieStore().Codecs...)
+	if err != nil {
+		return nil, ErrorInvalidSignedURL
+	}
+	if payload.Path != r.URL.Path {
+		return nil, ErrorInvalidSignedURL
+	}
+	expiresAt := time.Unix(payload.ExpiresAt, 0)
+	if !time.Now().Before(expiresAt) {
+		return nil, ErrorSignedURLExpired
+	}
+	if payload.Nonce != "" {
+		if s.signedURLReplayCache == nil {
+			return nil, ErrorReplayCacheRequired
+		}
+		ok, err := s.signedURLReplayCache.Use(r.Context(), "signed_url:"+payload.Nonce, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrorInvalidSignedURL
+		}
+	}
+
+	cookieData := &AuthSessionCookieData{
+		Token:                makeBearerToken("", payload.ExpiresAt), // no access token to forward
+		PermissionsExpiresAt: expiresAt,
+		SessionExpiresAt:     expiresAt,
+	}
+	cookieData.setPermissions(payload.Permissions)
+	return &AuthSessionData{
+		UserID:                payload.UserID,
+		ClientID:              s.getClient().ClientID,
+		AuthSessionCookieData: cookieData,
+	}, nil
+}
+
+// SignedURLF is a http middleware for http.HandlerFunc to authenticate requests by signed URLs of SignURL,
+// which must grant all the permissions. Requests without valid signature get 401, and insufficient permissions 403.
+func (s *OAuthSession) SignedURLF(permissions ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			sessionData, err := s.verifySignedURL(r)
+			if err != nil {
+				s.audit(r, AuditEventAccessDenied, nil, err)
+				if err == ErrorReplayCacheRequired {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				} else if err == ErrorInvalidSignedURL || err == ErrorSignedURLExpired {
+					s.unauthorized(w, r, err)
+				} else {
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				}
+				return
+			}
+			if !sessionData.HasAllPermissions(permissions...) {
+				err = fmt.Errorf("%w: signed URL grants no permissions %v", ErrorAccessDenied, permissions)
+				s.audit(r, AuditEventAccessDenied, sessionData, err)
+				s.forbidden(w, r, ErrorAccessDenied)
+				return
+			}
+
+			h(w, AttachRequestWithSessionData(r, sessionData))
+		}
+	}
+}
+
+// SignedURLH is a http middleware for http.Handler to authenticate requests by signed URLs of SignURL.
+func (s *OAuthSession) SignedURLH(permissions ...string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.Handler(s.SignedURLF(permissions...)(h.ServeHTTP))
+	}
+}
@@ -0,0 +1,103 @@
+package osecure
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+func requestSignedURL(s *OAuthSession, signedURL string, permissions ...string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.SignedURLF(permissions...)(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := GetRequestSessionData(r)
+		w.Write([]byte(data.UserID))
+	})(w, httptest.NewRequest(http.MethodGet, signedURL, nil))
+	return w
+}
+
+func TestSignedURL(t *testing.T) {
+	s := newTestSession(t, newTestVerifier(nil))
+	signedURL, err := s.SignURL("https://example.com/files/report.pdf?inline=1", SignedURLGrant{UserID: "alice", Permissions: []string{"files:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := requestSignedURL(s, signedURL, "files:read")
+	if w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Fatalf("signed URL: got %d %q", w.Code, w.Body.String())
+	}
+	if w := requestSignedURL(s, signedURL, "files:write"); w.Code != http.StatusForbidden {
+		t.Errorf("permission not granted: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := u.Query().Get(SignedURLParam)
+	tampered, err := base64.URLEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered[len(tampered)/2] ^= 1
+
+	cookieConfig := newTestCookieConfig()
+	cookieConfig.AuthenticationKey = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("b", 32)))
+	otherSession := NewOAuthSession("osecure", cookieConfig, &OAuthConfig{ClientID: testClientID}, OAuthEndpoint{
+		AuthURL:  "https://auth.example.com/authorize",
+		TokenURL: "https://auth.example.com/token",
+	}, newTestVerifier(nil), "https://example.com/callback", nil)
+	otherSigned, err := otherSession.SignURL("https://example.com/files/report.pdf", SignedURLGrant{UserID: "alice", Permissions: []string{"files:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := securecookie.EncodeMulti(signedURLName, &signedURLPayload{
+		Path:        "/files/report.pdf",
+		UserID:      "alice",
+		Permissions: []string{"files:read"},
+		ExpiresAt:   time.Now().Add(-time.Second).Unix(),
+	}, s.getCookieStore().Codecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, rawURL := range map[string]string{
+		"other path":         strings.Replace(signedURL, "report.pdf", "secret.pdf", 1),
+		"tampered signature": "https://example.com/files/report.pdf?" + SignedURLParam + "=" + base64.URLEncoding.EncodeToString(tampered),
+		"signed by others":   otherSigned,
+		"expired":            "https://example.com/files/report.pdf?" + SignedURLParam + "=" + expired,
+		"without signature":  "https://example.com/files/report.pdf",
+		"session cookie":     "https://example.com/files/report.pdf?" + SignedURLParam + "=" + newTestSessionCookie(t, s, "alice").Value,
+	} {
+		if w := requestSignedURL(s, rawURL, "files:read"); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want %d", name, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestSignedURLOneTime(t *testing.T) {
+	s := newTestSession(t, newTestVerifier(nil))
+	_, err := s.SignURL("https://example.com/files/report.pdf", SignedURLGrant{UserID: "alice", OneTime: true})
+	if err != ErrorReplayCacheRequired {
+		t.Fatalf("one-time URL without replay cache: got %v, want %v", err, ErrorReplayCacheRequired)
+	}
+
+	s.SetSignedURLReplayCache(NewMemoryReplayCache())
+	signedURL, err := s.SignURL("https://example.com/files/report.pdf", SignedURLGrant{UserID: "alice", OneTime: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := requestSignedURL(s, signedURL); w.Code != http.StatusOK {
+		t.Fatalf("first use: got %d", w.Code)
+	}
+	if w := requestSignedURL(s, signedURL); w.Code != http.StatusUnauthorized {
+		t.Errorf("second use: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,79 @@
+package osecure
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"golang.org/x/oauth2"
+)
+
+// introspectTokenOnce collapses concurrent introspections of the same token into one call,
+// protecting the auth server from bursts of requests with the same bearer token.
+// The key is the hash of the token.
+func (s *OAuthSession) introspectTokenOnce(ctx context.Context, key [sha256.Size]byte, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error) {
+	v, err, _ := s.introspectionGroup.Do(string(key[:]), func() (interface{}, error) {
+		result := &IntrospectionResult{}
+		err := s.callVerifier(ctx, func() error {
+			var err error
+			result.UserID, result.ClientID, result.ExpiresAt, result.Extra, err = s.tokenVerifier.IntrospectTokenFunc(ctx, accessToken)
+			return RedactError(err, accessToken)
+		})
+		if err == nil && s.circuitBreaker != nil {
+			s.circuitBreaker.remember("introspection:"+string(key[:]), result, result.ExpiresAt)
+		}
+		return result, err
+	})
+
+	if err != nil {
+		// fail open by the last known result
+		v, err = s.recallVerifierResult("introspection:"+string(key[:]), err)
+		if err != nil {
+			return "", "", 0, nil, err
+		}
+	}
+	result := v.(*IntrospectionResult)
+
+	// callers sharing the result get their own extra data
+	extra = make(map[string]interface{}, len(result.Extra))
+	for k, value := range result.Extra {
+		extra[k] = value
+	}
+	return result.UserID, result.ClientID, result.ExpiresAt, extra, nil
+}
+
+func permissionKey(userID string, clientID string, token *oauth2.Token) string {
+	sum := sha256.Sum256([]byte(token.AccessToken))
+	return userID + "\x00" + clientID + "\x00" + string(sum[:])
+}
+
+// getPermissionsOnce collapses concurrent permission fetches of the same user, client and token into one call.
+func (s *OAuthSession) getPermissionsOnce(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+	key := permissionKey(userID, clientID, token)
+
+	v, err, _ := s.permissionGroup.Do(key, func() (interface{}, error) {
+		var permissions []string
+		err := s.callVerifier(ctx, func() error {
+			var err error
+			permissions, err = s.tokenVerifier.GetPermissionsFunc(ctx, userID, clientID, token)
+			return RedactError(err, token.AccessToken, token.RefreshToken)
+		})
+		if err == nil && s.circuitBreaker != nil {
+			var expiresAt int64
+			if !token.Expiry.IsZero() {
+				expiresAt = token.Expiry.Unix()
+			}
+			s.circuitBreaker.remember("permissions:"+key, permissions, expiresAt)
+		}
+		return permissions, err
+	})
+	if err != nil {
+		// fail open by the last known permissions
+		v, err = s.recallVerifierResult("permissions:"+key, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// callers sharing the result get their own list
+	return append([]string(nil), v.([]string)...), nil
+}
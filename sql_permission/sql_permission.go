@@ -0,0 +1,131 @@
+// Package osecure/sql_permission provides permission source of subject→permission mappings in a SQL database.
+package sql_permission
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/rayark/osecure/v6"
+	"golang.org/x/oauth2"
+)
+
+// DefaultTable is the table of permissions if not specified.
+const DefaultTable = "osecure_permissions"
+
+// Schema is the PostgreSQL schema of DefaultTable, which can be adapted for other databases.
+// Permissions with empty client_id are granted for all clients.
+const Schema = `
+CREATE TABLE osecure_permissions (
+	subject    TEXT NOT NULL,
+	client_id  TEXT NOT NULL DEFAULT '',
+	permission TEXT NOT NULL,
+	PRIMARY KEY (subject, client_id, permission)
+);
+`
+
+// NotifySchema is the PostgreSQL trigger notifying subjects of changed permissions on NotifyChannel,
+// to be received by LISTEN (e.g. pq.Listener) and passed to Watch.
+const NotifySchema = `
+CREATE FUNCTION osecure_permissions_notify() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		PERFORM pg_notify('osecure_permissions', OLD.subject);
+	ELSE
+		PERFORM pg_notify('osecure_permissions', NEW.subject);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER osecure_permissions_notify AFTER INSERT OR UPDATE OR DELETE ON osecure_permissions
+	FOR EACH ROW EXECUTE PROCEDURE osecure_permissions_notify();
+`
+
+// NotifyChannel is the channel notified by NotifySchema.
+const NotifyChannel = "osecure_permissions"
+
+// Placeholder formats the nth (from 1) bind parameter of queries.
+type Placeholder func(n int) string
+
+// Dollar is the placeholder of PostgreSQL, e.g. $1.
+func Dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Question is the placeholder of MySQL and SQLite, i.e. ?.
+func Question(n int) string {
+	return "?"
+}
+
+// Source is an osecure.PermissionSource reading permissions of the subject from the table.
+// Permissions are nil if the subject has no rows, so it can be composed by osecure.OverridePermissionSources.
+type Source struct {
+	DB          *sql.DB
+	Table       string      // DefaultTable if empty
+	Placeholder Placeholder // Dollar if nil
+}
+
+// New creates Source of DefaultTable in PostgreSQL.
+func New(db *sql.DB) *Source {
+	return &Source{DB: db}
+}
+
+// GetPermissions implements osecure.PermissionSource.
+func (src *Source) GetPermissions(ctx context.Context, userID string, clientID string, token *oauth2.Token) ([]string, error) {
+	table := src.Table
+	if table == "" {
+		table = DefaultTable
+	}
+	placeholder := src.Placeholder
+	if placeholder == nil {
+		placeholder = Dollar
+	}
+
+	query := fmt.Sprintf("SELECT permission FROM %s WHERE subject = %s AND (client_id = '' OR client_id = %s)",
+		table, placeholder(1), placeholder(2))
+	rows, err := src.DB.QueryContext(ctx, query, userID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []string
+	for rows.Next() {
+		var permission string
+		err = rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// Watch invalidates cached permissions of subjects received from the notifications until ctx is done
+// or the channel is closed, e.g. payloads of pq.Listener on NotifyChannel. Empty payloads are ignored.
+// The session requires SetPermissionInvalidationList, failures are reported to onError (can be nil).
+func Watch(ctx context.Context, s *osecure.OAuthSession, notifications <-chan string, onError func(error)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case userID, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if userID == "" {
+				continue
+			}
+			err := s.InvalidatePermissions(ctx, userID)
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"unicode/utf8"
 
 	"github.com/gorilla/sessions"
 )
@@ -121,7 +123,7 @@ func (sh DefaultStateHandler) Verify(cookieStore *sessions.CookieStore, w http.R
 	}
 
 	// check if state is equal to stateData.Nonce
-	if state != stateData.Nonce {
+	if subtle.ConstantTimeCompare([]byte(state), []byte(stateData.Nonce)) != 1 {
 		return "", ErrorInvalidState
 	}
 
@@ -257,23 +259,21 @@ func (sh JSONStateHandler) Verify(cookieStore *sessions.CookieStore, w http.Resp
 	sum := h.Sum(nil)
 
 	// check if state checksum is expected
-	if bytes.Compare(sum, expectedSum) != 0 {
+	if subtle.ConstantTimeCompare(sum, expectedSum) != 1 {
 		return "", ErrorInvalidState
 	}
 
-	// retrieve continue_uri and nonce from state
+	// retrieve continue_uri and nonce from state, which must be exactly one JSON object
 	var stateData jsonStateData
-
-	/*err = json.Unmarshal(stateBytes, &stateData)
-	if err != nil {
-		return "", err
-	}*/
-
-	stateBuf := bytes.NewBuffer(stateBytes)
-	dec := json.NewDecoder(stateBuf)
-	err = dec.Decode(&stateData)
+	if !utf8.Valid(stateBytes) {
+		return "", ErrorInvalidState
+	}
+	err = json.Unmarshal(stateBytes, &stateData)
 	if err != nil {
-		return "", err
+		return "", ErrorInvalidState
+	}
+	if len(stateData.Nonce) != nonceSize {
+		return "", ErrorInvalidState
 	}
 
 	// delete checksum cookie
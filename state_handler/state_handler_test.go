@@ -0,0 +1,56 @@
+package state_handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+func FuzzJSONStateHandler(f *testing.F) {
+	for _, continueURI := range []string{"/", "/path?query=1#fragment", "https://example.com/", "/\xff", "/\"}"} {
+		f.Add(continueURI, "")
+		f.Add(continueURI, "eyJjb250aW51ZV91cmkiOiIvIn0")
+	}
+
+	cookieStore := sessions.NewCookieStore(securecookie.GenerateRandomKey(32), securecookie.GenerateRandomKey(32))
+	sh := JSONStateHandler{CookieName: "state"}
+
+	f.Fuzz(func(t *testing.T, continueURI string, forgedState string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RequestURI = continueURI
+		state, err := sh.Generate(cookieStore, w, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		callback := func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/callback", nil)
+			for _, cookie := range w.Result().Cookies() {
+				r.AddCookie(cookie)
+			}
+			return r
+		}
+
+		// forged states are rejected without panics
+		if forgedState != state {
+			_, err = sh.Verify(cookieStore, httptest.NewRecorder(), callback(), forgedState)
+			if err == nil {
+				t.Fatalf("forged state %q accepted", forgedState)
+			}
+		}
+
+		// the continue URI is restored from the state, with invalid UTF-8 replaced by JSON
+		verified, err := sh.Verify(cookieStore, httptest.NewRecorder(), callback(), state)
+		if err != nil {
+			t.Fatalf("state of %q rejected: %v", continueURI, err)
+		}
+		if utf8.ValidString(continueURI) && verified != continueURI {
+			t.Fatalf("continue URI %q restored as %q", continueURI, verified)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package osecure
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const stepUpCookieSuffix = "_step_up"
+
+// StepUp redirects to endpoint of OAuth service provider to authenticate the user again,
+// with prompt=login and optional acr_values. The session gets a new AuthTime after the callback,
+// which must have auth_time after the redirect, since providers may ignore prompt and max_age.
+func (s *OAuthSession) StepUp(w http.ResponseWriter, r *http.Request, acrValues []string) error {
+	if s.IsVerifierOnly() {
+		return ErrorVerifierOnlySession
+	}
+	state, err := s.stateHandler.Generate(s.getCookieStore(), w, r)
+	if err != nil {
+		return err
+	}
+
+	session, err := s.getCookieStore().New(r, s.name+stepUpCookieSuffix)
+	if err != nil {
+		return err
+	}
+	session.Values["requested_at"] = time.Now().Unix()
+	err = session.Save(r, w)
+	if err != nil {
+		return err
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("prompt", "login"),
+		oauth2.SetAuthURLParam("max_age", "0"),
+	}
+	if len(acrValues) > 0 {
+		opts = append(opts, oauth2.SetAuthURLParam("acr_values", strings.Join(acrValues, " ")))
+	}
+
+	authURL, err := s.authorizationURL(w, r, state, opts...)
+	if err != nil {
+		return err
+	}
+
+	s.redirect(w, r, authURL)
+	return nil
+}
+
+// takeStepUp takes the time StepUp redirected the user, zero if the callback isn't of StepUp.
+func (s *OAuthSession) takeStepUp(w http.ResponseWriter, r *http.Request) time.Time {
+	if _, err := r.Cookie(s.name + stepUpCookieSuffix); err != nil {
+		return time.Time{}
+	}
+
+	session, _ := s.getCookieStore().Get(r, s.name+stepUpCookieSuffix)
+	requestedAt, _ := session.Values["requested_at"].(int64)
+	session.Options.MaxAge = -1
+	session.Save(r, w)
+	if requestedAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(requestedAt, 0)
+}
+
+// checkStepUpAuthTime checks the user has authenticated again for StepUp (max_age=0),
+// which is only known by auth_time, so the local clock isn't used instead.
+func (s *OAuthSession) checkStepUpAuthTime(extra map[string]interface{}, stepUpAt time.Time) error {
+	authTime := getExtraTime(extra, ExtraKeyAuthTime)
+	if authTime.IsZero() || authTime.Before(stepUpAt.Add(-s.clockSkew)) {
+		return ErrorAuthenticationTooOld
+	}
+	return nil
+}
+
+func (s *OAuthSession) isRecentAuth(sessionData *AuthSessionData, maxAge time.Duration) bool {
+	if sessionData.AuthTime.IsZero() {
+		return false
+	}
+	return time.Since(sessionData.AuthTime) <= maxAge+s.clockSkew
+}
+
+// RequireRecentAuthF is a http middleware for http.HandlerFunc to check if the current user has logged in
+// within maxAge. Otherwise, users are sent to StepUp, and API clients get 401 with
+// error "insufficient_user_authentication" (RFC 9470).
+func (s *OAuthSession) RequireRecentAuthF(isAPI bool, maxAge time.Duration) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return s.SecuredF(isAPI)(func(w http.ResponseWriter, r *http.Request) {
+			sessionData, _ := GetRequestSessionData(r)
+			if s.isRecentAuth(sessionData, maxAge) {
+				h(w, r)
+				return
+			}
+
+			if isAPI {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_user_authentication", max_age=%d`, int64(maxAge/time.Second)))
+				s.unauthorized(w, r, ErrorAuthenticationTooOld)
+				return
+			}
+
+			err := s.StepUp(w, r, nil)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		})
+	}
+}
+
+// RequireRecentAuthH is a http middleware for http.Handler to check if the current user has logged in
+// within maxAge.
+func (s *OAuthSession) RequireRecentAuthH(isAPI bool, maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.Handler(s.RequireRecentAuthF(isAPI, maxAge)(h.ServeHTTP))
+	}
+}
@@ -0,0 +1,82 @@
+package osecure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rayark/osecure/v6/state_handler"
+	"golang.org/x/oauth2"
+)
+
+func TestStepUpRequiresAuthTime(t *testing.T) {
+	stepUpAt := time.Now().Truncate(time.Second)
+	for name, test := range map[string]struct {
+		authTime interface{}
+		valid    bool
+	}{
+		"authenticated again": {stepUpAt.Add(time.Second).Unix(), true},
+		"within clock skew":   {stepUpAt.Add(-time.Second).Unix(), true},
+		"old authentication":  {stepUpAt.Add(-time.Hour).Unix(), false},
+		"without auth_time":   {nil, false},
+	} {
+		extra := map[string]interface{}{}
+		if test.authTime != nil {
+			extra[ExtraKeyAuthTime] = test.authTime
+		}
+		verifier := newTestVerifier(nil)
+		verifier.IntrospectTokenFunc = func(ctx context.Context, accessToken string) (string, string, int64, map[string]interface{}, error) {
+			return accessToken, testClientID, time.Now().Add(time.Hour).Unix(), extra, nil
+		}
+		s := newTestSession(t, verifier)
+		s.clockSkew = 5 * time.Second
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/callback", nil)
+		token := &oauth2.Token{AccessToken: "alice", Expiry: time.Now().Add(time.Hour)}
+		err := s.verifyAndSaveToken(w, r, token, stepUpAt)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got %v", name, err)
+		}
+		if !test.valid && !CompareErrorMessage(err, ErrorStringNonCompliantResponse) {
+			t.Errorf("%s: got %v, want %s", name, err, ErrorStringNonCompliantResponse)
+		}
+	}
+}
+
+func TestTakeStepUp(t *testing.T) {
+	s := NewOAuthSession("osecure", newTestCookieConfig(), &OAuthConfig{ClientID: testClientID}, OAuthEndpoint{
+		AuthURL:  "https://auth.example.com/authorize",
+		TokenURL: "https://auth.example.com/token",
+	}, newTestVerifier(nil), "https://example.com/callback", state_handler.JSONStateHandler{CookieName: "osecure_state"})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if stepUpAt := s.takeStepUp(httptest.NewRecorder(), r); !stepUpAt.IsZero() {
+		t.Errorf("callback of login taken as step-up at %v", stepUpAt)
+	}
+
+	w := httptest.NewRecorder()
+	before := time.Now().Truncate(time.Second)
+	err := s.StepUp(w, r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback := httptest.NewRequest(http.MethodGet, "/callback", nil)
+	for _, cookie := range w.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	stepUpAt := s.takeStepUp(w, callback)
+	if stepUpAt.Before(before) || stepUpAt.After(time.Now()) {
+		t.Errorf("got step-up at %v, want after %v", stepUpAt, before)
+	}
+	// the marker is taken once
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "osecure"+stepUpCookieSuffix && cookie.MaxAge >= 0 {
+			t.Errorf("step-up cookie kept: %v", cookie)
+		}
+	}
+}
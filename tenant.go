@@ -0,0 +1,188 @@
+package osecure
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// TenantResolver resolves the tenant of the request, returns ErrorUnknownTenant if it's not resolved.
+type TenantResolver func(r *http.Request) (tenant string, err error)
+
+// TenantByHost resolves tenants by Host header, mapping hosts (without port) to tenants.
+func TenantByHost(hostTenants map[string]string) TenantResolver {
+	return func(r *http.Request) (string, error) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		tenant, found := hostTenants[strings.ToLower(host)]
+		if !found {
+			return "", ErrorUnknownTenant
+		}
+		return tenant, nil
+	}
+}
+
+// TenantByPathPrefix resolves tenants by the first segment of path, e.g. "acme" of "/acme/orders".
+// Handlers are still routed with the full path.
+func TenantByPathPrefix() TenantResolver {
+	return func(r *http.Request) (string, error) {
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		tenant := strings.SplitN(path, "/", 2)[0]
+		if tenant == "" {
+			return "", ErrorUnknownTenant
+		}
+		return tenant, nil
+	}
+}
+
+// TenantConfig is the configs to create OAuthSession of a tenant.
+type TenantConfig struct {
+	CookieConfig  *CookieConfig
+	OAuthConfig   *OAuthConfig
+	Endpoint      OAuthEndpoint
+	TokenVerifier *TokenVerifier
+	CallbackURL   string
+	StateHandler  StateHandler
+
+	// Setup customizes the created OAuthSession, e.g. SetSessionStore. It can be nil.
+	Setup func(s *OAuthSession)
+}
+
+// TenantConfigLoader loads configs of the tenant, returns ErrorUnknownTenant if the tenant doesn't exist.
+type TenantConfigLoader func(ctx context.Context, tenant string) (*TenantConfig, error)
+
+// MultiTenantSession serves many tenants with their own OAuth providers from one binary.
+// Each tenant has its own OAuthSession, created on first use, whose cookies are named by the tenant.
+type MultiTenantSession struct {
+	name     string
+	resolver TenantResolver
+	loader   TenantConfigLoader
+
+	mu       sync.Mutex
+	sessions map[string]*OAuthSession
+}
+
+// NewMultiTenantSession creates multi-tenant session, whose cookies are named by name and tenant.
+func NewMultiTenantSession(name string, resolver TenantResolver, loader TenantConfigLoader) *MultiTenantSession {
+	return &MultiTenantSession{
+		name:     name,
+		resolver: resolver,
+		loader:   loader,
+		sessions: make(map[string]*OAuthSession),
+	}
+}
+
+// tenantCookieName makes cookie name of the tenant, replacing characters not allowed in cookie names.
+func tenantCookieName(name string, tenant string) string {
+	return name + "_" + strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' {
+			return c
+		}
+		return '_'
+	}, tenant)
+}
+
+// Session gets OAuthSession of the tenant of the request.
+func (m *MultiTenantSession) Session(r *http.Request) (*OAuthSession, error) {
+	tenant, err := m.resolver(r)
+	if err != nil {
+		return nil, err
+	}
+	return m.TenantSession(r.Context(), tenant)
+}
+
+// TenantSession gets OAuthSession of the tenant.
+func (m *MultiTenantSession) TenantSession(ctx context.Context, tenant string) (*OAuthSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, found := m.sessions[tenant]
+	if found {
+		return s, nil
+	}
+
+	conf, err := m.loader(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	s = NewOAuthSession(tenantCookieName(m.name, tenant), conf.CookieConfig, conf.OAuthConfig, conf.Endpoint, conf.TokenVerifier, conf.CallbackURL, conf.StateHandler)
+	s.tenant = tenant
+	if conf.Setup != nil {
+		conf.Setup(s)
+	}
+
+	m.sessions[tenant] = s
+	return s, nil
+}
+
+// Forget drops the OAuthSession of the tenant, so it's created with reloaded configs on next use.
+func (m *MultiTenantSession) Forget(tenant string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, tenant)
+}
+
+func writeTenantError(w http.ResponseWriter, err error) {
+	if err == ErrorUnknownTenant {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Authorize authorizes the user by OAuthSession of the tenant.
+func (m *MultiTenantSession) Authorize(w http.ResponseWriter, r *http.Request) (*AuthSessionData, error) {
+	s, err := m.Session(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.Authorize(w, r)
+}
+
+// SecuredF is SecuredF of OAuthSession of the tenant.
+func (m *MultiTenantSession) SecuredF(isAPI bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			s, err := m.Session(r)
+			if err != nil {
+				writeTenantError(w, err)
+				return
+			}
+			s.SecuredF(isAPI)(h)(w, r)
+		}
+	}
+}
+
+// SecuredH is SecuredH of OAuthSession of the tenant.
+func (m *MultiTenantSession) SecuredH(isAPI bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return m.SecuredF(isAPI)(h.ServeHTTP)
+	}
+}
+
+// CallbackView is CallbackView of OAuthSession of the tenant.
+func (m *MultiTenantSession) CallbackView(w http.ResponseWriter, r *http.Request) {
+	s, err := m.Session(r)
+	if err != nil {
+		writeTenantError(w, err)
+		return
+	}
+	s.CallbackView(w, r)
+}
+
+// LogOut is LogOut of OAuthSession of the tenant.
+func (m *MultiTenantSession) LogOut(redirect string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.Session(r)
+		if err != nil {
+			writeTenantError(w, err)
+			return
+		}
+		s.LogOut(redirect)(w, r)
+	}
+}
@@ -0,0 +1,124 @@
+package osecure
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TokenExtractor extracts the access token from requests, see SetTokenExtractors.
+// ExtractToken returns an empty token if the request has none, or an error if it has a malformed one.
+type TokenExtractor interface {
+	ExtractToken(r *http.Request) (string, error)
+}
+
+// TokenExtractorFunc is a function of TokenExtractor.
+type TokenExtractorFunc func(r *http.Request) (string, error)
+
+// ExtractToken implements TokenExtractor.
+func (f TokenExtractorFunc) ExtractToken(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// authorizationHeaderExtractor extracts tokens of Bearer scheme. OAuthSession handles it by getBearerToken instead,
+// which also accepts DPoP scheme.
+type authorizationHeaderExtractor struct{}
+
+func (authorizationHeaderExtractor) ExtractToken(r *http.Request) (string, error) {
+	if r.Header.Get("Authorization") == "" {
+		return "", nil
+	}
+	authorizationData := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(authorizationData) != 2 || !strings.EqualFold(authorizationData[0], "bearer") {
+		return "", ErrorUnsupportedAuthorizationScheme
+	}
+	return strings.TrimLeft(authorizationData[1], " "), nil
+}
+
+// AuthorizationHeaderToken extracts the token of "Authorization: Bearer <token>" (RFC 6750), or DPoP scheme
+// if DPoP is enabled. It's the only extractor by default.
+func AuthorizationHeaderToken() TokenExtractor {
+	return authorizationHeaderExtractor{}
+}
+
+// HeaderToken extracts the token which is the whole value of the header, e.g. "X-Api-Token" of webhooks.
+func HeaderToken(name string) TokenExtractor {
+	return TokenExtractorFunc(func(r *http.Request) (string, error) {
+		return r.Header.Get(name), nil
+	})
+}
+
+// CookieToken extracts the token which is the value of the cookie, not the auth cookie of OAuthSession.
+func CookieToken(name string) TokenExtractor {
+	return TokenExtractorFunc(func(r *http.Request) (string, error) {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return "", nil
+		}
+		return cookie.Value, nil
+	})
+}
+
+// QueryToken extracts the token in the query parameter, e.g. "access_token" for signed download links.
+// Tokens in URLs leak by logs and referers, so they should be short-lived and narrowly scoped.
+func QueryToken(name string) TokenExtractor {
+	return TokenExtractorFunc(func(r *http.Request) (string, error) {
+		return r.URL.Query().Get(name), nil
+	})
+}
+
+// FormToken extracts the token in the field of url-encoded form body (RFC 6750 section 2.2), e.g. "access_token".
+func FormToken(name string) TokenExtractor {
+	return TokenExtractorFunc(func(r *http.Request) (string, error) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return "", nil
+		}
+		return r.PostFormValue(name), nil
+	})
+}
+
+// SetTokenExtractors sets the extractors of access tokens, tried in order until one finds a token,
+// when the request has no valid auth cookie. Extracted tokens are verified like bearer tokens,
+// but only AuthorizationHeaderToken accepts DPoP-bound tokens. It should be called before serving requests.
+func (s *OAuthSession) SetTokenExtractors(extractors ...TokenExtractor) {
+	s.tokenExtractors = extractors
+}
+
+// extractToken gets the access token of the request by the extractors, AuthorizationHeaderToken by default.
+// The token is empty without error if no extractor finds it.
+func (s *OAuthSession) extractToken(r *http.Request) (token string, isDPoP bool, err error) {
+	extractors := s.tokenExtractors
+	if extractors == nil {
+		extractors = []TokenExtractor{authorizationHeaderExtractor{}}
+	}
+
+	for _, extractor := range extractors {
+		if _, ok := extractor.(authorizationHeaderExtractor); ok {
+			if r.Header.Get("Authorization") == "" {
+				continue
+			}
+			return s.getBearerToken(r)
+		}
+
+		token, err = extractor.ExtractToken(r)
+		if err != nil {
+			return "", false, err
+		}
+		if token == "" {
+			continue
+		}
+		if len(token) > s.maxTokenLength {
+			return "", false, ErrorTokenTooLong
+		}
+		if !isToken68(token) {
+			return "", false, ErrorInvalidAuthorizationSyntax
+		}
+		return token, false, nil
+	}
+	return "", false, nil
+}
+
+// hasRequestToken checks if any extractor finds a token in the request, even a malformed one.
+func (s *OAuthSession) hasRequestToken(r *http.Request) bool {
+	token, _, err := s.extractToken(r)
+	return token != "" || err != nil
+}
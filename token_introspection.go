@@ -3,6 +3,9 @@ package osecure
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 )
@@ -10,7 +13,85 @@ import (
 type TokenVerifier struct {
 	IntrospectTokenFunc IntrospectTokenFunc
 	GetPermissionsFunc  GetPermissionsFunc
+
+	// BatchIntrospectTokenFunc introspects many tokens at once for BatchIntrospect, can be nil.
+	BatchIntrospectTokenFunc BatchIntrospectTokenFunc
 }
 
+// Keys of extra data returned by IntrospectTokenFunc.
+// Verifiers should fill ExtraKeyIssuer so that OAuthConfig.Issuer can be validated,
+// ExtraKeyAuthTime (unix time) if the time of user authentication is known,
+// and ExtraKeyConfirmation (map[string]interface{}) for sender-constrained tokens.
+// Profile keys fill the Profile of AuthSessionData, roles and groups are lists or space separated strings.
+const (
+	ExtraKeyIssuer       = "iss"
+	ExtraKeyAuthTime     = "auth_time"
+	ExtraKeyConfirmation = "cnf"
+
+	ExtraKeyRoles       = "roles"
+	ExtraKeyGroups      = "groups"
+	ExtraKeyEmail       = "email"
+	ExtraKeyDisplayName = "name"
+)
+
+// IntrospectTokenFunc verifies the access token and returns its subject (userID), audience (clientID),
+// expiry in unix time and extra data like ExtraKeyIssuer.
 type IntrospectTokenFunc func(ctx context.Context, accessToken string) (userID string, clientID string, expiresAt int64, extra map[string]interface{}, err error)
+
+// IntrospectionResult is the result of IntrospectTokenFunc, see BatchIntrospectTokenFunc.
+type IntrospectionResult struct {
+	UserID    string
+	ClientID  string
+	ExpiresAt int64
+	Extra     map[string]interface{}
+	Err       error // the token is invalid or failed to introspect
+}
+
+// BatchIntrospectTokenFunc verifies the access tokens at once, returns results in the same order of the tokens.
+// The error fails all tokens, failures of each token are in the results.
+type BatchIntrospectTokenFunc func(ctx context.Context, accessTokens []string) ([]IntrospectionResult, error)
+
+// GetPermissionsFunc gets the permissions of the user for the client.
 type GetPermissionsFunc func(ctx context.Context, userID string, clientID string, token *oauth2.Token) (permissions []string, err error)
+
+// getExtraTime reads a unix time from extra data, returns zero time if it's absent or malformed.
+func getExtraTime(extra map[string]interface{}, key string) time.Time {
+	var unix int64
+	switch v := extra[key].(type) {
+	case int64:
+		unix = v
+	case int:
+		unix = int64(v)
+	case float64:
+		unix = int64(v)
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return time.Time{}
+		}
+		unix = n
+	default:
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
+
+// getExtraStrings reads a list of strings from extra data, which is a list or a space separated string.
+func getExtraStrings(extra map[string]interface{}, key string) []string {
+	switch v := extra[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		a := make([]string, 0, len(v))
+		for _, x := range v {
+			if str, ok := x.(string); ok {
+				a = append(a, str)
+			}
+		}
+		return a
+	case string:
+		return strings.Fields(v)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,38 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// RFC 4226 appendix D
+	secret := []byte("12345678901234567890")
+	for step, want := range []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"} {
+		if code := Code(secret, int64(step)); code != want {
+			t.Errorf("Code(%d) = %q, want %q", step, code, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(59, 0) // step 1
+	for _, test := range []struct {
+		code   string
+		skew   int
+		step   int64
+		passed bool
+	}{
+		{code: "287082", skew: 0, step: 1, passed: true},
+		{code: "287 082", skew: 0, step: 1, passed: true},
+		{code: "755224", skew: 0, passed: false},
+		{code: "755224", skew: 1, step: 0, passed: true},
+		{code: "28708", skew: 1, passed: false},
+	} {
+		step, passed := Validate(secret, test.code, now, test.skew)
+		if step != test.step || passed != test.passed {
+			t.Errorf("Validate(%q, skew %d) = %d, %v", test.code, test.skew, step, passed)
+		}
+	}
+}
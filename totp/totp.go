@@ -0,0 +1,345 @@
+// Package osecure/totp provides TOTP (RFC 6238, authenticator apps) as the second factor of osecure.OAuthSession,
+// for deployments whose identity provider doesn't enforce MFA.
+//
+// TOTP is the osecure.MFAEnforcer too, which requires the second factor for sessions whose token lacks MFA claims
+// (see osecure.HasMFAClaim). Users without TOTP enroll right after logging in, which passes the second factor:
+//
+//	t, err := totp.New(conf, totp.NewMemoryStore())
+//	s.SetSecondFactor("/2fa")
+//	s.SetMFAEnforcer(t)
+//	mux.Handle("/totp/", t.Handler(s, "/totp/"))
+package totp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rayark/osecure/v6"
+)
+
+// Defaults of Config, used when the corresponding field is zero.
+const (
+	DefaultSkew        = 1
+	DefaultMaxFailures = 5
+	DefaultLockout     = 5 * time.Minute
+)
+
+var (
+	ErrorInvalidCode     = errors.New("invalid TOTP code")
+	ErrorNotEnrolled     = errors.New("TOTP is not enrolled")
+	ErrorLockedOut       = errors.New("too many invalid TOTP codes")
+	ErrorAlreadyEnrolled = errors.New("TOTP is already enrolled")
+)
+
+// Config is the config of TOTP.
+type Config struct {
+	Issuer string // issuer shown by authenticator apps, e.g. the site name
+
+	Skew int // time steps tolerated before and after the current one, DefaultSkew if zero
+
+	// MaxFailures is the number of invalid codes to lock the user out for Lockout,
+	// DefaultMaxFailures and DefaultLockout if zero.
+	MaxFailures int
+	Lockout     time.Duration
+
+	// AlwaysRequire requires the second factor even if the token claims MFA.
+	AlwaysRequire bool
+}
+
+// Enrollment is the TOTP secret of a user.
+type Enrollment struct {
+	Secret        []byte    `json:"secret"`         // nil until a code of PendingSecret is confirmed
+	PendingSecret []byte    `json:"pending_secret"` // generated by EnrollHandler, replacing Secret once confirmed
+	LastStep      int64     `json:"last_step"`      // time step of the last used code
+	Failures      int       `json:"failures"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// Store stores enrollments of users. Secrets should be encrypted at rest.
+type Store interface {
+	// Enrollment gets the enrollment of the user, nil if the user isn't enrolled.
+	Enrollment(userID string) (*Enrollment, error)
+	SaveEnrollment(userID string, enrollment *Enrollment) error
+}
+
+// MemoryStore is Store in memory, for tests and single instance deployments.
+type MemoryStore struct {
+	mutex       sync.Mutex
+	enrollments map[string]Enrollment
+}
+
+// NewMemoryStore creates MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		enrollments: make(map[string]Enrollment),
+	}
+}
+
+// Enrollment implements Store.
+func (store *MemoryStore) Enrollment(userID string) (*Enrollment, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	enrollment, ok := store.enrollments[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &enrollment, nil
+}
+
+// SaveEnrollment implements Store.
+func (store *MemoryStore) SaveEnrollment(userID string, enrollment *Enrollment) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.enrollments[userID] = *enrollment
+	return nil
+}
+
+// TOTP is the TOTP second factor.
+type TOTP struct {
+	conf  Config
+	store Store
+	mutex sync.Mutex // serializes updates of enrollments
+}
+
+// New creates TOTP with the config and the store.
+func New(conf Config, store Store) (*TOTP, error) {
+	switch {
+	case conf.Issuer == "":
+		return nil, errors.New("issuer is required")
+	case strings.Contains(conf.Issuer, ":"):
+		return nil, errors.New("issuer must not contain colons")
+	case store == nil:
+		return nil, errors.New("store is required")
+	}
+	if conf.Skew == 0 {
+		conf.Skew = DefaultSkew
+	}
+	if conf.MaxFailures == 0 {
+		conf.MaxFailures = DefaultMaxFailures
+	}
+	if conf.Lockout == 0 {
+		conf.Lockout = DefaultLockout
+	}
+
+	return &TOTP{
+		conf:  conf,
+		store: store,
+	}, nil
+}
+
+// RequiresSecondFactor implements osecure.MFAEnforcer.
+func (t *TOTP) RequiresSecondFactor(data *osecure.AuthSessionData) bool {
+	return t.conf.AlwaysRequire || !osecure.HasMFAClaim(data.Extra)
+}
+
+// Handler serves the handlers under the prefix: POST enroll, enroll/confirm and verify.
+func (t *TOTP) Handler(s *osecure.OAuthSession, prefix string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"enroll", t.EnrollHandler(s))
+	mux.Handle(prefix+"enroll/confirm", t.ConfirmHandler(s))
+	mux.Handle(prefix+"verify", t.VerifyHandler(s))
+	return mux
+}
+
+// EnrollHandler generates a new secret of the session user, responding it as JSON with "secret" and "uri" (otpauth URI).
+// The secret isn't used until ConfirmHandler validates a code of it, so the secret of enrolled users is kept until then.
+// Sessions pending the second factor can only enroll users not enrolled yet.
+func (t *TOTP) EnrollHandler(s *osecure.OAuthSession) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, enrollment, ok := t.authorize(w, r, s)
+		if !ok {
+			return
+		}
+		if enrollment != nil && enrollment.Secret != nil && s.IsSecondFactorPending(data) {
+			http.Error(w, ErrorAlreadyEnrolled.Error(), http.StatusForbidden)
+			return
+		}
+
+		secret, err := GenerateSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = t.update(data.UserID, func(enrollment *Enrollment) error {
+			enrollment.PendingSecret = secret
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]string{
+			"secret": EncodeSecret(secret),
+			"uri":    KeyURI(t.conf.Issuer, data.UserID, secret),
+		})
+	})
+}
+
+// ConfirmHandler validates the "code" of the secret generated by EnrollHandler, which replaces the enrolled secret.
+// Confirming passes the second factor of the session, redirecting to the "continue" parameter (a local URI).
+func (t *TOTP) ConfirmHandler(s *osecure.OAuthSession) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, enrollment, ok := t.authorize(w, r, s)
+		if !ok {
+			return
+		}
+
+		if enrollment != nil && enrollment.Secret != nil && s.IsSecondFactorPending(data) {
+			http.Error(w, ErrorAlreadyEnrolled.Error(), http.StatusForbidden)
+			return
+		}
+
+		err := t.validate(data.UserID, r.FormValue("code"), true)
+		if err != nil {
+			http.Error(w, err.Error(), statusCode(err))
+			return
+		}
+
+		t.completeSecondFactor(w, r, s, data)
+	})
+}
+
+// VerifyHandler validates the "code" of the enrolled secret of the session user, which passes the second factor,
+// redirecting to the "continue" parameter (a local URI).
+func (t *TOTP) VerifyHandler(s *osecure.OAuthSession) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, enrollment, ok := t.authorize(w, r, s)
+		if !ok {
+			return
+		}
+		if enrollment == nil || enrollment.Secret == nil {
+			http.Error(w, ErrorNotEnrolled.Error(), http.StatusNotFound)
+			return
+		}
+
+		err := t.validate(data.UserID, r.FormValue("code"), false)
+		if err != nil {
+			http.Error(w, err.Error(), statusCode(err))
+			return
+		}
+
+		t.completeSecondFactor(w, r, s, data)
+	})
+}
+
+// authorize gets the session and the enrollment of the user.
+func (t *TOTP) authorize(w http.ResponseWriter, r *http.Request, s *osecure.OAuthSession) (*osecure.AuthSessionData, *Enrollment, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+
+	data, err := s.VerifyPendingSecondFactor(r)
+	if err != nil {
+		http.Error(w, err.Error(), osecure.ErrorStatusCode(err))
+		return nil, nil, false
+	}
+
+	enrollment, err := t.store.Enrollment(data.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return data, enrollment, true
+}
+
+// validate validates the code of the secret, or the pending secret which replaces the secret if it's valid,
+// counting failures to lock out brute force attacks.
+func (t *TOTP) validate(userID string, code string, isPending bool) error {
+	return t.update(userID, func(enrollment *Enrollment) error {
+		secret := enrollment.Secret
+		if isPending {
+			secret = enrollment.PendingSecret
+		}
+		if secret == nil {
+			return ErrorNotEnrolled
+		}
+
+		now := time.Now()
+		if now.Before(enrollment.LockedUntil) {
+			return ErrorLockedOut
+		}
+
+		matchedStep, ok := Validate(secret, code, now, t.conf.Skew)
+		if !ok || matchedStep <= enrollment.LastStep {
+			enrollment.Failures++
+			if enrollment.Failures >= t.conf.MaxFailures {
+				enrollment.Failures = 0
+				enrollment.LockedUntil = now.Add(t.conf.Lockout)
+			}
+			return ErrorInvalidCode
+		}
+
+		enrollment.LastStep = matchedStep
+		enrollment.Failures = 0
+		if isPending {
+			enrollment.Secret = secret
+			enrollment.PendingSecret = nil
+		}
+		return nil
+	})
+}
+
+// update updates the enrollment of the user by f, which is saved even if f fails (e.g. to count failures).
+// Updates are serialized, so a code is used once.
+func (t *TOTP) update(userID string, f func(enrollment *Enrollment) error) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	enrollment, err := t.store.Enrollment(userID)
+	if err != nil {
+		return err
+	}
+	if enrollment == nil {
+		enrollment = &Enrollment{}
+	}
+
+	updateErr := f(enrollment)
+	if updateErr == ErrorNotEnrolled || updateErr == ErrorLockedOut {
+		return updateErr
+	}
+	err = t.store.SaveEnrollment(userID, enrollment)
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+func (t *TOTP) completeSecondFactor(w http.ResponseWriter, r *http.Request, s *osecure.OAuthSession, data *osecure.AuthSessionData) {
+	if s.IsSecondFactorPending(data) {
+		err := s.CompleteSecondFactor(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), osecure.ErrorStatusCode(err))
+			return
+		}
+	}
+
+	continueURI := r.FormValue("continue")
+	if !osecure.IsLocalURI(continueURI) {
+		continueURI = "/"
+	}
+	http.Redirect(w, r, continueURI, http.StatusSeeOther)
+}
+
+func statusCode(err error) int {
+	switch err {
+	case ErrorInvalidCode:
+		return http.StatusUnauthorized
+	case ErrorNotEnrolled:
+		return http.StatusNotFound
+	case ErrorLockedOut:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,63 @@
+package osecure
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// NewVerifierSession creates a verifier-only OAuthSession of a satellite service, which verifies session cookies
+// and bearer tokens but never performs the OAuth flow, so it needs no client secret, endpoints or state handler.
+// The issuer service performs the OAuth flow by NewOAuthSession, issuing the session cookie shared with satellites,
+// e.g. by SSOCookieDomain with the same session name and cookie keys. Unauthenticated users of non-API routes
+// are redirected to issuerLoginURL (e.g. "https://auth.example.com/login", served by LoginView of the issuer)
+// with the URL of the request as the "continue" parameter, which the issuer accepts by SetSatelliteHosts.
+// Satellites behind proxies terminating TLS should set OAuthConfig.ExternalURL, since the issuer only continues
+// to https URLs.
+func NewVerifierSession(name string, cookieConf *CookieConfig, oauthConf *OAuthConfig, tokenVerifier *TokenVerifier, issuerLoginURL string) *OAuthSession {
+	conf := *oauthConf
+	conf.ClientSecret = ""
+	s := NewOAuthSession(name, cookieConf, &conf, OAuthEndpoint{}, tokenVerifier, "", nil)
+	s.issuerLoginURL = issuerLoginURL
+	return s
+}
+
+// IsVerifierOnly checks if the session is created by NewVerifierSession.
+func (s *OAuthSession) IsVerifierOnly() bool {
+	return s.issuerLoginURL != ""
+}
+
+// issuerLoginRedirectURL makes the URL of the login page of the issuer, coming back to the request afterwards.
+func (s *OAuthSession) issuerLoginRedirectURL(r *http.Request) string {
+	loginURL, err := url.Parse(s.issuerLoginURL)
+	if err != nil {
+		return s.issuerLoginURL
+	}
+	qry := loginURL.Query()
+	qry.Set("continue", s.absoluteURL(r, r.RequestURI))
+	loginURL.RawQuery = qry.Encode()
+	return loginURL.String()
+}
+
+// SetSatelliteHosts allows the login page of the issuer service to continue to the hosts of satellite services
+// (e.g. "admin.example.com") after login, besides paths of this site. The hosts should be covered by
+// SSOCookieDomain, so the satellites get the session. It should be called before serving requests.
+func (s *OAuthSession) SetSatelliteHosts(hosts ...string) {
+	s.satelliteHosts = NewStringSet(hosts)
+}
+
+// isAllowedContinueURI checks the continue URI is a path of this site or a URL of satellite hosts,
+// to avoid open redirects by the continue parameter.
+func (s *OAuthSession) isAllowedContinueURI(uri string) bool {
+	if IsLocalURI(uri) {
+		return true
+	}
+	if len(s.satelliteHosts) == 0 || strings.ContainsAny(uri, "\\\r\n\t") {
+		return false
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.User != nil {
+		return false
+	}
+	return u.Scheme == "https" && s.satelliteHosts.Contain(u.Host)
+}
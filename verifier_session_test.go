@@ -0,0 +1,45 @@
+package osecure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestVerifierSessionBehindTLSProxy(t *testing.T) {
+	issuer := newTestSession(t, newTestVerifier(nil))
+	issuer.SetSatelliteHosts("admin.example.com")
+
+	tests := []struct {
+		name        string
+		externalURL string
+		want        string
+	}{
+		{name: "without external URL", externalURL: "", want: "http://admin.example.com/page?q=1"},
+		{name: "with external URL", externalURL: "https://admin.example.com", want: "https://admin.example.com/page?q=1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewVerifierSession("osecure", newTestCookieConfig(), &OAuthConfig{ClientID: testClientID, ExternalURL: test.externalURL},
+				newTestVerifier(nil), "https://auth.example.com/login")
+
+			// TLS is terminated by the proxy, so the request is plain http
+			r := httptest.NewRequest(http.MethodGet, "/page?q=1", nil)
+			r.Host = "admin.example.com"
+			loginURL, err := url.Parse(s.issuerLoginRedirectURL(r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			continueURI := loginURL.Query().Get("continue")
+			if continueURI != test.want {
+				t.Errorf("continue = %q, want %q", continueURI, test.want)
+			}
+			allowed := issuer.isAllowedContinueURI(continueURI)
+			if allowed != (test.externalURL != "") {
+				t.Errorf("isAllowedContinueURI(%q) = %v", continueURI, allowed)
+			}
+		})
+	}
+}
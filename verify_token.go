@@ -0,0 +1,71 @@
+package osecure
+
+import (
+	"context"
+)
+
+// VerifyAccessToken verifies the access token with the same verifier, audience and issuer rules as bearer tokens,
+// without a http request, e.g. for queue consumers and CLI tools.
+// Sender-constrained tokens (certificate or DPoP bound) are rejected since possession can't be proven without a request.
+func (s *OAuthSession) VerifyAccessToken(ctx context.Context, accessToken string) (*AuthSessionData, error) {
+	data, err := s.verifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, WrapError(ErrorStringUnauthorized, err)
+	}
+
+	_, err = s.ensurePermUpdated(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *OAuthSession) verifyAccessToken(ctx context.Context, accessToken string) (*AuthSessionData, error) {
+	if accessToken == "" {
+		return nil, ErrorInvalidAuthorizationSyntax
+	}
+
+	userID, clientID, expiresAt, extra, err := s.introspectToken(ctx, accessToken)
+	if err != nil {
+		return nil, WrapError(ErrorStringCannotIntrospectToken, err)
+	}
+	return s.checkIntrospection(accessToken, &IntrospectionResult{UserID: userID, ClientID: clientID, ExpiresAt: expiresAt, Extra: extra})
+}
+
+// checkIntrospection checks the introspection result of the access token like bearer tokens.
+func (s *OAuthSession) checkIntrospection(accessToken string, result *IntrospectionResult) (*AuthSessionData, error) {
+	extra := result.Extra
+	userID, clientID, permissions, profile := s.mapClaims(result.UserID, result.ClientID, extra)
+
+	token := makeBearerToken(accessToken, result.ExpiresAt).WithExtra(extra)
+	data := &AuthSessionData{
+		UserID:                userID,
+		ClientID:              clientID,
+		AuthSessionCookieData: s.newAuthSessionCookieData(token),
+		Profile:               profile,
+		Extra:                 extra,
+	}
+	if permissions != nil {
+		// permissions in claims are as fresh as the token
+		data.Permissions = NewPermissionSet(permissions)
+		data.PermissionsExpiresAt = token.Expiry
+	}
+
+	if !s.isValidClientID(data.ClientID) && !s.isServiceAccount(data.UserID, data.ClientID) {
+		return nil, ErrorInvalidClientID
+	}
+	if getConfirmation(extra, "x5t#S256") != "" || s.requireCertificateBoundTokens {
+		return nil, ErrorCertificateMismatch
+	}
+	if getConfirmation(extra, "jkt") != "" || (s.dpopValidator != nil && s.dpopValidator.Required) {
+		return nil, ErrorInvalidDPoPProof
+	}
+	if !s.isValidIssuer(extra) {
+		return nil, ErrorInvalidIssuer
+	}
+	if data.isTokenExpired(s.clockSkew) {
+		return nil, ErrorInvalidSession
+	}
+
+	return data, nil
+}
@@ -0,0 +1,229 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Flags of authenticator data.
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+// COSE algorithms of credential public keys.
+const (
+	AlgorithmES256 = -7
+	AlgorithmEdDSA = -8
+	AlgorithmRS256 = -257
+)
+
+// clientData is the client data collected by the browser.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// verifyClientData checks the type, challenge and origin of the client data.
+func (wa *WebAuthn) verifyClientData(clientDataJSON []byte, ceremonyType string, challenge []byte) error {
+	var data clientData
+	err := json.Unmarshal(clientDataJSON, &data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrorInvalidCredential, err)
+	}
+
+	receivedChallenge, err := decodeBase64URL(data.Challenge)
+	switch {
+	case data.Type != ceremonyType:
+		return fmt.Errorf("%w: type %q", ErrorInvalidCredential, data.Type)
+	case err != nil || subtle.ConstantTimeCompare(receivedChallenge, challenge) != 1:
+		return fmt.Errorf("%w: challenge mismatch", ErrorInvalidCredential)
+	case !containsString(wa.conf.Origins, data.Origin):
+		return fmt.Errorf("%w: origin %q", ErrorInvalidCredential, data.Origin)
+	}
+	return nil
+}
+
+// authenticatorData is the parsed authenticator data.
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte // only with attested credential data
+	PublicKey    []byte // COSE_Key, only with attested credential data
+}
+
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data is too short", ErrorInvalidCredential)
+	}
+
+	data := &authenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if data.Flags&flagAttestedCredentialData == 0 {
+		return data, nil
+	}
+
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("%w: attested credential data is too short", ErrorInvalidCredential)
+	}
+	// skip AAGUID
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, fmt.Errorf("%w: credential ID is too short", ErrorInvalidCredential)
+	}
+	data.CredentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	_, remaining, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: credential public key: %v", ErrorInvalidCredential, err)
+	}
+	data.PublicKey = rest[:len(rest)-len(remaining)]
+	return data, nil
+}
+
+// verifyAuthenticatorData checks the RP ID hash and the user presence, and the user verification if required.
+func (wa *WebAuthn) verifyAuthenticatorData(data *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(wa.conf.RPID))
+	switch {
+	case !bytes.Equal(data.RPIDHash, rpIDHash[:]):
+		return fmt.Errorf("%w: RP ID mismatch", ErrorInvalidCredential)
+	case data.Flags&flagUserPresent == 0:
+		return fmt.Errorf("%w: user is not present", ErrorInvalidCredential)
+	case wa.conf.RequireUserVerification && data.Flags&flagUserVerified == 0:
+		return fmt.Errorf("%w: user is not verified", ErrorInvalidCredential)
+	}
+	return nil
+}
+
+// parseAttestationObject gets the authenticator data of the attestation object.
+// Attestation statements aren't verified, since credentials are created with attestation "none".
+func parseAttestationObject(b []byte) (*authenticatorData, error) {
+	v, _, err := decodeCBOR(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation object: %v", ErrorInvalidCredential, err)
+	}
+	object, _ := v.(map[interface{}]interface{})
+	authData, _ := object["authData"].([]byte)
+	if authData == nil {
+		return nil, fmt.Errorf("%w: no authenticator data", ErrorInvalidCredential)
+	}
+
+	data, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if data.CredentialID == nil {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrorInvalidCredential)
+	}
+	_, err = parsePublicKey(data.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// parsePublicKey parses the COSE_Key of ES256, EdDSA (Ed25519) or RS256.
+func parsePublicKey(coseKey []byte) (crypto.PublicKey, error) {
+	v, _, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: public key: %v", ErrorInvalidCredential, err)
+	}
+	key, _ := v.(map[interface{}]interface{})
+	alg, _ := key[int64(3)].(int64)
+
+	switch alg {
+	case AlgorithmES256:
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv, _ := key[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid EC2 key", ErrorInvalidCredential)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("%w: invalid EC2 key", ErrorInvalidCredential)
+		}
+		return pub, nil
+	case AlgorithmEdDSA:
+		x, _ := key[int64(-2)].([]byte)
+		if crv, _ := key[int64(-1)].(int64); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid OKP key", ErrorInvalidCredential)
+		}
+		return ed25519.PublicKey(x), nil
+	case AlgorithmRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid RSA key", ErrorInvalidCredential)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %d", ErrorInvalidCredential, alg)
+	}
+}
+
+// verifySignature verifies the assertion signature over the authenticator data and the hash of the client data.
+func verifySignature(coseKey []byte, authData []byte, clientDataJSON []byte, signature []byte) error {
+	pub, err := parsePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	message := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	digest := sha256.Sum256(message)
+
+	var isValid bool
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct {
+			R, S *big.Int
+		}
+		rest, err := asn1.Unmarshal(signature, &sig)
+		isValid = err == nil && len(rest) == 0 && sig.R != nil && sig.S != nil && ecdsa.Verify(pub, digest[:], sig.R, sig.S)
+	case ed25519.PublicKey:
+		isValid = ed25519.Verify(pub, message, signature)
+	case *rsa.PublicKey:
+		isValid = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !isValid {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// decodeBase64URL decodes base64url with or without padding.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func containsString(a []string, x string) bool {
+	for _, s := range a {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
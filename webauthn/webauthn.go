@@ -0,0 +1,595 @@
+// Package osecure/webauthn provides WebAuthn (passkeys and security keys) as the second factor of osecure.OAuthSession,
+// with JSON handlers of the registration and authentication ceremonies.
+//
+// The second factor page (osecure.OAuthSession.SetSecondFactor) calls navigator.credentials.create or get
+// with options from the begin handlers, and posts the credential to the finish handlers, with binary fields
+// base64url encoded. Users without credentials register their first one right after logging in,
+// which passes the second factor too:
+//
+//	wa, err := webauthn.New(conf, webauthn.NewMemoryCredentialStore())
+//	s.SetSecondFactor("/2fa")
+//	mux.Handle("/webauthn/", wa.Handler(s, "/webauthn/"))
+package webauthn
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/rayark/osecure/v6"
+)
+
+// DefaultTimeout is the timeout of ceremonies, and the lifetime of their challenges.
+const DefaultTimeout = 5 * time.Minute
+
+// ChallengeCookieName is the cookie carrying the challenge between the begin and finish handlers.
+const ChallengeCookieName = "osecure_webauthn_challenge"
+
+var (
+	ErrorInvalidCredential = errors.New("invalid WebAuthn credential")
+	ErrorUnknownCredential = errors.New("unknown WebAuthn credential")
+	ErrorNoCredentials     = errors.New("no WebAuthn credentials registered")
+	ErrorCredentialCloned  = errors.New("WebAuthn credential may be cloned")
+	ErrorNoChallenge       = errors.New("no pending WebAuthn challenge")
+	ErrorChallengeUsed     = errors.New("WebAuthn challenge is already used")
+)
+
+// Ceremony types of client data.
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// Config is the config of WebAuthn.
+type Config struct {
+	RPID    string   // relying party ID, the domain of the site, e.g. "example.com"
+	RPName  string   // relying party name shown by authenticators
+	Origins []string // allowed origins of client data, e.g. "https://www.example.com"
+
+	// ChallengeKey signs the challenge cookie, at least 32 bytes.
+	ChallengeKey []byte
+
+	// RequireUserVerification rejects credentials without user verification (PIN or biometrics),
+	// user verification is only preferred by default.
+	RequireUserVerification bool
+
+	// ReplayCache keeps challenges used once, osecure.MemoryReplayCache if nil.
+	ReplayCache osecure.ReplayCache
+}
+
+// Credential is a registered public key credential of a user.
+type Credential struct {
+	ID         []byte    `json:"id"`
+	UserID     string    `json:"user_id"`
+	PublicKey  []byte    `json:"public_key"` // COSE_Key
+	SignCount  uint32    `json:"sign_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// CredentialStore stores credentials of users.
+type CredentialStore interface {
+	// Credentials gets all credentials of the user, nil if the user has none.
+	Credentials(userID string) ([]*Credential, error)
+	// SaveCredential creates the credential, or updates it if the ID exists.
+	SaveCredential(credential *Credential) error
+}
+
+// MemoryCredentialStore is CredentialStore in memory, for tests and single instance deployments.
+type MemoryCredentialStore struct {
+	mutex       sync.Mutex
+	credentials map[string][]*Credential
+}
+
+// NewMemoryCredentialStore creates MemoryCredentialStore.
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{
+		credentials: make(map[string][]*Credential),
+	}
+}
+
+// Credentials implements CredentialStore.
+func (store *MemoryCredentialStore) Credentials(userID string) ([]*Credential, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var credentials []*Credential
+	for _, credential := range store.credentials[userID] {
+		copied := *credential
+		credentials = append(credentials, &copied)
+	}
+	return credentials, nil
+}
+
+// SaveCredential implements CredentialStore.
+func (store *MemoryCredentialStore) SaveCredential(credential *Credential) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	copied := *credential
+	credentials := store.credentials[credential.UserID]
+	for i, c := range credentials {
+		if string(c.ID) == string(credential.ID) {
+			credentials[i] = &copied
+			return nil
+		}
+	}
+	store.credentials[credential.UserID] = append(credentials, &copied)
+	return nil
+}
+
+// WebAuthn is the relying party of WebAuthn.
+type WebAuthn struct {
+	conf            Config
+	store           CredentialStore
+	challengeCookie *securecookie.SecureCookie
+	replayCache     osecure.ReplayCache
+}
+
+// New creates WebAuthn with the config and the credential store.
+func New(conf Config, store CredentialStore) (*WebAuthn, error) {
+	switch {
+	case conf.RPID == "":
+		return nil, errors.New("RP ID is required")
+	case len(conf.Origins) == 0:
+		return nil, errors.New("origins are required")
+	case len(conf.ChallengeKey) < 32:
+		return nil, errors.New("challenge key must be at least 32 bytes")
+	case store == nil:
+		return nil, errors.New("credential store is required")
+	}
+	if conf.RPName == "" {
+		conf.RPName = conf.RPID
+	}
+
+	wa := &WebAuthn{
+		conf:            conf,
+		store:           store,
+		challengeCookie: securecookie.New(conf.ChallengeKey, nil).MaxAge(int(DefaultTimeout.Seconds())),
+		replayCache:     conf.ReplayCache,
+	}
+	if wa.replayCache == nil {
+		wa.replayCache = osecure.NewMemoryReplayCache()
+	}
+	return wa, nil
+}
+
+// Handler serves the handlers under the prefix: POST register/begin, register/finish, login/begin and login/finish.
+func (wa *WebAuthn) Handler(s *osecure.OAuthSession, prefix string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"register/begin", wa.RegisterBeginHandler(s))
+	mux.Handle(prefix+"register/finish", wa.RegisterFinishHandler(s))
+	mux.Handle(prefix+"login/begin", wa.LoginBeginHandler(s))
+	mux.Handle(prefix+"login/finish", wa.LoginFinishHandler(s))
+	return mux
+}
+
+// pendingChallenge is the challenge of a ceremony, kept in the challenge cookie.
+type pendingChallenge struct {
+	Challenge   []byte
+	Type        string
+	UserID      string
+	ContinueURI string
+	ExpiresAt   int64
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// RegisterBeginHandler responds PublicKeyCredentialCreationOptions to register a credential of the session user.
+// Sessions pending the second factor can only register the first credential of the user.
+func (wa *WebAuthn) RegisterBeginHandler(s *osecure.OAuthSession) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, credentials, ok := wa.authorizeRegistration(w, r, s)
+		if !ok {
+			return
+		}
+
+		challenge, err := wa.newChallenge(w, r, ceremonyCreate, data.UserID, r.FormValue("continue"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		displayName := data.DisplayName
+		if displayName == "" {
+			displayName = data.UserID
+		}
+		excludeCredentials := make([]credentialDescriptor, 0, len(credentials))
+		for _, credential := range credentials {
+			excludeCredentials = append(excludeCredentials, credentialDescriptor{Type: "public-key", ID: encodeBase64URL(credential.ID)})
+		}
+
+		userVerification := "preferred"
+		if wa.conf.RequireUserVerification {
+			userVerification = "required"
+		}
+		writeJSON(w, map[string]interface{}{
+			"publicKey": map[string]interface{}{
+				"rp": map[string]string{"id": wa.conf.RPID, "name": wa.conf.RPName},
+				"user": map[string]string{
+					"id":          encodeBase64URL([]byte(data.UserID)),
+					"name":        data.UserID,
+					"displayName": displayName,
+				},
+				"challenge": encodeBase64URL(challenge),
+				"pubKeyCredParams": []map[string]interface{}{
+					{"type": "public-key", "alg": AlgorithmES256},
+					{"type": "public-key", "alg": AlgorithmEdDSA},
+					{"type": "public-key", "alg": AlgorithmRS256},
+				},
+				"timeout":                DefaultTimeout.Milliseconds(),
+				"attestation":            "none",
+				"excludeCredentials":     excludeCredentials,
+				"authenticatorSelection": map[string]string{"userVerification": userVerification},
+			},
+		})
+	})
+}
+
+// RegisterFinishHandler verifies and saves the created credential, responding the continue URI as JSON.
+// Registering the first credential passes the second factor of the session.
+func (wa *WebAuthn) RegisterFinishHandler(s *osecure.OAuthSession) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, credentials, ok := wa.authorizeRegistration(w, r, s)
+		if !ok {
+			return
+		}
+
+		pending, err := wa.takeChallenge(w, r, ceremonyCreate, data.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			Response struct {
+				ClientDataJSON    string `json:"clientDataJSON"`
+				AttestationObject string `json:"attestationObject"`
+			} `json:"response"`
+		}
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		credential, err := wa.verifyRegistration(body.Response.ClientDataJSON, body.Response.AttestationObject, pending.Challenge)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, c := range credentials {
+			if string(c.ID) == string(credential.ID) {
+				http.Error(w, "credential is already registered", http.StatusConflict)
+				return
+			}
+		}
+
+		credential.UserID = data.UserID
+		err = wa.store.SaveCredential(credential)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if s.IsSecondFactorPending(data) {
+			err = s.CompleteSecondFactor(w, r)
+			if err != nil {
+				http.Error(w, err.Error(), osecure.ErrorStatusCode(err))
+				return
+			}
+		}
+		writeJSON(w, map[string]string{"continue": pending.ContinueURI})
+	})
+}
+
+// authorizeRegistration gets the session and the credentials of the user, responding an error if the user can't register.
+func (wa *WebAuthn) authorizeRegistration(w http.ResponseWriter, r *http.Request, s *osecure.OAuthSession) (*osecure.AuthSessionData, []*Credential, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+
+	data, err := s.VerifyPendingSecondFactor(r)
+	if err != nil {
+		http.Error(w, err.Error(), osecure.ErrorStatusCode(err))
+		return nil, nil, false
+	}
+
+	credentials, err := wa.store.Credentials(data.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if len(credentials) > 0 && s.IsSecondFactorPending(data) {
+		http.Error(w, osecure.ErrorSecondFactorRequired.Error(), http.StatusForbidden)
+		return nil, nil, false
+	}
+	return data, credentials, true
+}
+
+// LoginBeginHandler responds PublicKeyCredentialRequestOptions with credentials of the session user.
+// It responds 404 if the user has no credentials, who should register one instead.
+func (wa *WebAuthn) LoginBeginHandler(s *osecure.OAuthSession) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, credentials, ok := wa.authorizeLogin(w, r, s)
+		if !ok {
+			return
+		}
+
+		challenge, err := wa.newChallenge(w, r, ceremonyGet, data.UserID, r.FormValue("continue"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		allowCredentials := make([]credentialDescriptor, 0, len(credentials))
+		for _, credential := range credentials {
+			allowCredentials = append(allowCredentials, credentialDescriptor{Type: "public-key", ID: encodeBase64URL(credential.ID)})
+		}
+
+		userVerification := "preferred"
+		if wa.conf.RequireUserVerification {
+			userVerification = "required"
+		}
+		writeJSON(w, map[string]interface{}{
+			"publicKey": map[string]interface{}{
+				"challenge":        encodeBase64URL(challenge),
+				"rpId":             wa.conf.RPID,
+				"allowCredentials": allowCredentials,
+				"userVerification": userVerification,
+				"timeout":          DefaultTimeout.Milliseconds(),
+			},
+		})
+	})
+}
+
+// LoginFinishHandler verifies the assertion and passes the second factor of the session,
+// responding the continue URI as JSON.
+func (wa *WebAuthn) LoginFinishHandler(s *osecure.OAuthSession) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, credentials, ok := wa.authorizeLogin(w, r, s)
+		if !ok {
+			return
+		}
+
+		pending, err := wa.takeChallenge(w, r, ceremonyGet, data.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			RawID    string `json:"rawId"`
+			Response struct {
+				ClientDataJSON    string `json:"clientDataJSON"`
+				AuthenticatorData string `json:"authenticatorData"`
+				Signature         string `json:"signature"`
+			} `json:"response"`
+		}
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		credential, err := wa.verifyAssertion(credentials, body.RawID, body.Response.ClientDataJSON, body.Response.AuthenticatorData, body.Response.Signature, pending.Challenge)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		err = wa.store.SaveCredential(credential)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = s.CompleteSecondFactor(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), osecure.ErrorStatusCode(err))
+			return
+		}
+		writeJSON(w, map[string]string{"continue": pending.ContinueURI})
+	})
+}
+
+// authorizeLogin gets the session and the credentials of the user, responding an error if the user has none.
+func (wa *WebAuthn) authorizeLogin(w http.ResponseWriter, r *http.Request, s *osecure.OAuthSession) (*osecure.AuthSessionData, []*Credential, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+
+	data, err := s.VerifyPendingSecondFactor(r)
+	if err != nil {
+		http.Error(w, err.Error(), osecure.ErrorStatusCode(err))
+		return nil, nil, false
+	}
+
+	credentials, err := wa.store.Credentials(data.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if len(credentials) == 0 {
+		http.Error(w, ErrorNoCredentials.Error(), http.StatusNotFound)
+		return nil, nil, false
+	}
+	return data, credentials, true
+}
+
+// verifyRegistration verifies the client data and the attestation object, returning the new credential.
+func (wa *WebAuthn) verifyRegistration(encodedClientData string, encodedAttestationObject string, challenge []byte) (*Credential, error) {
+	clientDataJSON, err := decodeBase64URL(encodedClientData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCredential, err)
+	}
+	attestationObject, err := decodeBase64URL(encodedAttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCredential, err)
+	}
+
+	err = wa.verifyClientData(clientDataJSON, ceremonyCreate, challenge)
+	if err != nil {
+		return nil, err
+	}
+	authData, err := parseAttestationObject(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	err = wa.verifyAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &Credential{
+		ID:         append([]byte(nil), authData.CredentialID...),
+		PublicKey:  append([]byte(nil), authData.PublicKey...),
+		SignCount:  authData.SignCount,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}, nil
+}
+
+// verifyAssertion verifies the assertion of one of the credentials, returning the credential with updated sign count.
+func (wa *WebAuthn) verifyAssertion(credentials []*Credential, encodedID string, encodedClientData string, encodedAuthData string, encodedSignature string, challenge []byte) (*Credential, error) {
+	id, err := decodeBase64URL(encodedID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCredential, err)
+	}
+	var credential *Credential
+	for _, c := range credentials {
+		if string(c.ID) == string(id) {
+			credential = c
+			break
+		}
+	}
+	if credential == nil {
+		return nil, ErrorUnknownCredential
+	}
+
+	clientDataJSON, err := decodeBase64URL(encodedClientData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCredential, err)
+	}
+	rawAuthData, err := decodeBase64URL(encodedAuthData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCredential, err)
+	}
+	signature, err := decodeBase64URL(encodedSignature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCredential, err)
+	}
+
+	err = wa.verifyClientData(clientDataJSON, ceremonyGet, challenge)
+	if err != nil {
+		return nil, err
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	err = wa.verifyAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	err = verifySignature(credential.PublicKey, rawAuthData, clientDataJSON, signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidCredential, err)
+	}
+
+	// authenticators without counters always report 0, otherwise the counter must increase
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		return nil, ErrorCredentialCloned
+	}
+	credential.SignCount = authData.SignCount
+	credential.LastUsedAt = time.Now()
+	return credential, nil
+}
+
+// newChallenge generates a challenge of the ceremony, saving it into the challenge cookie.
+func (wa *WebAuthn) newChallenge(w http.ResponseWriter, r *http.Request, ceremonyType string, userID string, continueURI string) ([]byte, error) {
+	if !osecure.IsLocalURI(continueURI) {
+		continueURI = "/"
+	}
+
+	challenge := make([]byte, 32)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := wa.challengeCookie.Encode(ChallengeCookieName, &pendingChallenge{
+		Challenge:   challenge,
+		Type:        ceremonyType,
+		UserID:      userID,
+		ContinueURI: continueURI,
+		ExpiresAt:   time.Now().Add(DefaultTimeout).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ChallengeCookieName,
+		Value:    encoded,
+		Path:     "/",
+		MaxAge:   int(DefaultTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(wa.conf.Origins[0], "https://"),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return challenge, nil
+}
+
+// takeChallenge gets the challenge of the ceremony of the user, which is used once.
+// The cookie is deleted, and the challenge is kept in the replay cache to reject requests replaying the cookie.
+func (wa *WebAuthn) takeChallenge(w http.ResponseWriter, r *http.Request, ceremonyType string, userID string) (*pendingChallenge, error) {
+	cookie, err := r.Cookie(ChallengeCookieName)
+	if err != nil {
+		return nil, ErrorNoChallenge
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ChallengeCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cookie.Secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	pending := &pendingChallenge{}
+	err = wa.challengeCookie.Decode(ChallengeCookieName, cookie.Value, pending)
+	if err != nil || pending.Type != ceremonyType || pending.UserID != userID || time.Now().Unix() >= pending.ExpiresAt {
+		return nil, ErrorNoChallenge
+	}
+
+	isFirstUse, err := wa.replayCache.Use(r.Context(), "webauthn:"+encodeBase64URL(pending.Challenge), time.Unix(pending.ExpiresAt, 0))
+	if err != nil {
+		return nil, err
+	}
+	if !isFirstUse {
+		return nil, ErrorChallengeUsed
+	}
+	return pending, nil
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
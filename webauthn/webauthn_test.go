@@ -0,0 +1,39 @@
+package webauthn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTakeChallengeReplayed(t *testing.T) {
+	wa, err := New(Config{
+		RPID:         "example.com",
+		Origins:      []string{"https://example.com"},
+		ChallengeKey: []byte("0123456789abcdef0123456789abcdef"),
+	}, NewMemoryCredentialStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	_, err = wa.newChallenge(w, httptest.NewRequest(http.MethodPost, "/", nil), ceremonyGet, "alice", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	finish := func() error {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.AddCookie(cookie)
+		_, err := wa.takeChallenge(httptest.NewRecorder(), r, ceremonyGet, "alice")
+		return err
+	}
+	if err := finish(); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	// the client deletes the cookie, but a replayed request still carries it
+	if err := finish(); err != ErrorChallengeUsed {
+		t.Fatalf("replayed challenge: got %v, want %v", err, ErrorChallengeUsed)
+	}
+}
@@ -0,0 +1,106 @@
+package osecure
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// redactedClaims are claims which are never exposed by WhoAmIHandler.
+var redactedClaims = NewStringSet([]string{"access_token", "refresh_token", "id_token", "client_secret"})
+
+// WhoAmI is the view of the session responded by WhoAmIHandler.
+// Secrets of the session (tokens, ID token, session ID and client binding hashes) are never included.
+type WhoAmI struct {
+	Subject     string   `json:"subject"`
+	Audience    string   `json:"audience"`
+	Actor       string   `json:"actor,omitempty"` // the real user during impersonation
+	Provider    string   `json:"provider"`        // name of the session
+	Issuer      string   `json:"issuer,omitempty"`
+	Tenant      string   `json:"tenant,omitempty"`
+	Permissions []string `json:"permissions"`
+	Roles       []string `json:"roles,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	DisplayName string   `json:"display_name,omitempty"`
+	AAL         AAL      `json:"aal,omitempty"`
+
+	TokenType            string     `json:"token_type,omitempty"`
+	TokenExpiresAt       *time.Time `json:"token_expires_at,omitempty"`
+	PermissionsExpiresAt *time.Time `json:"permissions_expires_at,omitempty"`
+	SessionCreatedAt     *time.Time `json:"session_created_at,omitempty"`
+	SessionExpiresAt     *time.Time `json:"session_expires_at,omitempty"`
+	AuthTime             *time.Time `json:"auth_time,omitempty"`
+
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// NewWhoAmI creates the view of the session data.
+func (s *OAuthSession) NewWhoAmI(data *AuthSessionData) *WhoAmI {
+	whoAmI := &WhoAmI{
+		Subject:     data.UserID,
+		Audience:    data.ClientID,
+		Actor:       data.ActorID,
+		Provider:    s.name,
+		Issuer:      s.issuer,
+		Permissions: data.Permissions.List(),
+		Roles:       data.Roles,
+		Groups:      data.Groups,
+		Email:       data.Email,
+		DisplayName: data.DisplayName,
+		AAL:         data.AAL,
+	}
+	sort.Strings(whoAmI.Permissions)
+
+	if data.AuthSessionCookieData != nil {
+		whoAmI.Tenant = data.Tenant
+		whoAmI.PermissionsExpiresAt = timeOrNil(data.PermissionsExpiresAt)
+		whoAmI.SessionCreatedAt = timeOrNil(data.SessionCreatedAt)
+		whoAmI.SessionExpiresAt = timeOrNil(data.SessionExpiresAt)
+		whoAmI.AuthTime = timeOrNil(data.AuthTime)
+		if data.Token != nil {
+			whoAmI.TokenType = data.Token.Type()
+			whoAmI.TokenExpiresAt = timeOrNil(data.Token.Expiry)
+		}
+	}
+
+	if len(data.Extra) > 0 {
+		whoAmI.Claims = make(map[string]interface{}, len(data.Extra))
+		for key, value := range data.Extra {
+			if !redactedClaims.Contain(key) {
+				whoAmI.Claims[key] = value
+			}
+		}
+	}
+
+	return whoAmI
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// WhoAmIHandler responds WhoAmI of the current session as JSON, for frontend integration and debugging.
+// The session is taken from the request context if the handler is behind SecuredF, otherwise the request is verified
+// without writing cookies, responding the status hint of the error (see ErrorStatusCode) if unauthorized.
+func (s *OAuthSession) WhoAmIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := GetRequestSessionData(r)
+		if !ok {
+			var err error
+			data, err = s.VerifyRequest(r)
+			if err != nil {
+				http.Error(w, err.Error(), ErrorStatusCode(err))
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(s.NewWhoAmI(data))
+	})
+}